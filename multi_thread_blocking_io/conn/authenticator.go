@@ -0,0 +1,147 @@
+package conn
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"multi_thread_blocking_io/proto"
+	"os"
+)
+
+// TokenUsername is the username of the identity which is authenticated with the shared token.
+const TokenUsername = "default"
+
+// SaltLength is the length (in bytes) of the salt of a password, as generated by NewUserCredential.
+const SaltLength = 16
+
+var ErrNoCredentials = errors.New("authentication config has neither a token nor users")
+
+// AuthenticationConfig represents the config file of an Authenticator, which is JSON encoded:
+//
+//	{"token": "...", "users": [{"username": "...", "salt": "...", "hash": "..."}]}
+//
+// Either (or both) of the shared token and the users may be configured.
+type AuthenticationConfig struct {
+	Token string           `json:"token,omitempty"`
+	Users []UserCredential `json:"users,omitempty"`
+}
+
+// UserCredential represents the credential of a user: the hex encoded salt, and the hex encoded HashPassword of
+// the salt and the password. The password itself is never stored.
+type UserCredential struct {
+	Username string `json:"username"`
+	Salt     string `json:"salt"`
+	Hash     string `json:"hash"`
+}
+
+// Identity represents the authenticated identity of a connection.
+type Identity struct {
+	Username string
+}
+
+// Authenticator authenticates the connections, either with the shared token or with a username and a password.
+// Authenticator is safe for concurrent use, and is shared by all the connections of a server.
+type Authenticator struct {
+	token []byte
+	users map[string]credential
+}
+
+// credential is the decoded UserCredential.
+type credential struct {
+	salt []byte
+	hash []byte
+}
+
+// NewAuthenticator creates a new instance of Authenticator from the given config.
+// It returns an error if the config has no credentials, or if a salt or a hash is not hex encoded.
+func NewAuthenticator(config AuthenticationConfig) (*Authenticator, error) {
+	if config.Token == "" && len(config.Users) == 0 {
+		return nil, ErrNoCredentials
+	}
+	authenticator := &Authenticator{
+		token: []byte(config.Token),
+		users: make(map[string]credential, len(config.Users)),
+	}
+	for _, user := range config.Users {
+		salt, err := hex.DecodeString(user.Salt)
+		if err != nil {
+			return nil, fmt.Errorf("salt of the user %q: %w", user.Username, err)
+		}
+		hash, err := hex.DecodeString(user.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("hash of the user %q: %w", user.Username, err)
+		}
+		authenticator.users[user.Username] = credential{salt: salt, hash: hash}
+	}
+	return authenticator, nil
+}
+
+// LoadAuthenticator creates a new instance of Authenticator from the JSON encoded AuthenticationConfig in the file.
+func LoadAuthenticator(path string) (*Authenticator, error) {
+	buffer, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config AuthenticationConfig
+	if err := json.Unmarshal(buffer, &config); err != nil {
+		return nil, err
+	}
+	return NewAuthenticator(config)
+}
+
+// NewUserCredential creates the UserCredential of the user for the config file, with a random salt.
+func NewUserCredential(username, password string) (UserCredential, error) {
+	salt := make([]byte, SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return UserCredential{}, err
+	}
+	return UserCredential{
+		Username: username,
+		Salt:     hex.EncodeToString(salt),
+		Hash:     hex.EncodeToString(HashPassword(salt, []byte(password))),
+	}, nil
+}
+
+// HashPassword returns the SHA-256 of the salt followed by the password.
+func HashPassword(salt, password []byte) []byte {
+	hash := sha256.New()
+	hash.Write(salt)
+	hash.Write(password)
+	return hash.Sum(nil)
+}
+
+// AuthenticateToken returns the identity for the shared token, and false if the token does not match or
+// no token is configured.
+// The token is compared in constant time.
+func (authenticator *Authenticator) AuthenticateToken(token []byte) (Identity, bool) {
+	if len(authenticator.token) == 0 || subtle.ConstantTimeCompare(authenticator.token, token) != 1 {
+		return Identity{}, false
+	}
+	return Identity{Username: TokenUsername}, true
+}
+
+// AuthenticatePassword returns the identity of the user, and false if the user is unknown or the password
+// does not match.
+// The hashes are compared in constant time.
+func (authenticator *Authenticator) AuthenticatePassword(username, password []byte) (Identity, bool) {
+	user, ok := authenticator.users[string(username)]
+	if !ok || subtle.ConstantTimeCompare(user.hash, HashPassword(user.salt, password)) != 1 {
+		return Identity{}, false
+	}
+	return Identity{Username: string(username)}, true
+}
+
+// AuthenticatingHandler is a Handler which authenticates the connections. A connection whose Auth handler
+// requires authentication may only send an Auth till it is authenticated (see NewSessionFor).
+type AuthenticatingHandler interface {
+	Handler
+	// RequiresAuthentication returns true if the connections must authenticate before any other request.
+	RequiresAuthentication() bool
+	// Authenticate handles the Auth message, and returns the authenticated identity along with the response,
+	// or nil if the connection is not authenticated.
+	Authenticate(message *proto.KeyValueMessage) (*Identity, []byte, error)
+}
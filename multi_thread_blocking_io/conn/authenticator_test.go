@@ -0,0 +1,65 @@
+package conn
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuthenticatesWithTheSharedToken(t *testing.T) {
+	authenticator, err := NewAuthenticator(AuthenticationConfig{Token: "s3cr3t"})
+	assert.Nil(t, err)
+
+	identity, ok := authenticator.AuthenticateToken([]byte("s3cr3t"))
+	assert.True(t, ok)
+	assert.Equal(t, TokenUsername, identity.Username)
+
+	_, ok = authenticator.AuthenticateToken([]byte("guess"))
+	assert.False(t, ok)
+}
+
+func TestAuthenticatesWithAUsernameAndAPassword(t *testing.T) {
+	credential, err := NewUserCredential("alice", "wonderland")
+	assert.Nil(t, err)
+	assert.NotContains(t, credential.Hash, "wonderland")
+
+	authenticator, err := NewAuthenticator(AuthenticationConfig{Users: []UserCredential{credential}})
+	assert.Nil(t, err)
+
+	identity, ok := authenticator.AuthenticatePassword([]byte("alice"), []byte("wonderland"))
+	assert.True(t, ok)
+	assert.Equal(t, "alice", identity.Username)
+
+	_, ok = authenticator.AuthenticatePassword([]byte("alice"), []byte("looking-glass"))
+	assert.False(t, ok)
+	_, ok = authenticator.AuthenticatePassword([]byte("bob"), []byte("wonderland"))
+	assert.False(t, ok)
+	_, ok = authenticator.AuthenticateToken(nil)
+	assert.False(t, ok)
+}
+
+func TestLoadsAnAuthenticatorFromAConfigFile(t *testing.T) {
+	credential, _ := NewUserCredential("alice", "wonderland")
+	buffer, _ := json.Marshal(AuthenticationConfig{Token: "s3cr3t", Users: []UserCredential{credential}})
+
+	path := filepath.Join(t.TempDir(), "auth.json")
+	assert.Nil(t, os.WriteFile(path, buffer, 0o600))
+
+	authenticator, err := LoadAuthenticator(path)
+	assert.Nil(t, err)
+
+	_, ok := authenticator.AuthenticatePassword([]byte("alice"), []byte("wonderland"))
+	assert.True(t, ok)
+	_, ok = authenticator.AuthenticateToken([]byte("s3cr3t"))
+	assert.True(t, ok)
+}
+
+func TestDoesNotCreateAnAuthenticatorWithoutCredentials(t *testing.T) {
+	_, err := NewAuthenticator(AuthenticationConfig{})
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	_, err = NewAuthenticator(AuthenticationConfig{Users: []UserCredential{{Username: "alice", Salt: "not-hex"}}})
+	assert.NotNil(t, err)
+}
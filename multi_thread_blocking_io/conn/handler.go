@@ -1,41 +1,101 @@
 package conn
 
 import (
+	"errors"
 	"multi_thread_blocking_io/proto"
 	"multi_thread_blocking_io/store"
+	"strconv"
 )
 
+// SupportedFeatures are the features which the server agrees to in the Hello handshake.
+const SupportedFeatures = proto.FeaturePipelining | proto.FeatureChecksums | proto.FeatureCompression
+
 // Handler handles the incoming requests.
 type Handler interface {
 	Handle(message *proto.KeyValueMessage) ([]byte, error)
 }
 
+// NewHandlers creates the handlers for all the request kinds, keyed by the kind, on top of the given store.
+// The handlers which change the keys notify the Watchers which are shared by the Watch handlers, and the Publish
+// handler publishes to the Broker which is shared by the Subscribe handlers, so the handlers are expected to be
+// created once for a server. The Broker drops the messages of the subscribers which do not keep up.
+// The Multi, Exec, Discard and OptimisticWatch requests are handled by a TransactionHandler.
+// The Auth handler does not require authentication; it is replaced by an AuthHandler with an Authenticator
+// to require it.
+func NewHandlers(store store.Store) map[uint32]Handler {
+	watchers := NewWatchers()
+	broker := NewBroker(SubscriberQueueLength, OverflowDrop)
+	streamHandler := NewStreamHandler(store, watchers)
+	return map[uint32]Handler{
+		proto.KeyValueMessageKindPutOrUpdate:      NewPutOrUpdateHandler(store, watchers),
+		proto.KeyValueMessageKindGet:              NewGetHandler(store),
+		proto.KeyValueMessageKindDelete:           NewDeleteHandler(store, watchers),
+		proto.KeyValueMessageKindCompareAndSwap:   NewCompareAndSwapHandler(store, watchers),
+		proto.KeyValueMessageKindMultiGet:         NewMultiGetHandler(store),
+		proto.KeyValueMessageKindMultiPutOrUpdate: NewMultiPutOrUpdateHandler(store, watchers),
+		proto.KeyValueMessageKindScan:             NewScanHandler(store),
+		proto.KeyValueMessageKindPrefixScan:       NewScanHandler(store),
+		proto.KeyValueMessageKindTimeToLive:       NewTimeToLiveHandler(store),
+		proto.KeyValueMessageKindIncrementBy:      NewIncrementByHandler(store, watchers),
+		proto.KeyValueMessageKindHello:            NewHelloHandler(),
+		proto.KeyValueMessageKindWatch:            NewWatchHandler(watchers),
+		proto.KeyValueMessageKindPrefixWatch:      NewWatchHandler(watchers),
+		proto.KeyValueMessageKindPublish:          NewPublishHandler(broker),
+		proto.KeyValueMessageKindSubscribe:        NewSubscribeHandler(broker),
+		proto.KeyValueMessageKindPatternSubscribe: NewSubscribeHandler(broker),
+		proto.KeyValueMessageKindUnsubscribe:      NewSubscribeHandler(broker),
+		proto.KeyValueMessageKindMulti:            NewTransactionHandler(store, watchers),
+		proto.KeyValueMessageKindExec:             NewTransactionHandler(store, watchers),
+		proto.KeyValueMessageKindDiscard:          NewTransactionHandler(store, watchers),
+		proto.KeyValueMessageKindOptimisticWatch:  NewTransactionHandler(store, watchers),
+		proto.KeyValueMessageKindAuth:             NewAuthHandler(nil),
+		proto.KeyValueMessageKindPing:             NewPingHandler(),
+		proto.KeyValueMessageKindPong:             NewPingHandler(),
+		proto.KeyValueMessageKindStreamBegin:      streamHandler,
+		proto.KeyValueMessageKindStreamChunk:      streamHandler,
+		proto.KeyValueMessageKindStreamEnd:        streamHandler,
+		proto.KeyValueMessageKindGetStream:        streamHandler,
+	}
+}
+
 // PutOrUpdateHandler handles the PutOrUpdate request.
 type PutOrUpdateHandler struct {
-	store *store.InMemoryStore
+	store    store.Store
+	watchers *Watchers
 }
 
-// NewPutOrUpdateHandler creates a new instance of PutOrUpdateHandler.
-func NewPutOrUpdateHandler(store *store.InMemoryStore) Handler {
+// NewPutOrUpdateHandler creates a new instance of PutOrUpdateHandler, which notifies the watchers of the changed keys.
+func NewPutOrUpdateHandler(store store.Store, watchers *Watchers) Handler {
 	return PutOrUpdateHandler{
-		store: store,
+		store:    store,
+		watchers: watchers,
 	}
 }
 
 // Handle handles the incoming message.
 // It considers that the message is a proto.KeyValueMessageKindPutOrUpdate.
+// The key expires if the message carries a time to live.
+// The response has proto.Status_NotDurable if the store could not make the value durable.
 func (handler PutOrUpdateHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
-	handler.store.PutOrUpdate(message.Key, message.Value)
-	return proto.NewPutOrUpdateKeyValueSuccessfulResponseMessage().Serialize()
+	var err error
+	handler.watchers.Change([][]byte{message.RawKey()}, func() {
+		if err = handler.store.PutOrUpdateWithTTL(message.RawKey(), message.RawValue(), message.TimeToLive()); err == nil {
+			handler.watchers.NotifyPut(message.RawKey(), message.RawValue())
+		}
+	})
+	if err != nil {
+		return proto.NewPutOrUpdateKeyValueNotDurableResponseMessage().AnsweringTo(message).Serialize()
+	}
+	return proto.NewPutOrUpdateKeyValueSuccessfulResponseMessage().AnsweringTo(message).Serialize()
 }
 
 // GetHandler handles the Get request.
 type GetHandler struct {
-	store *store.InMemoryStore
+	store store.Store
 }
 
 // NewGetHandler creates a new instance of GetHandler.
-func NewGetHandler(store *store.InMemoryStore) Handler {
+func NewGetHandler(store store.Store) Handler {
 	return GetHandler{
 		store: store,
 	}
@@ -44,14 +104,663 @@ func NewGetHandler(store *store.InMemoryStore) Handler {
 // Handle handles the incoming message.
 // It considers that the message is a proto.KeyValueMessageKindGet.
 func (handler GetHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
-	value, ok := handler.store.GetValue(message.Key)
+	value, version, ok := handler.store.GetVersionedValue(message.RawKey())
 	var buffer []byte
 	var err error
 
 	if !ok {
-		buffer, err = proto.NewGetValueUnsuccessfulResponseMessage(message.Key).Serialize()
+		buffer, err = proto.NewGetValueUnsuccessfulResponseMessage(message.RawKey()).AnsweringTo(message).Serialize()
 	} else {
-		buffer, err = proto.NewGetValueSuccessfulResponseMessage(message.Key, value).Serialize()
+		buffer, err = proto.NewGetValueSuccessfulResponseMessage(message.RawKey(), value, version).AnsweringTo(message).Serialize()
 	}
 	return buffer, err
 }
+
+// DeleteHandler handles the Delete request.
+type DeleteHandler struct {
+	store    store.Store
+	watchers *Watchers
+}
+
+// NewDeleteHandler creates a new instance of DeleteHandler, which notifies the watchers of the changed keys.
+func NewDeleteHandler(store store.Store, watchers *Watchers) Handler {
+	return DeleteHandler{
+		store:    store,
+		watchers: watchers,
+	}
+}
+
+// Handle handles the incoming message.
+// It considers that the message is a proto.KeyValueMessageKindDelete.
+// The response has proto.Status_Ok if the key existed, and proto.Status_NotOk otherwise, or proto.Status_NotDurable
+// if the store could not make the deletion durable.
+func (handler DeleteHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
+	var ok bool
+	var err error
+	handler.watchers.Change([][]byte{message.RawKey()}, func() {
+		if ok, err = handler.store.Delete(message.RawKey()); ok && err == nil {
+			handler.watchers.NotifyDelete(message.RawKey())
+		}
+	})
+	if err != nil {
+		return proto.NewDeleteNotDurableResponseMessage(message.RawKey()).AnsweringTo(message).Serialize()
+	}
+	if !ok {
+		return proto.NewDeleteUnsuccessfulResponseMessage(message.RawKey()).AnsweringTo(message).Serialize()
+	}
+	return proto.NewDeleteSuccessfulResponseMessage(message.RawKey()).AnsweringTo(message).Serialize()
+}
+
+// CompareAndSwapHandler handles the CompareAndSwap request.
+type CompareAndSwapHandler struct {
+	store    store.Store
+	watchers *Watchers
+}
+
+// NewCompareAndSwapHandler creates a new instance of CompareAndSwapHandler, which notifies the watchers of the changed keys.
+func NewCompareAndSwapHandler(store store.Store, watchers *Watchers) Handler {
+	return CompareAndSwapHandler{
+		store:    store,
+		watchers: watchers,
+	}
+}
+
+// Handle handles the incoming message.
+// It considers that the message is a proto.KeyValueMessageKindCompareAndSwap.
+// The swapped value expires if the message carries a time to live.
+// The response carries the new version of the key, or proto.Status_Conflict along with the current version of the key,
+// or proto.Status_NotDurable if the store could not make the swapped value durable.
+func (handler CompareAndSwapHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
+	var version uint64
+	var ok bool
+	var err error
+	handler.watchers.Change([][]byte{message.RawKey()}, func() {
+		version, ok, err = handler.store.CompareAndSwapWithTTL(message.RawKey(), message.Version, message.RawValue(), message.TimeToLive())
+		if ok && err == nil {
+			handler.watchers.NotifyPut(message.RawKey(), message.RawValue())
+		}
+	})
+	if err != nil {
+		return proto.NewCompareAndSwapNotDurableResponseMessage(message.RawKey()).AnsweringTo(message).Serialize()
+	}
+	if !ok {
+		return proto.NewCompareAndSwapConflictResponseMessage(message.RawKey(), version).AnsweringTo(message).Serialize()
+	}
+	return proto.NewCompareAndSwapSuccessfulResponseMessage(message.RawKey(), version).AnsweringTo(message).Serialize()
+}
+
+// MultiGetHandler handles the MultiGet request.
+type MultiGetHandler struct {
+	store store.Store
+}
+
+// NewMultiGetHandler creates a new instance of MultiGetHandler.
+func NewMultiGetHandler(store store.Store) Handler {
+	return MultiGetHandler{
+		store: store,
+	}
+}
+
+// Handle handles the incoming message.
+// It considers that the message is a proto.KeyValueMessageKindMultiGet.
+// The response carries a pair for every requested key, in the order of the request.
+func (handler MultiGetHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
+	keys := make([][]byte, 0, len(message.Pairs))
+	for _, pair := range message.Pairs {
+		keys = append(keys, pair.RawKey())
+	}
+
+	pairs := make([]*proto.KeyValuePair, 0, len(keys))
+	for _, value := range handler.store.MultiGet(keys) {
+		pair := &proto.KeyValuePair{KeyBytes: value.Key, Status: proto.Status_NotOk}
+		if value.Exists {
+			pair.ValueBytes, pair.Version, pair.Status = value.Value, value.Version, proto.Status_Ok
+		}
+		pairs = append(pairs, pair)
+	}
+	return proto.NewMultiGetResponseMessage(pairs).AnsweringTo(message).Serialize()
+}
+
+// MultiPutOrUpdateHandler handles the MultiPutOrUpdate request.
+type MultiPutOrUpdateHandler struct {
+	store    store.Store
+	watchers *Watchers
+}
+
+// NewMultiPutOrUpdateHandler creates a new instance of MultiPutOrUpdateHandler, which notifies the watchers of the changed keys.
+func NewMultiPutOrUpdateHandler(store store.Store, watchers *Watchers) Handler {
+	return MultiPutOrUpdateHandler{
+		store:    store,
+		watchers: watchers,
+	}
+}
+
+// Handle handles the incoming message.
+// It considers that the message is a proto.KeyValueMessageKindMultiPutOrUpdate.
+// All the pairs are applied atomically, and the response carries the new version of every key, or
+// proto.Status_NotDurable if the store could not make the pairs durable.
+func (handler MultiPutOrUpdateHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
+	keyValuePairs := make([]store.KeyValuePair, 0, len(message.Pairs))
+	keys := make([][]byte, 0, len(message.Pairs))
+	for _, pair := range message.Pairs {
+		keyValuePairs = append(keyValuePairs, store.KeyValuePair{Key: pair.RawKey(), Value: pair.RawValue()})
+		keys = append(keys, pair.RawKey())
+	}
+
+	var versions []uint64
+	var err error
+	handler.watchers.Change(keys, func() {
+		if versions, err = handler.store.MultiPutOrUpdate(keyValuePairs); err != nil {
+			return
+		}
+		for _, pair := range keyValuePairs {
+			handler.watchers.NotifyPut(pair.Key, pair.Value)
+		}
+	})
+	if err != nil {
+		return proto.NewMultiPutOrUpdateNotDurableResponseMessage().AnsweringTo(message).Serialize()
+	}
+
+	pairs := make([]*proto.KeyValuePair, 0, len(versions))
+	for index, version := range versions {
+		pairs = append(pairs, &proto.KeyValuePair{KeyBytes: keyValuePairs[index].Key, Version: version, Status: proto.Status_Ok})
+	}
+	return proto.NewMultiPutOrUpdateResponseMessage(pairs).AnsweringTo(message).Serialize()
+}
+
+// ScanHandler handles the Scan and the PrefixScan requests.
+type ScanHandler struct {
+	store store.Store
+}
+
+// NewScanHandler creates a new instance of ScanHandler.
+func NewScanHandler(store store.Store) Handler {
+	return ScanHandler{
+		store: store,
+	}
+}
+
+// Handle handles the incoming message.
+// It considers that the message is either a proto.KeyValueMessageKindScan or a proto.KeyValueMessageKindPrefixScan.
+// The response is a stream of frames: one proto.KeyValueMessageKindScanResponse for every key,
+// followed by a proto.KeyValueMessageKindScanEnd. All the frames carry the request id.
+func (handler ScanHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
+	var values []store.VersionedKeyValue
+	if message.Kind == proto.KeyValueMessageKindPrefixScan {
+		values = handler.store.PrefixScan(message.RawKey(), int(message.Limit))
+	} else {
+		values = handler.store.Scan(message.RawKey(), message.RawEndKey(), int(message.Limit))
+	}
+
+	responses := make([]*proto.KeyValueMessage, 0, len(values)+1)
+	for _, value := range values {
+		responses = append(responses, proto.NewScanResponseMessage(value.Key, value.Value, value.Version).AnsweringTo(message))
+	}
+	responses = append(responses, proto.NewScanEndMessage().AnsweringTo(message))
+	return proto.SerializeAll(responses)
+}
+
+// TimeToLiveHandler handles the TimeToLive request.
+type TimeToLiveHandler struct {
+	store store.Store
+}
+
+// NewTimeToLiveHandler creates a new instance of TimeToLiveHandler.
+func NewTimeToLiveHandler(store store.Store) Handler {
+	return TimeToLiveHandler{
+		store: store,
+	}
+}
+
+// Handle handles the incoming message.
+// It considers that the message is a proto.KeyValueMessageKindTimeToLive.
+func (handler TimeToLiveHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
+	ttl, ok := handler.store.TimeToLive(message.RawKey())
+	if !ok {
+		return proto.NewTimeToLiveUnsuccessfulResponseMessage(message.RawKey()).AnsweringTo(message).Serialize()
+	}
+	return proto.NewTimeToLiveSuccessfulResponseMessage(message.RawKey(), ttl).AnsweringTo(message).Serialize()
+}
+
+// IncrementByHandler handles the IncrementBy request.
+type IncrementByHandler struct {
+	store    store.Store
+	watchers *Watchers
+}
+
+// NewIncrementByHandler creates a new instance of IncrementByHandler, which notifies the watchers of the changed keys.
+func NewIncrementByHandler(store store.Store, watchers *Watchers) Handler {
+	return IncrementByHandler{
+		store:    store,
+		watchers: watchers,
+	}
+}
+
+// Handle handles the incoming message.
+// It considers that the message is a proto.KeyValueMessageKindIncrementBy.
+// The increment is applied atomically by the store, so concurrent increments are never lost.
+// The response carries the new value, or proto.Status_NotANumber/proto.Status_Overflow, or proto.Status_NotDurable
+// if the store could not make the new value durable.
+func (handler IncrementByHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
+	var counter int64
+	var err error
+	handler.watchers.Change([][]byte{message.RawKey()}, func() {
+		if counter, err = handler.store.IncrementBy(message.RawKey(), message.Delta); err == nil {
+			handler.watchers.NotifyPut(message.RawKey(), []byte(strconv.FormatInt(counter, 10)))
+		}
+	})
+	if err != nil {
+		status := proto.Status_NotDurable
+		switch {
+		case errors.Is(err, store.ErrNotANumber):
+			status = proto.Status_NotANumber
+		case errors.Is(err, store.ErrCounterOverflow):
+			status = proto.Status_Overflow
+		}
+		return proto.NewIncrementByUnsuccessfulResponseMessage(message.RawKey(), status).AnsweringTo(message).Serialize()
+	}
+	return proto.NewIncrementBySuccessfulResponseMessage(message.RawKey(), counter).AnsweringTo(message).Serialize()
+}
+
+// HelloHandler handles the Hello request, which negotiates the protocol version and the features of a connection.
+type HelloHandler struct{}
+
+// NewHelloHandler creates a new instance of HelloHandler.
+func NewHelloHandler() Handler {
+	return HelloHandler{}
+}
+
+// Handle handles the incoming message.
+// It considers that the message is a proto.KeyValueMessageKindHello.
+// The agreed protocol version is the lower of the offered version and proto.ProtocolVersion, and the agreed
+// features are the offered features which are also in SupportedFeatures.
+// The response has proto.Status_NotOk if the offered version is older than proto.MinProtocolVersion.
+func (handler HelloHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
+	protocolVersion, features, ok := negotiate(message)
+	if !ok {
+		return proto.NewHelloUnsuccessfulResponseMessage(proto.ProtocolVersion).AnsweringTo(message).Serialize()
+	}
+	return proto.NewHelloSuccessfulResponseMessage(protocolVersion, features).AnsweringTo(message).Serialize()
+}
+
+// PingHandler handles the Ping request of a client, and the Pong which answers the Ping of the server.
+type PingHandler struct{}
+
+// NewPingHandler creates a new instance of PingHandler.
+func NewPingHandler() Handler {
+	return PingHandler{}
+}
+
+// Handle handles the incoming message.
+// A Ping is answered with a Pong. A Pong is not answered, so its response is empty; the connection is alive
+// because it has sent a frame (see Liveness).
+func (handler PingHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
+	if message.Kind == proto.KeyValueMessageKindPong {
+		return []byte{}, nil
+	}
+	return proto.NewPongMessage().AnsweringTo(message).Serialize()
+}
+
+// WatchHandler handles the Watch and the PrefixWatch requests.
+type WatchHandler struct {
+	watchers *Watchers
+}
+
+// NewWatchHandler creates a new instance of WatchHandler, which watches the keys in the given watchers.
+func NewWatchHandler(watchers *Watchers) SubscribingHandler {
+	return WatchHandler{
+		watchers: watchers,
+	}
+}
+
+// Handle handles the incoming message for a connection which can not receive notifications.
+// It considers that the message is either a proto.KeyValueMessageKindWatch or a proto.KeyValueMessageKindPrefixWatch,
+// and the response has proto.Status_NotOk.
+func (handler WatchHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
+	return proto.NewWatchUnsuccessfulResponseMessage().AnsweringTo(message).Serialize()
+}
+
+// HandleFor handles the incoming message on behalf of the subscriber.
+// It considers that the message is either a proto.KeyValueMessageKindWatch or a proto.KeyValueMessageKindPrefixWatch.
+// From then on, the subscriber is notified of the puts and the deletes of the watched keys, till it unsubscribes.
+func (handler WatchHandler) HandleFor(subscriber Subscriber, message *proto.KeyValueMessage) ([]byte, error) {
+	handler.watchers.Watch(subscriber, message)
+	return proto.NewWatchSuccessfulResponseMessage().AnsweringTo(message).Serialize()
+}
+
+// Unsubscribe unwatches all the keys which are watched by the subscriber.
+func (handler WatchHandler) Unsubscribe(subscriber Subscriber) {
+	handler.watchers.Unwatch(subscriber)
+}
+
+// PublishHandler handles the Publish request.
+type PublishHandler struct {
+	broker *Broker
+}
+
+// NewPublishHandler creates a new instance of PublishHandler, which publishes to the given broker.
+func NewPublishHandler(broker *Broker) Handler {
+	return PublishHandler{
+		broker: broker,
+	}
+}
+
+// Handle handles the incoming message.
+// It considers that the message is a proto.KeyValueMessageKindPublish.
+// The message is only queued for the subscribers, so the response does not wait for them.
+func (handler PublishHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
+	handler.broker.Publish(message.RawKey(), message.RawValue())
+	return proto.NewPublishSuccessfulResponseMessage().AnsweringTo(message).Serialize()
+}
+
+// SubscribeHandler handles the Subscribe, the PatternSubscribe and the Unsubscribe requests.
+type SubscribeHandler struct {
+	broker *Broker
+}
+
+// NewSubscribeHandler creates a new instance of SubscribeHandler, which subscribes to the given broker.
+func NewSubscribeHandler(broker *Broker) SubscribingHandler {
+	return SubscribeHandler{
+		broker: broker,
+	}
+}
+
+// Handle handles the incoming message for a connection which can not receive messages.
+// The response has proto.Status_NotOk.
+func (handler SubscribeHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
+	return proto.NewSubscribeUnsuccessfulResponseMessage().AnsweringTo(message).Serialize()
+}
+
+// HandleFor handles the incoming message on behalf of the subscriber.
+// It considers that the message is a proto.KeyValueMessageKindSubscribe, a proto.KeyValueMessageKindPatternSubscribe
+// or a proto.KeyValueMessageKindUnsubscribe.
+func (handler SubscribeHandler) HandleFor(subscriber Subscriber, message *proto.KeyValueMessage) ([]byte, error) {
+	if message.Kind == proto.KeyValueMessageKindUnsubscribe {
+		handler.broker.Unsubscribe(subscriber, message)
+	} else {
+		handler.broker.Subscribe(subscriber, message)
+	}
+	return proto.NewSubscribeSuccessfulResponseMessage().AnsweringTo(message).Serialize()
+}
+
+// Unsubscribe unsubscribes the subscriber from all the channels and the patterns.
+func (handler SubscribeHandler) Unsubscribe(subscriber Subscriber) {
+	handler.broker.UnsubscribeAll(subscriber)
+}
+
+// TransactionHandler handles the Multi, the Exec, the Discard and the OptimisticWatch requests.
+type TransactionHandler struct {
+	store    store.Store
+	watchers *Watchers
+}
+
+// NewTransactionHandler creates a new instance of TransactionHandler, which notifies the watchers of the keys which
+// are changed by a transaction.
+func NewTransactionHandler(store store.Store, watchers *Watchers) TransactionalHandler {
+	return TransactionHandler{
+		store:    store,
+		watchers: watchers,
+	}
+}
+
+// Handle handles the incoming message for a connection which does not support transactions.
+// It considers that the message is a proto.KeyValueMessageKindMulti, a proto.KeyValueMessageKindExec,
+// a proto.KeyValueMessageKindDiscard or a proto.KeyValueMessageKindOptimisticWatch, and the response has
+// proto.Status_NotOk.
+func (handler TransactionHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
+	if message.Kind == proto.KeyValueMessageKindExec {
+		return proto.NewExecUnsuccessfulResponseMessage().AnsweringTo(message).Serialize()
+	}
+	return proto.NewTransactionUnsuccessfulResponseMessage().AnsweringTo(message).Serialize()
+}
+
+// HandleIn handles the incoming message in the transaction of the connection.
+// A Multi opens the transaction, and an OptimisticWatch records the current versions of its keys; both are answered
+// with proto.Status_NotOk inside an open transaction. An Exec (or a Discard) without an open transaction is answered
+// with proto.Status_NotOk.
+func (handler TransactionHandler) HandleIn(transaction *Transaction, message *proto.KeyValueMessage) ([]byte, error) {
+	switch message.Kind {
+	case proto.KeyValueMessageKindExec:
+		if !transaction.Open() {
+			return proto.NewExecUnsuccessfulResponseMessage().AnsweringTo(message).Serialize()
+		}
+		return handler.exec(transaction, message)
+	case proto.KeyValueMessageKindMulti:
+		if transaction.Open() {
+			return proto.NewTransactionUnsuccessfulResponseMessage().AnsweringTo(message).Serialize()
+		}
+		transaction.open = true
+	case proto.KeyValueMessageKindDiscard:
+		if !transaction.Open() {
+			return proto.NewTransactionUnsuccessfulResponseMessage().AnsweringTo(message).Serialize()
+		}
+		transaction.reset()
+	case proto.KeyValueMessageKindOptimisticWatch:
+		if transaction.Open() {
+			return proto.NewTransactionUnsuccessfulResponseMessage().AnsweringTo(message).Serialize()
+		}
+		for _, pair := range message.Pairs {
+			_, version, _ := handler.store.GetVersionedValue(pair.RawKey())
+			transaction.watched = append(transaction.watched, store.KeyVersion{Key: pair.RawKey(), Version: version})
+		}
+	}
+	return proto.NewTransactionSuccessfulResponseMessage().AnsweringTo(message).Serialize()
+}
+
+// exec applies the queued requests of the transaction atomically, ends the transaction, and notifies the watchers
+// of the changed keys.
+// An exec which the store could not make durable is answered with proto.Status_NotDurable.
+func (handler TransactionHandler) exec(transaction *Transaction, message *proto.KeyValueMessage) ([]byte, error) {
+	operations := transaction.operations()
+	keys := make([][]byte, 0, len(operations))
+	for _, operation := range operations {
+		if operation.Kind != store.OperationGet {
+			keys = append(keys, operation.Key)
+		}
+	}
+	var results []store.VersionedKeyValue
+	var ok bool
+	var err error
+	handler.watchers.Change(keys, func() {
+		if results, ok, err = handler.store.Transact(transaction.watched, operations); !ok || err != nil {
+			return
+		}
+		for index, result := range results {
+			switch {
+			case operations[index].Kind == store.OperationPutOrUpdate:
+				handler.watchers.NotifyPut(result.Key, operations[index].Value)
+			case operations[index].Kind == store.OperationDelete && result.Exists:
+				handler.watchers.NotifyDelete(result.Key)
+			}
+		}
+	})
+	transaction.reset()
+	if err != nil {
+		return proto.NewExecNotDurableResponseMessage().AnsweringTo(message).Serialize()
+	}
+	if !ok {
+		return proto.NewExecConflictResponseMessage().AnsweringTo(message).Serialize()
+	}
+
+	pairs := make([]*proto.KeyValuePair, 0, len(results))
+	for index, result := range results {
+		pair := &proto.KeyValuePair{KeyBytes: result.Key, Status: proto.Status_Ok}
+		switch operations[index].Kind {
+		case store.OperationPutOrUpdate:
+			pair.Version = result.Version
+		case store.OperationGet:
+			if result.Exists {
+				pair.ValueBytes, pair.Version = result.Value, result.Version
+			}
+		}
+		if !result.Exists {
+			pair.Status = proto.Status_NotOk
+		}
+		pairs = append(pairs, pair)
+	}
+	return proto.NewExecResponseMessage(pairs).AnsweringTo(message).Serialize()
+}
+
+// AuthHandler handles the Auth request.
+type AuthHandler struct {
+	authenticator *Authenticator
+}
+
+// NewAuthHandler creates a new instance of AuthHandler, which authenticates the connections with the given
+// authenticator. A nil authenticator denotes that the connections need not authenticate.
+func NewAuthHandler(authenticator *Authenticator) AuthenticatingHandler {
+	return AuthHandler{
+		authenticator: authenticator,
+	}
+}
+
+// Handle handles the incoming message for a connection which can not be authenticated.
+// It considers that the message is a proto.KeyValueMessageKindAuth, and the response has proto.Status_NotOk.
+func (handler AuthHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
+	return proto.NewAuthUnsuccessfulResponseMessage().AnsweringTo(message).Serialize()
+}
+
+// RequiresAuthentication returns true if the handler has an Authenticator.
+func (handler AuthHandler) RequiresAuthentication() bool {
+	return handler.authenticator != nil
+}
+
+// Authenticate handles the incoming message, and returns the authenticated identity.
+// It considers that the message is a proto.KeyValueMessageKindAuth: a message without a key carries the shared token,
+// and a message with a key carries the username and the password.
+// The response has proto.Status_NotOk if the credentials are not valid, or if the handler has no Authenticator.
+func (handler AuthHandler) Authenticate(message *proto.KeyValueMessage) (*Identity, []byte, error) {
+	if handler.authenticator == nil {
+		buffer, err := handler.Handle(message)
+		return nil, buffer, err
+	}
+	var identity Identity
+	var ok bool
+	if len(message.RawKey()) == 0 {
+		identity, ok = handler.authenticator.AuthenticateToken(message.RawValue())
+	} else {
+		identity, ok = handler.authenticator.AuthenticatePassword(message.RawKey(), message.RawValue())
+	}
+	if !ok {
+		buffer, err := handler.Handle(message)
+		return nil, buffer, err
+	}
+	buffer, err := proto.NewAuthSuccessfulResponseMessage(identity.Username).AnsweringTo(message).Serialize()
+	return &identity, buffer, err
+}
+
+// negotiate returns the agreed protocol version and the agreed features for the given Hello,
+// and false if the offered protocol version is not supported.
+func negotiate(message *proto.KeyValueMessage) (uint32, uint32, bool) {
+	if message.ProtocolVersion < proto.MinProtocolVersion {
+		return 0, 0, false
+	}
+	return min(message.ProtocolVersion, proto.ProtocolVersion), message.Features & SupportedFeatures, true
+}
+
+// StreamHandler handles the put streams (the StreamBegin, the StreamChunk and the StreamEnd requests), and
+// the GetStream request.
+type StreamHandler struct {
+	store    store.Store
+	watchers *Watchers
+	budget   *StreamBudget
+}
+
+// NewStreamHandler creates a new instance of StreamHandler, which notifies the watchers of the keys which are put
+// by the streams. The put streams of all the connections share a StreamBudget of MaxStreamedBytes.
+func NewStreamHandler(store store.Store, watchers *Watchers) StreamingHandler {
+	return NewStreamHandlerWithBudget(store, watchers, NewStreamBudget(MaxStreamedBytes))
+}
+
+// NewStreamHandlerWithBudget creates a new instance of StreamHandler (see NewStreamHandler), whose put streams share
+// the given budget.
+func NewStreamHandlerWithBudget(store store.Store, watchers *Watchers, budget *StreamBudget) StreamingHandler {
+	return StreamHandler{
+		store:    store,
+		watchers: watchers,
+		budget:   budget,
+	}
+}
+
+// Handle handles the incoming message for a connection which does not support put streams.
+// A proto.KeyValueMessageKindGetStream is answered with the stream of the value, and the requests of a put stream
+// have proto.Status_NotOk.
+// The stream is serialized as a whole, because the connection can not pull its chunks (see HandleStream).
+func (handler StreamHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
+	if message.Kind != proto.KeyValueMessageKindGetStream {
+		return proto.NewStreamUnsuccessfulResponseMessage(message.StreamId, proto.Status_NotOk).AnsweringTo(message).Serialize()
+	}
+	value, version, ok := handler.store.GetVersionedValue(message.RawKey())
+	if !ok {
+		return proto.NewStreamUnsuccessfulResponseMessage(message.StreamId, proto.Status_NotOk).AnsweringTo(message).Serialize()
+	}
+	streams := &Streams{}
+	streams.Send(message, value, streamChunkSize(message))
+
+	responses := []*proto.KeyValueMessage{proto.NewGetStreamBeginMessage(message.StreamId, message.RawKey(), version).AnsweringTo(message)}
+	for response := streams.Next(); response != nil; response = streams.Next() {
+		responses = append(responses, response)
+	}
+	return proto.SerializeAll(responses)
+}
+
+// HandleStream handles the incoming message in the streams of the connection.
+// The StreamBegin and the StreamChunk requests are not answered (their response is empty) unless they fail, so that
+// a large value is uploaded without a round trip per chunk. A chunk which exceeds MaxStreamLength or the budget of
+// the handler aborts the stream, and is answered with proto.Status_TooLarge. The StreamEnd puts the reassembled value
+// into the store, and is answered with the version of the key.
+// A GetStream is answered with the StreamBegin of the value, whose chunks are sent to the streams of the connection
+// (see Streams.Send), so that the connection pulls them one at a time.
+func (handler StreamHandler) HandleStream(streams *Streams, message *proto.KeyValueMessage) ([]byte, error) {
+	switch message.Kind {
+	case proto.KeyValueMessageKindGetStream:
+		value, version, ok := handler.store.GetVersionedValue(message.RawKey())
+		if !ok {
+			return proto.NewStreamUnsuccessfulResponseMessage(message.StreamId, proto.Status_NotOk).AnsweringTo(message).Serialize()
+		}
+		streams.Send(message, value, streamChunkSize(message))
+		return proto.NewGetStreamBeginMessage(message.StreamId, message.RawKey(), version).AnsweringTo(message).Serialize()
+	case proto.KeyValueMessageKindStreamBegin:
+		if err := streams.Begin(message.StreamId, message.RawKey(), message.TimeToLive(), handler.budget); err != nil {
+			return proto.NewStreamUnsuccessfulResponseMessage(message.StreamId, proto.Status_NotOk).AnsweringTo(message).Serialize()
+		}
+		return []byte{}, nil
+	case proto.KeyValueMessageKindStreamChunk:
+		if err := streams.Append(message.StreamId, message.RawValue()); err != nil {
+			status := proto.Status_NotOk
+			if errors.Is(err, ErrStreamTooLarge) || errors.Is(err, ErrStreamBudget) {
+				status = proto.Status_TooLarge
+			}
+			return proto.NewStreamUnsuccessfulResponseMessage(message.StreamId, status).AnsweringTo(message).Serialize()
+		}
+		return []byte{}, nil
+	case proto.KeyValueMessageKindStreamEnd:
+		key, value, ttl, err := streams.End(message.StreamId)
+		if err != nil {
+			return proto.NewStreamUnsuccessfulResponseMessage(message.StreamId, proto.Status_NotOk).AnsweringTo(message).Serialize()
+		}
+		var results []store.VersionedKeyValue
+		handler.watchers.Change([][]byte{key}, func() {
+			results, _, err = handler.store.Transact(nil, []store.Operation{
+				{Kind: store.OperationPutOrUpdate, Key: key, Value: value, TTL: ttl},
+			})
+			if err == nil {
+				handler.watchers.NotifyPut(key, value)
+			}
+		})
+		if err != nil {
+			return proto.NewStreamUnsuccessfulResponseMessage(message.StreamId, proto.Status_NotDurable).AnsweringTo(message).Serialize()
+		}
+		return proto.NewPutStreamSuccessfulResponseMessage(message.StreamId, key, results[0].Version).AnsweringTo(message).Serialize()
+	}
+	return handler.Handle(message)
+}
+
+// streamChunkSize returns the chunk size of the get stream which is requested by the message: StreamChunkSize, or
+// the smaller chunk size of the request (at least MinStreamChunkSize).
+func streamChunkSize(message *proto.KeyValueMessage) int {
+	if message.Limit == 0 || int(message.Limit) >= StreamChunkSize {
+		return StreamChunkSize
+	}
+	return max(int(message.Limit), MinStreamChunkSize)
+}
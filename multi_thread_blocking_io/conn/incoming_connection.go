@@ -1,39 +1,71 @@
 package conn
 
 import (
+	"errors"
 	"multi_thread_blocking_io/proto"
-	"multi_thread_blocking_io/store"
 	"net"
+	"sync"
+	"time"
 )
 
 // IncomingTCPConnection represents the incoming TCP connection.
 type IncomingTCPConnection struct {
 	connectionReader      ConnectionReader
 	handlersByMessageType map[uint32]Handler
+	session               *Session
+	liveness              *Liveness
+	writeLock             *sync.Mutex
 	closeChannel          chan struct{}
 }
 
 // NewIncomingTCPConnection creates a new IncomingTCPConnection to handle incoming requests.
+// The handlers are shared by all the connections of a server (see NewHandlers), so that a connection is notified of
+// the changes which are made by the other connections to the keys it watches.
+// The notifications are written from the goroutines of the other connections, so the writes to the connection
+// are serialized by the writeLock.
+// The connection must authenticate first if the handlers require authentication (see NewSessionFor).
+// The connection is kept alive with the DefaultKeepalive.
 func NewIncomingTCPConnection(
 	connection net.Conn,
-	store *store.InMemoryStore,
+	handlers map[uint32]Handler,
 ) IncomingTCPConnection {
-	handlersByMessageType := map[uint32]Handler{
-		proto.KeyValueMessageKindPutOrUpdate: NewPutOrUpdateHandler(store),
-		proto.KeyValueMessageKindGet:         NewGetHandler(store),
-	}
-	return IncomingTCPConnection{
+	return NewIncomingTCPConnectionWithKeepalive(connection, handlers, DefaultKeepalive)
+}
+
+// NewIncomingTCPConnectionWithKeepalive creates a new IncomingTCPConnection (see NewIncomingTCPConnection), which is
+// pinged by the server when it is idle, and closed if it does not answer, as configured by the keepalive.
+func NewIncomingTCPConnectionWithKeepalive(
+	connection net.Conn,
+	handlers map[uint32]Handler,
+	keepalive Keepalive,
+) IncomingTCPConnection {
+	incomingConnection := IncomingTCPConnection{
 		connectionReader:      NewConnectionReader(connection),
-		handlersByMessageType: handlersByMessageType,
+		handlersByMessageType: handlers,
+		session:               NewSessionFor(handlers),
+		liveness:              NewLiveness(keepalive, time.Now()),
+		writeLock:             &sync.Mutex{},
 		closeChannel:          make(chan struct{}),
 	}
+	incomingConnection.session.PushTo(incomingConnection.write, incomingConnection.disconnect)
+	return incomingConnection
 }
 
 // Handle handles the incoming connection.
 // It runs an infinite loop, trying to read from the connection.
 // The method AttemptReadOrErrorOut() of ConnectionReader reads from the connection and returns the incoming message or an error.
 // The method returns if there is any error (including io.EOF) in reading from the connection.
+// Every message is handled by the Handler for its kind (see handle).
+// A corrupt request frame is answered with a proto.KeyValueMessageKindFrameError response. The connection continues
+// after a corrupt frame (such as a checksum mismatch), because the frame has been consumed; it is closed after
+// a malformed frame, because the position of the next frame is unknown.
+// A connection which is idle (including one which waits for the notifications of the watched keys, or the messages
+// of the subscribed channels) is pinged, and is closed if it does not answer the ping in time (see Liveness).
+// A connection which times out in the middle of a message is closed, because the position of the next message
+// is unknown.
+// The subscriptions of the connection are removed once it is closed.
 func (incomingConnection IncomingTCPConnection) Handle() {
+	defer incomingConnection.session.Close()
 	for {
 		select {
 		case <-incomingConnection.closeChannel:
@@ -41,36 +73,107 @@ func (incomingConnection IncomingTCPConnection) Handle() {
 		default:
 			incomingMessage, err := incomingConnection.connectionReader.AttemptReadOrErrorOut()
 			if err != nil {
+				if errors.Is(err, proto.ErrCorruptFrame) {
+					incomingConnection.handleFrameError()
+					continue
+				}
+				if errors.Is(err, proto.ErrMalformedFrame) {
+					incomingConnection.handleFrameError()
+				}
+				if isTimeout(err) {
+					if !errors.Is(err, errPartialFrame) && incomingConnection.keepAlive() {
+						continue
+					}
+					incomingConnection.disconnect()
+				}
 				return
 			}
-			switch incomingMessage.Kind {
-			case proto.KeyValueMessageKindPutOrUpdate:
-				incomingConnection.handlePutOrUpdate(incomingMessage)
-			case proto.KeyValueMessageKindGet:
-				incomingConnection.handleGet(incomingMessage)
+			if incomingMessage.Kind == proto.KeyValueMessageKindPing || incomingMessage.Kind == proto.KeyValueMessageKindPong {
+				incomingConnection.liveness.Heard(time.Now())
+			} else {
+				incomingConnection.liveness.HeardRequest(time.Now())
 			}
+			incomingConnection.handle(incomingMessage)
 		}
 	}
 }
 
+// Identity returns the authenticated identity of the connection, and false if the connection is not authenticated.
+func (incomingConnection IncomingTCPConnection) Identity() (Identity, bool) {
+	return incomingConnection.session.Identity()
+}
+
 // Close closes the IncomingTCPConnection.
 func (incomingConnection IncomingTCPConnection) Close() {
 	incomingConnection.connectionReader.Close()
 	close(incomingConnection.closeChannel)
 }
 
-// handlePutOrUpdate handles PutOrUpdate.
-func (incomingConnection IncomingTCPConnection) handlePutOrUpdate(message *proto.KeyValueMessage) {
-	buffer, err := incomingConnection.handlersByMessageType[message.Kind].Handle(message)
-	if err == nil {
-		_, _ = incomingConnection.connectionReader.connection.Write(buffer)
+// handle handles the message with the Handler for its kind, and writes the response (if any; an empty response,
+// such as the one to a Pong, is not written). A message of a kind which has no Handler is answered with
+// proto.Status_NotOk (see Session.UnknownKindResponse).
+// The response is followed by the frames of the get streams of the session (see Session.Pull), which are written
+// one at a time, before the next message is read.
+func (incomingConnection IncomingTCPConnection) handle(message *proto.KeyValueMessage) {
+	var buffer []byte
+	var err error
+	if handler, ok := incomingConnection.handlersByMessageType[message.Kind]; ok {
+		buffer, err = incomingConnection.session.Handle(handler, message)
+	} else {
+		buffer, err = incomingConnection.session.UnknownKindResponse(message)
+	}
+	if err == nil && len(buffer) > 0 {
+		err = incomingConnection.write(buffer)
+	}
+	for err == nil {
+		buffer, err = incomingConnection.session.Pull()
+		if err != nil || buffer == nil {
+			return
+		}
+		err = incomingConnection.write(buffer)
 	}
 }
 
-// handleGet handles Get.
-func (incomingConnection IncomingTCPConnection) handleGet(message *proto.KeyValueMessage) {
-	buffer, err := incomingConnection.handlersByMessageType[message.Kind].Handle(message)
+// handleFrameError handles a corrupt request frame.
+func (incomingConnection IncomingTCPConnection) handleFrameError() {
+	buffer, err := incomingConnection.session.FrameErrorResponse()
 	if err == nil {
-		_, _ = incomingConnection.connectionReader.connection.Write(buffer)
+		_ = incomingConnection.write(buffer)
 	}
 }
+
+// keepAlive pings the connection if it has been idle for too long, and returns false if the connection is not
+// alive: it has not answered the ping in time, or the ping could not be written.
+func (incomingConnection IncomingTCPConnection) keepAlive() bool {
+	ping, err := incomingConnection.liveness.Check(time.Now())
+	if err != nil {
+		return false
+	}
+	if !ping {
+		return true
+	}
+	buffer, err := incomingConnection.session.PingRequest()
+	if err != nil {
+		return false
+	}
+	return incomingConnection.write(buffer) == nil
+}
+
+// write writes the buffer to the connection, holding the writeLock.
+func (incomingConnection IncomingTCPConnection) write(buffer []byte) error {
+	incomingConnection.writeLock.Lock()
+	defer incomingConnection.writeLock.Unlock()
+	_, err := incomingConnection.connectionReader.connection.Write(buffer)
+	return err
+}
+
+// disconnect closes the connection, which ends the Handle loop.
+func (incomingConnection IncomingTCPConnection) disconnect() {
+	_ = incomingConnection.connectionReader.connection.Close()
+}
+
+// isTimeout returns true if the error is a timeout of the connection.
+func isTimeout(err error) bool {
+	var netError net.Error
+	return errors.As(err, &netError) && netError.Timeout()
+}
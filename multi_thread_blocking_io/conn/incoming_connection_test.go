@@ -3,6 +3,7 @@ package conn
 import (
 	"bufio"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"multi_thread_blocking_io/proto"
 	"multi_thread_blocking_io/store"
 	"net"
@@ -12,7 +13,7 @@ import (
 )
 
 func TestIncomingConnection(t *testing.T) {
-	inMemoryStore := store.NewInMemoryStore()
+	handlers := NewHandlers(store.NewInMemoryStore())
 
 	putOrUpdate := func() {
 		var wg sync.WaitGroup
@@ -24,7 +25,7 @@ func TestIncomingConnection(t *testing.T) {
 			_ = incoming.Close()
 		}()
 
-		incomingConnectionForPutOrUpdate := NewIncomingTCPConnection(incoming, inMemoryStore)
+		incomingConnectionForPutOrUpdate := NewIncomingTCPConnection(incoming, handlers)
 
 		go func() {
 			defer wg.Done()
@@ -54,7 +55,7 @@ func TestIncomingConnection(t *testing.T) {
 			_ = incoming.Close()
 		}()
 
-		incomingConnectionForGet := NewIncomingTCPConnection(incoming, inMemoryStore)
+		incomingConnectionForGet := NewIncomingTCPConnection(incoming, handlers)
 
 		go func() {
 			defer wg.Done()
@@ -66,7 +67,7 @@ func TestIncomingConnection(t *testing.T) {
 			message, err := proto.DeserializeFrom(bufio.NewReader(source))
 
 			assert.Nil(t, err)
-			assert.Equal(t, "NVMe SSD", message.Value)
+			assert.Equal(t, "NVMe SSD", string(message.RawValue()))
 		}()
 
 		buffer, _ := proto.NewGetValueMessage("DiskType").Serialize()
@@ -78,3 +79,51 @@ func TestIncomingConnection(t *testing.T) {
 	putOrUpdate()
 	get()
 }
+
+func TestIncomingConnectionAnswersAnUnknownKindWithNotOk(t *testing.T) {
+	source, incoming := net.Pipe()
+	defer func() {
+		_ = source.Close()
+		_ = incoming.Close()
+	}()
+
+	incomingConnection := NewIncomingTCPConnection(incoming, NewHandlers(store.NewInMemoryStore()))
+	go incomingConnection.Handle()
+	defer incomingConnection.Close()
+
+	buffer, _ := (&proto.KeyValueMessage{Kind: 1000, RequestId: 7}).Serialize()
+	_, _ = source.Write(buffer)
+
+	_ = source.SetReadDeadline(time.Now().Add(5 * time.Second))
+	message, err := proto.DeserializeFrom(bufio.NewReader(source))
+
+	assert.Nil(t, err)
+	assert.Equal(t, proto.KeyValueMessageKindFrameError, message.Kind)
+	assert.Equal(t, proto.Status_NotOk, message.Status)
+	assert.Equal(t, uint64(7), message.RequestId)
+}
+
+func TestIncomingConnectionIsClosedOnATimeoutInTheMiddleOfAMessage(t *testing.T) {
+	source, incoming := net.Pipe()
+	defer func() {
+		_ = source.Close()
+		_ = incoming.Close()
+	}()
+
+	incomingConnection := NewIncomingTCPConnection(incoming, NewHandlers(store.NewInMemoryStore()))
+	incomingConnection.connectionReader.frameTimeout = 100 * time.Millisecond
+	go incomingConnection.Handle()
+	defer incomingConnection.Close()
+
+	buffer, _ := proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe SSD").Serialize()
+	_, err := source.Write(buffer[:10])
+	assert.Nil(t, err)
+
+	time.Sleep(300 * time.Millisecond)
+	_, err = source.Write(buffer[10:])
+	assert.NotNil(t, err)
+
+	_ = source.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = source.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,62 @@
+package conn
+
+import (
+	"multi_thread_blocking_io/proto"
+	"multi_thread_blocking_io/store"
+)
+
+// Transaction represents the transaction of a connection: the versions of the keys of the OptimisticWatch requests,
+// and the requests which are queued between a Multi and an Exec (or a Discard).
+// A Transaction belongs to the Session of a single connection, and is not safe for concurrent use.
+type Transaction struct {
+	open    bool
+	watched []store.KeyVersion
+	queued  []*proto.KeyValueMessage
+}
+
+// TransactionalHandler is a Handler whose requests read or change the Transaction of the connection, such as
+// the Multi and the Exec requests.
+type TransactionalHandler interface {
+	Handler
+	// HandleIn handles the incoming message in the transaction of the connection.
+	HandleIn(transaction *Transaction, message *proto.KeyValueMessage) ([]byte, error)
+}
+
+// Open returns true between a Multi and an Exec (or a Discard), when the requests are queued.
+func (transaction *Transaction) Open() bool {
+	return transaction.open
+}
+
+// Queue queues the message till the Exec, and returns false if the message can not be queued in a transaction.
+// Only the PutOrUpdate, the Get and the Delete requests are queued.
+func (transaction *Transaction) Queue(message *proto.KeyValueMessage) bool {
+	switch message.Kind {
+	case proto.KeyValueMessageKindPutOrUpdate, proto.KeyValueMessageKindGet, proto.KeyValueMessageKindDelete:
+		transaction.queued = append(transaction.queued, message)
+		return true
+	}
+	return false
+}
+
+// operations returns the store operations of the queued requests, in the order of the requests.
+func (transaction *Transaction) operations() []store.Operation {
+	operations := make([]store.Operation, 0, len(transaction.queued))
+	for _, message := range transaction.queued {
+		operation := store.Operation{Key: message.RawKey()}
+		switch message.Kind {
+		case proto.KeyValueMessageKindPutOrUpdate:
+			operation.Kind, operation.Value, operation.TTL = store.OperationPutOrUpdate, message.RawValue(), message.TimeToLive()
+		case proto.KeyValueMessageKindGet:
+			operation.Kind = store.OperationGet
+		case proto.KeyValueMessageKindDelete:
+			operation.Kind = store.OperationDelete
+		}
+		operations = append(operations, operation)
+	}
+	return operations
+}
+
+// reset ends the transaction, and drops the queued requests and the watched keys.
+func (transaction *Transaction) reset() {
+	*transaction = Transaction{}
+}
@@ -0,0 +1,217 @@
+package conn
+
+import (
+	"bytes"
+	"hash/maphash"
+	"multi_thread_blocking_io/proto"
+	"slices"
+	"sync"
+)
+
+// Subscriber receives the notifications which are pushed to a connection, such as the ones of the keys it watches,
+// and the messages of the channels it subscribes to.
+// Notify is invoked from the goroutines of the other connections (or of the Broker), so it must be safe for
+// concurrent use. An error denotes that the notification could not be pushed, such as when the connection is too
+// slow to keep up.
+type Subscriber interface {
+	Notify(notification *proto.KeyValueMessage) error
+	// Disconnect closes the connection of the subscriber, such as when it is too slow to keep up.
+	Disconnect()
+}
+
+// SubscribingHandler is a Handler whose requests subscribe the connection to notifications, such as Watch.
+// Handle is used if the connection can not receive notifications.
+type SubscribingHandler interface {
+	Handler
+	// HandleFor handles the incoming message on behalf of the subscriber.
+	HandleFor(subscriber Subscriber, message *proto.KeyValueMessage) ([]byte, error)
+	// Unsubscribe removes all the subscriptions of the subscriber, once its connection is closed.
+	Unsubscribe(subscriber Subscriber)
+}
+
+// WatcherQueueLength is the number of notifications which are queued for a subscriber of the Watchers, before
+// the subscriber is disconnected for not keeping up.
+const WatcherQueueLength = 1024
+
+// watchedKeyLocks is the number of the locks which serialize the changes of the keys (see Watchers.Change).
+const watchedKeyLocks = 256
+
+// Watchers holds the keys and the prefixes which are watched by the subscribers, and notifies the subscribers
+// of the changes to those keys.
+// Every subscriber has a bounded queue of notifications, which is drained by a goroutine of the subscriber (as in
+// the Broker), so a slow (or stuck) subscriber never blocks the connections which change the keys. A subscriber whose
+// queue is full (or which fails a notification) is disconnected, because a watcher which silently misses a change
+// would hold a stale value.
+// The changes of a key are run under a lock of the key (see Change), so its notifications are queued in the order of
+// its changes.
+// Watchers is safe for concurrent use, and is shared by all the connections of a server.
+type Watchers struct {
+	lock     sync.RWMutex
+	keys     map[string]map[Subscriber]*proto.KeyValueMessage
+	prefixes map[string]map[Subscriber]*proto.KeyValueMessage
+	queues   map[Subscriber]*subscriberQueue
+	keyLocks [watchedKeyLocks]sync.Mutex
+	seed     maphash.Seed
+}
+
+// NewWatchers creates a new instance of Watchers, without any subscribers.
+func NewWatchers() *Watchers {
+	return &Watchers{
+		keys:     make(map[string]map[Subscriber]*proto.KeyValueMessage),
+		prefixes: make(map[string]map[Subscriber]*proto.KeyValueMessage),
+		queues:   make(map[Subscriber]*subscriberQueue),
+		seed:     maphash.MakeSeed(),
+	}
+}
+
+// Watch watches the keys (or the prefixes) of the Watch (or the PrefixWatch) request on behalf of the subscriber.
+// The notifications answer the request, so they carry its request id. Watching the same key again replaces the
+// request which watched it.
+func (watchers *Watchers) Watch(subscriber Subscriber, request *proto.KeyValueMessage) {
+	watchers.lock.Lock()
+	defer watchers.lock.Unlock()
+
+	watched := watchers.keys
+	if request.Kind == proto.KeyValueMessageKindPrefixWatch {
+		watched = watchers.prefixes
+	}
+	for _, pair := range request.Pairs {
+		key := string(pair.RawKey())
+		if watched[key] == nil {
+			watched[key] = make(map[Subscriber]*proto.KeyValueMessage)
+		}
+		watched[key][subscriber] = request
+	}
+	if watchers.queues[subscriber] == nil {
+		queue := &subscriberQueue{
+			messages: make(chan *proto.KeyValueMessage, WatcherQueueLength),
+			closed:   make(chan struct{}),
+		}
+		watchers.queues[subscriber] = queue
+		go watchers.deliver(subscriber, queue)
+	}
+}
+
+// Unwatch removes all the keys and the prefixes which are watched by the subscriber, and stops the delivery of its
+// queue.
+func (watchers *Watchers) Unwatch(subscriber Subscriber) {
+	watchers.unwatch(subscriber)
+}
+
+// Change runs the change of the given keys (a mutation of the store, followed by the notifications of the changed
+// keys) holding the locks of the keys, so that the concurrent changes of a key are notified in the order in which
+// they are applied (and so in the order of the versions of the key).
+func (watchers *Watchers) Change(keys [][]byte, change func()) {
+	indices := make([]int, 0, len(keys))
+	for _, key := range keys {
+		indices = append(indices, int(maphash.Bytes(watchers.seed, key)%watchedKeyLocks))
+	}
+	slices.Sort(indices)
+	indices = slices.Compact(indices)
+	for _, index := range indices {
+		watchers.keyLocks[index].Lock()
+	}
+	defer func() {
+		for _, index := range indices {
+			watchers.keyLocks[index].Unlock()
+		}
+	}()
+	change()
+}
+
+// NotifyPut notifies the subscribers which watch the key that it is put with the given value.
+// It is expected to be invoked in the Change of the key.
+func (watchers *Watchers) NotifyPut(key, value []byte) {
+	watchers.notify(key, func() *proto.KeyValueMessage {
+		return proto.NewWatchNotificationMessage(key, value)
+	})
+}
+
+// NotifyDelete notifies the subscribers which watch the key that it is deleted.
+// It is expected to be invoked in the Change of the key.
+func (watchers *Watchers) NotifyDelete(key []byte) {
+	watchers.notify(key, func() *proto.KeyValueMessage {
+		return proto.NewWatchDeleteNotificationMessage(key)
+	})
+}
+
+// notify queues a new notification once for every subscriber which watches the key (or a prefix of the key).
+// The notifications are queued after the lock is released, and a subscriber whose queue is full is disconnected.
+func (watchers *Watchers) notify(key []byte, newNotification func() *proto.KeyValueMessage) {
+	type delivery struct {
+		subscriber Subscriber
+		request    *proto.KeyValueMessage
+		queue      *subscriberQueue
+	}
+
+	watchers.lock.RLock()
+	requests := make(map[Subscriber]*proto.KeyValueMessage)
+	for prefix, subscribers := range watchers.prefixes {
+		if bytes.HasPrefix(key, []byte(prefix)) {
+			for subscriber, request := range subscribers {
+				requests[subscriber] = request
+			}
+		}
+	}
+	for subscriber, request := range watchers.keys[string(key)] {
+		requests[subscriber] = request
+	}
+	deliveries := make([]delivery, 0, len(requests))
+	for subscriber, request := range requests {
+		deliveries = append(deliveries, delivery{subscriber: subscriber, request: request, queue: watchers.queues[subscriber]})
+	}
+	watchers.lock.RUnlock()
+
+	for _, delivery := range deliveries {
+		select {
+		case delivery.queue.messages <- newNotification().AnsweringTo(delivery.request):
+		default:
+			watchers.overflow(delivery.subscriber)
+		}
+	}
+}
+
+// deliver runs in a goroutine of the subscriber, and pushes the queued notifications to the subscriber till its
+// queue is closed.
+func (watchers *Watchers) deliver(subscriber Subscriber, queue *subscriberQueue) {
+	for {
+		select {
+		case <-queue.closed:
+			return
+		case notification := <-queue.messages:
+			if err := subscriber.Notify(notification); err != nil {
+				watchers.overflow(subscriber)
+			}
+		}
+	}
+}
+
+// overflow unwatches and disconnects a subscriber which does not keep up. A subscriber is disconnected once, even if
+// many changes overflow its queue at the same time.
+func (watchers *Watchers) overflow(subscriber Subscriber) {
+	if watchers.unwatch(subscriber) {
+		subscriber.Disconnect()
+	}
+}
+
+// unwatch removes all the keys and the prefixes which are watched by the subscriber, and returns false if it had no
+// queue (it has never watched, or is already unwatched).
+func (watchers *Watchers) unwatch(subscriber Subscriber) bool {
+	watchers.lock.Lock()
+	defer watchers.lock.Unlock()
+
+	for _, watched := range []map[string]map[Subscriber]*proto.KeyValueMessage{watchers.keys, watchers.prefixes} {
+		for key, subscribers := range watched {
+			delete(subscribers, subscriber)
+			if len(subscribers) == 0 {
+				delete(watched, key)
+			}
+		}
+	}
+	queue, ok := watchers.queues[subscriber]
+	if ok {
+		close(queue.closed)
+		delete(watchers.queues, subscriber)
+	}
+	return ok
+}
@@ -0,0 +1,110 @@
+package conn
+
+import (
+	"github.com/stretchr/testify/assert"
+	"multi_thread_blocking_io/proto"
+	"multi_thread_blocking_io/store"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNotifiesTheSubscribersOfAWatchedKey(t *testing.T) {
+	watchers := NewWatchers()
+	subscriber := newChannelSubscriber(16)
+	watchers.Watch(subscriber, proto.NewWatchMessage("DiskType", "Engine").WithRequestId(7))
+
+	watchers.NotifyPut([]byte("DiskType"), []byte("NVMe SSD"))
+	watchers.NotifyPut([]byte("Unknown"), []byte("HDD"))
+	watchers.NotifyDelete([]byte("Engine"))
+
+	notification := receive(t, subscriber)
+	assert.Equal(t, proto.KeyValueMessageKindWatchNotification, notification.Kind)
+	assert.Equal(t, uint64(7), notification.RequestId)
+	assert.Equal(t, proto.Status_Ok, notification.Status)
+	assert.Equal(t, "NVMe SSD", string(notification.RawValue()))
+
+	notification = receive(t, subscriber)
+	assert.Equal(t, "Engine", string(notification.RawKey()))
+	assert.Equal(t, proto.Status_NotOk, notification.Status)
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, len(subscriber.messages))
+}
+
+func TestNotifiesTheSubscribersOfAWatchedPrefixOnce(t *testing.T) {
+	watchers := NewWatchers()
+	subscriber := newChannelSubscriber(16)
+	watchers.Watch(subscriber, proto.NewPrefixWatchMessage("Disk", "DiskType"))
+
+	watchers.NotifyPut([]byte("DiskType"), []byte("NVMe SSD"))
+	watchers.NotifyPut([]byte("Engine"), []byte("skiplist"))
+
+	assert.Equal(t, "DiskType", string(receive(t, subscriber).RawKey()))
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, len(subscriber.messages))
+}
+
+func TestDoesNotNotifyAnUnwatchedSubscriber(t *testing.T) {
+	watchers := NewWatchers()
+	subscriber, other := newChannelSubscriber(16), newChannelSubscriber(16)
+	watchers.Watch(subscriber, proto.NewWatchMessage("DiskType"))
+	watchers.Watch(other, proto.NewPrefixWatchMessage("Disk"))
+
+	watchers.Unwatch(subscriber)
+	watchers.NotifyPut([]byte("DiskType"), []byte("NVMe SSD"))
+
+	assert.Equal(t, "NVMe SSD", string(receive(t, other).RawValue()))
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, len(subscriber.messages))
+}
+
+func TestDisconnectsAStuckWatcherWithoutBlockingTheChanges(t *testing.T) {
+	watchers := NewWatchers()
+	stuck := newChannelSubscriber(16)
+	stuck.release = make(chan struct{})
+	watchers.Watch(stuck, proto.NewWatchMessage("DiskType"))
+
+	changed := make(chan struct{})
+	go func() {
+		defer close(changed)
+		for count := 0; count < 2*WatcherQueueLength; count++ {
+			watchers.Change([][]byte{[]byte("DiskType")}, func() {
+				watchers.NotifyPut([]byte("DiskType"), []byte("NVMe SSD"))
+			})
+		}
+	}()
+
+	for _, done := range []chan struct{}{changed, stuck.disconnected} {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			assert.Fail(t, "the changes are blocked by the stuck watcher")
+		}
+	}
+}
+
+func TestNotifiesTheConcurrentChangesOfAKeyInTheOrderOfTheChanges(t *testing.T) {
+	kvStore := store.NewInMemoryStore()
+	handlers := NewHandlers(kvStore)
+	watchers := handlers[proto.KeyValueMessageKindWatch].(WatchHandler).watchers
+	subscriber := newChannelSubscriber(1024)
+	watchers.Watch(subscriber, proto.NewWatchMessage("Counter"))
+
+	var group sync.WaitGroup
+	for goroutine := 0; goroutine < 8; goroutine++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for count := 0; count < 100; count++ {
+				_, _ = handlers[proto.KeyValueMessageKindIncrementBy].Handle(proto.NewIncrementByMessage("Counter", 1))
+			}
+		}()
+	}
+	group.Wait()
+
+	for expected := 1; expected <= 800; expected++ {
+		assert.Equal(t, strconv.Itoa(expected), string(receive(t, subscriber).RawValue()))
+	}
+}
@@ -0,0 +1,100 @@
+package single_threaded_blocking_io
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DetectionTimeout is the time within which a client of a ProtocolAuto server must send the bytes which decide its
+// protocol.
+const DetectionTimeout = time.Second
+
+// detectProtocol reads from the connection until DetectProtocol decides the protocol of the connection.
+// The bytes that were read are not lost: the returned connection replays them before it reads from the connection.
+func detectProtocol(connection net.Conn) (Protocol, net.Conn, error) {
+	_ = connection.SetReadDeadline(time.Now().Add(DetectionTimeout))
+	defer func() {
+		_ = connection.SetReadDeadline(time.Time{})
+	}()
+
+	var sniffed []byte
+	chunk := make([]byte, MaxDetectionLength)
+	for {
+		if protocol, ok := DetectProtocol(sniffed); ok {
+			return protocol, &sniffedConnection{
+				Conn:   connection,
+				reader: io.MultiReader(bytes.NewReader(sniffed), connection),
+			}, nil
+		}
+		n, err := connection.Read(chunk)
+		if err != nil {
+			return ProtocolProtobuf, nil, err
+		}
+		sniffed = append(sniffed, chunk[:n]...)
+	}
+}
+
+// sniffedConnection is a net.Conn which replays the bytes that were read to detect the protocol, before it reads
+// from the connection.
+type sniffedConnection struct {
+	net.Conn
+	reader io.Reader
+}
+
+// Read reads the sniffed bytes first, and then from the connection.
+func (connection *sniffedConnection) Read(buffer []byte) (int, error) {
+	return connection.reader.Read(buffer)
+}
+
+// handoffListener is a net.Listener whose connections are handed off by the server, once the server has detected
+// that they speak HTTP. It lets net/http serve the connections which are accepted by the server.
+type handoffListener struct {
+	address     net.Addr
+	connections chan net.Conn
+	closeOnce   sync.Once
+	closed      chan struct{}
+}
+
+// newHandoffListener creates a new instance of handoffListener, which reports the given address.
+func newHandoffListener(address net.Addr) *handoffListener {
+	return &handoffListener{
+		address:     address,
+		connections: make(chan net.Conn),
+		closed:      make(chan struct{}),
+	}
+}
+
+// handoff hands off the connection to the Accept of the listener. The connection is closed if the listener is closed.
+func (listener *handoffListener) handoff(connection net.Conn) {
+	select {
+	case listener.connections <- connection:
+	case <-listener.closed:
+		_ = connection.Close()
+	}
+}
+
+// Accept waits for the next connection which is handed off.
+func (listener *handoffListener) Accept() (net.Conn, error) {
+	select {
+	case connection := <-listener.connections:
+		return connection, nil
+	case <-listener.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener. The connections which are already accepted are not closed.
+func (listener *handoffListener) Close() error {
+	listener.closeOnce.Do(func() {
+		close(listener.closed)
+	})
+	return nil
+}
+
+// Addr returns the address of the server.
+func (listener *handoffListener) Addr() net.Addr {
+	return listener.address
+}
@@ -0,0 +1,83 @@
+package memcached
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+const (
+	maxLineLength  = 2048
+	maxKeyLength   = 250
+	maxValueLength = 1024 * 1024
+)
+
+var crlf = []byte{'\r', '\n'}
+
+var (
+	ErrIncompleteCommand = errors.New("incomplete command, more bytes are needed")
+	ErrLineTooLong       = errors.New("line too long")
+	ErrBadCommandLine    = errors.New("bad command line format")
+	ErrBadDataChunk      = errors.New("bad data chunk")
+)
+
+// Command is a command of the memcached text protocol.
+type Command struct {
+	// Args are the space separated fields of the command line, the first one is the name of the command.
+	Args [][]byte
+	// Data is the data block of a storage command (set, add, replace or cas).
+	Data []byte
+}
+
+// ParseCommand parses a single command from the beginning of data, and returns it along with the number of bytes
+// consumed.
+// A command is a line terminated by CRLF (a bare LF is accepted too). A storage command line is followed by a data
+// block of the length given on the command line, which is also terminated by CRLF.
+// If data does not hold a complete command, ErrIncompleteCommand is returned and nothing is consumed, so the parser is
+// incremental: it can be invoked again once more bytes have arrived.
+// Any other error (ErrLineTooLong, ErrBadCommandLine or ErrBadDataChunk) means that the position of the next command
+// is unknown.
+// The command refers to data, it is valid as long as data is not modified.
+func ParseCommand(data []byte) (Command, int, error) {
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		if len(data) > maxLineLength {
+			return Command{}, 0, ErrLineTooLong
+		}
+		return Command{}, 0, ErrIncompleteCommand
+	}
+	if end > maxLineLength {
+		return Command{}, 0, ErrLineTooLong
+	}
+
+	args := bytes.Fields(data[:end])
+	if len(args) == 0 || !isStorageCommand(string(args[0])) {
+		return Command{Args: args}, end + 1, nil
+	}
+
+	if len(args) < 5 {
+		return Command{}, 0, ErrBadCommandLine
+	}
+	length, err := strconv.Atoi(string(args[4]))
+	if err != nil || length < 0 || length > maxValueLength {
+		return Command{}, 0, ErrBadCommandLine
+	}
+	start := end + 1
+	if len(data) < start+length+len(crlf) {
+		return Command{}, 0, ErrIncompleteCommand
+	}
+	if !bytes.Equal(data[start+length:start+length+len(crlf)], crlf) {
+		return Command{}, 0, ErrBadDataChunk
+	}
+	return Command{Args: args, Data: data[start : start+length]}, start + length + len(crlf), nil
+}
+
+// isStorageCommand returns true if the command line of the named command is followed by a data block.
+func isStorageCommand(name string) bool {
+	switch name {
+	case "set", "add", "replace", "cas":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,24 @@
+package memcached
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReadPipelinedCommandsWhichArriveByteByByte(t *testing.T) {
+	reader := NewReader(iotest.OneByteReader(strings.NewReader("set DiskType 0 0 3\r\nSSD\r\nget DiskType\r\n")))
+
+	command, err := reader.ReadCommand()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("SSD"), command.Data)
+
+	command, err = reader.ReadCommand()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("get"), []byte("DiskType")}, command.Args)
+
+	_, err = reader.ReadCommand()
+	assert.ErrorIs(t, err, io.EOF)
+}
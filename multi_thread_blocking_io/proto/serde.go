@@ -1,9 +1,18 @@
 package proto
 
 import (
+	"bytes"
+	"compress/flate"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"github.com/golang/protobuf/proto"
+	"hash/crc32"
 	"io"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
 	"unsafe"
 )
 
@@ -14,26 +23,416 @@ var (
 	FooterLength = len(FooterBytes)
 )
 
+// ChecksumFlag is set in the size of a frame which carries a CRC32C checksum of its payload.
+// CompressionFlag is set in the size of a frame whose payload is compressed with DEFLATE (compress/flate).
+// Both are optional; the frames of legacy clients never set the flags, because their size is smaller than 2^30.
 const (
-	KeyValueMessageKindGet         = uint32(1)
-	KeyValueMessageKindGetResponse = uint32(2)
-	KeyValueMessageKindPutOrUpdate = uint32(3)
+	ChecksumFlag     = uint32(1 << 31)
+	CompressionFlag  = uint32(1 << 30)
+	ChecksumLength   = int(unsafe.Sizeof(uint32(0)))
+	MaxPayloadLength = int(CompressionFlag - 1)
+)
+
+// DefaultMaxFrameLength is the default of MaxFrameLength. A larger value is put with a stream (see
+// NewStreamBeginMessage).
+const DefaultMaxFrameLength = 32 << 20
+
+// MaxFrameLength is the maximum length of the body of a frame which is read (or of its decompressed payload), so that
+// a peer can not make the reader allocate up to MaxPayloadLength bytes with a single header. A longer frame is
+// rejected with ErrFrameTooLong before its body is read. It is expected to be set before a server is started.
+var MaxFrameLength = DefaultMaxFrameLength
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorruptFrame denotes that a frame is consumed but its payload can not be used, so the reader remains positioned
+// at the next frame.
+var ErrCorruptFrame = errors.New("corrupt frame")
+
+var (
+	ErrIncompleteFrame   = errors.New("incomplete frame, more bytes are needed")
+	ErrMalformedFrame    = errors.New("malformed frame, frame is too short or does not end with the footer")
+	ErrChecksumMismatch  = fmt.Errorf("%w, checksum does not match the payload", ErrCorruptFrame)
+	ErrCorruptCompressed = fmt.Errorf("%w, compressed payload can not be decompressed", ErrCorruptFrame)
+	ErrFrameTooLarge     = errors.New("frame is larger than the maximum payload length")
+	ErrFrameTooLong      = fmt.Errorf("%w, frame is longer than the maximum frame length", ErrMalformedFrame)
+)
+
+// FrameOptions denote the optional transformations of the payload of a frame.
+// Compression is applied before the checksum, so the checksum covers the bytes on the wire.
+type FrameOptions struct {
+	// Checksum adds a CRC32C checksum of the payload.
+	Checksum bool
+	// CompressAbove compresses the payloads which are longer than the given number of bytes.
+	// A payload is sent uncompressed if compression does not make it smaller. 0 denotes no compression.
+	CompressAbove int
+}
+
+const (
+	KeyValueMessageKindGet                      = uint32(1)
+	KeyValueMessageKindGetResponse              = uint32(2)
+	KeyValueMessageKindPutOrUpdate              = uint32(3)
+	KeyValueMessageKindDelete                   = uint32(4)
+	KeyValueMessageKindDeleteResponse           = uint32(5)
+	KeyValueMessageKindCompareAndSwap           = uint32(6)
+	KeyValueMessageKindCompareAndSwapResponse   = uint32(7)
+	KeyValueMessageKindMultiGet                 = uint32(8)
+	KeyValueMessageKindMultiGetResponse         = uint32(9)
+	KeyValueMessageKindMultiPutOrUpdate         = uint32(10)
+	KeyValueMessageKindMultiPutOrUpdateResponse = uint32(11)
+	KeyValueMessageKindScan                     = uint32(12)
+	KeyValueMessageKindPrefixScan               = uint32(13)
+	KeyValueMessageKindScanResponse             = uint32(14)
+	KeyValueMessageKindScanEnd                  = uint32(15)
+	KeyValueMessageKindTimeToLive               = uint32(16)
+	KeyValueMessageKindTimeToLiveResponse       = uint32(17)
+	KeyValueMessageKindIncrementBy              = uint32(18)
+	KeyValueMessageKindIncrementByResponse      = uint32(19)
+	KeyValueMessageKindHello                    = uint32(20)
+	KeyValueMessageKindHelloResponse            = uint32(21)
+	KeyValueMessageKindFrameError               = uint32(22)
+	KeyValueMessageKindWatch                    = uint32(23)
+	KeyValueMessageKindPrefixWatch              = uint32(24)
+	KeyValueMessageKindWatchResponse            = uint32(25)
+	KeyValueMessageKindWatchNotification        = uint32(26)
+	KeyValueMessageKindPublish                  = uint32(27)
+	KeyValueMessageKindPublishResponse          = uint32(28)
+	KeyValueMessageKindSubscribe                = uint32(29)
+	KeyValueMessageKindPatternSubscribe         = uint32(30)
+	KeyValueMessageKindUnsubscribe              = uint32(31)
+	KeyValueMessageKindSubscribeResponse        = uint32(32)
+	KeyValueMessageKindChannelMessage           = uint32(33)
+	KeyValueMessageKindMulti                    = uint32(34)
+	KeyValueMessageKindExec                     = uint32(35)
+	KeyValueMessageKindDiscard                  = uint32(36)
+	KeyValueMessageKindOptimisticWatch          = uint32(37)
+	KeyValueMessageKindTransactionResponse      = uint32(38)
+	KeyValueMessageKindExecResponse             = uint32(39)
+	KeyValueMessageKindAuth                     = uint32(40)
+	KeyValueMessageKindAuthResponse             = uint32(41)
+	KeyValueMessageKindPing                     = uint32(42)
+	KeyValueMessageKindPong                     = uint32(43)
+	KeyValueMessageKindStreamBegin              = uint32(44)
+	KeyValueMessageKindStreamChunk              = uint32(45)
+	KeyValueMessageKindStreamEnd                = uint32(46)
+	KeyValueMessageKindStreamResponse           = uint32(47)
+	KeyValueMessageKindGetStream                = uint32(48)
+)
+
+// ProtocolVersion is the latest version of the wire format, which is described in Serialize.
+// Clients which do not send a Hello (legacy clients) are considered to speak MinProtocolVersion.
+const (
+	MinProtocolVersion = uint32(1)
+	ProtocolVersion    = uint32(1)
+)
+
+// Features are the optional capabilities of a connection which are negotiated by the Hello handshake.
+// They are carried as a bitmask in the features field of KeyValueMessage.
+const (
+	FeaturePipelining = uint32(1 << iota)
+	FeatureCompression
+	FeatureChecksums
 )
 
 // NewPutOrUpdateKeyValueMessage creates a new instance of KeyValueMessage with kind as PutOrUpdate.
+// Request constructors accept strings for convenience; a Go string may hold arbitrary bytes, which are carried in the
+// bytes fields of the message.
 func NewPutOrUpdateKeyValueMessage(key, value string) *KeyValueMessage {
 	return &KeyValueMessage{
-		Key:   key,
-		Value: value,
-		Kind:  KeyValueMessageKindPutOrUpdate,
+		KeyBytes:   []byte(key),
+		ValueBytes: []byte(value),
+		Kind:       KeyValueMessageKindPutOrUpdate,
+	}
+}
+
+// NewPutOrUpdateKeyValueMessageWithTTL creates a new instance of KeyValueMessage with kind as PutOrUpdate.
+// The key expires after the given time to live, which is carried in milliseconds.
+func NewPutOrUpdateKeyValueMessageWithTTL(key, value string, ttl time.Duration) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes:   []byte(key),
+		ValueBytes: []byte(value),
+		TtlMillis:  uint64(ttl.Milliseconds()),
+		Kind:       KeyValueMessageKindPutOrUpdate,
 	}
 }
 
 // NewGetValueMessage a new instance of KeyValueMessage with kind as Get.
 func NewGetValueMessage(key string) *KeyValueMessage {
 	return &KeyValueMessage{
-		Key:  key,
-		Kind: KeyValueMessageKindGet,
+		KeyBytes: []byte(key),
+		Kind:     KeyValueMessageKindGet,
+	}
+}
+
+// NewDeleteMessage creates a new instance of KeyValueMessage with kind as Delete.
+func NewDeleteMessage(key string) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes: []byte(key),
+		Kind:     KeyValueMessageKindDelete,
+	}
+}
+
+// NewCompareAndSwapMessage creates a new instance of KeyValueMessage with kind as CompareAndSwap.
+// The value is put only if the current version of the key is expectedVersion.
+// An expectedVersion of 0 denotes that the key must not exist.
+func NewCompareAndSwapMessage(key, value string, expectedVersion uint64) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes:   []byte(key),
+		ValueBytes: []byte(value),
+		Version:    expectedVersion,
+		Kind:       KeyValueMessageKindCompareAndSwap,
+	}
+}
+
+// NewCompareAndSwapMessageWithTTL creates a new instance of KeyValueMessage with kind as CompareAndSwap.
+// The swapped value expires after the given time to live, which is carried in milliseconds.
+func NewCompareAndSwapMessageWithTTL(key, value string, expectedVersion uint64, ttl time.Duration) *KeyValueMessage {
+	message := NewCompareAndSwapMessage(key, value, expectedVersion)
+	message.TtlMillis = uint64(ttl.Milliseconds())
+	return message
+}
+
+// NewKeyValuePair creates a new instance of KeyValuePair.
+func NewKeyValuePair(key, value string) *KeyValuePair {
+	return &KeyValuePair{
+		KeyBytes:   []byte(key),
+		ValueBytes: []byte(value),
+	}
+}
+
+// NewMultiGetMessage creates a new instance of KeyValueMessage with kind as MultiGet.
+// Each key is carried as a KeyValuePair without a value.
+func NewMultiGetMessage(keys ...string) *KeyValueMessage {
+	pairs := make([]*KeyValuePair, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, &KeyValuePair{KeyBytes: []byte(key)})
+	}
+	return &KeyValueMessage{
+		Pairs: pairs,
+		Kind:  KeyValueMessageKindMultiGet,
+	}
+}
+
+// NewMultiPutOrUpdateMessage creates a new instance of KeyValueMessage with kind as MultiPutOrUpdate.
+func NewMultiPutOrUpdateMessage(pairs ...*KeyValuePair) *KeyValueMessage {
+	return &KeyValueMessage{
+		Pairs: pairs,
+		Kind:  KeyValueMessageKindMultiPutOrUpdate,
+	}
+}
+
+// NewScanMessage creates a new instance of KeyValueMessage with kind as Scan.
+// It scans the keys in the range [startKey, endKey). An empty endKey denotes no upper bound,
+// and a limit of 0 denotes no limit on the number of keys.
+func NewScanMessage(startKey, endKey string, limit uint32) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes:    []byte(startKey),
+		EndKeyBytes: []byte(endKey),
+		Limit:       limit,
+		Kind:        KeyValueMessageKindScan,
+	}
+}
+
+// NewPrefixScanMessage creates a new instance of KeyValueMessage with kind as PrefixScan.
+// It scans the keys which start with the given prefix. A limit of 0 denotes no limit on the number of keys.
+func NewPrefixScanMessage(prefix string, limit uint32) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes: []byte(prefix),
+		Limit:    limit,
+		Kind:     KeyValueMessageKindPrefixScan,
+	}
+}
+
+// NewTimeToLiveMessage creates a new instance of KeyValueMessage with kind as TimeToLive.
+func NewTimeToLiveMessage(key string) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes: []byte(key),
+		Kind:     KeyValueMessageKindTimeToLive,
+	}
+}
+
+// NewIncrementByMessage creates a new instance of KeyValueMessage with kind as IncrementBy.
+// A negative delta decrements the value of the key.
+func NewIncrementByMessage(key string, delta int64) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes: []byte(key),
+		Delta:    delta,
+		Kind:     KeyValueMessageKindIncrementBy,
+	}
+}
+
+// NewHelloMessage creates a new instance of KeyValueMessage with kind as Hello.
+// The handshake is optional; if sent, it is the first message on a connection. The client offers the highest
+// protocol version it speaks and the features it wants, and the server answers with the agreed version and features.
+func NewHelloMessage(protocolVersion uint32, features uint32) *KeyValueMessage {
+	return &KeyValueMessage{
+		ProtocolVersion: protocolVersion,
+		Features:        features,
+		Kind:            KeyValueMessageKindHello,
+	}
+}
+
+// NewWatchMessage creates a new instance of KeyValueMessage with kind as Watch.
+// Each key is carried as a KeyValuePair without a value. Once the watch is answered, the server pushes a
+// WatchNotification frame for every change of the keys, till the connection is closed.
+func NewWatchMessage(keys ...string) *KeyValueMessage {
+	message := NewMultiGetMessage(keys...)
+	message.Kind = KeyValueMessageKindWatch
+	return message
+}
+
+// NewPrefixWatchMessage creates a new instance of KeyValueMessage with kind as PrefixWatch.
+// It watches all the keys which start with any of the given prefixes. Each prefix is carried as a KeyValuePair
+// without a value.
+func NewPrefixWatchMessage(prefixes ...string) *KeyValueMessage {
+	message := NewMultiGetMessage(prefixes...)
+	message.Kind = KeyValueMessageKindPrefixWatch
+	return message
+}
+
+// NewPublishMessage creates a new instance of KeyValueMessage with kind as Publish.
+// The channel is carried as the key, and the payload as the value.
+func NewPublishMessage(channel, payload string) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes:   []byte(channel),
+		ValueBytes: []byte(payload),
+		Kind:       KeyValueMessageKindPublish,
+	}
+}
+
+// NewSubscribeMessage creates a new instance of KeyValueMessage with kind as Subscribe.
+// Each channel is carried as a KeyValuePair without a value. Once the subscription is answered, the server pushes
+// a ChannelMessage frame for every message which is published to the channels, till the connection unsubscribes.
+func NewSubscribeMessage(channels ...string) *KeyValueMessage {
+	message := NewMultiGetMessage(channels...)
+	message.Kind = KeyValueMessageKindSubscribe
+	return message
+}
+
+// NewPatternSubscribeMessage creates a new instance of KeyValueMessage with kind as PatternSubscribe.
+// It subscribes to all the channels which match any of the given glob patterns (see path.Match), such as "news.*".
+func NewPatternSubscribeMessage(patterns ...string) *KeyValueMessage {
+	message := NewMultiGetMessage(patterns...)
+	message.Kind = KeyValueMessageKindPatternSubscribe
+	return message
+}
+
+// NewUnsubscribeMessage creates a new instance of KeyValueMessage with kind as Unsubscribe.
+// It unsubscribes from the given channels and patterns, or from all of them if none is given.
+func NewUnsubscribeMessage(channelsOrPatterns ...string) *KeyValueMessage {
+	message := NewMultiGetMessage(channelsOrPatterns...)
+	message.Kind = KeyValueMessageKindUnsubscribe
+	return message
+}
+
+// NewMultiMessage creates a new instance of KeyValueMessage with kind as Multi.
+// It opens a transaction: the PutOrUpdate, Get and Delete requests which follow are queued (and answered with
+// a TransactionResponse), till an Exec applies all of them atomically, or a Discard drops them.
+func NewMultiMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind: KeyValueMessageKindMulti,
+	}
+}
+
+// NewExecMessage creates a new instance of KeyValueMessage with kind as Exec.
+// It applies the queued requests of the transaction atomically, unless a key of an OptimisticWatch has changed.
+func NewExecMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind: KeyValueMessageKindExec,
+	}
+}
+
+// NewDiscardMessage creates a new instance of KeyValueMessage with kind as Discard.
+// It drops the queued requests of the transaction, and the keys of the OptimisticWatch requests.
+func NewDiscardMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind: KeyValueMessageKindDiscard,
+	}
+}
+
+// NewOptimisticWatchMessage creates a new instance of KeyValueMessage with kind as OptimisticWatch.
+// It is sent before a Multi, and makes the next Exec fail with Status_Conflict if any of the keys changes (is put,
+// updated or deleted) in the meantime. Unlike a Watch, nothing is pushed to the connection.
+// Each key is carried as a KeyValuePair without a value.
+func NewOptimisticWatchMessage(keys ...string) *KeyValueMessage {
+	message := NewMultiGetMessage(keys...)
+	message.Kind = KeyValueMessageKindOptimisticWatch
+	return message
+}
+
+// NewAuthTokenMessage creates a new instance of KeyValueMessage with kind as Auth, which authenticates
+// the connection with the shared token of the server. The token is carried as the value, without a key.
+func NewAuthTokenMessage(token string) *KeyValueMessage {
+	return &KeyValueMessage{
+		ValueBytes: []byte(token),
+		Kind:       KeyValueMessageKindAuth,
+	}
+}
+
+// NewAuthPasswordMessage creates a new instance of KeyValueMessage with kind as Auth, which authenticates
+// the connection as the given user. The username is carried as the key, and the password as the value.
+func NewAuthPasswordMessage(username, password string) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes:   []byte(username),
+		ValueBytes: []byte(password),
+		Kind:       KeyValueMessageKindAuth,
+	}
+}
+
+// NewPingMessage creates a new instance of KeyValueMessage with kind as Ping, which checks that the other end of
+// the connection is alive. Either end may ping: a client pings the server, and the server pings an idle connection.
+func NewPingMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind: KeyValueMessageKindPing,
+	}
+}
+
+// NewPongMessage creates a new instance of KeyValueMessage with kind as Pong, which answers a Ping.
+func NewPongMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindPong,
+		Status: Status_Ok,
+	}
+}
+
+// NewPutStreamBeginMessage creates a new instance of KeyValueMessage with kind as StreamBegin, which begins a stream
+// that puts (or updates) the key with the value of its chunks, with the given time to live (0 denotes no expiry).
+// The value follows in StreamChunk messages, and is put once the StreamEnd message with the same stream id arrives.
+func NewPutStreamBeginMessage(streamId uint64, key string, ttl time.Duration) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes:  []byte(key),
+		Kind:      KeyValueMessageKindStreamBegin,
+		StreamId:  streamId,
+		TtlMillis: uint64(ttl.Milliseconds()),
+	}
+}
+
+// NewStreamChunkMessage creates a new instance of KeyValueMessage with kind as StreamChunk, which carries the next
+// chunk of the value of a stream, as the value.
+func NewStreamChunkMessage(streamId uint64, chunk []byte) *KeyValueMessage {
+	return &KeyValueMessage{
+		ValueBytes: chunk,
+		Kind:       KeyValueMessageKindStreamChunk,
+		StreamId:   streamId,
+	}
+}
+
+// NewStreamEndMessage creates a new instance of KeyValueMessage with kind as StreamEnd, which ends a stream.
+func NewStreamEndMessage(streamId uint64) *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:     KeyValueMessageKindStreamEnd,
+		StreamId: streamId,
+	}
+}
+
+// NewGetStreamMessage creates a new instance of KeyValueMessage with kind as GetStream, which gets the value of
+// the key as a stream with the given id: a StreamBegin (carrying the key and the version), the StreamChunk messages
+// of at most chunkSize bytes each, and a StreamEnd. A chunkSize of 0 denotes the default chunk size of the server.
+func NewGetStreamMessage(streamId uint64, key string, chunkSize uint32) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes: []byte(key),
+		Kind:     KeyValueMessageKindGetStream,
+		StreamId: streamId,
+		Limit:    chunkSize,
 	}
 }
 
@@ -45,63 +444,764 @@ func NewPutOrUpdateKeyValueSuccessfulResponseMessage() *KeyValueMessage {
 	}
 }
 
+// NewPutOrUpdateKeyValueNotDurableResponseMessage creates a new instance of KeyValueMessage with kind as PutOrUpdate.
+// It denotes that the store could not make the value durable, so the value is not put.
+func NewPutOrUpdateKeyValueNotDurableResponseMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindPutOrUpdate,
+		Status: Status_NotDurable,
+	}
+}
+
 // NewGetValueSuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as GetResponse.
-func NewGetValueSuccessfulResponseMessage(key string, value string) *KeyValueMessage {
+// The version can be used in a subsequent CompareAndSwap of the key.
+func NewGetValueSuccessfulResponseMessage(key, value []byte, version uint64) *KeyValueMessage {
 	return &KeyValueMessage{
-		Key:    key,
-		Value:  value,
-		Kind:   KeyValueMessageKindGetResponse,
-		Status: Status_Ok,
+		KeyBytes:   key,
+		ValueBytes: value,
+		Version:    version,
+		Kind:       KeyValueMessageKindGetResponse,
+		Status:     Status_Ok,
 	}
 }
 
 // NewGetValueUnsuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as GetResponse.
-func NewGetValueUnsuccessfulResponseMessage(key string) *KeyValueMessage {
+func NewGetValueUnsuccessfulResponseMessage(key []byte) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes: key,
+		Kind:     KeyValueMessageKindGetResponse,
+		Status:   Status_NotOk,
+	}
+}
+
+// NewDeleteSuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as DeleteResponse.
+// It denotes that the key existed and is deleted.
+func NewDeleteSuccessfulResponseMessage(key []byte) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes: key,
+		Kind:     KeyValueMessageKindDeleteResponse,
+		Status:   Status_Ok,
+	}
+}
+
+// NewDeleteUnsuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as DeleteResponse.
+// It denotes that the key did not exist.
+func NewDeleteUnsuccessfulResponseMessage(key []byte) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes: key,
+		Kind:     KeyValueMessageKindDeleteResponse,
+		Status:   Status_NotOk,
+	}
+}
+
+// NewDeleteNotDurableResponseMessage creates a new instance of KeyValueMessage with kind as DeleteResponse.
+// It denotes that the store could not make the deletion durable, so the key is not deleted.
+func NewDeleteNotDurableResponseMessage(key []byte) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes: key,
+		Kind:     KeyValueMessageKindDeleteResponse,
+		Status:   Status_NotDurable,
+	}
+}
+
+// NewCompareAndSwapSuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as CompareAndSwapResponse.
+// It carries the new version of the key.
+func NewCompareAndSwapSuccessfulResponseMessage(key []byte, version uint64) *KeyValueMessage {
 	return &KeyValueMessage{
-		Key:    key,
-		Kind:   KeyValueMessageKindGetResponse,
+		KeyBytes: key,
+		Version:  version,
+		Kind:     KeyValueMessageKindCompareAndSwapResponse,
+		Status:   Status_Ok,
+	}
+}
+
+// NewCompareAndSwapConflictResponseMessage creates a new instance of KeyValueMessage with kind as CompareAndSwapResponse.
+// It carries the current version of the key, which is 0 if the key does not exist.
+func NewCompareAndSwapConflictResponseMessage(key []byte, currentVersion uint64) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes: key,
+		Version:  currentVersion,
+		Kind:     KeyValueMessageKindCompareAndSwapResponse,
+		Status:   Status_Conflict,
+	}
+}
+
+// NewCompareAndSwapNotDurableResponseMessage creates a new instance of KeyValueMessage with kind as CompareAndSwapResponse.
+// It denotes that the store could not make the swapped value durable, so the value is not swapped.
+func NewCompareAndSwapNotDurableResponseMessage(key []byte) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes: key,
+		Kind:     KeyValueMessageKindCompareAndSwapResponse,
+		Status:   Status_NotDurable,
+	}
+}
+
+// NewMultiGetResponseMessage creates a new instance of KeyValueMessage with kind as MultiGetResponse.
+// Every pair carries its own status: proto.Status_Ok with the value and the version if the key exists,
+// proto.Status_NotOk otherwise.
+func NewMultiGetResponseMessage(pairs []*KeyValuePair) *KeyValueMessage {
+	return &KeyValueMessage{
+		Pairs:  pairs,
+		Kind:   KeyValueMessageKindMultiGetResponse,
+		Status: Status_Ok,
+	}
+}
+
+// NewMultiPutOrUpdateResponseMessage creates a new instance of KeyValueMessage with kind as MultiPutOrUpdateResponse.
+// Every pair carries its own status and the new version of the key.
+func NewMultiPutOrUpdateResponseMessage(pairs []*KeyValuePair) *KeyValueMessage {
+	return &KeyValueMessage{
+		Pairs:  pairs,
+		Kind:   KeyValueMessageKindMultiPutOrUpdateResponse,
+		Status: Status_Ok,
+	}
+}
+
+// NewMultiPutOrUpdateNotDurableResponseMessage creates a new instance of KeyValueMessage with kind as MultiPutOrUpdateResponse.
+// It denotes that the store could not make the pairs durable, so none of the pairs is put.
+func NewMultiPutOrUpdateNotDurableResponseMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindMultiPutOrUpdateResponse,
+		Status: Status_NotDurable,
+	}
+}
+
+// NewScanResponseMessage creates a new instance of KeyValueMessage with kind as ScanResponse.
+// A scan is answered with one ScanResponse frame for every key, followed by a ScanEnd frame.
+func NewScanResponseMessage(key, value []byte, version uint64) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes:   key,
+		ValueBytes: value,
+		Version:    version,
+		Kind:       KeyValueMessageKindScanResponse,
+		Status:     Status_Ok,
+	}
+}
+
+// NewScanEndMessage creates a new instance of KeyValueMessage with kind as ScanEnd.
+// It marks the end of the ScanResponse frames of a scan.
+func NewScanEndMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindScanEnd,
+		Status: Status_Ok,
+	}
+}
+
+// NewTimeToLiveSuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as TimeToLiveResponse.
+// It carries the remaining time to live in milliseconds, rounded up. A ttl of 0 denotes that the key never expires.
+func NewTimeToLiveSuccessfulResponseMessage(key []byte, ttl time.Duration) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes:  key,
+		TtlMillis: uint64((ttl + time.Millisecond - 1) / time.Millisecond),
+		Kind:      KeyValueMessageKindTimeToLiveResponse,
+		Status:    Status_Ok,
+	}
+}
+
+// NewTimeToLiveUnsuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as TimeToLiveResponse.
+// It denotes that the key does not exist.
+func NewTimeToLiveUnsuccessfulResponseMessage(key []byte) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes: key,
+		Kind:     KeyValueMessageKindTimeToLiveResponse,
+		Status:   Status_NotOk,
+	}
+}
+
+// NewIncrementBySuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as IncrementByResponse.
+// It carries the new value of the key.
+func NewIncrementBySuccessfulResponseMessage(key []byte, counter int64) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes:   key,
+		ValueBytes: strconv.AppendInt(nil, counter, 10),
+		Kind:       KeyValueMessageKindIncrementByResponse,
+		Status:     Status_Ok,
+	}
+}
+
+// NewIncrementByUnsuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as IncrementByResponse.
+// The status is either Status_NotANumber or Status_Overflow.
+func NewIncrementByUnsuccessfulResponseMessage(key []byte, status Status) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes: key,
+		Kind:     KeyValueMessageKindIncrementByResponse,
+		Status:   status,
+	}
+}
+
+// NewHelloSuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as HelloResponse.
+// It carries the agreed protocol version and the agreed features, which are a subset of the offered features.
+func NewHelloSuccessfulResponseMessage(protocolVersion uint32, features uint32) *KeyValueMessage {
+	return &KeyValueMessage{
+		ProtocolVersion: protocolVersion,
+		Features:        features,
+		Kind:            KeyValueMessageKindHelloResponse,
+		Status:          Status_Ok,
+	}
+}
+
+// NewHelloUnsuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as HelloResponse.
+// It denotes that the server does not speak the offered protocol version, and carries the latest version the server speaks.
+func NewHelloUnsuccessfulResponseMessage(protocolVersion uint32) *KeyValueMessage {
+	return &KeyValueMessage{
+		ProtocolVersion: protocolVersion,
+		Kind:            KeyValueMessageKindHelloResponse,
+		Status:          Status_NotOk,
+	}
+}
+
+// NewFrameErrorResponseMessage creates a new instance of KeyValueMessage with kind as FrameError.
+// It is sent in place of a response when a request frame is corrupt, with status as Status_Corrupt.
+// It carries no request id, because the request id of a corrupt frame can not be trusted.
+func NewFrameErrorResponseMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindFrameError,
+		Status: Status_Corrupt,
+	}
+}
+
+// NewUnknownKindResponseMessage creates a new instance of KeyValueMessage with kind as FrameError.
+// It is sent in place of a response when the kind of a request is not known to the server, with status as
+// Status_NotOk. Unlike a corrupt frame, the request id of the request can be trusted.
+func NewUnknownKindResponseMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindFrameError,
+		Status: Status_NotOk,
+	}
+}
+
+// NewWatchSuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as WatchResponse.
+// It denotes that the keys are watched.
+func NewWatchSuccessfulResponseMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindWatchResponse,
+		Status: Status_Ok,
+	}
+}
+
+// NewWatchUnsuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as WatchResponse.
+// It denotes that the connection can not receive notifications, so nothing is watched.
+func NewWatchUnsuccessfulResponseMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindWatchResponse,
+		Status: Status_NotOk,
+	}
+}
+
+// NewWatchNotificationMessage creates a new instance of KeyValueMessage with kind as WatchNotification.
+// It is pushed (unsolicited) when a watched key is put, and carries the new value of the key.
+// A notification carries the request id of the Watch (or PrefixWatch) which watched the key.
+func NewWatchNotificationMessage(key, value []byte) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes:   key,
+		ValueBytes: value,
+		Kind:       KeyValueMessageKindWatchNotification,
+		Status:     Status_Ok,
+	}
+}
+
+// NewWatchDeleteNotificationMessage creates a new instance of KeyValueMessage with kind as WatchNotification.
+// It is pushed (unsolicited) when a watched key is deleted, with status as Status_NotOk.
+func NewWatchDeleteNotificationMessage(key []byte) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes: key,
+		Kind:     KeyValueMessageKindWatchNotification,
+		Status:   Status_NotOk,
+	}
+}
+
+// NewPublishSuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as PublishResponse.
+// It denotes that the message is published; a subscriber may still drop it (see conn.Broker).
+func NewPublishSuccessfulResponseMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindPublishResponse,
+		Status: Status_Ok,
+	}
+}
+
+// NewSubscribeSuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as SubscribeResponse.
+// It answers a Subscribe, a PatternSubscribe and an Unsubscribe.
+func NewSubscribeSuccessfulResponseMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindSubscribeResponse,
+		Status: Status_Ok,
+	}
+}
+
+// NewSubscribeUnsuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as SubscribeResponse.
+// It denotes that the connection can not receive messages, so nothing is subscribed.
+func NewSubscribeUnsuccessfulResponseMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindSubscribeResponse,
+		Status: Status_NotOk,
+	}
+}
+
+// NewChannelMessage creates a new instance of KeyValueMessage with kind as ChannelMessage.
+// It is pushed (unsolicited) to the subscribers of the channel, and carries the channel as the key and the payload
+// as the value. A message carries the request id of the Subscribe (or PatternSubscribe) which subscribed to it.
+func NewChannelMessage(channel, payload []byte) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes:   channel,
+		ValueBytes: payload,
+		Kind:       KeyValueMessageKindChannelMessage,
+		Status:     Status_Ok,
+	}
+}
+
+// NewTransactionSuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as TransactionResponse.
+// It answers a Multi, a Discard and an OptimisticWatch, and denotes that a request is queued in a transaction.
+func NewTransactionSuccessfulResponseMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindTransactionResponse,
+		Status: Status_Ok,
+	}
+}
+
+// NewTransactionUnsuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as TransactionResponse.
+// It denotes that the request is not valid in the state of the transaction (such as a Multi inside a transaction),
+// or can not be queued in a transaction.
+func NewTransactionUnsuccessfulResponseMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindTransactionResponse,
 		Status: Status_NotOk,
 	}
 }
 
+// NewExecResponseMessage creates a new instance of KeyValueMessage with kind as ExecResponse.
+// Every pair answers a queued request, in the order of the requests, and carries its own status: proto.Status_Ok
+// with the new version for a PutOrUpdate, proto.Status_Ok with the value and the version (or proto.Status_NotOk)
+// for a Get, and proto.Status_Ok (or proto.Status_NotOk if the key did not exist) for a Delete.
+func NewExecResponseMessage(pairs []*KeyValuePair) *KeyValueMessage {
+	return &KeyValueMessage{
+		Pairs:  pairs,
+		Kind:   KeyValueMessageKindExecResponse,
+		Status: Status_Ok,
+	}
+}
+
+// NewExecConflictResponseMessage creates a new instance of KeyValueMessage with kind as ExecResponse.
+// It denotes that a key of an OptimisticWatch has changed, so none of the queued requests is applied.
+func NewExecConflictResponseMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindExecResponse,
+		Status: Status_Conflict,
+	}
+}
+
+// NewExecUnsuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as ExecResponse.
+// It denotes that there is no transaction to execute, or that the connection does not support transactions.
+func NewExecUnsuccessfulResponseMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindExecResponse,
+		Status: Status_NotOk,
+	}
+}
+
+// NewExecNotDurableResponseMessage creates a new instance of KeyValueMessage with kind as ExecResponse.
+// It denotes that the store could not make the queued requests durable, so none of them is applied.
+func NewExecNotDurableResponseMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindExecResponse,
+		Status: Status_NotDurable,
+	}
+}
+
+// NewAuthSuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as AuthResponse.
+// It carries the username of the authenticated identity as the key.
+func NewAuthSuccessfulResponseMessage(username string) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes: []byte(username),
+		Kind:     KeyValueMessageKindAuthResponse,
+		Status:   Status_Ok,
+	}
+}
+
+// NewAuthUnsuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as AuthResponse.
+// It denotes that the credentials are not valid, or that the server does not require authentication.
+func NewAuthUnsuccessfulResponseMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindAuthResponse,
+		Status: Status_NotOk,
+	}
+}
+
+// NewUnauthenticatedResponseMessage creates a new instance of KeyValueMessage with kind as AuthResponse.
+// It is sent in place of a response to any request other than an Auth, while the connection is not authenticated,
+// with status as Status_Unauthenticated.
+func NewUnauthenticatedResponseMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindAuthResponse,
+		Status: Status_Unauthenticated,
+	}
+}
+
+// NewPermissionDeniedResponseMessage creates a new instance of KeyValueMessage with kind as AuthResponse.
+// It is sent in place of a response to a request which the authenticated identity of the connection is not allowed
+// to make, with status as Status_PermissionDenied.
+func NewPermissionDeniedResponseMessage() *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:   KeyValueMessageKindAuthResponse,
+		Status: Status_PermissionDenied,
+	}
+}
+
+// NewGetStreamBeginMessage creates a new instance of KeyValueMessage with kind as StreamBegin, which begins the stream
+// of the value of the key in response to a GetStream.
+func NewGetStreamBeginMessage(streamId uint64, key []byte, version uint64) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes: key,
+		Kind:     KeyValueMessageKindStreamBegin,
+		StreamId: streamId,
+		Version:  version,
+		Status:   Status_Ok,
+	}
+}
+
+// NewPutStreamSuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as StreamResponse, which
+// answers the StreamEnd of a put stream with the version of the key.
+func NewPutStreamSuccessfulResponseMessage(streamId uint64, key []byte, version uint64) *KeyValueMessage {
+	return &KeyValueMessage{
+		KeyBytes: key,
+		Kind:     KeyValueMessageKindStreamResponse,
+		StreamId: streamId,
+		Version:  version,
+		Status:   Status_Ok,
+	}
+}
+
+// NewStreamUnsuccessfulResponseMessage creates a new instance of KeyValueMessage with kind as StreamResponse, which
+// answers a stream message that could not be handled, with the given status: Status_TooLarge if the stream exceeds
+// its memory limit (and is aborted), Status_NotDurable if the store could not make the streamed value durable, or
+// Status_NotOk otherwise (such as for an unknown stream, or a missing key).
+func NewStreamUnsuccessfulResponseMessage(streamId uint64, status Status) *KeyValueMessage {
+	return &KeyValueMessage{
+		Kind:     KeyValueMessageKindStreamResponse,
+		StreamId: streamId,
+		Status:   status,
+	}
+}
+
+// TimeToLive returns the time to live carried by the message.
+func (message *KeyValueMessage) TimeToLive() time.Duration {
+	return time.Duration(message.TtlMillis) * time.Millisecond
+}
+
+// RawKey returns the key of the message.
+// It prefers the bytes field, and falls back to the deprecated string field which is set by the legacy clients.
+func (message *KeyValueMessage) RawKey() []byte {
+	return bytesOrLegacy(message.KeyBytes, message.Key)
+}
+
+// RawValue returns the value of the message.
+// It prefers the bytes field, and falls back to the deprecated string field which is set by the legacy clients.
+func (message *KeyValueMessage) RawValue() []byte {
+	return bytesOrLegacy(message.ValueBytes, message.Value)
+}
+
+// RawEndKey returns the end key of a scan.
+// It prefers the bytes field, and falls back to the deprecated string field which is set by the legacy clients.
+func (message *KeyValueMessage) RawEndKey() []byte {
+	return bytesOrLegacy(message.EndKeyBytes, message.EndKey)
+}
+
+// RawKey returns the key of the pair.
+// It prefers the bytes field, and falls back to the deprecated string field which is set by the legacy clients.
+func (pair *KeyValuePair) RawKey() []byte {
+	return bytesOrLegacy(pair.KeyBytes, pair.Key)
+}
+
+// RawValue returns the value of the pair.
+// It prefers the bytes field, and falls back to the deprecated string field which is set by the legacy clients.
+func (pair *KeyValuePair) RawValue() []byte {
+	return bytesOrLegacy(pair.ValueBytes, pair.Value)
+}
+
+// AnsweringTo makes the message a response to the given request and returns the same message.
+// The response carries the request id of the request, and if the request came from a legacy client (one which sets
+// only the deprecated string fields), the bytes fields of the response are mirrored into the string fields.
+func (message *KeyValueMessage) AnsweringTo(request *KeyValueMessage) *KeyValueMessage {
+	message.RequestId = request.RequestId
+	if request.isLegacy() {
+		message.mirrorIntoLegacyFields()
+	}
+	return message
+}
+
+// WithRequestId sets the request id of the KeyValueMessage and returns the same message.
+// A response carries the request id of the request it answers, which allows clients to pipeline requests.
+func (message *KeyValueMessage) WithRequestId(requestId uint64) *KeyValueMessage {
+	message.RequestId = requestId
+	return message
+}
+
 // Serialize serializes the KeyValueMessage in bytes.
 // KeyValueMessage is serialized in the following format:
 // 4 bytes to denote size -> message.serialize() -> FooterBytes
 func (message *KeyValueMessage) Serialize() ([]byte, error) {
+	return message.SerializeWith(FrameOptions{})
+}
+
+// SerializeWithChecksum serializes the KeyValueMessage in bytes, along with a CRC32C checksum of the payload.
+// KeyValueMessage is serialized in the following format:
+// 4 bytes to denote size (with ChecksumFlag set) -> message.serialize() -> 4 bytes of checksum -> FooterBytes
+func (message *KeyValueMessage) SerializeWithChecksum() ([]byte, error) {
+	return message.SerializeWith(FrameOptions{Checksum: true})
+}
+
+// SerializeWith serializes the KeyValueMessage in bytes, applying the given FrameOptions.
+func (message *KeyValueMessage) SerializeWith(options FrameOptions) ([]byte, error) {
 	payload, err := message.serialize()
 	if err != nil {
 		return nil, err
 	}
+	return frameOf(payload, options)
+}
 
-	body := make([]byte, ReservedHeaderLength+len(payload)+FooterLength)
-	binary.LittleEndian.PutUint32(body, uint32(len(payload))+uint32(FooterLength))
-	copy(body[ReservedHeaderLength:], payload)
-	copy(body[ReservedHeaderLength+len(payload):], FooterBytes)
+// SerializeAll serializes all the messages, one frame after the other, in a single buffer.
+// It is used to answer a single request with multiple response frames.
+func SerializeAll(messages []*KeyValueMessage) ([]byte, error) {
+	var buffer []byte
+	for _, message := range messages {
+		frame, err := message.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		buffer = append(buffer, frame...)
+	}
+	return buffer, nil
+}
 
-	return body, nil
+// Reframe re-frames all the frames of the buffer, applying the given FrameOptions.
+// It is used by the server to answer a client which has agreed to FeatureChecksums or FeatureCompression,
+// because the handlers serialize the responses without either.
+func Reframe(frames []byte, options FrameOptions) ([]byte, error) {
+	var buffer []byte
+	reader := bytes.NewReader(frames)
+	for reader.Len() > 0 {
+		payload, err := readPayload(reader)
+		if err != nil {
+			return nil, err
+		}
+		frame, err := frameOf(payload, options)
+		if err != nil {
+			return nil, err
+		}
+		buffer = append(buffer, frame...)
+	}
+	return buffer, nil
 }
 
 // DeserializeFrom deserializes the reader into KeyValueMessage.
 // Usually the incoming connection is passed as a reader.
+// io.ReadFull is used because a single Read may return fewer bytes than a frame, which is common when requests are pipelined.
+// The footer of every frame is verified, and so is the checksum of a frame which carries one.
+// Frames with and without checksums (or compression) may be mixed on the same reader.
+// An error which wraps ErrCorruptFrame is returned after the whole frame is consumed, so the reader remains positioned
+// at the next frame.
 func DeserializeFrom(reader io.Reader) (*KeyValueMessage, error) {
-	headerBytes := make([]byte, ReservedHeaderLength)
-	_, err := reader.Read(headerBytes)
+	payload, err := readPayload(reader)
 	if err != nil {
 		return nil, err
 	}
 
-	bodyWithFooter := make([]byte, binary.LittleEndian.Uint32(headerBytes))
-	_, err = reader.Read(bodyWithFooter)
+	message := &KeyValueMessage{}
+	err = proto.Unmarshal(payload, message)
 	if err != nil {
 		return nil, err
 	}
+	return message, nil
+}
 
-	message := &KeyValueMessage{}
-	err = proto.Unmarshal(bodyWithFooter[:len(bodyWithFooter)-FooterLength], message)
+// DeserializeFromBuffer deserializes the first frame of the buffer into KeyValueMessage.
+// It is used by the non-blocking flavors which accumulate the bytes read from a file descriptor in a buffer.
+// If the buffer does not contain a complete frame, ErrIncompleteFrame is returned and the buffer is left untouched,
+// unless the header denotes a frame which is longer than MaxFrameLength, which is rejected with ErrFrameTooLong
+// without waiting for its body.
+func DeserializeFromBuffer(buffer *bytes.Buffer) (*KeyValueMessage, error) {
+	if buffer.Len() < ReservedHeaderLength {
+		return nil, ErrIncompleteFrame
+	}
+	bodyLength := int(binary.LittleEndian.Uint32(buffer.Bytes()) &^ (ChecksumFlag | CompressionFlag))
+	if bodyLength > MaxFrameLength {
+		return nil, ErrFrameTooLong
+	}
+	if buffer.Len() < ReservedHeaderLength+bodyLength {
+		return nil, ErrIncompleteFrame
+	}
+	return DeserializeFrom(buffer)
+}
+
+// frameOf frames the payload: 4 bytes to denote size -> payload -> (4 bytes of checksum) -> FooterBytes.
+// The checksum is the CRC32C (Castagnoli) of the (compressed) payload. The presence of the checksum and the
+// compression of the payload are denoted by ChecksumFlag and CompressionFlag in the size.
+func frameOf(payload []byte, options FrameOptions) ([]byte, error) {
+	header := uint32(0)
+	if options.CompressAbove > 0 && len(payload) > options.CompressAbove {
+		compressed, err := compress(payload)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(payload) {
+			payload, header = compressed, header|CompressionFlag
+		}
+	}
+	checksumLength := 0
+	if options.Checksum {
+		checksumLength, header = ChecksumLength, header|ChecksumFlag
+	}
+	bodyLength := len(payload) + checksumLength + FooterLength
+	if bodyLength > MaxPayloadLength {
+		return nil, ErrFrameTooLarge
+	}
+
+	frame := make([]byte, ReservedHeaderLength+bodyLength)
+	binary.LittleEndian.PutUint32(frame, header|uint32(bodyLength))
+	copy(frame[ReservedHeaderLength:], payload)
+	if options.Checksum {
+		binary.LittleEndian.PutUint32(frame[ReservedHeaderLength+len(payload):], crc32.Checksum(payload, castagnoliTable))
+	}
+	copy(frame[ReservedHeaderLength+len(payload)+checksumLength:], FooterBytes)
+	return frame, nil
+}
+
+// readPayload reads a single frame from the reader, verifies its footer and its checksum (if any),
+// and returns its (decompressed) payload.
+// The length of the body is checked against MaxFrameLength before the body is allocated.
+func readPayload(reader io.Reader) ([]byte, error) {
+	headerBytes := make([]byte, ReservedHeaderLength)
+	_, err := io.ReadFull(reader, headerBytes)
 	if err != nil {
 		return nil, err
 	}
-	return message, nil
+
+	header := binary.LittleEndian.Uint32(headerBytes)
+	withChecksum, compressed := header&ChecksumFlag != 0, header&CompressionFlag != 0
+	bodyLength := header &^ (ChecksumFlag | CompressionFlag)
+	checksumLength := 0
+	if withChecksum {
+		checksumLength = ChecksumLength
+	}
+	if bodyLength < uint32(checksumLength+FooterLength) {
+		return nil, ErrMalformedFrame
+	}
+	if int(bodyLength) > MaxFrameLength {
+		return nil, ErrFrameTooLong
+	}
+	bodyWithFooter := make([]byte, bodyLength)
+	_, err = io.ReadFull(reader, bodyWithFooter)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(bodyWithFooter[len(bodyWithFooter)-FooterLength:], FooterBytes) {
+		return nil, ErrMalformedFrame
+	}
+
+	payload := bodyWithFooter[:len(bodyWithFooter)-FooterLength-checksumLength]
+	if withChecksum {
+		checksum := binary.LittleEndian.Uint32(bodyWithFooter[len(payload):])
+		if crc32.Checksum(payload, castagnoliTable) != checksum {
+			return nil, ErrChecksumMismatch
+		}
+	}
+	if compressed {
+		return decompress(payload)
+	}
+	return payload, nil
+}
+
+// flateWriters and flateReaders pool the DEFLATE writers and readers, because allocating them costs far more than
+// compressing a small payload.
+var (
+	flateWriters = sync.Pool{New: func() any {
+		writer, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return writer
+	}}
+	flateReaders = sync.Pool{New: func() any {
+		return flate.NewReader(nil)
+	}}
+)
+
+// compress compresses the payload with DEFLATE.
+// flate.BestSpeed is used because the payload is compressed on every response; the benchmarks in
+// serde_benchmark_test.go show the trade-off between the CPU and the bytes.
+func compress(payload []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(writer)
+
+	writer.Reset(&buffer)
+	if _, err := writer.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// decompress decompresses the DEFLATE payload.
+// The decompressed payload is limited to MaxFrameLength bytes, so a small malicious frame can not exhaust the memory.
+func decompress(payload []byte) ([]byte, error) {
+	reader := flateReaders.Get().(io.ReadCloser)
+	defer flateReaders.Put(reader)
+
+	if err := reader.(flate.Resetter).Reset(bytes.NewReader(payload), nil); err != nil {
+		return nil, ErrCorruptCompressed
+	}
+	decompressed, err := io.ReadAll(io.LimitReader(reader, int64(MaxFrameLength)+1))
+	if err != nil || len(decompressed) > MaxFrameLength {
+		return nil, ErrCorruptCompressed
+	}
+	return decompressed, nil
+}
+
+// isLegacy returns true if the message sets any of the deprecated string fields and none of the bytes fields.
+func (message *KeyValueMessage) isLegacy() bool {
+	if len(message.KeyBytes) > 0 || len(message.ValueBytes) > 0 || len(message.EndKeyBytes) > 0 {
+		return false
+	}
+	if message.Key != "" || message.Value != "" || message.EndKey != "" {
+		return true
+	}
+	for _, pair := range message.Pairs {
+		if len(pair.KeyBytes) > 0 || len(pair.ValueBytes) > 0 {
+			return false
+		}
+		if pair.Key != "" || pair.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// mirrorIntoLegacyFields copies the bytes fields into the deprecated string fields.
+// Bytes which are not valid UTF-8 can not be carried in a string field; such a field is left empty, because
+// proto.Marshal refuses to serialize invalid UTF-8 in a string field.
+func (message *KeyValueMessage) mirrorIntoLegacyFields() {
+	message.Key = legacyStringOf(message.KeyBytes)
+	message.Value = legacyStringOf(message.ValueBytes)
+	message.EndKey = legacyStringOf(message.EndKeyBytes)
+	for _, pair := range message.Pairs {
+		pair.Key = legacyStringOf(pair.KeyBytes)
+		pair.Value = legacyStringOf(pair.ValueBytes)
+	}
+}
+
+// bytesOrLegacy returns the bytes field if it is set, else the deprecated string field.
+func bytesOrLegacy(field []byte, legacyField string) []byte {
+	if len(field) > 0 || legacyField == "" {
+		return field
+	}
+	return []byte(legacyField)
+}
+
+// legacyStringOf returns the bytes as a string if the bytes are valid UTF-8, else an empty string.
+func legacyStringOf(field []byte) string {
+	if !utf8.Valid(field) {
+		return ""
+	}
+	return string(field)
 }
 
 // serialize uses proto.Marshal to serialize KeyValueMessage.
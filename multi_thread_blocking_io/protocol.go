@@ -0,0 +1,112 @@
+package single_threaded_blocking_io
+
+import "bytes"
+
+// Protocol is the protocol which is spoken by the clients of a server.
+type Protocol int
+
+const (
+	// ProtocolProtobuf denotes the length prefixed protobuf frames of the proto package.
+	ProtocolProtobuf Protocol = iota
+	// ProtocolRESP denotes RESP2, the Redis serialization protocol, of the resp package.
+	ProtocolRESP
+	// ProtocolMemcached denotes the memcached text protocol, of the memcached package.
+	ProtocolMemcached
+	// ProtocolHTTP denotes HTTP/1.1, which is served by the gateway package.
+	ProtocolHTTP
+	// ProtocolAuto denotes a server which detects the protocol of every connection from its first bytes
+	// (see DetectProtocol), on a single port.
+	ProtocolAuto
+)
+
+// MaxDetectionLength is the number of bytes after which DetectProtocol always decides.
+const MaxDetectionLength = 16
+
+var (
+	memcachedCommands = []string{"get", "gets", "set", "add", "replace", "cas", "delete", "incr", "decr"}
+	httpMethods       = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+)
+
+// DetectProtocol detects the protocol of a connection from the first bytes that the client has sent.
+// It returns false if the data is not enough to decide, and the caller is expected to try again with more data.
+// The rules are:
+// - "*<digits>\r\n" or "$<digits>\r\n" is ProtocolRESP,
+// - a memcached command name (in lowercase) followed by a space is ProtocolMemcached,
+// - an HTTP method followed by a space is ProtocolHTTP,
+// - everything else is ProtocolProtobuf.
+// The length header of a protobuf frame is little-endian, so its first byte can be anything. A frame is mistaken
+// for a text protocol only if all of its 4 header bytes spell one of the prefixes above, which takes a frame
+// larger than 160 MiB.
+func DetectProtocol(data []byte) (Protocol, bool) {
+	if len(data) == 0 {
+		return ProtocolProtobuf, false
+	}
+	protocol, ok := detect(data)
+	if !ok && len(data) >= MaxDetectionLength {
+		return ProtocolProtobuf, true
+	}
+	return protocol, ok
+}
+
+func detect(data []byte) (Protocol, bool) {
+	switch first := data[0]; {
+	case first == '*' || first == '$':
+		return detectRESP(data)
+	case 'a' <= first && first <= 'z':
+		return detectCommand(data, 'a', 'z', memcachedCommands, ProtocolMemcached)
+	case 'A' <= first && first <= 'Z':
+		return detectCommand(data, 'A', 'Z', httpMethods, ProtocolHTTP)
+	default:
+		return ProtocolProtobuf, true
+	}
+}
+
+// detectRESP detects ProtocolRESP from the header line of an array or a bulk string: "*<digits>\r\n".
+func detectRESP(data []byte) (Protocol, bool) {
+	for index := 1; index < len(data); index++ {
+		switch ch := data[index]; {
+		case '0' <= ch && ch <= '9':
+		case ch == '-' && index == 1:
+		case ch == '\r' && index > 1 && data[index-1] != '-':
+			if index+1 == len(data) {
+				return ProtocolProtobuf, false
+			}
+			if data[index+1] == '\n' {
+				return ProtocolRESP, true
+			}
+			return ProtocolProtobuf, true
+		default:
+			return ProtocolProtobuf, true
+		}
+	}
+	return ProtocolProtobuf, false
+}
+
+// detectCommand detects the given protocol from a command name (within the letters from..to) followed by a space.
+func detectCommand(data []byte, from, to byte, commands []string, protocol Protocol) (Protocol, bool) {
+	for index := 0; index < len(data); index++ {
+		ch := data[index]
+		if from <= ch && ch <= to {
+			continue
+		}
+		if ch == ' ' && isOneOf(data[:index], commands) {
+			return protocol, true
+		}
+		return ProtocolProtobuf, true
+	}
+	for _, command := range commands {
+		if bytes.HasPrefix([]byte(command), data) {
+			return ProtocolProtobuf, false
+		}
+	}
+	return ProtocolProtobuf, true
+}
+
+func isOneOf(name []byte, commands []string) bool {
+	for _, command := range commands {
+		if string(name) == command {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,206 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"multi_thread_blocking_io/conn"
+	"multi_thread_blocking_io/proto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// command describes a RESP command.
+// arity is the number of arguments including the name of the command, a negative arity denotes the minimum number of
+// arguments (same as the arity of the Redis commands).
+type command struct {
+	arity   int
+	execute func(executor *Executor, args [][]byte) []byte
+}
+
+var commands = map[string]command{
+	"ping":   {arity: -1, execute: (*Executor).ping},
+	"get":    {arity: 2, execute: (*Executor).get},
+	"set":    {arity: -3, execute: (*Executor).set},
+	"del":    {arity: -2, execute: (*Executor).del},
+	"incr":   {arity: 2, execute: (*Executor).incr},
+	"decr":   {arity: 2, execute: (*Executor).decr},
+	"incrby": {arity: 3, execute: (*Executor).incrBy},
+	"decrby": {arity: 3, execute: (*Executor).decrBy},
+}
+
+// errNotDurable is answered with an error reply when the store could not make a mutation durable.
+var errNotDurable = errors.New("the store could not make the mutation durable")
+
+// Executor executes the RESP commands.
+// A command is mapped onto a proto.KeyValueMessage which is handled by the conn.Handler for its kind, and the
+// response of the handler is mapped onto a RESP reply. This way, RESP is just another front end of the store.
+type Executor struct {
+	handlers map[uint32]conn.Handler
+}
+
+// NewExecutor creates a new instance of Executor.
+func NewExecutor(handlers map[uint32]conn.Handler) *Executor {
+	return &Executor{
+		handlers: handlers,
+	}
+}
+
+// Execute executes the command (the first argument is the name of the command) and returns the RESP reply.
+// An unknown command or a command with a wrong number of arguments is answered with an error reply.
+func (executor *Executor) Execute(args [][]byte) []byte {
+	name := strings.ToLower(string(args[0]))
+	command, ok := commands[name]
+	if !ok {
+		return AppendError(nil, fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+	if (command.arity > 0 && len(args) != command.arity) || (command.arity < 0 && len(args) < -command.arity) {
+		return AppendError(nil, fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+	}
+	return command.execute(executor, args)
+}
+
+// ping answers PING [message].
+func (executor *Executor) ping(args [][]byte) []byte {
+	switch len(args) {
+	case 1:
+		return AppendSimpleString(nil, "PONG")
+	case 2:
+		return AppendBulkString(nil, args[1])
+	default:
+		return AppendError(nil, "ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+// get answers GET key with the value of the key, or a null bulk string if the key does not exist.
+func (executor *Executor) get(args [][]byte) []byte {
+	response, err := executor.handle(proto.NewGetValueMessage(string(args[1])))
+	if err != nil {
+		return errorReplyOf(err)
+	}
+	if response.Status != proto.Status_Ok {
+		return AppendNullBulkString(nil)
+	}
+	return AppendBulkString(nil, response.RawValue())
+}
+
+// set answers SET key value [EX seconds|PX milliseconds].
+func (executor *Executor) set(args [][]byte) []byte {
+	var ttl time.Duration
+	for index := 3; index < len(args); index += 2 {
+		unit := time.Duration(0)
+		switch strings.ToLower(string(args[index])) {
+		case "ex":
+			unit = time.Second
+		case "px":
+			unit = time.Millisecond
+		}
+		if unit == 0 || ttl != 0 || index+1 == len(args) {
+			return AppendError(nil, "ERR syntax error")
+		}
+		amount, err := strconv.ParseInt(string(args[index+1]), 10, 64)
+		if err != nil {
+			return AppendError(nil, "ERR value is not an integer or out of range")
+		}
+		if amount <= 0 || amount > int64(time.Duration(1<<63-1)/unit) {
+			return AppendError(nil, "ERR invalid expire time in 'set' command")
+		}
+		ttl = time.Duration(amount) * unit
+	}
+
+	message := proto.NewPutOrUpdateKeyValueMessage(string(args[1]), string(args[2]))
+	if ttl > 0 {
+		message = proto.NewPutOrUpdateKeyValueMessageWithTTL(string(args[1]), string(args[2]), ttl)
+	}
+	if _, err := executor.handle(message); err != nil {
+		return errorReplyOf(err)
+	}
+	return AppendSimpleString(nil, "OK")
+}
+
+// del answers DEL key [key ...] with the number of keys that were deleted.
+func (executor *Executor) del(args [][]byte) []byte {
+	deleted := int64(0)
+	for _, key := range args[1:] {
+		response, err := executor.handle(proto.NewDeleteMessage(string(key)))
+		if err != nil {
+			return errorReplyOf(err)
+		}
+		if response.Status == proto.Status_Ok {
+			deleted++
+		}
+	}
+	return AppendInteger(nil, deleted)
+}
+
+// incr answers INCR key.
+func (executor *Executor) incr(args [][]byte) []byte {
+	return executor.incrementBy(args[1], 1)
+}
+
+// decr answers DECR key.
+func (executor *Executor) decr(args [][]byte) []byte {
+	return executor.incrementBy(args[1], -1)
+}
+
+// incrBy answers INCRBY key increment.
+func (executor *Executor) incrBy(args [][]byte) []byte {
+	delta, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return AppendError(nil, "ERR value is not an integer or out of range")
+	}
+	return executor.incrementBy(args[1], delta)
+}
+
+// decrBy answers DECRBY key decrement.
+func (executor *Executor) decrBy(args [][]byte) []byte {
+	delta, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil || delta == -1<<63 {
+		return AppendError(nil, "ERR value is not an integer or out of range")
+	}
+	return executor.incrementBy(args[1], -delta)
+}
+
+// incrementBy increments the key by delta, and answers with the new value.
+func (executor *Executor) incrementBy(key []byte, delta int64) []byte {
+	response, err := executor.handle(proto.NewIncrementByMessage(string(key), delta))
+	if err != nil {
+		return errorReplyOf(err)
+	}
+	switch response.Status {
+	case proto.Status_Ok:
+		counter, err := strconv.ParseInt(string(response.RawValue()), 10, 64)
+		if err != nil {
+			return errorReplyOf(err)
+		}
+		return AppendInteger(nil, counter)
+	case proto.Status_Overflow:
+		return AppendError(nil, "ERR increment or decrement would overflow")
+	default:
+		return AppendError(nil, "ERR value is not an integer or out of range")
+	}
+}
+
+// handle handles the message with the conn.Handler for its kind, and returns the deserialized response.
+// A response with proto.Status_NotDurable is returned as errNotDurable.
+func (executor *Executor) handle(message *proto.KeyValueMessage) (*proto.KeyValueMessage, error) {
+	handler, ok := executor.handlers[message.Kind]
+	if !ok {
+		return nil, fmt.Errorf("no handler for the message kind %v", message.Kind)
+	}
+	buffer, err := handler.Handle(message)
+	if err != nil {
+		return nil, err
+	}
+	response, err := proto.DeserializeFrom(bytes.NewReader(buffer))
+	if err == nil && response.Status == proto.Status_NotDurable {
+		return nil, errNotDurable
+	}
+	return response, err
+}
+
+// errorReplyOf returns the RESP error reply for an unexpected error.
+func errorReplyOf(err error) []byte {
+	return AppendError(nil, "ERR "+err.Error())
+}
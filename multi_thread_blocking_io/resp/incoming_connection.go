@@ -0,0 +1,65 @@
+package resp
+
+import (
+	"errors"
+	"multi_thread_blocking_io/conn"
+	"net"
+)
+
+// IncomingConnection represents an incoming TCP connection which speaks RESP (the Redis serialization protocol),
+// so that redis-cli and the Redis client libraries can talk to the server.
+type IncomingConnection struct {
+	connection   net.Conn
+	reader       *Reader
+	executor     *Executor
+	closeChannel chan struct{}
+}
+
+// NewIncomingConnection creates a new IncomingConnection to handle incoming commands.
+func NewIncomingConnection(connection net.Conn, handlers map[uint32]conn.Handler) IncomingConnection {
+	return IncomingConnection{
+		connection:   connection,
+		reader:       NewReader(connection),
+		executor:     NewExecutor(handlers),
+		closeChannel: make(chan struct{}),
+	}
+}
+
+// Handle handles the incoming connection.
+// It runs a loop which reads a command from the connection (using blocking IO), executes it and writes the reply.
+// Unlike the protobuf connection, an idle RESP connection is kept open, since an interactive client (such as redis-cli)
+// may wait for long between two commands.
+// The method returns (and closes the connection) if there is any error in reading from the connection. A protocol
+// error is answered with an error reply before the connection is closed, because the position of the next command is
+// unknown.
+func (incomingConnection IncomingConnection) Handle() {
+	defer func() {
+		_ = incomingConnection.connection.Close()
+	}()
+	for {
+		select {
+		case <-incomingConnection.closeChannel:
+			return
+		default:
+			args, err := incomingConnection.reader.ReadCommand()
+			if err != nil {
+				if errors.Is(err, ErrProtocol) {
+					_, _ = incomingConnection.connection.Write(AppendError(nil, "ERR "+err.Error()))
+				}
+				return
+			}
+			if len(args) == 0 {
+				continue
+			}
+			if _, err := incomingConnection.connection.Write(incomingConnection.executor.Execute(args)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Close closes the IncomingConnection.
+func (incomingConnection IncomingConnection) Close() {
+	close(incomingConnection.closeChannel)
+	_ = incomingConnection.connection.Close()
+}
@@ -0,0 +1,90 @@
+package single_threaded_blocking_io
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// respClient is a hand-written RESP client, which sends the commands as RESP arrays of bulk strings.
+// The replies are rendered the way redis-cli renders them, such as OK, (integer) 1, "NVMe SSD" or (nil).
+type respClient struct {
+	connection net.Conn
+	reader     *bufio.Reader
+}
+
+func newRESPClient(connection net.Conn) *respClient {
+	return &respClient{
+		connection: connection,
+		reader:     bufio.NewReader(connection),
+	}
+}
+
+func (client *respClient) do(args ...string) (string, error) {
+	if err := client.send(args...); err != nil {
+		return "", err
+	}
+	return client.receive()
+}
+
+func (client *respClient) send(args ...string) error {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, arg := range args {
+		builder.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg))
+	}
+	_, err := client.connection.Write([]byte(builder.String()))
+	return err
+}
+
+func (client *respClient) receive() (string, error) {
+	line, err := client.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 {
+		return "", fmt.Errorf("empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return "(error) " + line[1:], nil
+	case ':':
+		return "(integer) " + line[1:], nil
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", err
+		}
+		if length < 0 {
+			return "(nil)", nil
+		}
+		value := make([]byte, length+2)
+		if _, err := io.ReadFull(client.reader, value); err != nil {
+			return "", err
+		}
+		return strconv.Quote(string(value[:length])), nil
+	case '*':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", err
+		}
+		elements := make([]string, 0, length)
+		for index := 0; index < length; index++ {
+			element, err := client.receive()
+			if err != nil {
+				return "", err
+			}
+			elements = append(elements, fmt.Sprintf("%d) %s", index+1, element))
+		}
+		return strings.Join(elements, "\n"), nil
+	default:
+		return "", fmt.Errorf("unexpected reply type %q", line[0])
+	}
+}
@@ -1,55 +1,266 @@
 package single_threaded_blocking_io
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"multi_thread_blocking_io/conn"
+	"multi_thread_blocking_io/gateway"
+	"multi_thread_blocking_io/memcached"
+	"multi_thread_blocking_io/proto"
+	"multi_thread_blocking_io/resp"
 	"multi_thread_blocking_io/store"
 	"net"
+	"net/http"
 	_ "net/http/pprof"
+	"time"
 )
 
+const (
+	ExpiryInterval           = 100 * time.Millisecond
+	MaxKeysExaminedPerExpiry = 1000
+	ACLReloadInterval        = time.Second
+)
+
+// ErrAuthenticationNotSupported denotes that authentication is required for a server which serves the protocols
+// other than ProtocolProtobuf, whose connections can not authenticate.
+var ErrAuthenticationNotSupported = errors.New("authentication is only supported by the protobuf protocol")
+
+// ErrAuthenticationRequired denotes that authorization is required for a server which does not require authentication,
+// whose connections have no identity to authorize.
+var ErrAuthenticationRequired = errors.New("authorization requires authentication")
+
 // TCPServer represents a TCP TCPServer
 type TCPServer struct {
-	address  string
-	listener net.Listener
-	store    *store.InMemoryStore
+	address                string
+	listener               net.Listener
+	store                  store.Store
+	handlers               map[uint32]conn.Handler
+	protocol               Protocol
+	httpServer             *http.Server
+	httpListener           *handoffListener
+	httpGateway            bool
+	requiresAuthentication bool
+	keepalive              conn.Keepalive
+	stopChannel            chan struct{}
+	openedStore            *store.DurableStore
 }
 
-// NewTCPServer creates a new instance of TCPServer.
+// NewTCPServer creates a new instance of TCPServer, which speaks ProtocolProtobuf.
 func NewTCPServer(host string, port uint16) (*TCPServer, error) {
+	return NewTCPServerWithProtocol(host, port, ProtocolProtobuf)
+}
+
+// NewTCPServerWithProtocol creates a new instance of TCPServer, which speaks the given protocol.
+// A server with ProtocolAuto detects the protocol of every connection (see DetectProtocol), and serves all the
+// protocols on a single port. The HTTP connections are served by the HTTP gateway (see gateway.Gateway).
+// The Key/Value pairs are held in a store.ShardedInMemoryStore, so that the connections contend for a lock per shard.
+func NewTCPServerWithProtocol(host string, port uint16, protocol Protocol) (*TCPServer, error) {
+	return NewTCPServerWithStore(host, port, protocol, store.NewShardedInMemoryStore())
+}
+
+// NewDurableTCPServer creates a new instance of TCPServer, which speaks the given protocol and holds the Key/Value
+// pairs in a store.DurableStore: the write-ahead log at the given path is replayed into the store, and is synced as
+// per the policy, so that the pairs survive a restart of the server. The log is closed when the server is stopped.
+// It returns store.ErrInvalidSyncPolicy for an invalid policy, and store.ErrCorruptRecord for a log which can not be
+// replayed.
+func NewDurableTCPServer(host string, port uint16, protocol Protocol, path string, policy store.SyncPolicy) (*TCPServer, error) {
+	durableStore, err := store.OpenDurableStore(path, policy, store.NewShardedInMemoryStore())
+	if err != nil {
+		return nil, err
+	}
+	server, err := NewTCPServerWithStore(host, port, protocol, durableStore)
+	if err != nil {
+		_ = durableStore.Close()
+		return nil, err
+	}
+	server.openedStore = durableStore
+	return server, nil
+}
+
+// NewTCPServerWithStore creates a new instance of TCPServer, which speaks the given protocol and holds the Key/Value
+// pairs in the given store. The store may be any implementation of store.Store (see storetest.TestStore).
+func NewTCPServerWithStore(host string, port uint16, protocol Protocol, store store.Store) (*TCPServer, error) {
 	address := fmt.Sprintf("%s:%v", host, port)
 	listener, err := net.Listen("tcp", address)
 	if err != nil {
 		return nil, err
 	}
 
-	return &TCPServer{
-		address:  address,
-		listener: listener,
-		store:    store.NewInMemoryStore(),
-	}, nil
+	server := &TCPServer{
+		address:     address,
+		listener:    listener,
+		store:       store,
+		handlers:    conn.NewHandlers(store),
+		protocol:    protocol,
+		keepalive:   conn.DefaultKeepalive,
+		stopChannel: make(chan struct{}),
+	}
+	if protocol == ProtocolHTTP || protocol == ProtocolAuto {
+		server.httpListener = newHandoffListener(listener.Addr())
+		server.serveHTTP(server.httpListener)
+	}
+	return server, nil
 }
 
 // Start starts the server.
 // TCPServer implements "Multi thread blocking IO" pattern.
 // TCPServer:
 // - runs a continuous loop in a single goroutine (/main goroutine).
-// - a new instance of IncomingTCPConnection is created for every new connection.
+// - a new instance of IncomingTCPConnection (or the IncomingConnection of the resp/memcached package) is created for every new connection,
+// or the connection is handed off to the HTTP gateway.
 // - The incoming TCP connection is handled in new goroutine.
 // - This pattern involves goroutine per connection and blocking IO to read from the incoming connection.
+// Expired keys are actively deleted from the store in a separate goroutine, every ExpiryInterval.
 func (server *TCPServer) Start() {
+	go server.evictExpiredKeys()
 	for {
 		connection, err := server.listener.Accept()
 		if err != nil {
 			return
 		}
-		go conn.NewIncomingTCPConnection(connection, server.store).Handle()
+		go server.handle(connection)
+	}
+}
+
+// StartHTTPGateway starts the HTTP/JSON gateway (see gateway.Gateway) on the given host and port, alongside the
+// server. The gateway shares the store of the server, and is stopped along with the server.
+// It returns once the gateway is listening, and is expected to be invoked before the server is stopped.
+// It returns ErrAuthenticationNotSupported if the server requires authentication.
+func (server *TCPServer) StartHTTPGateway(host string, port uint16) error {
+	if server.requiresAuthentication {
+		return ErrAuthenticationNotSupported
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%v", host, port))
+	if err != nil {
+		return err
 	}
+	server.serveHTTP(listener)
+	server.httpGateway = true
+	return nil
 }
 
-// Stop stops the server.
+// RequireAuthentication makes every connection authenticate with the given authenticator (see conn.NewAuthHandler)
+// before any other request, and records the authenticated identity of the connection.
+// Only the protobuf connections can authenticate, so a server with ProtocolAuto closes the connections of the other
+// protocols. It returns ErrAuthenticationNotSupported for a server of any other protocol, or with the HTTP gateway.
+// It is expected to be invoked before the server is started.
+func (server *TCPServer) RequireAuthentication(authenticator *conn.Authenticator) error {
+	if (server.protocol != ProtocolProtobuf && server.protocol != ProtocolAuto) || server.httpGateway {
+		return ErrAuthenticationNotSupported
+	}
+	server.handlers[proto.KeyValueMessageKindAuth] = conn.NewAuthHandler(authenticator)
+	server.requiresAuthentication = true
+	return nil
+}
+
+// RequireAuthorization makes every get, put, delete and scan of the keys be authorized by the ACL for the identity of
+// the connection (see conn.NewAuthorizedHandlers), and answers the denied requests with proto.Status_PermissionDenied.
+// The rules of the ACL are reloaded if its file is modified, every ACLReloadInterval, so that they can be changed
+// without restarting the server.
+// It returns ErrAuthenticationRequired if the server does not require authentication (see RequireAuthentication).
+// It is expected to be invoked before the server is started.
+func (server *TCPServer) RequireAuthorization(acl *conn.ACL) error {
+	if !server.requiresAuthentication {
+		return ErrAuthenticationRequired
+	}
+	conn.NewAuthorizedHandlers(server.handlers, acl)
+	go server.reloadACL(acl)
+	return nil
+}
+
+// SetKeepalive sets the conn.Keepalive of the protobuf connections, which are pinged when they are idle and closed
+// if they do not answer in time. It is expected to be invoked before the server is started.
+func (server *TCPServer) SetKeepalive(keepalive conn.Keepalive) {
+	server.keepalive = keepalive
+}
+
+// Stop stops the server, and the HTTP gateway if it is started. The write-ahead log of a server which is created by
+// NewDurableTCPServer is closed after syncing the appended records.
 func (server *TCPServer) Stop() {
 	log.Println("Stopping TCPServer")
+	close(server.stopChannel)
 	_ = server.listener.Close()
+	if server.httpServer != nil {
+		_ = server.httpServer.Close()
+	}
+	if server.openedStore != nil {
+		_ = server.openedStore.Close()
+	}
+}
+
+// serveHTTP serves the HTTP gateway on the listener, in its own goroutine.
+// All the listeners are served by a single http.Server, which is closed along with the server.
+func (server *TCPServer) serveHTTP(listener net.Listener) {
+	if server.httpServer == nil {
+		server.httpServer = &http.Server{Handler: gateway.NewGateway(server.handlers)}
+	}
+	go func() {
+		_ = server.httpServer.Serve(listener)
+	}()
+}
+
+// handle handles the incoming connection in the protocol of the server.
+// With ProtocolAuto, the protocol is detected from the first bytes of the connection, and the connection is closed
+// if it can not authenticate when the server requires authentication.
+func (server *TCPServer) handle(connection net.Conn) {
+	protocol := server.protocol
+	if protocol == ProtocolAuto {
+		detected, sniffedConnection, err := detectProtocol(connection)
+		if err != nil {
+			_ = connection.Close()
+			return
+		}
+		protocol, connection = detected, sniffedConnection
+		if server.requiresAuthentication && protocol != ProtocolProtobuf {
+			_ = connection.Close()
+			return
+		}
+	}
+	switch protocol {
+	case ProtocolHTTP:
+		server.httpListener.handoff(connection)
+	case ProtocolRESP:
+		resp.NewIncomingConnection(connection, server.handlers).Handle()
+	case ProtocolMemcached:
+		memcached.NewIncomingConnection(connection, server.handlers).Handle()
+	default:
+		conn.NewIncomingTCPConnectionWithKeepalive(connection, server.handlers, server.keepalive).Handle()
+	}
+}
+
+// evictExpiredKeys runs in its own goroutine and deletes the expired keys from the store, every ExpiryInterval.
+// It examines at most MaxKeysExaminedPerExpiry keys in one go, so that the connections are not blocked on the store
+// for long.
+func (server *TCPServer) evictExpiredKeys() {
+	ticker := time.NewTicker(ExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.stopChannel:
+			return
+		case <-ticker.C:
+			server.store.EvictExpired(MaxKeysExaminedPerExpiry)
+		}
+	}
+}
+
+// reloadACL runs in its own goroutine and reloads the rules of the ACL if its file is modified, every
+// ACLReloadInterval. The ACL keeps its rules if the file can not be loaded, and the failure is logged once for every
+// modification of the file (see conn.ACL.ReloadIfModified).
+func (server *TCPServer) reloadACL(acl *conn.ACL) {
+	ticker := time.NewTicker(ACLReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.stopChannel:
+			return
+		case <-ticker.C:
+			if _, err := acl.ReloadIfModified(); err != nil {
+				log.Println("Failed to reload the ACL:", err)
+			}
+		}
+	}
 }
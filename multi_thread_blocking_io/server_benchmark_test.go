@@ -0,0 +1,81 @@
+package single_threaded_blocking_io
+
+import (
+	"bytes"
+	"multi_thread_blocking_io/conn"
+	"multi_thread_blocking_io/proto"
+	"net"
+	"testing"
+)
+
+// countingConnection is a net.Conn which counts the bytes read from it.
+type countingConnection struct {
+	net.Conn
+	read int
+}
+
+// Read reads from the underlying connection and counts the bytes.
+func (connection *countingConnection) Read(buffer []byte) (int, error) {
+	n, err := connection.Conn.Read(buffer)
+	connection.read += n
+	return n, err
+}
+
+// BenchmarkGetsALargeValueOverAConnection shows the CPU versus bytes trade-off of compression end to end:
+// compare ns/op and wire-bytes/op of a session without features and a session which has agreed to compression.
+func BenchmarkGetsALargeValueOverAConnection(b *testing.B) {
+	server, err := NewTCPServer("localhost", 7078)
+	if err != nil {
+		b.Fatal(err)
+	}
+	go func() {
+		server.Start()
+	}()
+	defer func() {
+		server.Stop()
+	}()
+
+	value := bytes.Repeat([]byte(`{"disk":"nvme","storage":"lsm","replicas":3},`), 1024)
+
+	for _, variant := range []struct {
+		name     string
+		features uint32
+	}{
+		{name: "Uncompressed", features: 0},
+		{name: "Compressed", features: proto.FeatureCompression},
+	} {
+		b.Run(variant.name, func(b *testing.B) {
+			connection, err := net.Dial("tcp", "localhost:7078")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer func() {
+				_ = connection.Close()
+			}()
+
+			counting := &countingConnection{Conn: connection}
+			connectionReader := conn.NewConnectionReader(counting)
+
+			hello, _ := proto.NewHelloMessage(proto.ProtocolVersion, variant.features).Serialize()
+			putOrUpdate, _ := proto.NewPutOrUpdateKeyValueMessage("DiskType", string(value)).Serialize()
+			for _, buffer := range [][]byte{hello, putOrUpdate} {
+				_, _ = connection.Write(buffer)
+				if _, err := connectionReader.AttemptReadOrErrorOut(); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			get, _ := proto.NewGetValueMessage("DiskType").Serialize()
+			counting.read = 0
+			b.SetBytes(int64(len(value)))
+			b.ResetTimer()
+			for count := 0; count < b.N; count++ {
+				_, _ = connection.Write(get)
+				if _, err := connectionReader.AttemptReadOrErrorOut(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(counting.read)/float64(b.N), "wire-bytes/op")
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCorruptOperations denotes a record of the write-ahead log whose operations can not be decoded.
+var ErrCorruptOperations = errors.New("write-ahead log record has corrupt operations")
+
+// DurableStore represents a Store whose mutations go through a write-ahead log (WAL), so that the Key/Value pairs
+// survive a restart. A mutation is appended to the log as a single record of the puts and the deletes which it makes,
+// and is then applied to the wrapped store (under a lock, so the log has the order of the mutations), and returns
+// (is acknowledged) once the record is synced as per the SyncPolicy of the log. A caller which must not block on
+// the syncs (such as the event loop) mutates a Deferred view of the store instead, and holds back its own
+// acknowledgements till the record is synced.
+// The reads are served by the wrapped store, and may observe a mutation which is not synced yet.
+// A put carries the absolute expiry time of the key, and the expiries are not logged: an expired key is deleted
+// on replay. Every put of the log is replayed (even if the key has expired), so the versions are the same after
+// a replay.
+// If an append fails (see WAL.Err), the mutation is not applied and returns the error, and so do all the subsequent
+// mutations.
+// The mutations are serialized by a single lock, even if the wrapped store is sharded: the records must be appended
+// in the order in which the mutations are applied, so that a replay draws the same versions, and the conditions of
+// a mutation (such as the version of a CompareAndSwap) must be checked along with its append. A lock per shard would
+// need a log per shard, and a mutation of many keys would have to take the locks of all their shards. The lock
+// covers the append (a write to the file) but not the sync, so the concurrent mutations are still synced together;
+// the reads do not take the lock.
+type DurableStore struct {
+	store      Store
+	wal        *WAL
+	lock       *sync.Mutex
+	deferSyncs bool
+	clock      func() time.Time
+}
+
+// OpenDurableStore opens the write-ahead log at the given path, replays it into the (empty) store, and returns
+// the DurableStore which wraps the store.
+func OpenDurableStore(path string, policy SyncPolicy, store Store) (*DurableStore, error) {
+	wal, err := OpenWAL(path, policy)
+	if err != nil {
+		return nil, err
+	}
+	durableStore := &DurableStore{store: store, wal: wal, lock: &sync.Mutex{}, clock: time.Now}
+	if _, err := wal.Replay(durableStore.replay); err != nil {
+		_ = wal.Close()
+		return nil, err
+	}
+	return durableStore, nil
+}
+
+// Deferred returns a view of the store whose mutations return once their records are appended, without waiting
+// for the syncs. The view shares the log, the lock and the wrapped store, so the mutations of both are ordered in
+// the log. The caller of the view is expected to hold back its acknowledgements till Synced reaches the Appended
+// sequence of the mutation (see OnSync, WaitSynced).
+func (store *DurableStore) Deferred() *DurableStore {
+	deferred := *store
+	deferred.deferSyncs = true
+	return &deferred
+}
+
+// OnSync registers onSync which is invoked every time records are synced, or the log fails (see WAL.OnSync).
+// It is expected to be invoked before the first mutation.
+func (store *DurableStore) OnSync(onSync func()) {
+	store.wal.OnSync(onSync)
+}
+
+// Policy returns the SyncPolicy of the log.
+func (store *DurableStore) Policy() SyncPolicy {
+	return store.wal.policy
+}
+
+// Appended returns the sequence of the last record which is appended to the log.
+func (store *DurableStore) Appended() uint64 {
+	return store.wal.Appended()
+}
+
+// Synced returns the sequence of the last record which is synced to the log. Unless the policy is SyncAlways,
+// it may lag behind Appended even after the mutations return, so the caller decides whether to wait for it.
+func (store *DurableStore) Synced() uint64 {
+	return store.wal.Synced()
+}
+
+// WaitSynced blocks till the record with the given sequence is synced, and returns the error of the log if it fails
+// before that (see WAL.WaitSynced).
+func (store *DurableStore) WaitSynced(sequence uint64) error {
+	return store.wal.WaitSynced(sequence)
+}
+
+// Err returns the error which failed the log, if any.
+func (store *DurableStore) Err() error {
+	return store.wal.Err()
+}
+
+// Close closes the write-ahead log, after syncing the appended records.
+func (store *DurableStore) Close() error {
+	return store.wal.Close()
+}
+
+// PutOrUpdate puts or updates the value of the given key.
+func (store *DurableStore) PutOrUpdate(key, value []byte) error {
+	return store.PutOrUpdateWithTTL(key, value, 0)
+}
+
+// PutOrUpdateWithTTL puts or updates the value of the given key, which expires after the given time to live.
+func (store *DurableStore) PutOrUpdateWithTTL(key, value []byte, ttl time.Duration) error {
+	_, err := store.mutate(func() []Operation {
+		return []Operation{{Kind: OperationPutOrUpdate, Key: key, Value: value, TTL: ttl}}
+	})
+	return err
+}
+
+// GetValue gets the value of the given key.
+func (store *DurableStore) GetValue(key []byte) ([]byte, bool) {
+	return store.store.GetValue(key)
+}
+
+// GetVersionedValue gets the value and the version of the given key.
+func (store *DurableStore) GetVersionedValue(key []byte) ([]byte, uint64, bool) {
+	return store.store.GetVersionedValue(key)
+}
+
+// TimeToLive returns the remaining time to live of the given key, and true if the key exists.
+func (store *DurableStore) TimeToLive(key []byte) (time.Duration, bool) {
+	return store.store.TimeToLive(key)
+}
+
+// MultiGet gets the values and the versions of the given keys.
+func (store *DurableStore) MultiGet(keys [][]byte) []VersionedKeyValue {
+	return store.store.MultiGet(keys)
+}
+
+// MultiPutOrUpdate puts or updates the values of all the given pairs atomically, as a single record of the log.
+func (store *DurableStore) MultiPutOrUpdate(pairs []KeyValuePair) ([]uint64, error) {
+	results, err := store.mutate(func() []Operation {
+		operations := make([]Operation, 0, len(pairs))
+		for _, pair := range pairs {
+			operations = append(operations, Operation{Kind: OperationPutOrUpdate, Key: pair.Key, Value: pair.Value})
+		}
+		return operations
+	})
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]uint64, 0, len(results))
+	for _, result := range results {
+		versions = append(versions, result.Version)
+	}
+	return versions, nil
+}
+
+// Transact applies the operations atomically, provided that every watched key still has its watched version.
+// The puts and the deletes of an applied transaction are logged as a single record.
+func (store *DurableStore) Transact(watched []KeyVersion, operations []Operation) ([]VersionedKeyValue, bool, error) {
+	ok := true
+	results, err := store.mutate(func() []Operation {
+		keys := make([][]byte, 0, len(watched))
+		for _, keyVersion := range watched {
+			keys = append(keys, keyVersion.Key)
+		}
+		for index, current := range store.store.MultiGet(keys) {
+			if current.Version != watched[index].Version {
+				ok = false
+				return nil
+			}
+		}
+		return operations
+	})
+	return results, ok, err
+}
+
+// Scan returns the key/value pairs with keys in the range [start, end), in ascending order of keys.
+func (store *DurableStore) Scan(start, end []byte, limit int) []VersionedKeyValue {
+	return store.store.Scan(start, end, limit)
+}
+
+// PrefixScan returns the key/value pairs with keys starting with the given prefix, in ascending order of keys.
+func (store *DurableStore) PrefixScan(prefix []byte, limit int) []VersionedKeyValue {
+	return store.store.PrefixScan(prefix, limit)
+}
+
+// CompareAndSwap puts or updates the value of the given key only if the current version of the key is expectedVersion.
+func (store *DurableStore) CompareAndSwap(key []byte, expectedVersion uint64, value []byte) (uint64, bool, error) {
+	return store.CompareAndSwapWithTTL(key, expectedVersion, value, 0)
+}
+
+// CompareAndSwapWithTTL is the same as CompareAndSwap, and the swapped value expires after the given time to live.
+// Only a swapped value is logged.
+func (store *DurableStore) CompareAndSwapWithTTL(key []byte, expectedVersion uint64, value []byte, ttl time.Duration) (uint64, bool, error) {
+	var version uint64
+	results, err := store.mutate(func() []Operation {
+		if _, version, _ = store.store.GetVersionedValue(key); version != expectedVersion {
+			return nil
+		}
+		return []Operation{{Kind: OperationPutOrUpdate, Key: key, Value: value, TTL: ttl}}
+	})
+	if err != nil || len(results) == 0 {
+		return version, false, err
+	}
+	return results[0].Version, true, nil
+}
+
+// IncrementBy atomically adds delta to the value of the given key, which is parsed as int64.
+// The new value is logged as a put, which retains the time to live of the key.
+func (store *DurableStore) IncrementBy(key []byte, delta int64) (int64, error) {
+	var counter int64
+	var err error
+	_, mutateErr := store.mutate(func() []Operation {
+		value, ok := store.store.GetValue(key)
+		if counter, err = incremented(value, ok, delta); err != nil {
+			return nil
+		}
+		ttl, _ := store.store.TimeToLive(key)
+		return []Operation{{Kind: OperationPutOrUpdate, Key: key, Value: strconv.AppendInt(nil, counter, 10), TTL: ttl}}
+	})
+	if mutateErr != nil {
+		return 0, mutateErr
+	}
+	return counter, err
+}
+
+// Delete deletes the given key, and returns true if the key existed. Only the deletion of an existing key is logged.
+func (store *DurableStore) Delete(key []byte) (bool, error) {
+	results, err := store.mutate(func() []Operation {
+		if _, ok := store.store.GetValue(key); !ok {
+			return nil
+		}
+		return []Operation{{Kind: OperationDelete, Key: key}}
+	})
+	return len(results) > 0, err
+}
+
+// EvictExpired deletes the expired keys from the wrapped store. The evictions are not logged, because the expired
+// keys are deleted on replay.
+func (store *DurableStore) EvictExpired(maxKeys int) int {
+	return store.store.EvictExpired(maxKeys)
+}
+
+// mutate runs the mutation under the lock, which checks its conditions against the wrapped store and returns
+// the operations to apply (nil if there are none). The puts and the deletes of the operations are appended to
+// the log as a single record, and the operations are applied to the wrapped store once the record is appended, so
+// a mutation whose append fails is not applied. mutate then waits for the record to be synced, unless the store is
+// Deferred or the policy is not SyncAlways. It returns the results of the operations (see Store.Transact), and
+// the error of the append or of the sync; a mutation whose sync fails is applied, but is not durable.
+func (store *DurableStore) mutate(mutation func() []Operation) ([]VersionedKeyValue, error) {
+	store.lock.Lock()
+	operations := mutation()
+	if len(operations) == 0 {
+		store.lock.Unlock()
+		return nil, nil
+	}
+	var sequence uint64
+	if mutations := mutationsOf(operations); len(mutations) > 0 {
+		var err error
+		if sequence, err = store.wal.Append(encodeOperations(mutations, store.clock())); err != nil {
+			store.lock.Unlock()
+			return nil, err
+		}
+	}
+	results, _, err := store.store.Transact(nil, operations)
+	store.lock.Unlock()
+
+	if err != nil || sequence == 0 || store.deferSyncs || store.wal.policy.Mode != SyncAlways {
+		return results, err
+	}
+	return results, store.wal.WaitSynced(sequence)
+}
+
+// replay applies the operations of a record of the log to the wrapped store, in a single transaction.
+func (store *DurableStore) replay(payload []byte) error {
+	operations, err := decodeOperations(payload, store.clock())
+	if err != nil {
+		return err
+	}
+	_, _, err = store.store.Transact(nil, operations)
+	return err
+}
+
+// mutationsOf returns the puts and the deletes of the operations.
+func mutationsOf(operations []Operation) []Operation {
+	mutations := make([]Operation, 0, len(operations))
+	for _, operation := range operations {
+		if operation.Kind != OperationGet {
+			mutations = append(mutations, operation)
+		}
+	}
+	return mutations
+}
+
+// encodeOperations encodes the puts and the deletes as the payload of a record.
+// Every operation is encoded as its kind, its key, its value and the absolute expiry time of a put (in Unix
+// nanoseconds, 0 if the key never expires).
+func encodeOperations(operations []Operation, now time.Time) []byte {
+	payload := binary.AppendUvarint(nil, uint64(len(operations)))
+	for _, operation := range operations {
+		expiresAt := int64(0)
+		if operation.Kind == OperationPutOrUpdate && operation.TTL > 0 {
+			expiresAt = now.Add(operation.TTL).UnixNano()
+		}
+		payload = append(payload, byte(operation.Kind))
+		payload = binary.AppendUvarint(payload, uint64(len(operation.Key)))
+		payload = append(payload, operation.Key...)
+		payload = binary.AppendUvarint(payload, uint64(len(operation.Value)))
+		payload = append(payload, operation.Value...)
+		payload = binary.AppendVarint(payload, expiresAt)
+	}
+	return payload
+}
+
+// decodeOperations decodes the payload of a record into the operations to replay at the given time.
+// A put of a key which has expired is replayed as the put followed by the delete of the key.
+func decodeOperations(payload []byte, now time.Time) ([]Operation, error) {
+	count, n := binary.Uvarint(payload)
+	if n <= 0 {
+		return nil, ErrCorruptOperations
+	}
+	payload = payload[n:]
+
+	readBytes := func() ([]byte, bool) {
+		length, n := binary.Uvarint(payload)
+		if n <= 0 || uint64(len(payload)-n) < length {
+			return nil, false
+		}
+		bytes := payload[n : n+int(length)]
+		payload = payload[n+int(length):]
+		return bytes, true
+	}
+	var operations []Operation
+	for index := uint64(0); index < count; index++ {
+		if len(payload) == 0 {
+			return nil, ErrCorruptOperations
+		}
+		kind := OperationKind(payload[0])
+		payload = payload[1:]
+		key, ok := readBytes()
+		if !ok {
+			return nil, ErrCorruptOperations
+		}
+		value, ok := readBytes()
+		if !ok {
+			return nil, ErrCorruptOperations
+		}
+		expiresAt, n := binary.Varint(payload)
+		if n <= 0 {
+			return nil, ErrCorruptOperations
+		}
+		payload = payload[n:]
+
+		switch kind {
+		case OperationPutOrUpdate:
+			operation := Operation{Kind: OperationPutOrUpdate, Key: key, Value: value}
+			if expiresAt == 0 {
+				operations = append(operations, operation)
+				continue
+			}
+			if ttl := time.Unix(0, expiresAt).Sub(now); ttl > 0 {
+				operation.TTL = ttl
+				operations = append(operations, operation)
+				continue
+			}
+			operations = append(operations, operation, Operation{Kind: OperationDelete, Key: key})
+		case OperationDelete:
+			operations = append(operations, Operation{Kind: OperationDelete, Key: key})
+		default:
+			return nil, ErrCorruptOperations
+		}
+	}
+	return operations, nil
+}
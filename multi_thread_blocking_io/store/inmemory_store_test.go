@@ -2,35 +2,424 @@ package store
 
 import (
 	"github.com/stretchr/testify/assert"
+	"math"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestPutsAKeyValuePair(t *testing.T) {
 	store := NewInMemoryStore()
-	store.PutOrUpdate("DiskType", "SSD")
+	store.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
 
-	value, ok := store.GetValue("DiskType")
+	value, ok := store.GetValue([]byte("DiskType"))
 
 	assert.True(t, ok)
-	assert.Equal(t, "SSD", value)
+	assert.Equal(t, []byte("SSD"), value)
 }
 
 func TestUpdatesTheValueOfAKey(t *testing.T) {
 	store := NewInMemoryStore()
-	store.PutOrUpdate("DiskType", "SSD")
+	store.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
 
-	store.PutOrUpdate("DiskType", "HDD")
-	value, ok := store.GetValue("DiskType")
+	store.PutOrUpdate([]byte("DiskType"), []byte("HDD"))
+	value, ok := store.GetValue([]byte("DiskType"))
 
 	assert.True(t, ok)
-	assert.Equal(t, "HDD", value)
+	assert.Equal(t, []byte("HDD"), value)
 }
 
 func TestGetsTheValueOfANonExistingKey(t *testing.T) {
 	store := NewInMemoryStore()
 
-	value, ok := store.GetValue("DiskType")
+	value, ok := store.GetValue([]byte("DiskType"))
 
 	assert.False(t, ok)
 	assert.Empty(t, value)
 }
+
+func TestDeletesAnExistingKey(t *testing.T) {
+	store := NewInMemoryStore()
+	store.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+
+	ok, _ := store.Delete([]byte("DiskType"))
+	assert.True(t, ok)
+
+	_, ok = store.GetValue([]byte("DiskType"))
+	assert.False(t, ok)
+}
+
+func TestDeletesANonExistingKey(t *testing.T) {
+	store := NewInMemoryStore()
+
+	ok, _ := store.Delete([]byte("DiskType"))
+	assert.False(t, ok)
+}
+
+func TestGetsTheVersionOfAKey(t *testing.T) {
+	store := NewInMemoryStore()
+	store.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+
+	_, version, ok := store.GetVersionedValue([]byte("DiskType"))
+	assert.True(t, ok)
+
+	store.PutOrUpdate([]byte("DiskType"), []byte("HDD"))
+
+	_, newVersion, ok := store.GetVersionedValue([]byte("DiskType"))
+	assert.True(t, ok)
+	assert.Greater(t, newVersion, version)
+}
+
+func TestCompareAndSwapANonExistingKey(t *testing.T) {
+	store := NewInMemoryStore()
+
+	version, ok, _ := store.CompareAndSwap([]byte("DiskType"), 0, []byte("SSD"))
+	assert.True(t, ok)
+
+	value, currentVersion, _ := store.GetVersionedValue([]byte("DiskType"))
+	assert.Equal(t, []byte("SSD"), value)
+	assert.Equal(t, version, currentVersion)
+}
+
+func TestCompareAndSwapWithTheCurrentVersion(t *testing.T) {
+	store := NewInMemoryStore()
+	store.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+
+	_, version, _ := store.GetVersionedValue([]byte("DiskType"))
+	_, ok, _ := store.CompareAndSwap([]byte("DiskType"), version, []byte("HDD"))
+	assert.True(t, ok)
+
+	value, _ := store.GetValue([]byte("DiskType"))
+	assert.Equal(t, []byte("HDD"), value)
+}
+
+func TestCompareAndSwapWithAStaleVersion(t *testing.T) {
+	store := NewInMemoryStore()
+	store.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+
+	_, staleVersion, _ := store.GetVersionedValue([]byte("DiskType"))
+	store.PutOrUpdate([]byte("DiskType"), []byte("NVMe"))
+
+	currentVersion, ok, _ := store.CompareAndSwap([]byte("DiskType"), staleVersion, []byte("HDD"))
+	assert.False(t, ok)
+	assert.NotEqual(t, staleVersion, currentVersion)
+
+	value, _ := store.GetValue([]byte("DiskType"))
+	assert.Equal(t, []byte("NVMe"), value)
+}
+
+func TestCompareAndSwapAnExistingKeyWithoutVersion(t *testing.T) {
+	store := NewInMemoryStore()
+	store.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+
+	_, ok, _ := store.CompareAndSwap([]byte("DiskType"), 0, []byte("HDD"))
+	assert.False(t, ok)
+}
+
+func TestMultiPutOrUpdateAndMultiGet(t *testing.T) {
+	store := NewInMemoryStore()
+	versions, _ := store.MultiPutOrUpdate([]KeyValuePair{
+		{Key: []byte("DiskType"), Value: []byte("SSD")},
+		{Key: []byte("Storage"), Value: []byte("LSM")},
+	})
+
+	assert.Equal(t, 2, len(versions))
+
+	values := store.MultiGet([][]byte{[]byte("DiskType"), []byte("System"), []byte("Storage")})
+
+	assert.Equal(t, []VersionedKeyValue{
+		{Key: []byte("DiskType"), Value: []byte("SSD"), Version: versions[0], Exists: true},
+		{Key: []byte("System")},
+		{Key: []byte("Storage"), Value: []byte("LSM"), Version: versions[1], Exists: true},
+	}, values)
+}
+
+func TestScansKeysInARange(t *testing.T) {
+	store := NewInMemoryStore()
+	store.PutOrUpdate([]byte("Storage"), []byte("LSM"))
+	store.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+	store.PutOrUpdate([]byte("System"), []byte("Distributed"))
+	store.PutOrUpdate([]byte("Consensus"), []byte("Raft"))
+
+	values := store.Scan([]byte("D"), []byte("T"), 0)
+
+	assert.Equal(t, 3, len(values))
+	assert.Equal(t, []byte("DiskType"), values[0].Key)
+	assert.Equal(t, []byte("Storage"), values[1].Key)
+	assert.Equal(t, []byte("System"), values[2].Key)
+}
+
+func TestScansKeysInARangeWithLimit(t *testing.T) {
+	store := NewInMemoryStore()
+	store.PutOrUpdate([]byte("Storage"), []byte("LSM"))
+	store.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+	store.PutOrUpdate([]byte("System"), []byte("Distributed"))
+
+	values := store.Scan(nil, nil, 2)
+
+	assert.Equal(t, 2, len(values))
+	assert.Equal(t, []byte("DiskType"), values[0].Key)
+	assert.Equal(t, []byte("SSD"), values[0].Value)
+	assert.Equal(t, []byte("Storage"), values[1].Key)
+}
+
+func TestPrefixScansKeys(t *testing.T) {
+	store := NewInMemoryStore()
+	store.PutOrUpdate([]byte("disk:ssd"), []byte("SSD"))
+	store.PutOrUpdate([]byte("storage:lsm"), []byte("LSM"))
+	store.PutOrUpdate([]byte("disk:nvme"), []byte("NVMe"))
+
+	values := store.PrefixScan([]byte("disk:"), 0)
+
+	assert.Equal(t, 2, len(values))
+	assert.Equal(t, []byte("disk:nvme"), values[0].Key)
+	assert.Equal(t, []byte("disk:ssd"), values[1].Key)
+}
+
+func TestGetsAKeyBeforeItExpires(t *testing.T) {
+	store := NewInMemoryStore()
+	now := time.Now()
+	store.clock = func() time.Time { return now }
+
+	store.PutOrUpdateWithTTL([]byte("DiskType"), []byte("SSD"), 5*time.Second)
+	now = now.Add(4 * time.Second)
+
+	value, ok := store.GetValue([]byte("DiskType"))
+
+	assert.True(t, ok)
+	assert.Equal(t, []byte("SSD"), value)
+}
+
+func TestDoesNotGetAnExpiredKey(t *testing.T) {
+	store := NewInMemoryStore()
+	now := time.Now()
+	store.clock = func() time.Time { return now }
+
+	store.PutOrUpdateWithTTL([]byte("DiskType"), []byte("SSD"), 5*time.Second)
+	now = now.Add(5 * time.Second)
+
+	_, ok := store.GetValue([]byte("DiskType"))
+	assert.False(t, ok)
+
+	_, ok = store.entries.get("DiskType")
+	assert.False(t, ok)
+}
+
+func TestPutOrUpdateClearsTheTimeToLive(t *testing.T) {
+	store := NewInMemoryStore()
+	now := time.Now()
+	store.clock = func() time.Time { return now }
+
+	store.PutOrUpdateWithTTL([]byte("DiskType"), []byte("SSD"), 5*time.Second)
+	store.PutOrUpdate([]byte("DiskType"), []byte("HDD"))
+	now = now.Add(10 * time.Second)
+
+	value, ok := store.GetValue([]byte("DiskType"))
+
+	assert.True(t, ok)
+	assert.Equal(t, []byte("HDD"), value)
+}
+
+func TestCompareAndSwapWithATimeToLive(t *testing.T) {
+	store := NewInMemoryStore()
+	now := time.Now()
+	store.clock = func() time.Time { return now }
+
+	_, ok, _ := store.CompareAndSwapWithTTL([]byte("DiskType"), 0, []byte("SSD"), 5*time.Second)
+	assert.True(t, ok)
+
+	now = now.Add(5 * time.Second)
+
+	_, ok = store.GetValue([]byte("DiskType"))
+	assert.False(t, ok)
+}
+
+func TestGetsTheTimeToLiveOfAKey(t *testing.T) {
+	store := NewInMemoryStore()
+	now := time.Now()
+	store.clock = func() time.Time { return now }
+
+	store.PutOrUpdateWithTTL([]byte("DiskType"), []byte("SSD"), 5*time.Second)
+	store.PutOrUpdate([]byte("Storage"), []byte("LSM"))
+	now = now.Add(2 * time.Second)
+
+	ttl, ok := store.TimeToLive([]byte("DiskType"))
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, ttl)
+
+	ttl, ok = store.TimeToLive([]byte("Storage"))
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), ttl)
+
+	_, ok = store.TimeToLive([]byte("System"))
+	assert.False(t, ok)
+}
+
+func TestEvictsExpiredKeys(t *testing.T) {
+	store := NewInMemoryStore()
+	now := time.Now()
+	store.clock = func() time.Time { return now }
+
+	store.PutOrUpdateWithTTL([]byte("DiskType"), []byte("SSD"), time.Second)
+	store.PutOrUpdateWithTTL([]byte("Storage"), []byte("LSM"), time.Second)
+	store.PutOrUpdateWithTTL([]byte("System"), []byte("Distributed"), time.Minute)
+	store.PutOrUpdate([]byte("Consensus"), []byte("Raft"))
+	now = now.Add(2 * time.Second)
+
+	evicted := store.EvictExpired(10)
+
+	assert.Equal(t, 2, evicted)
+	assert.Equal(t, 2, store.entries.length)
+	assert.Equal(t, 1, len(store.expiringKeys))
+}
+
+func TestScanSkipsExpiredKeys(t *testing.T) {
+	store := NewInMemoryStore()
+	now := time.Now()
+	store.clock = func() time.Time { return now }
+
+	store.PutOrUpdateWithTTL([]byte("disk:ssd"), []byte("SSD"), time.Second)
+	store.PutOrUpdate([]byte("disk:nvme"), []byte("NVMe"))
+	now = now.Add(2 * time.Second)
+
+	values := store.PrefixScan([]byte("disk:"), 0)
+
+	assert.Equal(t, 1, len(values))
+	assert.Equal(t, []byte("disk:nvme"), values[0].Key)
+}
+
+func TestIncrementsANonExistingKey(t *testing.T) {
+	store := NewInMemoryStore()
+
+	counter, err := store.IncrementBy([]byte("Counter"), 5)
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), counter)
+
+	value, _ := store.GetValue([]byte("Counter"))
+	assert.Equal(t, []byte("5"), value)
+}
+
+func TestIncrementsAndDecrementsAnExistingKey(t *testing.T) {
+	store := NewInMemoryStore()
+	store.PutOrUpdate([]byte("Counter"), []byte("10"))
+
+	counter, err := store.IncrementBy([]byte("Counter"), 5)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(15), counter)
+
+	counter, err = store.IncrementBy([]byte("Counter"), -20)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-5), counter)
+}
+
+func TestIncrementRetainsTheTimeToLive(t *testing.T) {
+	store := NewInMemoryStore()
+	now := time.Now()
+	store.clock = func() time.Time { return now }
+
+	store.PutOrUpdateWithTTL([]byte("Counter"), []byte("10"), 5*time.Second)
+	_, err := store.IncrementBy([]byte("Counter"), 1)
+	assert.Nil(t, err)
+
+	ttl, _ := store.TimeToLive([]byte("Counter"))
+	assert.Equal(t, 5*time.Second, ttl)
+}
+
+func TestDoesNotIncrementANonNumericValue(t *testing.T) {
+	store := NewInMemoryStore()
+	store.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+
+	_, err := store.IncrementBy([]byte("DiskType"), 1)
+
+	assert.ErrorIs(t, err, ErrNotANumber)
+}
+
+func TestDoesNotIncrementBeyondInt64(t *testing.T) {
+	store := NewInMemoryStore()
+	store.PutOrUpdate([]byte("Counter"), []byte(strconv.FormatInt(math.MaxInt64, 10)))
+
+	_, err := store.IncrementBy([]byte("Counter"), 1)
+	assert.ErrorIs(t, err, ErrCounterOverflow)
+
+	store.PutOrUpdate([]byte("Counter"), []byte(strconv.FormatInt(math.MinInt64, 10)))
+
+	_, err = store.IncrementBy([]byte("Counter"), -1)
+	assert.ErrorIs(t, err, ErrCounterOverflow)
+}
+
+func TestPutsAndScansBinaryKeysAndValues(t *testing.T) {
+	store := NewInMemoryStore()
+	key, value := []byte{0x00, 0xff, 0xfe}, []byte{0xc3, 0x28, 0x00, '\n'}
+	store.PutOrUpdate(key, value)
+	store.PutOrUpdate([]byte{0x00, 0x01}, []byte("low"))
+
+	storedValue, ok := store.GetValue(key)
+	assert.True(t, ok)
+	assert.Equal(t, value, storedValue)
+
+	values := store.PrefixScan([]byte{0x00}, 0)
+	assert.Equal(t, 2, len(values))
+	assert.Equal(t, []byte{0x00, 0x01}, values[0].Key)
+	assert.Equal(t, key, values[1].Key)
+}
+
+func TestStoresACopyOfTheValue(t *testing.T) {
+	store := NewInMemoryStore()
+	value := []byte("SSD")
+	store.PutOrUpdate([]byte("DiskType"), value)
+
+	copy(value, "HDD")
+
+	storedValue, _ := store.GetValue([]byte("DiskType"))
+	assert.Equal(t, []byte("SSD"), storedValue)
+}
+
+func TestAppliesTheOperationsOfATransaction(t *testing.T) {
+	store := NewInMemoryStore()
+	store.PutOrUpdate([]byte("Engine"), []byte("btree"))
+
+	results, ok, _ := store.Transact(nil, []Operation{
+		{Kind: OperationPutOrUpdate, Key: []byte("DiskType"), Value: []byte("SSD")},
+		{Kind: OperationGet, Key: []byte("DiskType")},
+		{Kind: OperationDelete, Key: []byte("Engine")},
+		{Kind: OperationDelete, Key: []byte("Cache")},
+	})
+
+	assert.True(t, ok)
+	assert.Equal(t, 4, len(results))
+	assert.Equal(t, uint64(2), results[0].Version)
+	assert.Equal(t, []byte("SSD"), results[1].Value)
+	assert.Equal(t, uint64(2), results[1].Version)
+	assert.True(t, results[2].Exists)
+	assert.False(t, results[3].Exists)
+
+	_, ok = store.GetValue([]byte("Engine"))
+	assert.False(t, ok)
+}
+
+func TestDoesNotApplyATransactionIfAWatchedKeyHasChanged(t *testing.T) {
+	store := NewInMemoryStore()
+	store.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+	_, version, _ := store.GetVersionedValue([]byte("DiskType"))
+	watched := []KeyVersion{{Key: []byte("DiskType"), Version: version}, {Key: []byte("Engine")}}
+
+	store.PutOrUpdate([]byte("Engine"), []byte("btree"))
+	_, ok, _ := store.Transact(watched, []Operation{
+		{Kind: OperationPutOrUpdate, Key: []byte("DiskType"), Value: []byte("HDD")},
+	})
+
+	assert.False(t, ok)
+	value, _ := store.GetValue([]byte("DiskType"))
+	assert.Equal(t, []byte("SSD"), value)
+
+	// a key which did not exist is unchanged once it is deleted again.
+	store.Delete([]byte("Engine"))
+	_, ok, _ = store.Transact(watched, []Operation{
+		{Kind: OperationPutOrUpdate, Key: []byte("DiskType"), Value: []byte("HDD")},
+	})
+
+	assert.True(t, ok)
+	value, _ = store.GetValue([]byte("DiskType"))
+	assert.Equal(t, []byte("HDD"), value)
+}
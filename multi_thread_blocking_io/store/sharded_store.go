@@ -0,0 +1,285 @@
+package store
+
+import (
+	"bytes"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultShards is the number of shards of a ShardedInMemoryStore which is created by NewShardedInMemoryStore.
+const DefaultShards = 64
+
+// ShardedInMemoryStore represents a store to hold Key/Value pairs in RAM, with the same API as InMemoryStore.
+// The keys are spread over a power-of-two number of shards by the hash of the key, and every shard is an
+// InMemoryStore with its own lock (lock striping), so the operations on the keys of different shards do not contend
+// for a single lock.
+// Every shard draws its versions from a counter of its own, whose versions are interleaved with those of the other
+// shards (see versionCounter), so the writes to different shards do not contend for a counter and a version is never
+// reused across the shards. The shard of a key is chosen by a hash without a seed, so a key lands in the same shard
+// (and draws the same versions) when a write-ahead log is replayed after a restart.
+// MultiGet, MultiPutOrUpdate and Transact lock the shards of their keys in ascending order of shards, and the scans
+// read-lock all the shards, so these operations remain atomic (and free of deadlocks) across the shards.
+type ShardedInMemoryStore struct {
+	shards       []*InMemoryStore
+	mask         uint64
+	evictionTurn atomic.Uint64
+}
+
+// NewShardedInMemoryStore creates a new instance of ShardedInMemoryStore with DefaultShards shards.
+func NewShardedInMemoryStore() *ShardedInMemoryStore {
+	return NewShardedInMemoryStoreWithShards(DefaultShards)
+}
+
+// NewShardedInMemoryStoreWithShards creates a new instance of ShardedInMemoryStore with the given number of shards,
+// which is rounded up to a power of two.
+func NewShardedInMemoryStoreWithShards(shards int) *ShardedInMemoryStore {
+	count := 1
+	for count < shards {
+		count <<= 1
+	}
+	store := &ShardedInMemoryStore{
+		shards: make([]*InMemoryStore, count),
+		mask:   uint64(count - 1),
+	}
+	for index := range store.shards {
+		shard := NewInMemoryStore()
+		shard.versions = versionCounter{stride: uint64(count), offset: uint64(index)}
+		store.shards[index] = shard
+	}
+	return store
+}
+
+// Shards returns the number of shards.
+func (store *ShardedInMemoryStore) Shards() int {
+	return len(store.shards)
+}
+
+// PutOrUpdate puts or updates the value of the given key.
+// The key does not expire, even if it had a time to live earlier.
+func (store *ShardedInMemoryStore) PutOrUpdate(key, value []byte) error {
+	return store.shardOf(key).PutOrUpdate(key, value)
+}
+
+// PutOrUpdateWithTTL puts or updates the value of the given key, which expires after the given time to live.
+// A time to live of 0 denotes that the key never expires.
+func (store *ShardedInMemoryStore) PutOrUpdateWithTTL(key, value []byte, ttl time.Duration) error {
+	return store.shardOf(key).PutOrUpdateWithTTL(key, value, ttl)
+}
+
+// GetValue gets the value of the given key.
+// The returned value is shared with the store, and must not be modified.
+func (store *ShardedInMemoryStore) GetValue(key []byte) ([]byte, bool) {
+	return store.shardOf(key).GetValue(key)
+}
+
+// GetVersionedValue gets the value and the version of the given key.
+func (store *ShardedInMemoryStore) GetVersionedValue(key []byte) ([]byte, uint64, bool) {
+	return store.shardOf(key).GetVersionedValue(key)
+}
+
+// TimeToLive returns the remaining time to live of the given key, and true if the key exists.
+// A remaining time to live of 0 denotes that the key never expires.
+func (store *ShardedInMemoryStore) TimeToLive(key []byte) (time.Duration, bool) {
+	return store.shardOf(key).TimeToLive(key)
+}
+
+// MultiGet gets the values and the versions of the given keys.
+// The shards of all the keys are read-locked together, so the result is a consistent snapshot.
+func (store *ShardedInMemoryStore) MultiGet(keys [][]byte) []VersionedKeyValue {
+	unlock := store.lockShardsOf(keys, false)
+	defer unlock()
+
+	now := store.now()
+	values := make([]VersionedKeyValue, 0, len(keys))
+	for _, key := range keys {
+		versioned, ok := store.shardOf(key).get(string(key), now)
+		values = append(values, VersionedKeyValue{Key: key, Value: versioned.value, Version: versioned.version, Exists: ok})
+	}
+	return values
+}
+
+// MultiPutOrUpdate puts or updates the values of all the given pairs atomically, under the locks of their shards.
+// It returns the new version of each pair, in the order of the pairs.
+func (store *ShardedInMemoryStore) MultiPutOrUpdate(pairs []KeyValuePair) ([]uint64, error) {
+	keys := make([][]byte, 0, len(pairs))
+	for _, pair := range pairs {
+		keys = append(keys, pair.Key)
+	}
+	unlock := store.lockShardsOf(keys, true)
+	defer unlock()
+
+	versions := make([]uint64, 0, len(pairs))
+	for _, pair := range pairs {
+		versions = append(versions, store.shardOf(pair.Key).put(string(pair.Key), pair.Value, time.Time{}))
+	}
+	return versions, nil
+}
+
+// Transact applies the operations in order, atomically under the locks of the shards of the watched keys and of
+// the keys of the operations, provided that every watched key still has its watched version.
+// It behaves as InMemoryStore.Transact otherwise.
+func (store *ShardedInMemoryStore) Transact(watched []KeyVersion, operations []Operation) ([]VersionedKeyValue, bool, error) {
+	keys := make([][]byte, 0, len(watched)+len(operations))
+	for _, keyVersion := range watched {
+		keys = append(keys, keyVersion.Key)
+	}
+	for _, operation := range operations {
+		keys = append(keys, operation.Key)
+	}
+	unlock := store.lockShardsOf(keys, true)
+	defer unlock()
+
+	results, ok := transact(watched, operations, store.now(), store.shardOf)
+	return results, ok, nil
+}
+
+// Scan returns the key/value pairs with keys in the range [start, end), in ascending order of keys.
+// An empty end denotes no upper bound, and a limit of 0 denotes no limit on the number of pairs.
+// All the shards are read-locked together, so the result is a consistent snapshot.
+func (store *ShardedInMemoryStore) Scan(start, end []byte, limit int) []VersionedKeyValue {
+	unlock := store.lockAllShards()
+	defer unlock()
+
+	now := store.now()
+	var values []VersionedKeyValue
+	for _, shard := range store.shards {
+		var shardValues []VersionedKeyValue
+		shard.entries.scan(string(start), string(end), collectInto(&shardValues, limit, now))
+		values = append(values, shardValues...)
+	}
+	return sortedWithin(values, limit)
+}
+
+// PrefixScan returns the key/value pairs with keys starting with the given prefix, in ascending order of keys.
+// A limit of 0 denotes no limit on the number of pairs.
+func (store *ShardedInMemoryStore) PrefixScan(prefix []byte, limit int) []VersionedKeyValue {
+	unlock := store.lockAllShards()
+	defer unlock()
+
+	now := store.now()
+	var values []VersionedKeyValue
+	for _, shard := range store.shards {
+		var shardValues []VersionedKeyValue
+		shard.entries.prefixScan(string(prefix), collectInto(&shardValues, limit, now))
+		values = append(values, shardValues...)
+	}
+	return sortedWithin(values, limit)
+}
+
+// CompareAndSwap puts or updates the value of the given key only if the current version of the key is expectedVersion.
+// An expectedVersion of 0 denotes that the key must not exist.
+// It returns the new version and true if the value is swapped, else the current version and false.
+func (store *ShardedInMemoryStore) CompareAndSwap(key []byte, expectedVersion uint64, value []byte) (uint64, bool, error) {
+	return store.shardOf(key).CompareAndSwap(key, expectedVersion, value)
+}
+
+// CompareAndSwapWithTTL is the same as CompareAndSwap, and the swapped value expires after the given time to live.
+func (store *ShardedInMemoryStore) CompareAndSwapWithTTL(key []byte, expectedVersion uint64, value []byte, ttl time.Duration) (uint64, bool, error) {
+	return store.shardOf(key).CompareAndSwapWithTTL(key, expectedVersion, value, ttl)
+}
+
+// IncrementBy atomically adds delta (which may be negative) to the value of the given key, which is parsed as int64.
+func (store *ShardedInMemoryStore) IncrementBy(key []byte, delta int64) (int64, error) {
+	return store.shardOf(key).IncrementBy(key, delta)
+}
+
+// Delete deletes the given key.
+// It returns true if the key existed.
+func (store *ShardedInMemoryStore) Delete(key []byte) (bool, error) {
+	return store.shardOf(key).Delete(key)
+}
+
+// EvictExpired deletes the expired keys (active expiry), and returns the number of deleted keys.
+// It examines at most maxKeys keys which carry a time to live, spread evenly over the shards, and locks one shard at
+// a time. The shards which examine the remainder keys take turns, so no shard is starved if maxKeys is smaller than
+// the number of shards.
+func (store *ShardedInMemoryStore) EvictExpired(maxKeys int) int {
+	count := len(store.shards)
+	perShard, remainder := maxKeys/count, maxKeys%count
+	turn := int(store.evictionTurn.Add(uint64(remainder)) % uint64(count))
+
+	evicted := 0
+	for index, shard := range store.shards {
+		maxShardKeys := perShard
+		if (index-turn+count)%count < remainder {
+			maxShardKeys++
+		}
+		if maxShardKeys > 0 {
+			evicted += shard.EvictExpired(maxShardKeys)
+		}
+	}
+	return evicted
+}
+
+// shardOf returns the shard which holds the given key.
+func (store *ShardedInMemoryStore) shardOf(key []byte) *InMemoryStore {
+	return store.shards[store.shardIndexOf(key)]
+}
+
+// shardIndexOf returns the index of the shard which holds the given key, by the 64-bit FNV-1a hash of the key.
+func (store *ShardedInMemoryStore) shardIndexOf(key []byte) int {
+	hash := uint64(14695981039346656037)
+	for _, b := range key {
+		hash ^= uint64(b)
+		hash *= 1099511628211
+	}
+	return int(hash & store.mask)
+}
+
+// lockShardsOf locks the shards of the given keys in ascending order of shards (exclusively, or for reading), and
+// returns the function which unlocks them.
+func (store *ShardedInMemoryStore) lockShardsOf(keys [][]byte, exclusive bool) func() {
+	indices := make([]int, 0, len(keys))
+	for _, key := range keys {
+		indices = append(indices, store.shardIndexOf(key))
+	}
+	slices.Sort(indices)
+	indices = slices.Compact(indices)
+
+	for _, index := range indices {
+		if exclusive {
+			store.shards[index].lock.Lock()
+		} else {
+			store.shards[index].lock.RLock()
+		}
+	}
+	return func() {
+		for _, index := range indices {
+			if exclusive {
+				store.shards[index].lock.Unlock()
+			} else {
+				store.shards[index].lock.RUnlock()
+			}
+		}
+	}
+}
+
+// lockAllShards read-locks all the shards in ascending order of shards, and returns the function which unlocks them.
+func (store *ShardedInMemoryStore) lockAllShards() func() {
+	for _, shard := range store.shards {
+		shard.lock.RLock()
+	}
+	return func() {
+		for _, shard := range store.shards {
+			shard.lock.RUnlock()
+		}
+	}
+}
+
+// now returns the current time, as per the clock of the shards.
+func (store *ShardedInMemoryStore) now() time.Time {
+	return store.shards[0].clock()
+}
+
+// sortedWithin sorts the pairs which are collected from all the shards in ascending order of keys, and returns at
+// most limit pairs. Every shard collects at most limit pairs, so the first limit pairs across the shards are among them.
+func sortedWithin(values []VersionedKeyValue, limit int) []VersionedKeyValue {
+	slices.SortFunc(values, func(one, other VersionedKeyValue) int {
+		return bytes.Compare(one.Key, other.Key)
+	})
+	if limit > 0 && len(values) > limit {
+		values = values[:limit]
+	}
+	return values
+}
@@ -0,0 +1,163 @@
+package store
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRoundsTheShardsUpToAPowerOfTwo(t *testing.T) {
+	assert.Equal(t, 1, NewShardedInMemoryStoreWithShards(0).Shards())
+	assert.Equal(t, 8, NewShardedInMemoryStoreWithShards(5).Shards())
+	assert.Equal(t, 16, NewShardedInMemoryStoreWithShards(16).Shards())
+}
+
+func TestPutsAndGetsKeysAcrossTheShards(t *testing.T) {
+	store := NewShardedInMemoryStoreWithShards(8)
+	for count := 0; count < 100; count++ {
+		store.PutOrUpdate([]byte(fmt.Sprintf("key-%03d", count)), []byte(fmt.Sprintf("value-%03d", count)))
+	}
+
+	for count := 0; count < 100; count++ {
+		value, ok := store.GetValue([]byte(fmt.Sprintf("key-%03d", count)))
+		assert.True(t, ok)
+		assert.Equal(t, []byte(fmt.Sprintf("value-%03d", count)), value)
+	}
+	ok, err := store.Delete([]byte("key-042"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, ok = store.GetValue([]byte("key-042"))
+	assert.False(t, ok)
+}
+
+func TestDrawsUniqueVersionsAcrossTheShards(t *testing.T) {
+	store := NewShardedInMemoryStoreWithShards(8)
+	versions := make(map[uint64]bool)
+	for count := 0; count < 100; count++ {
+		key := []byte(fmt.Sprintf("key-%03d", count))
+		store.PutOrUpdate(key, []byte("value"))
+		_, version, _ := store.GetVersionedValue(key)
+		versions[version] = true
+	}
+
+	assert.Equal(t, 100, len(versions))
+}
+
+func TestDrawsIncreasingVersionsOfAKeyWhichAreTheSameInAnotherStore(t *testing.T) {
+	store, otherStore := NewShardedInMemoryStoreWithShards(8), NewShardedInMemoryStoreWithShards(8)
+	var previous uint64
+	for count := 0; count < 10; count++ {
+		for _, key := range [][]byte{[]byte("DiskType"), []byte(fmt.Sprintf("key-%03d", count))} {
+			store.PutOrUpdate(key, []byte("value"))
+			otherStore.PutOrUpdate(key, []byte("value"))
+		}
+		_, version, _ := store.GetVersionedValue([]byte("DiskType"))
+		_, otherVersion, _ := otherStore.GetVersionedValue([]byte("DiskType"))
+
+		assert.Greater(t, version, previous)
+		assert.Equal(t, version, otherVersion)
+		previous = version
+	}
+}
+
+func TestMultiPutOrUpdateAndMultiGetAcrossTheShards(t *testing.T) {
+	store := NewShardedInMemoryStoreWithShards(8)
+	versions, _ := store.MultiPutOrUpdate([]KeyValuePair{
+		{Key: []byte("DiskType"), Value: []byte("SSD")},
+		{Key: []byte("Storage"), Value: []byte("LSM")},
+		{Key: []byte("Consensus"), Value: []byte("Raft")},
+	})
+
+	values := store.MultiGet([][]byte{[]byte("Storage"), []byte("Cache"), []byte("DiskType")})
+
+	assert.Equal(t, 3, len(versions))
+	assert.Equal(t, []byte("LSM"), values[0].Value)
+	assert.Equal(t, versions[1], values[0].Version)
+	assert.False(t, values[1].Exists)
+	assert.Equal(t, []byte("SSD"), values[2].Value)
+}
+
+func TestScansKeysInARangeWithLimitAcrossTheShards(t *testing.T) {
+	store := NewShardedInMemoryStoreWithShards(8)
+	for count := 0; count < 100; count++ {
+		store.PutOrUpdate([]byte(fmt.Sprintf("key-%03d", count)), []byte("value"))
+	}
+
+	values := store.Scan([]byte("key-010"), []byte("key-020"), 0)
+	assert.Equal(t, 10, len(values))
+	for index, value := range values {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", 10+index)), value.Key)
+	}
+
+	values = store.PrefixScan([]byte("key-0"), 5)
+	assert.Equal(t, 5, len(values))
+	for index, value := range values {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", index)), value.Key)
+	}
+}
+
+func TestAppliesATransactionAcrossTheShards(t *testing.T) {
+	store := NewShardedInMemoryStoreWithShards(8)
+	store.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+	_, version, _ := store.GetVersionedValue([]byte("DiskType"))
+	watched := []KeyVersion{{Key: []byte("DiskType"), Version: version}, {Key: []byte("Engine")}}
+
+	results, ok, _ := store.Transact(watched, []Operation{
+		{Kind: OperationPutOrUpdate, Key: []byte("Engine"), Value: []byte("btree")},
+		{Kind: OperationGet, Key: []byte("DiskType")},
+		{Kind: OperationDelete, Key: []byte("DiskType")},
+	})
+
+	assert.True(t, ok)
+	_, engineVersion, _ := store.GetVersionedValue([]byte("Engine"))
+	assert.Equal(t, engineVersion, results[0].Version)
+	assert.Equal(t, []byte("SSD"), results[1].Value)
+	assert.True(t, results[2].Exists)
+
+	_, ok, _ = store.Transact(watched, []Operation{
+		{Kind: OperationPutOrUpdate, Key: []byte("DiskType"), Value: []byte("HDD")},
+	})
+	assert.False(t, ok)
+}
+
+func TestEvictsExpiredKeysAcrossTheShards(t *testing.T) {
+	store := NewShardedInMemoryStoreWithShards(8)
+	now := time.Now()
+	for _, shard := range store.shards {
+		shard.clock = func() time.Time { return now }
+	}
+	for count := 0; count < 20; count++ {
+		store.PutOrUpdateWithTTL([]byte(fmt.Sprintf("key-%03d", count)), []byte("value"), time.Second)
+	}
+	store.PutOrUpdate([]byte("Consensus"), []byte("Raft"))
+	now = now.Add(2 * time.Second)
+
+	evicted := 0
+	for round := 0; round < 60; round++ {
+		evicted += store.EvictExpired(3)
+	}
+
+	assert.Equal(t, 20, evicted)
+	assert.Equal(t, 1, len(store.Scan(nil, nil, 0)))
+}
+
+func TestIncrementsAKeyConcurrently(t *testing.T) {
+	store := NewShardedInMemoryStoreWithShards(8)
+
+	var group sync.WaitGroup
+	for goroutine := 0; goroutine < 8; goroutine++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for count := 0; count < 100; count++ {
+				_, _ = store.IncrementBy([]byte("Counter"), 1)
+			}
+		}()
+	}
+	group.Wait()
+
+	value, _ := store.GetValue([]byte("Counter"))
+	assert.Equal(t, []byte("800"), value)
+}
@@ -0,0 +1,44 @@
+package store
+
+import "time"
+
+// Store represents a store to hold Key/Value pairs, which is shared by the handlers of all the connections.
+// InMemoryStore is the default implementation. Every implementation is expected to behave as InMemoryStore, which is
+// verified by the conformance suite of the storetest package (see storetest.TestStore).
+// A mutation returns an error if it can not be made durable, such as when the write-ahead log of a DurableStore
+// fails; the mutations of an InMemoryStore never fail.
+type Store interface {
+	// PutOrUpdate puts or updates the value of the given key, which does not expire.
+	PutOrUpdate(key, value []byte) error
+	// PutOrUpdateWithTTL puts or updates the value of the given key, which expires after the given time to live.
+	// A time to live of 0 denotes that the key never expires.
+	PutOrUpdateWithTTL(key, value []byte, ttl time.Duration) error
+	// GetValue gets the value of the given key.
+	GetValue(key []byte) ([]byte, bool)
+	// GetVersionedValue gets the value and the version of the given key.
+	GetVersionedValue(key []byte) ([]byte, uint64, bool)
+	// TimeToLive returns the remaining time to live of the given key, and true if the key exists.
+	TimeToLive(key []byte) (time.Duration, bool)
+	// MultiGet gets the values and the versions of the given keys, as a consistent snapshot.
+	MultiGet(keys [][]byte) []VersionedKeyValue
+	// MultiPutOrUpdate puts or updates the values of all the given pairs atomically, and returns their new versions.
+	MultiPutOrUpdate(pairs []KeyValuePair) ([]uint64, error)
+	// Transact applies the operations atomically, provided that every watched key still has its watched version.
+	Transact(watched []KeyVersion, operations []Operation) ([]VersionedKeyValue, bool, error)
+	// Scan returns the key/value pairs with keys in the range [start, end), in ascending order of keys.
+	Scan(start, end []byte, limit int) []VersionedKeyValue
+	// PrefixScan returns the key/value pairs with keys starting with the given prefix, in ascending order of keys.
+	PrefixScan(prefix []byte, limit int) []VersionedKeyValue
+	// CompareAndSwap puts or updates the value of the given key only if the current version of the key is
+	// expectedVersion.
+	CompareAndSwap(key []byte, expectedVersion uint64, value []byte) (uint64, bool, error)
+	// CompareAndSwapWithTTL is the same as CompareAndSwap, and the swapped value expires after the given time to live.
+	CompareAndSwapWithTTL(key []byte, expectedVersion uint64, value []byte, ttl time.Duration) (uint64, bool, error)
+	// IncrementBy atomically adds delta to the value of the given key, which is parsed as int64.
+	IncrementBy(key []byte, delta int64) (int64, error)
+	// Delete deletes the given key, and returns true if the key existed.
+	Delete(key []byte) (bool, error)
+	// EvictExpired deletes the expired keys, examining at most maxKeys keys which carry a time to live, and returns
+	// the number of deleted keys.
+	EvictExpired(maxKeys int) int
+}
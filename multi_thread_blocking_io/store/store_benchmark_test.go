@@ -0,0 +1,87 @@
+package store
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+const benchmarkKeys = 10_000
+
+// contendedStore is the part of the API of InMemoryStore, ShardedInMemoryStore and DurableStore which is contended
+// by the benchmarks.
+type contendedStore interface {
+	GetValue(key []byte) ([]byte, bool)
+	PutOrUpdate(key, value []byte) error
+}
+
+// BenchmarkContention shows the lock contention of the single-lock InMemoryStore versus the ShardedInMemoryStore:
+// compare ns/op of both the stores as the number of goroutines grows, with a read-mostly workload
+// (9 gets for every put) over benchmarkKeys keys.
+func BenchmarkContention(b *testing.B) {
+	benchmarkContention(b, 10)
+}
+
+// BenchmarkWriteContention shows the contention of the stores with a write-only workload, in which every operation
+// draws a version (see versionCounter). The DurableStore over a ShardedInMemoryStore shows the cost of its single
+// lock, which serializes the mutations of all the shards; its log is never synced, so that the benchmark measures
+// the lock and the appends rather than fsync.
+func BenchmarkWriteContention(b *testing.B) {
+	benchmarkContention(b, 1)
+}
+
+// benchmarkContention runs the workload of one put for every putEvery operations (and gets otherwise) on all
+// the stores, with a growing number of goroutines.
+func benchmarkContention(b *testing.B, putEvery int) {
+	keys := make([][]byte, benchmarkKeys)
+	for index := range keys {
+		keys[index] = []byte(fmt.Sprintf("key-%05d", index))
+	}
+	for _, variant := range []struct {
+		name     string
+		newStore func(b *testing.B) contendedStore
+	}{
+		{name: "InMemoryStore", newStore: func(*testing.B) contendedStore { return NewInMemoryStore() }},
+		{name: "ShardedInMemoryStore", newStore: func(*testing.B) contendedStore { return NewShardedInMemoryStore() }},
+		{name: "DurableStore", newStore: func(b *testing.B) contendedStore {
+			store, err := OpenDurableStore(filepath.Join(b.TempDir(), "store.wal"), SyncPolicy{Mode: SyncNever}, NewShardedInMemoryStore())
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Cleanup(func() {
+				_ = store.Close()
+			})
+			return store
+		}},
+	} {
+		for _, goroutines := range []int{1, 2, 4, 8, 16, 32, 64} {
+			b.Run(fmt.Sprintf("%v/goroutines-%v", variant.name, goroutines), func(b *testing.B) {
+				store := variant.newStore(b)
+				for _, key := range keys {
+					store.PutOrUpdate(key, []byte("value"))
+				}
+				b.ResetTimer()
+
+				var group sync.WaitGroup
+				for goroutine := 0; goroutine < goroutines; goroutine++ {
+					group.Add(1)
+					go func(goroutine int) {
+						defer group.Done()
+						random := rand.New(rand.NewSource(int64(goroutine)))
+						for count := goroutine; count < b.N; count += goroutines {
+							key := keys[random.Intn(len(keys))]
+							if count%putEvery == 0 {
+								store.PutOrUpdate(key, []byte("value"))
+							} else {
+								store.GetValue(key)
+							}
+						}
+					}(goroutine)
+				}
+				group.Wait()
+			})
+		}
+	}
+}
@@ -0,0 +1,284 @@
+// Package storetest implements the conformance suite of store.Store, which every implementation is expected to pass.
+package storetest
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"multi_thread_blocking_io/store"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// expiringTTL is the time to live of the keys which are expected to expire during the suite.
+const expiringTTL = 50 * time.Millisecond
+
+// TestStore runs the conformance suite against the stores which are created by newStore.
+// Every test of the suite creates a new (empty) store.
+func TestStore(t *testing.T, newStore func() store.Store) {
+	for _, test := range []struct {
+		name string
+		run  func(t *testing.T, kvStore store.Store)
+	}{
+		{name: "PutsAndGetsAKeyValuePair", run: testPutsAndGetsAKeyValuePair},
+		{name: "UpdatesTheValueOfAKey", run: testUpdatesTheValueOfAKey},
+		{name: "DeletesAKey", run: testDeletesAKey},
+		{name: "StoresACopyOfTheValue", run: testStoresACopyOfTheValue},
+		{name: "PutsBinaryKeysAndValues", run: testPutsBinaryKeysAndValues},
+		{name: "ChangesTheVersionOfAKeyOnEveryPut", run: testChangesTheVersionOfAKeyOnEveryPut},
+		{name: "CompareAndSwapsAKey", run: testCompareAndSwapsAKey},
+		{name: "MultiPutsAndMultiGetsKeys", run: testMultiPutsAndMultiGetsKeys},
+		{name: "AppliesATransaction", run: testAppliesATransaction},
+		{name: "DoesNotApplyATransactionIfAWatchedKeyHasChanged", run: testDoesNotApplyATransactionIfAWatchedKeyHasChanged},
+		{name: "ScansKeysInARange", run: testScansKeysInARange},
+		{name: "PrefixScansKeys", run: testPrefixScansKeys},
+		{name: "IncrementsAKey", run: testIncrementsAKey},
+		{name: "DoesNotIncrementANonNumericOrOverflowingValue", run: testDoesNotIncrementANonNumericOrOverflowingValue},
+		{name: "ExpiresAKey", run: testExpiresAKey},
+		{name: "PutOrUpdateClearsTheTimeToLive", run: testPutOrUpdateClearsTheTimeToLive},
+		{name: "EvictsExpiredKeys", run: testEvictsExpiredKeys},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, newStore())
+		})
+	}
+}
+
+func testPutsAndGetsAKeyValuePair(t *testing.T, kvStore store.Store) {
+	kvStore.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+
+	value, ok := kvStore.GetValue([]byte("DiskType"))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("SSD"), value)
+
+	_, ok = kvStore.GetValue([]byte("Storage"))
+	assert.False(t, ok)
+}
+
+func testUpdatesTheValueOfAKey(t *testing.T, kvStore store.Store) {
+	kvStore.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+	kvStore.PutOrUpdate([]byte("DiskType"), []byte("HDD"))
+
+	value, ok := kvStore.GetValue([]byte("DiskType"))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("HDD"), value)
+}
+
+func testDeletesAKey(t *testing.T, kvStore store.Store) {
+	kvStore.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+
+	ok, err := kvStore.Delete([]byte("DiskType"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = kvStore.Delete([]byte("DiskType"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, ok = kvStore.GetValue([]byte("DiskType"))
+	assert.False(t, ok)
+}
+
+func testStoresACopyOfTheValue(t *testing.T, kvStore store.Store) {
+	value := []byte("SSD")
+	kvStore.PutOrUpdate([]byte("DiskType"), value)
+
+	copy(value, "HDD")
+
+	storedValue, _ := kvStore.GetValue([]byte("DiskType"))
+	assert.Equal(t, []byte("SSD"), storedValue)
+}
+
+func testPutsBinaryKeysAndValues(t *testing.T, kvStore store.Store) {
+	key, value := []byte{0x00, 0xff, 0xfe}, []byte{0xc3, 0x28, 0x00, '\n'}
+	kvStore.PutOrUpdate(key, value)
+
+	storedValue, ok := kvStore.GetValue(key)
+	assert.True(t, ok)
+	assert.Equal(t, value, storedValue)
+}
+
+func testChangesTheVersionOfAKeyOnEveryPut(t *testing.T, kvStore store.Store) {
+	kvStore.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+	_, version, ok := kvStore.GetVersionedValue([]byte("DiskType"))
+	assert.True(t, ok)
+	assert.NotEqual(t, uint64(0), version)
+
+	kvStore.PutOrUpdate([]byte("DiskType"), []byte("HDD"))
+	_, updatedVersion, _ := kvStore.GetVersionedValue([]byte("DiskType"))
+	assert.Greater(t, updatedVersion, version)
+
+	// a key which is deleted and put again never reuses an old version.
+	kvStore.Delete([]byte("DiskType"))
+	kvStore.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+	_, reputVersion, _ := kvStore.GetVersionedValue([]byte("DiskType"))
+	assert.Greater(t, reputVersion, updatedVersion)
+}
+
+func testCompareAndSwapsAKey(t *testing.T, kvStore store.Store) {
+	version, ok, _ := kvStore.CompareAndSwap([]byte("DiskType"), 0, []byte("SSD"))
+	assert.True(t, ok)
+
+	_, ok, _ = kvStore.CompareAndSwap([]byte("DiskType"), 0, []byte("HDD"))
+	assert.False(t, ok)
+
+	swappedVersion, ok, _ := kvStore.CompareAndSwap([]byte("DiskType"), version, []byte("NVMe"))
+	assert.True(t, ok)
+
+	currentVersion, ok, _ := kvStore.CompareAndSwap([]byte("DiskType"), version, []byte("HDD"))
+	assert.False(t, ok)
+	assert.Equal(t, swappedVersion, currentVersion)
+
+	value, _ := kvStore.GetValue([]byte("DiskType"))
+	assert.Equal(t, []byte("NVMe"), value)
+}
+
+func testMultiPutsAndMultiGetsKeys(t *testing.T, kvStore store.Store) {
+	versions, _ := kvStore.MultiPutOrUpdate([]store.KeyValuePair{
+		{Key: []byte("DiskType"), Value: []byte("SSD")},
+		{Key: []byte("Storage"), Value: []byte("LSM")},
+	})
+
+	values := kvStore.MultiGet([][]byte{[]byte("Storage"), []byte("Cache"), []byte("DiskType")})
+
+	assert.Equal(t, 2, len(versions))
+	assert.Equal(t, 3, len(values))
+	assert.Equal(t, []byte("LSM"), values[0].Value)
+	assert.Equal(t, versions[1], values[0].Version)
+	assert.True(t, values[0].Exists)
+	assert.False(t, values[1].Exists)
+	assert.Equal(t, []byte("SSD"), values[2].Value)
+	assert.Equal(t, versions[0], values[2].Version)
+}
+
+func testAppliesATransaction(t *testing.T, kvStore store.Store) {
+	kvStore.PutOrUpdate([]byte("Engine"), []byte("btree"))
+
+	results, ok, _ := kvStore.Transact(nil, []store.Operation{
+		{Kind: store.OperationPutOrUpdate, Key: []byte("DiskType"), Value: []byte("SSD")},
+		{Kind: store.OperationGet, Key: []byte("DiskType")},
+		{Kind: store.OperationDelete, Key: []byte("Engine")},
+		{Kind: store.OperationDelete, Key: []byte("Cache")},
+	})
+
+	assert.True(t, ok)
+	assert.Equal(t, 4, len(results))
+	assert.Equal(t, []byte("SSD"), results[1].Value)
+	assert.Equal(t, results[0].Version, results[1].Version)
+	assert.True(t, results[2].Exists)
+	assert.False(t, results[3].Exists)
+
+	_, ok = kvStore.GetValue([]byte("Engine"))
+	assert.False(t, ok)
+}
+
+func testDoesNotApplyATransactionIfAWatchedKeyHasChanged(t *testing.T, kvStore store.Store) {
+	kvStore.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+	_, version, _ := kvStore.GetVersionedValue([]byte("DiskType"))
+	watched := []store.KeyVersion{{Key: []byte("DiskType"), Version: version}, {Key: []byte("Engine")}}
+
+	kvStore.PutOrUpdate([]byte("Engine"), []byte("btree"))
+	_, ok, _ := kvStore.Transact(watched, []store.Operation{
+		{Kind: store.OperationPutOrUpdate, Key: []byte("DiskType"), Value: []byte("HDD")},
+	})
+
+	assert.False(t, ok)
+	value, _ := kvStore.GetValue([]byte("DiskType"))
+	assert.Equal(t, []byte("SSD"), value)
+}
+
+func testScansKeysInARange(t *testing.T, kvStore store.Store) {
+	for count := 0; count < 20; count++ {
+		kvStore.PutOrUpdate([]byte(fmt.Sprintf("key-%02d", count)), []byte(fmt.Sprintf("value-%02d", count)))
+	}
+
+	values := kvStore.Scan([]byte("key-05"), []byte("key-10"), 0)
+	assert.Equal(t, 5, len(values))
+	for index, value := range values {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%02d", 5+index)), value.Key)
+		assert.Equal(t, []byte(fmt.Sprintf("value-%02d", 5+index)), value.Value)
+	}
+
+	values = kvStore.Scan(nil, nil, 3)
+	assert.Equal(t, 3, len(values))
+	assert.Equal(t, []byte("key-00"), values[0].Key)
+	assert.Equal(t, []byte("key-02"), values[2].Key)
+}
+
+func testPrefixScansKeys(t *testing.T, kvStore store.Store) {
+	kvStore.PutOrUpdate([]byte("disk:ssd"), []byte("SSD"))
+	kvStore.PutOrUpdate([]byte("storage:lsm"), []byte("LSM"))
+	kvStore.PutOrUpdate([]byte("disk:nvme"), []byte("NVMe"))
+
+	values := kvStore.PrefixScan([]byte("disk:"), 0)
+
+	assert.Equal(t, 2, len(values))
+	assert.Equal(t, []byte("disk:nvme"), values[0].Key)
+	assert.Equal(t, []byte("disk:ssd"), values[1].Key)
+}
+
+func testIncrementsAKey(t *testing.T, kvStore store.Store) {
+	counter, err := kvStore.IncrementBy([]byte("Counter"), 5)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), counter)
+
+	counter, err = kvStore.IncrementBy([]byte("Counter"), -20)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-15), counter)
+
+	value, _ := kvStore.GetValue([]byte("Counter"))
+	assert.Equal(t, []byte("-15"), value)
+}
+
+func testDoesNotIncrementANonNumericOrOverflowingValue(t *testing.T, kvStore store.Store) {
+	kvStore.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+	_, err := kvStore.IncrementBy([]byte("DiskType"), 1)
+	assert.ErrorIs(t, err, store.ErrNotANumber)
+
+	kvStore.PutOrUpdate([]byte("Counter"), []byte(strconv.FormatInt(math.MaxInt64, 10)))
+	_, err = kvStore.IncrementBy([]byte("Counter"), 1)
+	assert.ErrorIs(t, err, store.ErrCounterOverflow)
+}
+
+func testExpiresAKey(t *testing.T, kvStore store.Store) {
+	kvStore.PutOrUpdateWithTTL([]byte("DiskType"), []byte("SSD"), expiringTTL)
+	kvStore.PutOrUpdate([]byte("Storage"), []byte("LSM"))
+
+	ttl, ok := kvStore.TimeToLive([]byte("DiskType"))
+	assert.True(t, ok)
+	assert.True(t, ttl > 0 && ttl <= expiringTTL)
+
+	time.Sleep(2 * expiringTTL)
+
+	_, ok = kvStore.GetValue([]byte("DiskType"))
+	assert.False(t, ok)
+	_, ok = kvStore.TimeToLive([]byte("DiskType"))
+	assert.False(t, ok)
+	assert.Equal(t, 1, len(kvStore.Scan(nil, nil, 0)))
+}
+
+func testPutOrUpdateClearsTheTimeToLive(t *testing.T, kvStore store.Store) {
+	kvStore.PutOrUpdateWithTTL([]byte("DiskType"), []byte("SSD"), expiringTTL)
+	kvStore.PutOrUpdate([]byte("DiskType"), []byte("HDD"))
+
+	ttl, ok := kvStore.TimeToLive([]byte("DiskType"))
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), ttl)
+}
+
+func testEvictsExpiredKeys(t *testing.T, kvStore store.Store) {
+	kvStore.PutOrUpdateWithTTL([]byte("DiskType"), []byte("SSD"), expiringTTL)
+	kvStore.PutOrUpdateWithTTL([]byte("Storage"), []byte("LSM"), expiringTTL)
+	kvStore.PutOrUpdateWithTTL([]byte("System"), []byte("Distributed"), time.Minute)
+	kvStore.PutOrUpdate([]byte("Consensus"), []byte("Raft"))
+
+	time.Sleep(2 * expiringTTL)
+
+	// a single invocation need not examine every key which carries a time to live.
+	evicted := 0
+	for round := 0; round < 100; round++ {
+		evicted += kvStore.EvictExpired(10)
+	}
+	assert.Equal(t, 2, evicted)
+	assert.Equal(t, 2, len(kvStore.Scan(nil, nil, 0)))
+}
@@ -0,0 +1,329 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SyncMode denotes when the WAL is synced (fsync) to the disk.
+type SyncMode int
+
+const (
+	// SyncAlways syncs the log after every append; an appended record is durable once WaitSynced returns.
+	SyncAlways SyncMode = iota
+	// SyncPeriodically syncs the log every SyncPolicy.Interval, so the records of the last interval may be lost.
+	SyncPeriodically
+	// SyncNever leaves the syncs to the operating system; an appended record is considered synced once it is written.
+	SyncNever
+)
+
+const (
+	// recordHeaderSize is the size of the header of a record: the length and the CRC-32 (Castagnoli) of its payload.
+	recordHeaderSize = 8
+	// maxRecordLength is the length beyond which the header of a record is considered corrupt.
+	maxRecordLength = 1 << 30
+)
+
+var (
+	ErrWALClosed         = errors.New("write-ahead log is closed")
+	ErrCorruptRecord     = errors.New("write-ahead log record is corrupt")
+	ErrInvalidSyncPolicy = errors.New("sync policy is invalid")
+	// errTruncatedRecord denotes that the log ends in the middle of a record.
+	errTruncatedRecord = errors.New("write-ahead log record is truncated")
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// SyncPolicy configures the syncs of a WAL. Interval is only used by SyncPeriodically.
+type SyncPolicy struct {
+	Mode     SyncMode
+	Interval time.Duration
+}
+
+// WAL represents an append-only write-ahead log on the disk.
+// Every record is framed by its length and the CRC-32 of its payload, so a record which is partially written
+// (a torn tail, such as after a crash in the middle of an append) or corrupted is detected by Replay. A torn tail is
+// truncated, and the appends continue from the last complete record; a corrupt record which is followed by more of
+// the log fails the replay instead, because the records after it would be lost.
+// A record is identified by its sequence: the offset of the end of the record in the log. The log is synced by
+// its own goroutine as per the SyncPolicy, which syncs the records appended by many goroutines together (group commit).
+// The log is never compacted.
+type WAL struct {
+	file            *os.File
+	policy          SyncPolicy
+	appendLock      sync.Mutex
+	appended        atomic.Uint64
+	synced          atomic.Uint64
+	syncLock        sync.Mutex
+	syncedCondition *sync.Cond
+	appendedChannel chan struct{}
+	stopChannel     chan struct{}
+	stoppedChannel  chan struct{}
+	onSync          func()
+	err             error
+	closed          bool
+}
+
+// OpenWAL opens the write-ahead log at the given path, creating it if it does not exist, and starts syncing it as per
+// the policy. Replay is expected to be invoked once, before the first Append.
+// It returns ErrInvalidSyncPolicy if the mode of the policy is unknown, or if SyncPeriodically has no positive
+// interval.
+func OpenWAL(path string, policy SyncPolicy) (*WAL, error) {
+	switch {
+	case policy.Mode != SyncAlways && policy.Mode != SyncPeriodically && policy.Mode != SyncNever:
+		return nil, fmt.Errorf("%w: unknown mode %v", ErrInvalidSyncPolicy, policy.Mode)
+	case policy.Mode == SyncPeriodically && policy.Interval <= 0:
+		return nil, fmt.Errorf("%w: interval %v is not positive", ErrInvalidSyncPolicy, policy.Interval)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	wal := &WAL{
+		file:            file,
+		policy:          policy,
+		appendedChannel: make(chan struct{}, 1),
+		stopChannel:     make(chan struct{}),
+		stoppedChannel:  make(chan struct{}),
+	}
+	wal.syncedCondition = sync.NewCond(&wal.syncLock)
+	go wal.runSyncs()
+	return wal, nil
+}
+
+// Replay reads the records of the log in order, and invokes apply with the payload of every complete record.
+// Reading stops at the first truncated or corrupt record. If the record runs to the end of the log (a torn tail),
+// the log is truncated to the end of the last complete record, and subsequent appends follow it. Otherwise the log
+// is left untouched, and ErrCorruptRecord is returned. It returns the number of replayed records.
+func (wal *WAL) Replay(apply func(payload []byte) error) (int, error) {
+	info, err := wal.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := wal.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	reader := bufio.NewReader(wal.file)
+	offset, records := uint64(0), 0
+	for {
+		payload, length, err := readRecord(reader)
+		if err != nil {
+			if errors.Is(err, ErrCorruptRecord) && (length > maxRecordLength || offset+recordHeaderSize+uint64(length) < uint64(info.Size())) {
+				return records, fmt.Errorf("%w at offset %v", ErrCorruptRecord, offset)
+			}
+			if !errors.Is(err, io.EOF) && !errors.Is(err, errTruncatedRecord) && !errors.Is(err, ErrCorruptRecord) {
+				return records, err
+			}
+			break
+		}
+		if err := apply(payload); err != nil {
+			return records, err
+		}
+		offset += uint64(recordHeaderSize + len(payload))
+		records++
+	}
+	if err := wal.file.Truncate(int64(offset)); err != nil {
+		return records, err
+	}
+	if _, err := wal.file.Seek(int64(offset), io.SeekStart); err != nil {
+		return records, err
+	}
+	wal.appended.Store(offset)
+	wal.synced.Store(offset)
+	return records, nil
+}
+
+// Append appends a record with the given payload to the log, and returns the sequence of the record.
+// The record is written to the file before Append returns, but is durable only once it is synced (see WaitSynced).
+// Once an append (or a sync) fails, the log is failed: all the subsequent appends return the same error.
+func (wal *WAL) Append(payload []byte) (uint64, error) {
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload, crc32cTable))
+	record = append(record, payload...)
+
+	wal.appendLock.Lock()
+	defer wal.appendLock.Unlock()
+
+	if err := wal.Err(); err != nil {
+		return 0, err
+	}
+	if _, err := wal.file.Write(record); err != nil {
+		wal.fail(err)
+		return 0, err
+	}
+	sequence := wal.appended.Add(uint64(len(record)))
+	switch wal.policy.Mode {
+	case SyncAlways:
+		select {
+		case wal.appendedChannel <- struct{}{}:
+		default:
+		}
+	case SyncNever:
+		wal.markSynced(sequence)
+	}
+	return sequence, nil
+}
+
+// WaitSynced blocks till the record with the given sequence is synced, and returns the error of the log if it fails
+// (or is closed) before that.
+func (wal *WAL) WaitSynced(sequence uint64) error {
+	wal.syncLock.Lock()
+	defer wal.syncLock.Unlock()
+	for wal.synced.Load() < sequence {
+		if wal.err != nil {
+			return wal.err
+		}
+		wal.syncedCondition.Wait()
+	}
+	return nil
+}
+
+// Appended returns the sequence of the last appended record.
+func (wal *WAL) Appended() uint64 {
+	return wal.appended.Load()
+}
+
+// Synced returns the sequence of the last synced record.
+func (wal *WAL) Synced() uint64 {
+	return wal.synced.Load()
+}
+
+// OnSync registers the function which is invoked every time records are synced, and once the log fails, so that
+// a caller which does not block in WaitSynced learns of both (see Err). It is invoked in the goroutine of the log,
+// or in the goroutine of an Append which syncs (SyncNever) or fails, and is expected to be registered before the
+// first Append.
+func (wal *WAL) OnSync(onSync func()) {
+	wal.syncLock.Lock()
+	defer wal.syncLock.Unlock()
+	wal.onSync = onSync
+}
+
+// Err returns the error which failed the log, if any.
+func (wal *WAL) Err() error {
+	wal.syncLock.Lock()
+	defer wal.syncLock.Unlock()
+	return wal.err
+}
+
+// Close syncs the appended records, stops the syncs and closes the log.
+func (wal *WAL) Close() error {
+	wal.appendLock.Lock()
+	defer wal.appendLock.Unlock()
+
+	wal.syncLock.Lock()
+	if wal.closed {
+		wal.syncLock.Unlock()
+		return nil
+	}
+	wal.closed = true
+	wal.syncLock.Unlock()
+
+	close(wal.stopChannel)
+	<-wal.stoppedChannel
+	wal.sync()
+	wal.fail(ErrWALClosed)
+	return wal.file.Close()
+}
+
+// runSyncs runs in its own goroutine and syncs the appended records, after every append (SyncAlways) or every
+// interval (SyncPeriodically).
+func (wal *WAL) runSyncs() {
+	defer close(wal.stoppedChannel)
+
+	var ticks <-chan time.Time
+	if wal.policy.Mode == SyncPeriodically {
+		ticker := time.NewTicker(wal.policy.Interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	for {
+		select {
+		case <-wal.stopChannel:
+			return
+		case <-wal.appendedChannel:
+		case <-ticks:
+		}
+		wal.sync()
+	}
+}
+
+// sync syncs the file, if there are appended records which are not synced.
+func (wal *WAL) sync() {
+	appended := wal.appended.Load()
+	if appended == wal.synced.Load() {
+		return
+	}
+	if err := wal.file.Sync(); err != nil {
+		wal.fail(err)
+		return
+	}
+	wal.markSynced(appended)
+}
+
+// markSynced records that the records up to the given sequence are synced, and wakes up their waiters.
+func (wal *WAL) markSynced(sequence uint64) {
+	wal.syncLock.Lock()
+	if sequence > wal.synced.Load() {
+		wal.synced.Store(sequence)
+	}
+	onSync := wal.onSync
+	wal.syncedCondition.Broadcast()
+	wal.syncLock.Unlock()
+
+	if onSync != nil {
+		onSync()
+	}
+}
+
+// fail fails the log with the given error (unless it has already failed), and wakes up the waiters, along with
+// the function registered by OnSync.
+func (wal *WAL) fail(err error) {
+	wal.syncLock.Lock()
+	if wal.err == nil {
+		wal.err = err
+	}
+	onSync := wal.onSync
+	wal.syncedCondition.Broadcast()
+	wal.syncLock.Unlock()
+
+	if onSync != nil {
+		onSync()
+	}
+}
+
+// readRecord reads the payload of the next record, and returns it along with the length in the header of the record.
+// It returns io.EOF at the end of the log, errTruncatedRecord if the log ends in the middle of a record, and
+// ErrCorruptRecord if the length exceeds maxRecordLength or the checksum of the payload does not match.
+func readRecord(reader io.Reader) ([]byte, uint32, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, 0, errTruncatedRecord
+		}
+		return nil, 0, err
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length > maxRecordLength {
+		return nil, length, ErrCorruptRecord
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, length, errTruncatedRecord
+		}
+		return nil, length, err
+	}
+	if crc32.Checksum(payload, crc32cTable) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, length, ErrCorruptRecord
+	}
+	return payload, length, nil
+}
@@ -0,0 +1,125 @@
+package conn
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestACLAllowsThePermissionsOnTheMatchingKeys(t *testing.T) {
+	acl, err := NewACL(ACLConfig{Rules: []ACLRule{
+		{User: "alice", Permissions: []Permission{PermissionGet, PermissionPut}, Keys: []string{"orders.*", "profile"}},
+		{User: "alice", Permissions: []Permission{PermissionDelete}, Keys: []string{"orders.draft-?"}},
+	}})
+	assert.Nil(t, err)
+
+	assert.True(t, acl.Allows("alice", PermissionGet, []byte("orders.1")))
+	assert.True(t, acl.Allows("alice", PermissionPut, []byte("profile")))
+	assert.True(t, acl.Allows("alice", PermissionDelete, []byte("orders.draft-1")))
+
+	assert.False(t, acl.Allows("alice", PermissionDelete, []byte("orders.1")))
+	assert.False(t, acl.Allows("alice", PermissionGet, []byte("invoices.1")))
+	assert.False(t, acl.Allows("bob", PermissionGet, []byte("orders.1")))
+}
+
+func TestACLAllowsAScanOnlyWithinAPrefixPattern(t *testing.T) {
+	acl, _ := NewACL(ACLConfig{Rules: []ACLRule{
+		{User: "alice", Permissions: []Permission{PermissionScan}, Keys: []string{"orders.*", "profile"}},
+		{User: "admin", Permissions: []Permission{PermissionScan}, Keys: []string{"*"}},
+	}})
+
+	assert.True(t, acl.AllowsScan("alice", []byte("orders."), []byte("orders/")))
+	assert.True(t, acl.AllowsScan("alice", []byte("orders.1"), []byte("orders.5")))
+
+	assert.False(t, acl.AllowsScan("alice", []byte("orders."), nil))
+	assert.False(t, acl.AllowsScan("alice", []byte("orders.1"), []byte("profile")))
+	assert.False(t, acl.AllowsScan("alice", []byte("profile"), []byte("profilf")))
+	assert.True(t, acl.AllowsScan("admin", nil, nil))
+}
+
+func TestACLMatchesTheKeysWithSlashesAsItScansThem(t *testing.T) {
+	acl, _ := NewACL(ACLConfig{Rules: []ACLRule{
+		{User: "alice", Permissions: []Permission{PermissionGet, PermissionScan}, Keys: []string{"users/*", "teams/[a-c]?", `logs/\*`}},
+	}})
+
+	assert.True(t, acl.Allows("alice", PermissionGet, []byte("users/a")))
+	assert.True(t, acl.Allows("alice", PermissionGet, []byte("users/a/b")))
+	assert.True(t, acl.AllowsScan("alice", []byte("users/"), []byte("users0")))
+	assert.True(t, acl.AllowsScan("alice", []byte("users/a/"), []byte("users/a0")))
+
+	assert.True(t, acl.Allows("alice", PermissionGet, []byte("teams/b/")))
+	assert.False(t, acl.Allows("alice", PermissionGet, []byte("teams/d/")))
+	assert.True(t, acl.Allows("alice", PermissionGet, []byte("logs/*")))
+	assert.False(t, acl.Allows("alice", PermissionGet, []byte("logs/a")))
+	assert.False(t, acl.Allows("alice", PermissionGet, []byte("users")))
+}
+
+func TestACLRejectsAnInvalidConfig(t *testing.T) {
+	_, err := NewACL(ACLConfig{Rules: []ACLRule{{User: "alice", Permissions: []Permission{"write"}, Keys: []string{"*"}}}})
+	assert.NotNil(t, err)
+
+	_, err = NewACL(ACLConfig{Rules: []ACLRule{{User: "alice", Permissions: []Permission{PermissionGet}, Keys: []string{"orders.["}}}})
+	assert.NotNil(t, err)
+}
+
+func TestACLReloadsTheRulesOnceTheFileIsModified(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.json")
+	write := func(rule ACLRule, modifiedAt time.Time) {
+		buffer, _ := json.Marshal(ACLConfig{Rules: []ACLRule{rule}})
+		assert.Nil(t, os.WriteFile(path, buffer, 0o600))
+		assert.Nil(t, os.Chtimes(path, modifiedAt, modifiedAt))
+	}
+	now := time.Now()
+	write(ACLRule{User: "alice", Permissions: []Permission{PermissionGet}, Keys: []string{"*"}}, now)
+
+	acl, err := LoadACL(path)
+	assert.Nil(t, err)
+	assert.True(t, acl.Allows("alice", PermissionGet, []byte("orders.1")))
+
+	reloaded, err := acl.ReloadIfModified()
+	assert.Nil(t, err)
+	assert.False(t, reloaded)
+
+	write(ACLRule{User: "alice", Permissions: []Permission{PermissionPut}, Keys: []string{"*"}}, now.Add(time.Second))
+	reloaded, err = acl.ReloadIfModified()
+	assert.Nil(t, err)
+	assert.True(t, reloaded)
+	assert.False(t, acl.Allows("alice", PermissionGet, []byte("orders.1")))
+	assert.True(t, acl.Allows("alice", PermissionPut, []byte("orders.1")))
+
+	assert.Nil(t, os.WriteFile(path, []byte("{"), 0o600))
+	assert.Nil(t, os.Chtimes(path, now.Add(2*time.Second), now.Add(2*time.Second)))
+	_, err = acl.ReloadIfModified()
+	assert.NotNil(t, err)
+	assert.True(t, acl.Allows("alice", PermissionPut, []byte("orders.1")))
+}
+
+func TestACLReportsABrokenFileOnceTillItIsModified(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.json")
+	buffer, _ := json.Marshal(ACLConfig{Rules: []ACLRule{{User: "alice", Permissions: []Permission{PermissionGet}, Keys: []string{"*"}}}})
+	assert.Nil(t, os.WriteFile(path, buffer, 0o600))
+	acl, err := LoadACL(path)
+	assert.Nil(t, err)
+
+	now := time.Now()
+	assert.Nil(t, os.WriteFile(path, []byte("{"), 0o600))
+	assert.Nil(t, os.Chtimes(path, now.Add(time.Second), now.Add(time.Second)))
+	_, err = acl.ReloadIfModified()
+	assert.NotNil(t, err)
+	_, err = acl.ReloadIfModified()
+	assert.Nil(t, err)
+
+	assert.Nil(t, os.Chtimes(path, now.Add(2*time.Second), now.Add(2*time.Second)))
+	_, err = acl.ReloadIfModified()
+	assert.NotNil(t, err)
+
+	assert.Nil(t, os.Remove(path))
+	_, err = acl.ReloadIfModified()
+	assert.NotNil(t, err)
+	_, err = acl.ReloadIfModified()
+	assert.Nil(t, err)
+	assert.True(t, acl.Allows("alice", PermissionGet, []byte("orders.1")))
+}
@@ -0,0 +1,193 @@
+package conn
+
+import (
+	"non_blocking_busy_waiting/proto"
+	"path"
+	"sync"
+)
+
+// SubscriberQueueLength is the number of published messages which are queued for a subscriber of the Broker of
+// NewHandlers, before its OverflowPolicy applies.
+const SubscriberQueueLength = 1024
+
+// OverflowPolicy decides what happens to a subscriber whose queue is full, because it does not keep up with
+// the publishers.
+type OverflowPolicy int
+
+const (
+	// OverflowDrop drops the messages which do not fit in the queue of the subscriber.
+	OverflowDrop OverflowPolicy = iota
+	// OverflowDisconnect disconnects the subscriber, and removes all of its subscriptions.
+	OverflowDisconnect
+)
+
+// Broker fans the published messages out to the subscribers of the channels, and is shared by all the connections
+// of a server.
+// Every subscriber has a bounded queue of its own, which is drained by a goroutine of the subscriber. A publisher only
+// enqueues the message, so a slow subscriber never blocks the publishers (or the other subscribers); once its queue
+// is full (or a message can not be pushed to it), the OverflowPolicy of the Broker applies.
+// Broker is safe for concurrent use.
+type Broker struct {
+	lock        sync.RWMutex
+	queueLength int
+	policy      OverflowPolicy
+	channels    map[string]map[Subscriber]*proto.KeyValueMessage
+	patterns    map[string]map[Subscriber]*proto.KeyValueMessage
+	queues      map[Subscriber]*subscriberQueue
+}
+
+// subscriberQueue is the queue of the published messages for a single subscriber.
+type subscriberQueue struct {
+	messages chan *proto.KeyValueMessage
+	closed   chan struct{}
+}
+
+// NewBroker creates a new instance of Broker, which queues up to queueLength messages for every subscriber and
+// applies the given policy to the subscribers which do not keep up.
+func NewBroker(queueLength int, policy OverflowPolicy) *Broker {
+	return &Broker{
+		queueLength: queueLength,
+		policy:      policy,
+		channels:    make(map[string]map[Subscriber]*proto.KeyValueMessage),
+		patterns:    make(map[string]map[Subscriber]*proto.KeyValueMessage),
+		queues:      make(map[Subscriber]*subscriberQueue),
+	}
+}
+
+// Subscribe subscribes the subscriber to the channels (or the patterns) of the Subscribe (or the PatternSubscribe)
+// request. The messages answer the request, so they carry its request id.
+func (broker *Broker) Subscribe(subscriber Subscriber, request *proto.KeyValueMessage) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	subscriptions := broker.channels
+	if request.Kind == proto.KeyValueMessageKindPatternSubscribe {
+		subscriptions = broker.patterns
+	}
+	for _, pair := range request.Pairs {
+		name := string(pair.RawKey())
+		if subscriptions[name] == nil {
+			subscriptions[name] = make(map[Subscriber]*proto.KeyValueMessage)
+		}
+		subscriptions[name][subscriber] = request
+	}
+	if broker.queues[subscriber] == nil {
+		queue := &subscriberQueue{
+			messages: make(chan *proto.KeyValueMessage, broker.queueLength),
+			closed:   make(chan struct{}),
+		}
+		broker.queues[subscriber] = queue
+		go broker.deliver(subscriber, queue)
+	}
+}
+
+// Unsubscribe unsubscribes the subscriber from the channels and the patterns of the Unsubscribe request,
+// or from all of them if the request carries none.
+func (broker *Broker) Unsubscribe(subscriber Subscriber, request *proto.KeyValueMessage) {
+	if len(request.Pairs) == 0 {
+		broker.UnsubscribeAll(subscriber)
+		return
+	}
+
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	for _, pair := range request.Pairs {
+		name := string(pair.RawKey())
+		for _, subscriptions := range []map[string]map[Subscriber]*proto.KeyValueMessage{broker.channels, broker.patterns} {
+			delete(subscriptions[name], subscriber)
+			if len(subscriptions[name]) == 0 {
+				delete(subscriptions, name)
+			}
+		}
+	}
+}
+
+// UnsubscribeAll removes all the subscriptions of the subscriber, and stops the delivery of its queue.
+func (broker *Broker) UnsubscribeAll(subscriber Subscriber) {
+	broker.unsubscribeAll(subscriber)
+}
+
+// unsubscribeAll removes all the subscriptions of the subscriber, and returns false if it had none.
+func (broker *Broker) unsubscribeAll(subscriber Subscriber) bool {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	for _, subscriptions := range []map[string]map[Subscriber]*proto.KeyValueMessage{broker.channels, broker.patterns} {
+		for name, subscribers := range subscriptions {
+			delete(subscribers, subscriber)
+			if len(subscribers) == 0 {
+				delete(subscriptions, name)
+			}
+		}
+	}
+	queue, ok := broker.queues[subscriber]
+	if ok {
+		close(queue.closed)
+		delete(broker.queues, subscriber)
+	}
+	return ok
+}
+
+// Publish publishes the payload to the channel, and returns the number of subscribers whose queue accepted it.
+// A subscriber which subscribes to the channel more than once (such as by a channel and a pattern) receives
+// the message once.
+func (broker *Broker) Publish(channel, payload []byte) int {
+	type delivery struct {
+		subscriber Subscriber
+		request    *proto.KeyValueMessage
+		queue      *subscriberQueue
+	}
+
+	broker.lock.RLock()
+	requests := make(map[Subscriber]*proto.KeyValueMessage)
+	for pattern, subscribers := range broker.patterns {
+		if matched, _ := path.Match(pattern, string(channel)); matched {
+			for subscriber, request := range subscribers {
+				requests[subscriber] = request
+			}
+		}
+	}
+	for subscriber, request := range broker.channels[string(channel)] {
+		requests[subscriber] = request
+	}
+	deliveries := make([]delivery, 0, len(requests))
+	for subscriber, request := range requests {
+		deliveries = append(deliveries, delivery{subscriber: subscriber, request: request, queue: broker.queues[subscriber]})
+	}
+	broker.lock.RUnlock()
+
+	accepted := 0
+	for _, delivery := range deliveries {
+		select {
+		case delivery.queue.messages <- proto.NewChannelMessage(channel, payload).AnsweringTo(delivery.request):
+			accepted++
+		default:
+			broker.overflow(delivery.subscriber)
+		}
+	}
+	return accepted
+}
+
+// deliver runs in a goroutine of the subscriber, and pushes the queued messages to the subscriber till its queue
+// is closed.
+func (broker *Broker) deliver(subscriber Subscriber, queue *subscriberQueue) {
+	for {
+		select {
+		case <-queue.closed:
+			return
+		case message := <-queue.messages:
+			if err := subscriber.Notify(message); err != nil {
+				broker.overflow(subscriber)
+			}
+		}
+	}
+}
+
+// overflow applies the OverflowPolicy to a subscriber which does not keep up.
+// A subscriber is disconnected once, even if many publishers overflow its queue at the same time.
+func (broker *Broker) overflow(subscriber Subscriber) {
+	if broker.policy == OverflowDisconnect && broker.unsubscribeAll(subscriber) {
+		subscriber.Disconnect()
+	}
+}
@@ -0,0 +1,122 @@
+package conn
+
+import (
+	"github.com/stretchr/testify/assert"
+	"non_blocking_busy_waiting/proto"
+	"testing"
+	"time"
+)
+
+type channelSubscriber struct {
+	messages     chan *proto.KeyValueMessage
+	release      chan struct{}
+	disconnected chan struct{}
+}
+
+func newChannelSubscriber(capacity int) *channelSubscriber {
+	release := make(chan struct{})
+	close(release)
+	return &channelSubscriber{
+		messages:     make(chan *proto.KeyValueMessage, capacity),
+		release:      release,
+		disconnected: make(chan struct{}),
+	}
+}
+
+func (subscriber *channelSubscriber) Notify(notification *proto.KeyValueMessage) error {
+	<-subscriber.release
+	subscriber.messages <- notification
+	return nil
+}
+
+func (subscriber *channelSubscriber) Disconnect() {
+	close(subscriber.disconnected)
+}
+
+func receive(t *testing.T, subscriber *channelSubscriber) *proto.KeyValueMessage {
+	select {
+	case message := <-subscriber.messages:
+		return message
+	case <-time.After(time.Second):
+		assert.Fail(t, "no message is received")
+		return nil
+	}
+}
+
+func TestPublishesToTheSubscribersOfAChannelAndOfAPattern(t *testing.T) {
+	broker := NewBroker(16, OverflowDrop)
+	subscriber, other := newChannelSubscriber(16), newChannelSubscriber(16)
+	broker.Subscribe(subscriber, proto.NewSubscribeMessage("news.sports").WithRequestId(5))
+	broker.Subscribe(subscriber, proto.NewPatternSubscribeMessage("news.*"))
+	broker.Subscribe(other, proto.NewPatternSubscribeMessage("news.*"))
+
+	assert.Equal(t, 2, broker.Publish([]byte("news.sports"), []byte("kick-off")))
+	assert.Equal(t, 0, broker.Publish([]byte("weather"), []byte("sunny")))
+
+	message := receive(t, subscriber)
+	assert.Equal(t, proto.KeyValueMessageKindChannelMessage, message.Kind)
+	assert.Equal(t, uint64(5), message.RequestId)
+	assert.Equal(t, "news.sports", string(message.RawKey()))
+	assert.Equal(t, "kick-off", string(message.RawValue()))
+	assert.Equal(t, "kick-off", string(receive(t, other).RawValue()))
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, len(subscriber.messages))
+}
+
+func TestDoesNotPublishToAnUnsubscribedChannel(t *testing.T) {
+	broker := NewBroker(16, OverflowDrop)
+	subscriber := newChannelSubscriber(16)
+	broker.Subscribe(subscriber, proto.NewSubscribeMessage("news", "weather"))
+
+	broker.Unsubscribe(subscriber, proto.NewUnsubscribeMessage("news"))
+	assert.Equal(t, 0, broker.Publish([]byte("news"), []byte("kick-off")))
+	assert.Equal(t, 1, broker.Publish([]byte("weather"), []byte("sunny")))
+
+	broker.Unsubscribe(subscriber, proto.NewUnsubscribeMessage())
+	assert.Equal(t, 0, broker.Publish([]byte("weather"), []byte("rainy")))
+}
+
+func TestDropsTheMessagesOfASlowSubscriberWithoutBlockingThePublisher(t *testing.T) {
+	broker := NewBroker(2, OverflowDrop)
+	slow, fast := newChannelSubscriber(16), newChannelSubscriber(16)
+	slow.release = make(chan struct{})
+	broker.Subscribe(slow, proto.NewSubscribeMessage("news"))
+	broker.Subscribe(fast, proto.NewSubscribeMessage("news"))
+
+	accepted := broker.Publish([]byte("news"), []byte("kick-off"))
+	_ = receive(t, fast)
+	// the slow subscriber takes the first message off its queue, and blocks on it.
+	time.Sleep(10 * time.Millisecond)
+	for count := 1; count < 10; count++ {
+		accepted += broker.Publish([]byte("news"), []byte("kick-off"))
+		_ = receive(t, fast)
+	}
+	// the slow subscriber holds one message, and queues two more.
+	assert.Equal(t, 10+3, accepted)
+
+	close(slow.release)
+	for count := 0; count < 3; count++ {
+		_ = receive(t, slow)
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, len(slow.messages))
+}
+
+func TestDisconnectsASlowSubscriber(t *testing.T) {
+	broker := NewBroker(1, OverflowDisconnect)
+	slow := newChannelSubscriber(16)
+	slow.release = make(chan struct{})
+	broker.Subscribe(slow, proto.NewSubscribeMessage("news"))
+
+	for count := 0; count < 10; count++ {
+		broker.Publish([]byte("news"), []byte("kick-off"))
+	}
+
+	select {
+	case <-slow.disconnected:
+	case <-time.After(time.Second):
+		assert.Fail(t, "slow subscriber is not disconnected")
+	}
+	assert.Equal(t, 0, broker.Publish([]byte("news"), []byte("kick-off")))
+}
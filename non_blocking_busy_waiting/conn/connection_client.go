@@ -4,111 +4,205 @@ import (
 	"bytes"
 	"errors"
 	"io"
-	"non_blocking_busy_waiting/proto"
+	"sync"
 	"syscall"
+	"time"
 )
 
+// PushTimeout is the time within which a pushed frame must be written, before the connection is shut down for not
+// reading its pushed frames.
+const PushTimeout = time.Second
+
+// ErrPushTimeout is returned when a pushed frame could not be written within PushTimeout.
+var ErrPushTimeout = errors.New("pushed frame could not be written in time")
+
 // Client handles an incoming connection (/socket).
 type Client struct {
 	fd            int
-	handlers      map[uint32]Handler
+	codec         Codec
 	stopChannel   chan struct{}
 	readBuffer    []byte
 	currentBuffer *bytes.Buffer
+	writeLock     sync.Mutex
 }
 
 // NewClient creates a new instance of the client.
 // It reads chunks from the file descriptor and maintains the current buffer.
 // currentBuffer denotes the chunk that is read currently.
+// The codec decodes the requests from the currentBuffer and answers them, in the protocol of the server.
+// If the codec is a PushingCodec, the client receives the pushed frames (such as the notifications of the watched
+// keys and the published messages), which are written between the responses.
 // The provided file descriptor is set to non-blocking by the caller.
-func NewClient(fd int, handlers map[uint32]Handler) *Client {
-	return &Client{
+func NewClient(fd int, codec Codec) *Client {
+	client := &Client{
 		fd:            fd,
-		handlers:      handlers,
+		codec:         codec,
 		stopChannel:   make(chan struct{}),
 		readBuffer:    make([]byte, 1024),
 		currentBuffer: bytes.NewBuffer([]byte{}),
 	}
+	if pushingCodec, ok := codec.(PushingCodec); ok {
+		pushingCodec.PushTo(client.push, client.disconnect)
+	}
+	return client
 }
 
 // Run runs the client.
+// While there is nothing to be read, the client pings the connection if its codec is a KeepaliveCodec, and returns
+// if the connection has not answered the previous ping in time.
 func (client *Client) Run() {
 	for {
 		select {
 		case <-client.stopChannel:
 			return
 		default:
-			keyValueMessage, err := client.read()
-			if err != nil {
-				return
+			response, err := client.read()
+			if response == nil && err == nil {
+				response, err = client.ping()
 			}
-			if keyValueMessage == nil {
-				continue
+			if response != nil {
+				if _, err := client.writeResponse(response); err != nil {
+					return
+				}
+				if err := client.pull(); err != nil {
+					return
+				}
 			}
-			if err := client.handle(keyValueMessage); err != nil {
+			if err != nil {
 				return
 			}
 		}
 	}
 }
 
-// Stop stops the client.
+// Stop stops the client, and closes the codec if it is a PushingCodec.
 func (client *Client) Stop() {
+	if pushingCodec, ok := client.codec.(PushingCodec); ok {
+		pushingCodec.Close()
+	}
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
 	close(client.stopChannel)
 	_ = syscall.Close(client.fd)
 }
 
-// read reads a single proto.KeyValueMessage from the file descriptor.
+// read reads a single request from the file descriptor, and returns the response of the codec.
 // The file descriptor is already set to non-blocking, which means syscall.Read(..) will not block.
 // However, if there is nothing to be read from the file descriptor, an error would be returned.
 // The error would be EAGAIN or EWOULDBLOCK.
 // For any error, other than EAGAIN or EWOULDBLOCK, the read method will return.
-// read will continue reading till it finds the proto.FooterBytes.
+// read first attempts to answer a complete request from client.currentBuffer. A client may pipeline requests,
+// which means a single syscall.Read(..) may return many requests, and all of them remain in the currentBuffer.
+// If the currentBuffer does not contain a complete request, read will continue reading till a complete request is received.
 // However, it is possible that syscall.Read(..) does not return the amount of data that is requested.
 // In that case, the received data will be stored in client.currentBuffer and the read method will perform poll again.
-// When it polls again, it will read further data until proto.FooterBytes are found.
-// The combined data represented by the currentBuffer will be deserialized into proto.KeyValueMessage.
-func (client *Client) read() (*proto.KeyValueMessage, error) {
+// When it polls again, it will read further data until a complete request is received.
+func (client *Client) read() ([]byte, error) {
 	for {
-		n, err := syscall.Read(client.fd, client.readBuffer)
-		if n <= 0 {
-			break
+		response, err := client.codec.Answer(client.currentBuffer)
+		if !errors.Is(err, ErrIncompleteRequest) {
+			return response, err
 		}
-		client.currentBuffer.Write(client.readBuffer[:n])
+		n, err := syscall.Read(client.fd, client.readBuffer)
 		if err != nil {
-			if err == io.EOF {
-				return nil, err
-			}
 			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EWOULDBLOCK) {
 				return nil, nil
 			}
 			return nil, err
 		}
-		if bytes.Contains(client.readBuffer, proto.FooterBytes) {
-			break
+		if n == 0 {
+			return nil, io.EOF
 		}
+		client.currentBuffer.Write(client.readBuffer[:n])
 	}
-	if client.currentBuffer.Len() > 0 {
-		keyValueMessage, err := proto.DeserializeFrom(client.currentBuffer)
-		if err != nil {
-			return nil, err
-		}
-		return keyValueMessage, nil
+}
+
+// ping returns the ping which is due for the connection, if the codec is a KeepaliveCodec.
+func (client *Client) ping() ([]byte, error) {
+	if keepaliveCodec, ok := client.codec.(KeepaliveCodec); ok {
+		return keepaliveCodec.Ping(time.Now())
 	}
 	return nil, nil
 }
 
-// handle handles the incoming message.
-func (client *Client) handle(keyValueMessage *proto.KeyValueMessage) error {
-	buffer, err := client.handlers[keyValueMessage.Kind].Handle(keyValueMessage)
-	if err != nil {
-		return err
+// pull writes the frames which are pulled from the codec (such as the chunks of a get stream) once a response is
+// written, if the codec is a PullingCodec. Every frame is written before the next one is pulled.
+func (client *Client) pull() error {
+	pullingCodec, ok := client.codec.(PullingCodec)
+	if !ok {
+		return nil
+	}
+	for {
+		frame, err := pullingCodec.Pull()
+		if err != nil || frame == nil {
+			return err
+		}
+		if _, err := client.writeResponse(frame); err != nil {
+			return err
+		}
 	}
-	_, err = client.writeResponse(buffer)
-	return err
 }
 
 // writeResponse writes the response to the file descriptor.
+// syscall.Write(..) on a non-blocking file descriptor may write fewer bytes than requested,
+// or fail with EAGAIN/EWOULDBLOCK if the socket send buffer is full (for example, when a client pipelines requests
+// without reading the responses). writeResponse busy-waits until the entire buffer is written.
+// The writes are serialized by the writeLock, because the frames are also pushed from the goroutines of the HTTP
+// gateway.
 func (client *Client) writeResponse(buffer []byte) (int, error) {
-	return syscall.Write(client.fd, buffer)
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
+	return client.write(buffer, time.Time{})
+}
+
+// write writes the entire buffer to the file descriptor, and is invoked holding the writeLock.
+// It returns ErrPushTimeout if the buffer is not written by the deadline (unless the deadline is zero).
+func (client *Client) write(buffer []byte, deadline time.Time) (int, error) {
+	written := 0
+	for written < len(buffer) {
+		n, err := syscall.Write(client.fd, buffer[written:])
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EWOULDBLOCK) {
+				if !deadline.IsZero() && time.Now().After(deadline) {
+					return written, ErrPushTimeout
+				}
+				continue
+			}
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// push writes the pushed frame to the file descriptor, unless the client is stopped.
+// A frame is pushed from a goroutine of the Watchers (or of the Broker), so a connection which does not read its
+// pushed frames would keep it busy-waiting; the connection is shut down if the frame is not written within
+// PushTimeout, because a partially written frame can not be followed by the next one.
+func (client *Client) push(frame []byte) error {
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
+	select {
+	case <-client.stopChannel:
+		return nil
+	default:
+		_, err := client.write(frame, time.Now().Add(PushTimeout))
+		if errors.Is(err, ErrPushTimeout) {
+			_ = syscall.Shutdown(client.fd, syscall.SHUT_RDWR)
+		}
+		return err
+	}
+}
+
+// disconnect shuts the file descriptor down, unless the client is stopped. The pending read of Run returns io.EOF,
+// so the client is stopped by its goroutine.
+func (client *Client) disconnect() {
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
+	select {
+	case <-client.stopChannel:
+		return
+	default:
+		_ = syscall.Shutdown(client.fd, syscall.SHUT_RDWR)
+	}
 }
@@ -10,6 +10,9 @@ import (
 
 const maxTimeoutErrorsTolerable = 10
 
+// frameReadTimeout is the time within which the rest of a message must arrive, once its first byte has arrived.
+const frameReadTimeout = 5 * time.Second
+
 // ConnectionReader represents an abstraction to read from the connection.
 type ConnectionReader struct {
 	connection     net.Conn
@@ -34,9 +37,12 @@ func NewConnectionReader(connection net.Conn) ConnectionReader {
 // It runs an infinite loop to read a single message from the incoming connection.
 //
 // proto.DeserializeFrom() reads from the connection using "blocking IO" and returns either a message or an error.
-// The method tolerates network timeout errors.
+// The method tolerates network timeout errors, any other error (including io.EOF) is returned immediately.
 //
 // This method also sets ReadDeadline for future Read calls and any currently-blocked Read call.
+// Once the first byte of a message has arrived, the deadline is extended by frameReadTimeout for the rest of the
+// message, so that a peer which sends a partial message does not block the reader forever. A timeout in the middle
+// of a message leaves it partially consumed, so the error is returned and the connection is expected to be closed.
 func (connectionReader ConnectionReader) AttemptReadOrErrorOut() (*proto.KeyValueMessage, error) {
 	totalTimeoutsErrors := 0
 	for {
@@ -45,18 +51,21 @@ func (connectionReader ConnectionReader) AttemptReadOrErrorOut() (*proto.KeyValu
 			return nil, errors.New("ConnectionReader is closed")
 		default:
 			_ = connectionReader.connection.SetReadDeadline(time.Now().Add(120 * time.Millisecond))
-
-			message, err := proto.DeserializeFrom(connectionReader.bufferedReader)
-			if err != nil {
-				if errors.As(err, &connectionReader.netError) && connectionReader.netError.Timeout() {
-					totalTimeoutsErrors += 1
+			if _, err := connectionReader.bufferedReader.Peek(1); err != nil {
+				if !errors.As(err, &connectionReader.netError) || !connectionReader.netError.Timeout() {
+					return nil, err
 				}
+				totalTimeoutsErrors += 1
 				if totalTimeoutsErrors <= maxTimeoutErrorsTolerable {
 					continue
 				}
 				return nil, err
 			}
-			return message, nil
+			_ = connectionReader.connection.SetReadDeadline(time.Now().Add(frameReadTimeout))
+
+			message, err := proto.DeserializeFrom(connectionReader.bufferedReader)
+			_ = connectionReader.connection.SetReadDeadline(time.Time{})
+			return message, err
 		}
 	}
 }
@@ -5,12 +5,14 @@ import (
 	"github.com/stretchr/testify/assert"
 	"non_blocking_busy_waiting/proto"
 	store2 "non_blocking_busy_waiting/store"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestPutAKeyValuePair(t *testing.T) {
 	store := store2.NewInMemoryStore()
-	handler := NewPutOrUpdateHandler(store)
+	handler := NewPutOrUpdateHandler(store, NewWatchers())
 
 	putOrUpdateKeyValueMessage := proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe")
 	handle, err := handler.Handle(putOrUpdateKeyValueMessage)
@@ -38,7 +40,7 @@ func TestGetANonExistingKeyValuePair(t *testing.T) {
 
 func TestGetAnExistingKeyValuePair(t *testing.T) {
 	store := store2.NewInMemoryStore()
-	handler := NewPutOrUpdateHandler(store)
+	handler := NewPutOrUpdateHandler(store, NewWatchers())
 
 	putOrUpdateKeyValueMessage := proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe")
 	_, err := handler.Handle(putOrUpdateKeyValueMessage)
@@ -53,5 +55,442 @@ func TestGetAnExistingKeyValuePair(t *testing.T) {
 
 	assert.Equal(t, proto.KeyValueMessageKindGetResponse, response.Kind)
 	assert.Equal(t, proto.Status_Ok, response.GetStatus())
-	assert.Equal(t, "NVMe", response.GetValue())
+	assert.Equal(t, "NVMe", string(response.RawValue()))
+}
+
+func TestGetABinaryValueContainingTheFooter(t *testing.T) {
+	store := store2.NewInMemoryStore()
+	key, value := string([]byte{0x00, 0xff}), string([]byte{0xc3, 0x28})+string(proto.FooterBytes)+"NVMe"
+
+	_, err := NewPutOrUpdateHandler(store, NewWatchers()).Handle(proto.NewPutOrUpdateKeyValueMessage(key, value))
+	assert.Nil(t, err)
+
+	handle, err := NewGetHandler(store).Handle(proto.NewGetValueMessage(key))
+
+	assert.Nil(t, err)
+	response, err := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Nil(t, err)
+	assert.Equal(t, proto.Status_Ok, response.GetStatus())
+	assert.Equal(t, []byte(key), response.RawKey())
+	assert.Equal(t, []byte(value), response.RawValue())
+}
+
+func TestGetAKeyValuePairForALegacyClient(t *testing.T) {
+	store := store2.NewInMemoryStore()
+
+	_, err := NewPutOrUpdateHandler(store, NewWatchers()).Handle(&proto.KeyValueMessage{Key: "DiskType", Value: "NVMe", Kind: proto.KeyValueMessageKindPutOrUpdate})
+	assert.Nil(t, err)
+
+	handle, err := NewGetHandler(store).Handle(&proto.KeyValueMessage{Key: "DiskType", Kind: proto.KeyValueMessageKindGet})
+
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.Status_Ok, response.GetStatus())
+	assert.Equal(t, "NVMe", response.Value)
+}
+
+func TestResponseCarriesTheRequestId(t *testing.T) {
+	store := store2.NewInMemoryStore()
+	handler := NewGetHandler(store)
+
+	getValueMessage := proto.NewGetValueMessage("DiskType").WithRequestId(7)
+	handle, err := handler.Handle(getValueMessage)
+
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, uint64(7), response.RequestId)
+}
+
+func TestDeleteAnExistingKeyValuePair(t *testing.T) {
+	store := store2.NewInMemoryStore()
+	_, err := NewPutOrUpdateHandler(store, NewWatchers()).Handle(proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe"))
+
+	assert.Nil(t, err)
+
+	handle, err := NewDeleteHandler(store, NewWatchers()).Handle(proto.NewDeleteMessage("DiskType"))
+
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.KeyValueMessageKindDeleteResponse, response.Kind)
+	assert.Equal(t, proto.Status_Ok, response.GetStatus())
+
+	_, ok := store.GetValue([]byte("DiskType"))
+	assert.False(t, ok)
+}
+
+func TestDeleteANonExistingKeyValuePair(t *testing.T) {
+	store := store2.NewInMemoryStore()
+	handler := NewDeleteHandler(store, NewWatchers())
+
+	handle, err := handler.Handle(proto.NewDeleteMessage("DiskType"))
+
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.KeyValueMessageKindDeleteResponse, response.Kind)
+	assert.Equal(t, proto.Status_NotOk, response.GetStatus())
+}
+
+func TestCompareAndSwapWithTheCurrentVersion(t *testing.T) {
+	store := store2.NewInMemoryStore()
+	handler := NewCompareAndSwapHandler(store, NewWatchers())
+
+	handle, err := handler.Handle(proto.NewCompareAndSwapMessage("DiskType", "NVMe", 0))
+
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.KeyValueMessageKindCompareAndSwapResponse, response.Kind)
+	assert.Equal(t, proto.Status_Ok, response.GetStatus())
+
+	handle, err = handler.Handle(proto.NewCompareAndSwapMessage("DiskType", "HDD", response.Version))
+
+	assert.Nil(t, err)
+	response, _ = proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.Status_Ok, response.GetStatus())
+
+	value, _ := store.GetValue([]byte("DiskType"))
+	assert.Equal(t, []byte("HDD"), value)
+}
+
+func TestCompareAndSwapWithAStaleVersion(t *testing.T) {
+	store := store2.NewInMemoryStore()
+	store.PutOrUpdate([]byte("DiskType"), []byte("NVMe"))
+	_, version, _ := store.GetVersionedValue([]byte("DiskType"))
+
+	handle, err := NewCompareAndSwapHandler(store, NewWatchers()).Handle(proto.NewCompareAndSwapMessage("DiskType", "HDD", version+1))
+
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.KeyValueMessageKindCompareAndSwapResponse, response.Kind)
+	assert.Equal(t, proto.Status_Conflict, response.GetStatus())
+	assert.Equal(t, version, response.Version)
+}
+
+func TestCompareAndSwapWithATimeToLive(t *testing.T) {
+	store := store2.NewInMemoryStore()
+
+	_, err := NewCompareAndSwapHandler(store, NewWatchers()).Handle(proto.NewCompareAndSwapMessageWithTTL("DiskType", "NVMe", 0, time.Minute))
+	assert.Nil(t, err)
+
+	ttl, ok := store.TimeToLive([]byte("DiskType"))
+	assert.True(t, ok)
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+}
+
+func TestMultiPutOrUpdateAndMultiGetKeyValuePairs(t *testing.T) {
+	store := store2.NewInMemoryStore()
+
+	multiPutOrUpdateMessage := proto.NewMultiPutOrUpdateMessage(
+		proto.NewKeyValuePair("DiskType", "NVMe"),
+		proto.NewKeyValuePair("Storage", "LSM"),
+	)
+	handle, err := NewMultiPutOrUpdateHandler(store, NewWatchers()).Handle(multiPutOrUpdateMessage)
+
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.KeyValueMessageKindMultiPutOrUpdateResponse, response.Kind)
+	assert.Equal(t, 2, len(response.Pairs))
+
+	handle, err = NewMultiGetHandler(store).Handle(proto.NewMultiGetMessage("DiskType", "System", "Storage"))
+
+	assert.Nil(t, err)
+	response, _ = proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.KeyValueMessageKindMultiGetResponse, response.Kind)
+	assert.Equal(t, 3, len(response.Pairs))
+	assert.Equal(t, proto.Status_Ok, response.Pairs[0].Status)
+	assert.Equal(t, "NVMe", string(response.Pairs[0].RawValue()))
+	assert.Equal(t, proto.Status_NotOk, response.Pairs[1].Status)
+	assert.Equal(t, proto.Status_Ok, response.Pairs[2].Status)
+	assert.Equal(t, "LSM", string(response.Pairs[2].RawValue()))
+}
+
+func TestScanKeyValuePairs(t *testing.T) {
+	store := store2.NewInMemoryStore()
+	store.PutOrUpdate([]byte("disk:ssd"), []byte("SSD"))
+	store.PutOrUpdate([]byte("storage:lsm"), []byte("LSM"))
+	store.PutOrUpdate([]byte("disk:nvme"), []byte("NVMe"))
+
+	handle, err := NewScanHandler(store).Handle(proto.NewPrefixScanMessage("disk:", 0).WithRequestId(3))
+
+	assert.Nil(t, err)
+	reader := bytes.NewReader(handle)
+
+	var responses []*proto.KeyValueMessage
+	for {
+		response, err := proto.DeserializeFrom(reader)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(3), response.RequestId)
+		if response.Kind == proto.KeyValueMessageKindScanEnd {
+			break
+		}
+		responses = append(responses, response)
+	}
+
+	assert.Equal(t, 2, len(responses))
+	assert.Equal(t, "disk:nvme", string(responses[0].RawKey()))
+	assert.Equal(t, "NVMe", string(responses[0].RawValue()))
+	assert.Equal(t, "disk:ssd", string(responses[1].RawKey()))
+}
+
+func TestScanAnEmptyRange(t *testing.T) {
+	store := store2.NewInMemoryStore()
+	store.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+
+	handle, err := NewScanHandler(store).Handle(proto.NewScanMessage("E", "F", 0))
+
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.KeyValueMessageKindScanEnd, response.Kind)
+}
+
+func TestGetTheTimeToLiveOfAKey(t *testing.T) {
+	store := store2.NewInMemoryStore()
+	_, err := NewPutOrUpdateHandler(store, NewWatchers()).Handle(proto.NewPutOrUpdateKeyValueMessageWithTTL("DiskType", "NVMe", time.Minute))
+
+	assert.Nil(t, err)
+
+	handle, err := NewTimeToLiveHandler(store).Handle(proto.NewTimeToLiveMessage("DiskType"))
+
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.KeyValueMessageKindTimeToLiveResponse, response.Kind)
+	assert.Equal(t, proto.Status_Ok, response.GetStatus())
+	assert.True(t, response.TimeToLive() > 0 && response.TimeToLive() <= time.Minute)
+}
+
+func TestGetTheTimeToLiveOfANonExistingKey(t *testing.T) {
+	store := store2.NewInMemoryStore()
+
+	handle, err := NewTimeToLiveHandler(store).Handle(proto.NewTimeToLiveMessage("DiskType"))
+
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.KeyValueMessageKindTimeToLiveResponse, response.Kind)
+	assert.Equal(t, proto.Status_NotOk, response.GetStatus())
+}
+
+func TestIncrementByAKey(t *testing.T) {
+	store := store2.NewInMemoryStore()
+	store.PutOrUpdate([]byte("Counter"), []byte("10"))
+
+	handle, err := NewIncrementByHandler(store, NewWatchers()).Handle(proto.NewIncrementByMessage("Counter", 5))
+
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.KeyValueMessageKindIncrementByResponse, response.Kind)
+	assert.Equal(t, proto.Status_Ok, response.GetStatus())
+	assert.Equal(t, "15", string(response.RawValue()))
+}
+
+func TestIncrementByANonNumericKey(t *testing.T) {
+	store := store2.NewInMemoryStore()
+	store.PutOrUpdate([]byte("DiskType"), []byte("NVMe"))
+
+	handle, err := NewIncrementByHandler(store, NewWatchers()).Handle(proto.NewIncrementByMessage("DiskType", 5))
+
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.KeyValueMessageKindIncrementByResponse, response.Kind)
+	assert.Equal(t, proto.Status_NotANumber, response.GetStatus())
+}
+
+func TestMutationsWhichTheStoreCanNotMakeDurable(t *testing.T) {
+	store, _ := store2.OpenDurableStore(filepath.Join(t.TempDir(), "store.wal"), store2.SyncPolicy{Mode: store2.SyncAlways}, store2.NewInMemoryStore())
+	store.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+	_ = store.Close()
+	handlers := NewHandlers(store)
+
+	for _, message := range []*proto.KeyValueMessage{
+		proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe"),
+		proto.NewDeleteMessage("DiskType"),
+		proto.NewCompareAndSwapMessage("DiskType", "NVMe", 1),
+		proto.NewMultiPutOrUpdateMessage(proto.NewKeyValuePair("DiskType", "NVMe")),
+		proto.NewIncrementByMessage("Counter", 5),
+	} {
+		handle, err := handlers[message.Kind].Handle(message)
+
+		assert.Nil(t, err)
+		response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+		assert.Equal(t, proto.Status_NotDurable, response.GetStatus())
+	}
+	value, _ := store.GetValue([]byte("DiskType"))
+	assert.Equal(t, []byte("SSD"), value)
+}
+
+func TestHelloNegotiatesTheProtocolVersionAndTheFeatures(t *testing.T) {
+	unknownFeature := uint32(1 << 31)
+	handle, err := NewHelloHandler().Handle(proto.NewHelloMessage(proto.ProtocolVersion+1, proto.FeaturePipelining|proto.FeatureCompression|unknownFeature))
+
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.KeyValueMessageKindHelloResponse, response.Kind)
+	assert.Equal(t, proto.Status_Ok, response.GetStatus())
+	assert.Equal(t, proto.ProtocolVersion, response.ProtocolVersion)
+	assert.Equal(t, proto.FeaturePipelining|proto.FeatureCompression, response.Features)
+}
+
+func TestHelloWithAnUnsupportedProtocolVersion(t *testing.T) {
+	handle, err := NewHelloHandler().Handle(proto.NewHelloMessage(proto.MinProtocolVersion-1, proto.FeaturePipelining))
+
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.Status_NotOk, response.GetStatus())
+	assert.Equal(t, proto.ProtocolVersion, response.ProtocolVersion)
+}
+
+func TestExecWithoutATransaction(t *testing.T) {
+	handle, err := NewTransactionHandler(store2.NewInMemoryStore(), NewWatchers()).Handle(proto.NewExecMessage())
+
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.KeyValueMessageKindExecResponse, response.Kind)
+	assert.Equal(t, proto.Status_NotOk, response.GetStatus())
+}
+
+func TestPingIsAnsweredWithAPong(t *testing.T) {
+	handle, err := NewPingHandler().Handle(proto.NewPingMessage().WithRequestId(7))
+
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.KeyValueMessageKindPong, response.Kind)
+	assert.Equal(t, uint64(7), response.RequestId)
+}
+
+func TestPongIsNotAnswered(t *testing.T) {
+	handle, err := NewPingHandler().Handle(proto.NewPongMessage())
+
+	assert.Nil(t, err)
+	assert.Empty(t, handle)
+}
+
+func TestPutAValueWithAStreamAndGetItWithAStream(t *testing.T) {
+	handler := NewStreamHandler(store2.NewInMemoryStore(), NewWatchers())
+	streams := &Streams{}
+
+	for _, message := range []*proto.KeyValueMessage{
+		proto.NewPutStreamBeginMessage(1, "DiskImage", 0),
+		proto.NewStreamChunkMessage(1, []byte("NVMe ")),
+		proto.NewStreamChunkMessage(1, []byte("SSD")),
+	} {
+		handle, err := handler.HandleStream(streams, message)
+		assert.Nil(t, err)
+		assert.Empty(t, handle)
+	}
+	handle, err := handler.HandleStream(streams, proto.NewStreamEndMessage(1))
+	assert.Nil(t, err)
+
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+	assert.Equal(t, proto.KeyValueMessageKindStreamResponse, response.Kind)
+	assert.Equal(t, proto.Status_Ok, response.Status)
+	assert.Equal(t, uint64(1), response.StreamId)
+
+	handle, err = handler.HandleStream(streams, proto.NewGetStreamMessage(2, "DiskImage", 0))
+	assert.Nil(t, err)
+
+	begin, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+	assert.Equal(t, proto.KeyValueMessageKindStreamBegin, begin.Kind)
+	assert.Equal(t, response.Version, begin.Version)
+
+	var value []byte
+	for {
+		message := streams.Next()
+		assert.Equal(t, uint64(2), message.StreamId)
+		if message.Kind == proto.KeyValueMessageKindStreamEnd {
+			break
+		}
+		value = append(value, message.RawValue()...)
+	}
+	assert.Equal(t, "NVMe SSD", string(value))
+	assert.Nil(t, streams.Next())
+}
+
+func TestGetAValueWithAStreamInChunksOfTheMinimumChunkSize(t *testing.T) {
+	store := store2.NewInMemoryStore()
+	store.PutOrUpdate([]byte("DiskImage"), make([]byte, 3*MinStreamChunkSize))
+
+	handle, err := NewStreamHandler(store, NewWatchers()).Handle(proto.NewGetStreamMessage(1, "DiskImage", 1))
+	assert.Nil(t, err)
+
+	reader := bytes.NewReader(handle)
+	begin, _ := proto.DeserializeFrom(reader)
+	assert.Equal(t, proto.KeyValueMessageKindStreamBegin, begin.Kind)
+
+	chunks := 0
+	for {
+		message, err := proto.DeserializeFrom(reader)
+		assert.Nil(t, err)
+		if message.Kind == proto.KeyValueMessageKindStreamEnd {
+			break
+		}
+		assert.Equal(t, MinStreamChunkSize, len(message.RawValue()))
+		chunks++
+	}
+	assert.Equal(t, 3, chunks)
+}
+
+func TestGetANonExistingKeyWithAStream(t *testing.T) {
+	handle, err := NewStreamHandler(store2.NewInMemoryStore(), NewWatchers()).Handle(proto.NewGetStreamMessage(1, "DiskImage", 0))
+
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.KeyValueMessageKindStreamResponse, response.Kind)
+	assert.Equal(t, proto.Status_NotOk, response.Status)
+}
+
+func TestChunkOfAStreamWhichExceedsTheMaximumStreamLength(t *testing.T) {
+	handler := NewStreamHandler(store2.NewInMemoryStore(), NewWatchers())
+	streams := &Streams{}
+
+	_, _ = handler.HandleStream(streams, proto.NewPutStreamBeginMessage(1, "DiskImage", 0))
+	handle, err := handler.HandleStream(streams, proto.NewStreamChunkMessage(1, make([]byte, MaxStreamLength+1)))
+
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+
+	assert.Equal(t, proto.KeyValueMessageKindStreamResponse, response.Kind)
+	assert.Equal(t, proto.Status_TooLarge, response.Status)
+}
+
+func TestChunkOfAStreamWhichExceedsTheStreamBudget(t *testing.T) {
+	budget := NewStreamBudget(8)
+	handler := NewStreamHandlerWithBudget(store2.NewInMemoryStore(), NewWatchers(), budget)
+	streams, otherStreams := &Streams{}, &Streams{}
+
+	_, _ = handler.HandleStream(streams, proto.NewPutStreamBeginMessage(1, "DiskImage", 0))
+	_, _ = handler.HandleStream(otherStreams, proto.NewPutStreamBeginMessage(1, "DiskType", 0))
+	handle, err := handler.HandleStream(streams, proto.NewStreamChunkMessage(1, []byte("NVMe ")))
+	assert.Nil(t, err)
+	assert.Empty(t, handle)
+
+	handle, err = handler.HandleStream(otherStreams, proto.NewStreamChunkMessage(1, []byte("NVMe SSD")))
+	assert.Nil(t, err)
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+	assert.Equal(t, proto.KeyValueMessageKindStreamResponse, response.Kind)
+	assert.Equal(t, proto.Status_TooLarge, response.Status)
+	assert.Equal(t, 0, otherStreams.Open())
+
+	handle, err = handler.HandleStream(streams, proto.NewStreamEndMessage(1))
+	assert.Nil(t, err)
+	response, _ = proto.DeserializeFrom(bytes.NewReader(handle))
+	assert.Equal(t, proto.Status_Ok, response.Status)
+	assert.Equal(t, int64(0), budget.Reserved())
 }
@@ -0,0 +1,110 @@
+package conn
+
+import (
+	"non_blocking_busy_waiting/proto"
+	"sync"
+)
+
+// SynchronizedHandler is a Handler which holds a lock while the message is handled.
+// The store of the busy-waiting server has no locks of its own, because the server handles the clients in a single
+// goroutine; the handlers are synchronized when they are also invoked from other goroutines, such as the ones of
+// the HTTP gateway.
+type SynchronizedHandler struct {
+	handler Handler
+	lock    *sync.Mutex
+}
+
+// NewSynchronizedHandlers wraps every handler in a SynchronizedHandler (or a SynchronizedSubscribingHandler,
+// a SynchronizedTransactionalHandler, or a SynchronizedStreamingHandler), and all of them share a single lock, so only
+// one message is handled at a time.
+func NewSynchronizedHandlers(handlers map[uint32]Handler) map[uint32]Handler {
+	return NewSynchronizedHandlersWithLock(handlers, &sync.Mutex{})
+}
+
+// NewSynchronizedHandlersWithLock wraps every handler (see NewSynchronizedHandlers) with the given lock, which is also
+// held by the caller while it changes the store outside the handlers, such as when it evicts the expired keys.
+func NewSynchronizedHandlersWithLock(handlers map[uint32]Handler, lock *sync.Mutex) map[uint32]Handler {
+	synchronizedHandlers := make(map[uint32]Handler, len(handlers))
+	for kind, handler := range handlers {
+		synchronizedHandler := SynchronizedHandler{handler: handler, lock: lock}
+		if subscribingHandler, ok := handler.(SubscribingHandler); ok {
+			synchronizedHandlers[kind] = SynchronizedSubscribingHandler{
+				SynchronizedHandler: synchronizedHandler,
+				subscribingHandler:  subscribingHandler,
+			}
+			continue
+		}
+		if transactionalHandler, ok := handler.(TransactionalHandler); ok {
+			synchronizedHandlers[kind] = SynchronizedTransactionalHandler{
+				SynchronizedHandler:  synchronizedHandler,
+				transactionalHandler: transactionalHandler,
+			}
+			continue
+		}
+		if streamingHandler, ok := handler.(StreamingHandler); ok {
+			synchronizedHandlers[kind] = SynchronizedStreamingHandler{
+				SynchronizedHandler: synchronizedHandler,
+				streamingHandler:    streamingHandler,
+			}
+			continue
+		}
+		synchronizedHandlers[kind] = synchronizedHandler
+	}
+	return synchronizedHandlers
+}
+
+// Handle handles the incoming message, holding the lock.
+func (handler SynchronizedHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	return handler.handler.Handle(message)
+}
+
+// SynchronizedSubscribingHandler is a SubscribingHandler which holds the lock of a SynchronizedHandler while
+// the message is handled, and while the subscriber unsubscribes.
+type SynchronizedSubscribingHandler struct {
+	SynchronizedHandler
+	subscribingHandler SubscribingHandler
+}
+
+// HandleFor handles the incoming message on behalf of the subscriber, holding the lock.
+func (handler SynchronizedSubscribingHandler) HandleFor(subscriber Subscriber, message *proto.KeyValueMessage) ([]byte, error) {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	return handler.subscribingHandler.HandleFor(subscriber, message)
+}
+
+// Unsubscribe unsubscribes the subscriber, holding the lock.
+func (handler SynchronizedSubscribingHandler) Unsubscribe(subscriber Subscriber) {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	handler.subscribingHandler.Unsubscribe(subscriber)
+}
+
+// SynchronizedTransactionalHandler is a TransactionalHandler which holds the lock of a SynchronizedHandler while
+// the message is handled, so the queued requests of an Exec are applied atomically.
+type SynchronizedTransactionalHandler struct {
+	SynchronizedHandler
+	transactionalHandler TransactionalHandler
+}
+
+// HandleIn handles the incoming message in the transaction of the connection, holding the lock.
+func (handler SynchronizedTransactionalHandler) HandleIn(transaction *Transaction, message *proto.KeyValueMessage) ([]byte, error) {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	return handler.transactionalHandler.HandleIn(transaction, message)
+}
+
+// SynchronizedStreamingHandler is a StreamingHandler which holds the lock of a SynchronizedHandler while the message
+// is handled, so the value of a stream is put into the store under the lock.
+type SynchronizedStreamingHandler struct {
+	SynchronizedHandler
+	streamingHandler StreamingHandler
+}
+
+// HandleStream handles the incoming message in the streams of the connection, holding the lock.
+func (handler SynchronizedStreamingHandler) HandleStream(streams *Streams, message *proto.KeyValueMessage) ([]byte, error) {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	return handler.streamingHandler.HandleStream(streams, message)
+}
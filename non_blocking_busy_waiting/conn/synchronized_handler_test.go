@@ -0,0 +1,52 @@
+package conn
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"non_blocking_busy_waiting/proto"
+	store2 "non_blocking_busy_waiting/store"
+	"sync"
+	"testing"
+)
+
+func TestSynchronizedHandlersIncrementACounterFromConcurrentGoroutines(t *testing.T) {
+	handlers := NewSynchronizedHandlers(NewHandlers(store2.NewInMemoryStore()))
+
+	var waitGroup sync.WaitGroup
+	for goroutine := 0; goroutine < 8; goroutine++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for count := 0; count < 100; count++ {
+				_, _ = handlers[proto.KeyValueMessageKindIncrementBy].Handle(proto.NewIncrementByMessage("counter", 1))
+			}
+		}()
+	}
+	waitGroup.Wait()
+
+	handle, err := handlers[proto.KeyValueMessageKindGet].Handle(proto.NewGetValueMessage("counter"))
+	assert.Nil(t, err)
+
+	response, _ := proto.DeserializeFrom(bytes.NewReader(handle))
+	assert.Equal(t, "800", string(response.RawValue()))
+}
+
+func TestSynchronizedHandlersRetainTheTransactionalHandlers(t *testing.T) {
+	handlers := NewSynchronizedHandlers(NewHandlers(store2.NewInMemoryStore()))
+
+	_, ok := handlers[proto.KeyValueMessageKindExec].(TransactionalHandler)
+	assert.True(t, ok)
+
+	_, ok = handlers[proto.KeyValueMessageKindGet].(TransactionalHandler)
+	assert.False(t, ok)
+}
+
+func TestSynchronizedHandlersRetainTheStreamingHandlers(t *testing.T) {
+	handlers := NewSynchronizedHandlers(NewHandlers(store2.NewInMemoryStore()))
+
+	_, ok := handlers[proto.KeyValueMessageKindStreamChunk].(StreamingHandler)
+	assert.True(t, ok)
+
+	_, ok = handlers[proto.KeyValueMessageKindGet].(StreamingHandler)
+	assert.False(t, ok)
+}
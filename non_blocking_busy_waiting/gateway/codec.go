@@ -0,0 +1,159 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"non_blocking_busy_waiting/conn"
+	"strings"
+)
+
+// MaxHeaderLength is the maximum length of the request line and the headers of a request.
+const MaxHeaderLength = http.DefaultMaxHeaderBytes
+
+var ErrConnectionClose = errors.New("the client asked to close the connection")
+
+// Codec is the conn.Codec for HTTP/1.1, which serves the requests with the Gateway.
+// The non-blocking servers have no net.Conn to hand to net/http, so the Codec parses the requests from the buffer
+// and writes the responses itself.
+type Codec struct {
+	gateway *Gateway
+}
+
+// NewCodec creates a new instance of Codec.
+func NewCodec(handlers map[uint32]conn.Handler) *Codec {
+	return &Codec{
+		gateway: NewGateway(handlers),
+	}
+}
+
+// Answer serves the first request in the buffer, and returns its response.
+// A request stays in the buffer until its headers and its body have arrived. A malformed request is answered with
+// 400 Bad Request, and a request whose body is longer than MaxValueLength with 413 Request Entity Too Large, before
+// the connection is closed (as are the requests whose headers are longer than MaxHeaderLength). The connection is also closed after a request with "Connection: close".
+func (codec *Codec) Answer(buffer *bytes.Buffer) ([]byte, error) {
+	// net/http takes the bytes before an EOF as a complete header line, so the request is not parsed before
+	// the blank line which ends its headers has arrived.
+	if !hasHeaders(buffer.Bytes()) {
+		if buffer.Len() > MaxHeaderLength {
+			return errorResponseOf(http.StatusRequestHeaderFieldsTooLarge), fmt.Errorf("request headers are longer than %d bytes", MaxHeaderLength)
+		}
+		return nil, conn.ErrIncompleteRequest
+	}
+	counter := &countingReader{reader: bytes.NewReader(buffer.Bytes())}
+	reader := bufio.NewReader(counter)
+
+	request, err := http.ReadRequest(reader)
+	if err != nil {
+		if isIncomplete(err) {
+			return nil, conn.ErrIncompleteRequest
+		}
+		return errorResponseOf(http.StatusBadRequest), err
+	}
+	if request.ContentLength > MaxValueLength {
+		return errorResponseOf(http.StatusRequestEntityTooLarge), fmt.Errorf("request body is longer than %d bytes", MaxValueLength)
+	}
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		// the error of a chunked body which is truncated in its trailer does not wrap io.ErrUnexpectedEOF.
+		if isIncomplete(err) || strings.Contains(err.Error(), "unexpected EOF") {
+			return nil, conn.ErrIncompleteRequest
+		}
+		return errorResponseOf(http.StatusBadRequest), err
+	}
+	buffer.Next(counter.count - reader.Buffered())
+
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	writer := newResponseWriter()
+	codec.gateway.ServeHTTP(writer, request)
+
+	response, err := writer.serialize(request)
+	if err != nil {
+		return nil, err
+	}
+	if request.Close {
+		return response, ErrConnectionClose
+	}
+	return response, nil
+}
+
+func hasHeaders(data []byte) bool {
+	return bytes.Contains(data, []byte("\r\n\r\n")) || bytes.Contains(data, []byte("\n\n"))
+}
+
+func isIncomplete(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// errorResponseOf returns the serialized response with the given status code, which closes the connection.
+func errorResponseOf(statusCode int) []byte {
+	return []byte(fmt.Sprintf(
+		"HTTP/1.1 %d %s\r\nConnection: close\r\nContent-Length: 0\r\n\r\n",
+		statusCode, http.StatusText(statusCode),
+	))
+}
+
+// countingReader counts the bytes which are read from the reader.
+type countingReader struct {
+	reader io.Reader
+	count  int
+}
+
+func (reader *countingReader) Read(buffer []byte) (int, error) {
+	n, err := reader.reader.Read(buffer)
+	reader.count += n
+	return n, err
+}
+
+// responseWriter is an http.ResponseWriter which holds the response in memory.
+type responseWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func newResponseWriter() *responseWriter {
+	return &responseWriter{header: make(http.Header)}
+}
+
+func (writer *responseWriter) Header() http.Header {
+	return writer.header
+}
+
+func (writer *responseWriter) Write(buffer []byte) (int, error) {
+	if writer.statusCode == 0 {
+		writer.WriteHeader(http.StatusOK)
+	}
+	return writer.body.Write(buffer)
+}
+
+func (writer *responseWriter) WriteHeader(statusCode int) {
+	if writer.statusCode == 0 {
+		writer.statusCode = statusCode
+	}
+}
+
+// serialize serializes the response to the request, in the wire format of HTTP/1.1.
+func (writer *responseWriter) serialize(request *http.Request) ([]byte, error) {
+	if writer.statusCode == 0 {
+		writer.statusCode = http.StatusOK
+	}
+	response := &http.Response{
+		StatusCode:    writer.statusCode,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        writer.header,
+		Body:          io.NopCloser(&writer.body),
+		ContentLength: int64(writer.body.Len()),
+		Close:         request.Close,
+		Request:       request,
+	}
+	var serialized bytes.Buffer
+	if err := response.Write(&serialized); err != nil {
+		return nil, err
+	}
+	return serialized.Bytes(), nil
+}
@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"non_blocking_busy_waiting/conn"
+	"non_blocking_busy_waiting/store"
+	"strings"
+	"testing"
+)
+
+func readResponse(t *testing.T, response []byte) *http.Response {
+	httpResponse, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(response)), nil)
+	assert.Nil(t, err)
+	return httpResponse
+}
+
+func TestAnswersPipelinedRequests(t *testing.T) {
+	codec := NewCodec(conn.NewHandlers(store.NewInMemoryStore()))
+	buffer := bytes.NewBufferString(
+		"PUT /keys/DiskType HTTP/1.1\r\nHost: localhost\r\nContent-Length: 8\r\n\r\nNVMe SSD" +
+			"GET /keys/DiskType HTTP/1.1\r\nHost: localhost\r\n\r\n",
+	)
+
+	response, err := codec.Answer(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, readResponse(t, response).StatusCode)
+
+	response, err = codec.Answer(buffer)
+	assert.Nil(t, err)
+
+	httpResponse := readResponse(t, response)
+	assert.Equal(t, http.StatusOK, httpResponse.StatusCode)
+
+	var keyValue KeyValue
+	assert.Nil(t, json.NewDecoder(httpResponse.Body).Decode(&keyValue))
+	assert.Equal(t, "NVMe SSD", keyValue.Value)
+	assert.Equal(t, 0, buffer.Len())
+}
+
+func TestAnswersARequestWhichArrivesIncrementally(t *testing.T) {
+	codec := NewCodec(conn.NewHandlers(store.NewInMemoryStore()))
+	for _, request := range []string{
+		"PUT /keys/DiskType HTTP/1.1\r\nHost: localhost\r\nContent-Length: 8\r\n\r\nNVMe SSD",
+		"PUT /keys/DiskType HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n4\r\nNVMe\r\n4\r\n SSD\r\n0\r\n\r\n",
+	} {
+		buffer := bytes.NewBuffer(nil)
+		for index := 0; index < len(request)-1; index++ {
+			buffer.WriteByte(request[index])
+			_, err := codec.Answer(buffer)
+			assert.ErrorIs(t, err, conn.ErrIncompleteRequest)
+			assert.Equal(t, index+1, buffer.Len())
+		}
+		buffer.WriteByte(request[len(request)-1])
+
+		response, err := codec.Answer(buffer)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusNoContent, readResponse(t, response).StatusCode)
+		assert.Equal(t, 0, buffer.Len())
+	}
+}
+
+func TestAnswersAChunkedRequest(t *testing.T) {
+	codec := NewCodec(conn.NewHandlers(store.NewInMemoryStore()))
+	buffer := bytes.NewBufferString(
+		"PUT /keys/DiskType HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n4\r\nNVMe\r\n4\r\n SSD\r\n0\r\n\r\n" +
+			"GET /keys/DiskType HTTP/1.1\r\nHost: localhost\r\n\r\n",
+	)
+
+	_, err := codec.Answer(buffer)
+	assert.Nil(t, err)
+
+	response, err := codec.Answer(buffer)
+	assert.Nil(t, err)
+
+	body, _ := io.ReadAll(readResponse(t, response).Body)
+	assert.True(t, strings.Contains(string(body), `"value":"NVMe SSD"`))
+}
+
+func TestClosesTheConnectionAfterAMalformedRequest(t *testing.T) {
+	codec := NewCodec(conn.NewHandlers(store.NewInMemoryStore()))
+
+	response, err := codec.Answer(bytes.NewBufferString("GET /keys/DiskType\r\n\r\n"))
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, readResponse(t, response).StatusCode)
+}
+
+func TestClosesTheConnectionWhenTheClientAsksTo(t *testing.T) {
+	codec := NewCodec(conn.NewHandlers(store.NewInMemoryStore()))
+
+	response, err := codec.Answer(bytes.NewBufferString("GET /keys/DiskType HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrConnectionClose)
+
+	httpResponse := readResponse(t, response)
+	assert.Equal(t, http.StatusNotFound, httpResponse.StatusCode)
+	assert.True(t, httpResponse.Close)
+}
@@ -0,0 +1,296 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"non_blocking_busy_waiting/conn"
+	"non_blocking_busy_waiting/proto"
+	"strconv"
+	"time"
+)
+
+// MaxValueLength is the maximum length of the body of a request.
+const MaxValueLength = 16 * 1024 * 1024
+
+// Gateway exposes the store over HTTP/JSON, so that operators can use curl:
+// - GET /keys/{key} returns the value and the version of the key,
+// - PUT /keys/{key} puts the request body as the value of the key. The optional query parameters are ttl (such as 10s),
+// and version which turns the PUT into a compare-and-swap against the given version (0 for a non-existing key),
+// - DELETE /keys/{key} deletes the key,
+// - POST /batch/get gets many keys, the body is {"keys": ["DiskType", ...]},
+// - POST /batch/put puts many keys atomically, the body is {"pairs": [{"key": "DiskType", "value": "SSD"}, ...]}.
+// A request is mapped onto a proto.KeyValueMessage which is handled by the conn.Handler for its kind, the same as
+// the requests of the TCP server, so both share the store.
+// An error is answered with a JSON body that carries the proto.Status of the response, such as
+// {"status": "NotOk", "key": "DiskType", "error": "key does not exist"}.
+// Keys and values are carried as JSON strings, so the JSON bodies expect them to be UTF-8. The value of a PUT is the
+// raw request body, and may hold arbitrary bytes.
+type Gateway struct {
+	handlers   map[uint32]conn.Handler
+	durability Durability
+	mux        *http.ServeMux
+}
+
+// Durability represents the write-ahead log of a durable store whose mutations return without waiting for the syncs
+// (see store.DurableStore.Deferred), so the gateway waits for the syncs before it answers.
+type Durability interface {
+	// Appended returns the sequence of the last record which is appended to the log.
+	Appended() uint64
+	// WaitSynced blocks till the record with the given sequence is synced, and returns the error of the log if it
+	// fails before that.
+	WaitSynced(sequence uint64) error
+}
+
+// KeyValue is the JSON representation of a key, its value and its version.
+type KeyValue struct {
+	Key     string `json:"key"`
+	Value   string `json:"value,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+	Status  string `json:"status,omitempty"`
+}
+
+// BatchGetRequest is the body of POST /batch/get.
+type BatchGetRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// BatchPutRequest is the body of POST /batch/put.
+type BatchPutRequest struct {
+	Pairs []KeyValue `json:"pairs"`
+}
+
+// BatchResponse is the body of the response of POST /batch/get and POST /batch/put.
+type BatchResponse struct {
+	Pairs []KeyValue `json:"pairs"`
+}
+
+// ErrorResponse is the body of an error response.
+type ErrorResponse struct {
+	Status  string `json:"status"`
+	Key     string `json:"key,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+	Error   string `json:"error"`
+}
+
+// NewGateway creates a new instance of Gateway.
+func NewGateway(handlers map[uint32]conn.Handler) *Gateway {
+	return NewGatewayWithDurability(handlers, nil)
+}
+
+// NewGatewayWithDurability creates a new instance of Gateway, whose handlers mutate a store which does not wait for
+// the syncs of its write-ahead log. Every request is answered once the records which are appended so far are synced,
+// so a mutation is acknowledged once it is durable. A request waits conservatively (even if it is not a mutation),
+// because the gateway does not know which requests append a record.
+func NewGatewayWithDurability(handlers map[uint32]conn.Handler, durability Durability) *Gateway {
+	gateway := &Gateway{
+		handlers:   handlers,
+		durability: durability,
+		mux:        http.NewServeMux(),
+	}
+	gateway.mux.HandleFunc("GET /keys/{key...}", gateway.get)
+	gateway.mux.HandleFunc("PUT /keys/{key...}", gateway.put)
+	gateway.mux.HandleFunc("DELETE /keys/{key...}", gateway.delete)
+	gateway.mux.HandleFunc("POST /batch/get", gateway.batchGet)
+	gateway.mux.HandleFunc("POST /batch/put", gateway.batchPut)
+	return gateway
+}
+
+// ServeHTTP serves the HTTP request.
+func (gateway *Gateway) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	gateway.mux.ServeHTTP(writer, request)
+}
+
+// get handles GET /keys/{key}.
+func (gateway *Gateway) get(writer http.ResponseWriter, request *http.Request) {
+	key := request.PathValue("key")
+	response, ok := gateway.handle(writer, proto.NewGetValueMessage(key))
+	if !ok {
+		return
+	}
+	if response.Status != proto.Status_Ok {
+		writeError(writer, response.Status, key, 0, "key does not exist")
+		return
+	}
+	writeJSON(writer, http.StatusOK, KeyValue{Key: key, Value: string(response.RawValue()), Version: response.Version})
+}
+
+// put handles PUT /keys/{key}.
+func (gateway *Gateway) put(writer http.ResponseWriter, request *http.Request) {
+	key := request.PathValue("key")
+	value, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, MaxValueLength))
+	if err != nil {
+		writeError(writer, proto.Status_Corrupt, key, 0, err.Error())
+		return
+	}
+	var ttl time.Duration
+	if parameter := request.URL.Query().Get("ttl"); parameter != "" {
+		if ttl, err = time.ParseDuration(parameter); err != nil || ttl < time.Millisecond {
+			writeError(writer, proto.Status_Corrupt, key, 0, fmt.Sprintf("invalid ttl %q", parameter))
+			return
+		}
+	}
+
+	if parameter := request.URL.Query().Get("version"); parameter != "" {
+		version, err := strconv.ParseUint(parameter, 10, 64)
+		if err != nil {
+			writeError(writer, proto.Status_Corrupt, key, 0, fmt.Sprintf("invalid version %q", parameter))
+			return
+		}
+		response, ok := gateway.handle(writer, proto.NewCompareAndSwapMessageWithTTL(key, string(value), version, ttl))
+		if !ok {
+			return
+		}
+		if response.Status != proto.Status_Ok {
+			writeError(writer, response.Status, key, response.Version, "version does not match")
+			return
+		}
+		writeJSON(writer, http.StatusOK, KeyValue{Key: key, Version: response.Version})
+		return
+	}
+
+	if _, ok := gateway.handle(writer, proto.NewPutOrUpdateKeyValueMessageWithTTL(key, string(value), ttl)); !ok {
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// delete handles DELETE /keys/{key}.
+func (gateway *Gateway) delete(writer http.ResponseWriter, request *http.Request) {
+	key := request.PathValue("key")
+	response, ok := gateway.handle(writer, proto.NewDeleteMessage(key))
+	if !ok {
+		return
+	}
+	if response.Status != proto.Status_Ok {
+		writeError(writer, response.Status, key, 0, "key does not exist")
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// batchGet handles POST /batch/get. The response carries a pair for every requested key, in the order of the request;
+// the status of a key which does not exist is NotOk.
+func (gateway *Gateway) batchGet(writer http.ResponseWriter, request *http.Request) {
+	var body BatchGetRequest
+	if !readJSON(writer, request, &body) {
+		return
+	}
+	response, ok := gateway.handle(writer, proto.NewMultiGetMessage(body.Keys...))
+	if !ok {
+		return
+	}
+	pairs := make([]KeyValue, 0, len(response.Pairs))
+	for _, pair := range response.Pairs {
+		pairs = append(pairs, KeyValue{
+			Key:     string(pair.RawKey()),
+			Value:   string(pair.RawValue()),
+			Version: pair.Version,
+			Status:  pair.Status.String(),
+		})
+	}
+	writeJSON(writer, http.StatusOK, BatchResponse{Pairs: pairs})
+}
+
+// batchPut handles POST /batch/put. All the pairs are put atomically, and the response carries the new version of
+// every key.
+func (gateway *Gateway) batchPut(writer http.ResponseWriter, request *http.Request) {
+	var body BatchPutRequest
+	if !readJSON(writer, request, &body) {
+		return
+	}
+	keyValuePairs := make([]*proto.KeyValuePair, 0, len(body.Pairs))
+	for _, pair := range body.Pairs {
+		keyValuePairs = append(keyValuePairs, proto.NewKeyValuePair(pair.Key, pair.Value))
+	}
+	response, ok := gateway.handle(writer, proto.NewMultiPutOrUpdateMessage(keyValuePairs...))
+	if !ok {
+		return
+	}
+	pairs := make([]KeyValue, 0, len(response.Pairs))
+	for _, pair := range response.Pairs {
+		pairs = append(pairs, KeyValue{Key: string(pair.RawKey()), Version: pair.Version, Status: pair.Status.String()})
+	}
+	writeJSON(writer, http.StatusOK, BatchResponse{Pairs: pairs})
+}
+
+// handle handles the message with the conn.Handler for its kind, waits for the syncs if the gateway has
+// a Durability, and returns the deserialized response.
+// An unexpected error, or a mutation which the store could not make durable, is answered with an internal server
+// error, and false is returned.
+func (gateway *Gateway) handle(writer http.ResponseWriter, message *proto.KeyValueMessage) (*proto.KeyValueMessage, bool) {
+	handler, ok := gateway.handlers[message.Kind]
+	if !ok {
+		writeJSON(writer, http.StatusInternalServerError, ErrorResponse{
+			Status: proto.Status_NotOk.String(),
+			Error:  fmt.Sprintf("no handler for the message kind %v", message.Kind),
+		})
+		return nil, false
+	}
+	buffer, err := handler.Handle(message)
+	if err == nil && gateway.durability != nil {
+		if err := gateway.durability.WaitSynced(gateway.durability.Appended()); err != nil {
+			writeError(writer, proto.Status_NotDurable, string(message.RawKey()), 0, err.Error())
+			return nil, false
+		}
+	}
+	if err == nil {
+		var response *proto.KeyValueMessage
+		if response, err = proto.DeserializeFrom(bytes.NewReader(buffer)); err == nil {
+			if response.Status == proto.Status_NotDurable {
+				writeError(writer, response.Status, string(message.RawKey()), 0, "the store could not make the mutation durable")
+				return nil, false
+			}
+			return response, true
+		}
+	}
+	writeJSON(writer, http.StatusInternalServerError, ErrorResponse{Status: proto.Status_NotOk.String(), Error: err.Error()})
+	return nil, false
+}
+
+// readJSON decodes the JSON body of the request into body. A malformed body is answered with a bad request,
+// and false is returned.
+func readJSON(writer http.ResponseWriter, request *http.Request, body any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(writer, request.Body, MaxValueLength)).Decode(body); err != nil {
+		writeError(writer, proto.Status_Corrupt, "", 0, err.Error())
+		return false
+	}
+	return true
+}
+
+// writeError writes an ErrorResponse with the HTTP status code which corresponds to the proto.Status.
+func writeError(writer http.ResponseWriter, status proto.Status, key string, version uint64, message string) {
+	writeJSON(writer, httpStatusOf(status), ErrorResponse{
+		Status:  status.String(),
+		Key:     key,
+		Version: version,
+		Error:   message,
+	})
+}
+
+// writeJSON writes the body as JSON, with the given HTTP status code.
+func writeJSON(writer http.ResponseWriter, code int, body any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(code)
+	_ = json.NewEncoder(writer).Encode(body)
+}
+
+// httpStatusOf returns the HTTP status code which corresponds to the proto.Status.
+func httpStatusOf(status proto.Status) int {
+	switch status {
+	case proto.Status_Ok:
+		return http.StatusOK
+	case proto.Status_NotOk:
+		return http.StatusNotFound
+	case proto.Status_Conflict:
+		return http.StatusConflict
+	case proto.Status_NotANumber, proto.Status_Overflow:
+		return http.StatusUnprocessableEntity
+	case proto.Status_NotDurable:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,103 @@
+package resp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"non_blocking_busy_waiting/conn"
+	"non_blocking_busy_waiting/store"
+	"strings"
+	"testing"
+)
+
+func execute(executor *Executor, command string) string {
+	args := make([][]byte, 0)
+	for _, arg := range strings.Fields(command) {
+		args = append(args, []byte(arg))
+	}
+	return string(executor.Execute(args))
+}
+
+func TestExecutePing(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+
+	assert.Equal(t, "+PONG\r\n", execute(executor, "PING"))
+	assert.Equal(t, "$5\r\nhello\r\n", execute(executor, "ping hello"))
+}
+
+func TestExecuteSetAndGet(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+
+	assert.Equal(t, "+OK\r\n", execute(executor, "SET DiskType SSD"))
+	assert.Equal(t, "$3\r\nSSD\r\n", execute(executor, "GET DiskType"))
+}
+
+func TestExecuteGetANonExistingKey(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+
+	assert.Equal(t, "$-1\r\n", execute(executor, "GET DiskType"))
+}
+
+func TestExecuteSetWithAnExpiry(t *testing.T) {
+	keyValueStore := store.NewInMemoryStore()
+	executor := NewExecutor(conn.NewHandlers(keyValueStore))
+
+	assert.Equal(t, "+OK\r\n", execute(executor, "SET DiskType SSD EX 100"))
+
+	ttl, ok := keyValueStore.TimeToLive([]byte("DiskType"))
+	assert.True(t, ok)
+	assert.Greater(t, ttl.Seconds(), float64(90))
+}
+
+func TestExecuteSetWithAnInvalidExpiry(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+
+	assert.Equal(t, "-ERR invalid expire time in 'set' command\r\n", execute(executor, "SET DiskType SSD PX 0"))
+	assert.Equal(t, "-ERR syntax error\r\n", execute(executor, "SET DiskType SSD NX"))
+	assert.Equal(t, "$-1\r\n", execute(executor, "GET DiskType"))
+}
+
+func TestExecuteDel(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+	execute(executor, "SET DiskType SSD")
+	execute(executor, "SET Engine skiplist")
+
+	assert.Equal(t, ":2\r\n", execute(executor, "DEL DiskType Engine Unknown"))
+	assert.Equal(t, "$-1\r\n", execute(executor, "GET DiskType"))
+}
+
+func TestExecuteIncrAndDecr(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+
+	assert.Equal(t, ":1\r\n", execute(executor, "INCR counter"))
+	assert.Equal(t, ":11\r\n", execute(executor, "INCRBY counter 10"))
+	assert.Equal(t, ":10\r\n", execute(executor, "DECR counter"))
+	assert.Equal(t, ":-5\r\n", execute(executor, "DECRBY counter 15"))
+	assert.Equal(t, "$2\r\n-5\r\n", execute(executor, "GET counter"))
+}
+
+func TestExecuteIncrOnANonNumericValue(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+	execute(executor, "SET DiskType SSD")
+
+	assert.Equal(t, "-ERR value is not an integer or out of range\r\n", execute(executor, "INCR DiskType"))
+	assert.Equal(t, "-ERR value is not an integer or out of range\r\n", execute(executor, "INCRBY counter ten"))
+}
+
+func TestExecuteIncrWhichOverflows(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+	execute(executor, "SET counter 9223372036854775807")
+
+	assert.Equal(t, "-ERR increment or decrement would overflow\r\n", execute(executor, "INCR counter"))
+}
+
+func TestExecuteAnUnknownCommand(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+
+	assert.Equal(t, "-ERR unknown command 'FLUSHALL'\r\n", execute(executor, "FLUSHALL"))
+}
+
+func TestExecuteACommandWithAWrongNumberOfArguments(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+
+	assert.Equal(t, "-ERR wrong number of arguments for 'get' command\r\n", execute(executor, "GET"))
+	assert.Equal(t, "-ERR wrong number of arguments for 'set' command\r\n", execute(executor, "SET DiskType"))
+}
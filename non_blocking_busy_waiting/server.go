@@ -2,25 +2,83 @@ package non_blocking_busy_waiting
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"non_blocking_busy_waiting/conn"
+	"non_blocking_busy_waiting/gateway"
+	"non_blocking_busy_waiting/memcached"
 	"non_blocking_busy_waiting/proto"
+	"non_blocking_busy_waiting/resp"
 	store2 "non_blocking_busy_waiting/store"
+	"sync"
 	"syscall"
+	"time"
 )
 
-const MaxClients = 10_000
+const (
+	MaxClients               = 10_000
+	ExpiryInterval           = 100 * time.Millisecond
+	MaxKeysExaminedPerExpiry = 1000
+	ACLReloadInterval        = time.Second
+)
+
+// ErrAuthenticationNotSupported denotes that authentication is required for a server which serves the protocols
+// other than ProtocolProtobuf, whose connections can not authenticate.
+var ErrAuthenticationNotSupported = errors.New("authentication is only supported by the protobuf protocol")
+
+// ErrAuthenticationRequired denotes that authorization is required for a server which does not require authentication,
+// whose connections have no identity to authorize.
+var ErrAuthenticationRequired = errors.New("authorization requires authentication")
 
 // TCPServer represents a non-blocking busy-waiting TCP TCPServer
 type TCPServer struct {
 	serverFd    int
+	store       store2.Store
+	storeLock   *sync.Mutex
 	handlers    map[uint32]conn.Handler
+	protocol    Protocol
+	httpServer  *http.Server
+	keepalive   conn.Keepalive
 	stopChannel chan struct{}
+	openedStore *store2.DurableStore
 }
 
-// NewTCPServer creates a new instance of TCPServer.
+// NewTCPServer creates a new instance of TCPServer, which speaks ProtocolProtobuf.
 func NewTCPServer(host string, port uint16) (*TCPServer, error) {
+	return NewTCPServerWithProtocol(host, port, ProtocolProtobuf)
+}
+
+// NewTCPServerWithProtocol creates a new instance of TCPServer, which speaks the given protocol.
+// A server with ProtocolAuto detects the protocol of every connection (see DetectProtocol), and serves all the
+// protocols on a single port. The HTTP connections are served by the gateway.Codec.
+func NewTCPServerWithProtocol(host string, port uint16, protocol Protocol) (*TCPServer, error) {
+	return NewTCPServerWithStore(host, port, protocol, store2.NewInMemoryStore())
+}
+
+// NewDurableTCPServer creates a new instance of TCPServer, which speaks the given protocol and holds the Key/Value
+// pairs in a store.DurableStore: the write-ahead log at the given path is replayed into the store, and is synced as
+// per the policy, so that the pairs survive a restart of the server. The log is closed when the server is stopped.
+// It returns store.ErrInvalidSyncPolicy for an invalid policy, and store.ErrCorruptRecord for a log which can not be
+// replayed.
+func NewDurableTCPServer(host string, port uint16, protocol Protocol, path string, policy store2.SyncPolicy) (*TCPServer, error) {
+	durableStore, err := store2.OpenDurableStore(path, policy, store2.NewInMemoryStore())
+	if err != nil {
+		return nil, err
+	}
+	server, err := NewTCPServerWithStore(host, port, protocol, durableStore)
+	if err != nil {
+		_ = durableStore.Close()
+		return nil, err
+	}
+	server.openedStore = durableStore
+	return server, nil
+}
+
+// NewTCPServerWithStore creates a new instance of TCPServer, which speaks the given protocol and holds the Key/Value
+// pairs in the given store. The store may be any implementation of store.Store (see storetest.TestStore).
+func NewTCPServerWithStore(host string, port uint16, protocol Protocol, store store2.Store) (*TCPServer, error) {
 	//starts the listener on the given port and returns the server file descriptor, if there is no error.
 	startListener := func() (int, error) {
 		// syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0) creates an IPv4 (AF_INET), bidirectional (SOCK_STREAM), TCP (0) socket.
@@ -54,13 +112,14 @@ func NewTCPServer(host string, port uint16) (*TCPServer, error) {
 		return nil, err
 	}
 
-	store := store2.NewInMemoryStore()
+	storeLock := &sync.Mutex{}
 	return &TCPServer{
-		serverFd: serverFd,
-		handlers: map[uint32]conn.Handler{
-			proto.KeyValueMessageKindPutOrUpdate: conn.NewPutOrUpdateHandler(store),
-			proto.KeyValueMessageKindGet:         conn.NewGetHandler(store),
-		},
+		serverFd:    serverFd,
+		store:       store,
+		storeLock:   storeLock,
+		handlers:    conn.NewSynchronizedHandlersWithLock(conn.NewHandlers(store), storeLock),
+		protocol:    protocol,
+		keepalive:   conn.DefaultKeepalive,
 		stopChannel: make(chan struct{}),
 	}, nil
 }
@@ -72,10 +131,13 @@ func NewTCPServer(host string, port uint16) (*TCPServer, error) {
 // - serverFd is already marked non-blocking, this means any IO operations on this file descriptor will not block. However, the file descriptor can be polled.
 // - an incoming connection is represented by its file descriptor "connectionFd".
 // - connectionFd is also marked non-blocking.
-// - a new client is created (for the incoming connectionFd) which handles the connection.
+// - a new client is created (for the incoming connectionFd) which handles the connection, in the protocol of the server.
 // - all the IO operations are non-blocking.
 // This server handles only one client at a time.
+// Expired keys are actively deleted from the store in a separate goroutine, every ExpiryInterval (see
+// evictExpiredKeys).
 func (server *TCPServer) Start() {
+	go server.evictExpiredKeys()
 	for {
 		select {
 		case <-server.stopChannel:
@@ -89,14 +151,144 @@ func (server *TCPServer) Start() {
 				return
 			}
 			_ = syscall.SetNonblock(connectionFd, true)
-			conn.NewClient(connectionFd, server.handlers).Run()
+
+			client := conn.NewClient(connectionFd, server.newCodec())
+			client.Run()
+			client.Stop()
 		}
 	}
 }
 
-// Stop stops the server.
+// StartHTTPGateway starts the HTTP/JSON gateway (see gateway.Gateway) on the given host and port, alongside the
+// server. The gateway shares the store of the server, and is stopped along with the server.
+// The gateway serves the requests in goroutines of its own, which is why the handlers of the server are synchronized.
+// It returns once the gateway is listening, and is expected to be invoked before the server is stopped.
+// It returns ErrAuthenticationNotSupported if the server requires authentication.
+func (server *TCPServer) StartHTTPGateway(host string, port uint16) error {
+	if requiresAuthentication(server.handlers) {
+		return ErrAuthenticationNotSupported
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%v", host, port))
+	if err != nil {
+		return err
+	}
+	server.httpServer = &http.Server{Handler: gateway.NewGateway(server.handlers)}
+	go func() {
+		_ = server.httpServer.Serve(listener)
+	}()
+	return nil
+}
+
+// RequireAuthentication makes every connection authenticate with the given authenticator (see conn.NewAuthHandler)
+// before any other request, and records the authenticated identity of the connection.
+// Only the protobuf connections can authenticate, so a server with ProtocolAuto closes the connections of the other
+// protocols. It returns ErrAuthenticationNotSupported for a server of any other protocol, or with the HTTP gateway.
+// The conn.AuthHandler is not synchronized, because the conn.Authenticator is safe for concurrent use.
+// It is expected to be invoked before the server is started.
+func (server *TCPServer) RequireAuthentication(authenticator *conn.Authenticator) error {
+	if (server.protocol != ProtocolProtobuf && server.protocol != ProtocolAuto) || server.httpServer != nil {
+		return ErrAuthenticationNotSupported
+	}
+	server.handlers[proto.KeyValueMessageKindAuth] = conn.NewAuthHandler(authenticator)
+	return nil
+}
+
+// RequireAuthorization makes every get, put, delete and scan of the keys be authorized by the ACL for the identity of
+// the connection (see conn.NewAuthorizedHandlers), and answers the denied requests with proto.Status_PermissionDenied.
+// The rules of the ACL are reloaded if its file is modified, every ACLReloadInterval, in a separate goroutine; this is
+// safe because the conn.ACL is safe for concurrent use.
+// It returns ErrAuthenticationRequired if the server does not require authentication (see RequireAuthentication).
+// It is expected to be invoked before the server is started.
+func (server *TCPServer) RequireAuthorization(acl *conn.ACL) error {
+	if !requiresAuthentication(server.handlers) {
+		return ErrAuthenticationRequired
+	}
+	conn.NewAuthorizedHandlers(server.handlers, acl)
+	go server.reloadACL(acl)
+	return nil
+}
+
+// SetKeepalive sets the conn.Keepalive of the protobuf connections, which are pinged when they are idle and closed
+// if they do not answer in time. It is expected to be invoked before the server is started.
+func (server *TCPServer) SetKeepalive(keepalive conn.Keepalive) {
+	server.keepalive = keepalive
+}
+
+// Stop stops the server, and the HTTP gateway if it is started. The write-ahead log of a server which is created by
+// NewDurableTCPServer is closed after syncing the appended records.
 func (server *TCPServer) Stop() {
 	log.Println("Stopping TCPServer")
 	_ = syscall.Close(server.serverFd)
 	close(server.stopChannel)
+	if server.httpServer != nil {
+		_ = server.httpServer.Close()
+	}
+	if server.openedStore != nil {
+		_ = server.openedStore.Close()
+	}
+}
+
+// evictExpiredKeys runs in its own goroutine and deletes the expired keys from the store, every ExpiryInterval.
+// The busy-waiting loop is busy with a client for as long as the client is connected, so the keys are not evicted
+// from the loop; the store has no locks of its own, so they are evicted holding the lock of the synchronized handlers.
+// It examines at most MaxKeysExaminedPerExpiry keys in one go, so that the client is not blocked on the store for long.
+func (server *TCPServer) evictExpiredKeys() {
+	ticker := time.NewTicker(ExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.stopChannel:
+			return
+		case <-ticker.C:
+			server.storeLock.Lock()
+			server.store.EvictExpired(MaxKeysExaminedPerExpiry)
+			server.storeLock.Unlock()
+		}
+	}
+}
+
+// reloadACL runs in its own goroutine and reloads the rules of the ACL if its file is modified, every
+// ACLReloadInterval. The ACL keeps its rules if the file can not be loaded, and the failure is logged once for every
+// modification of the file (see conn.ACL.ReloadIfModified).
+func (server *TCPServer) reloadACL(acl *conn.ACL) {
+	ticker := time.NewTicker(ACLReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.stopChannel:
+			return
+		case <-ticker.C:
+			if _, err := acl.ReloadIfModified(); err != nil {
+				log.Println("Failed to reload the ACL:", err)
+			}
+		}
+	}
+}
+
+// newCodec creates the conn.Codec of a new client, for the protocol of the server.
+func (server *TCPServer) newCodec() conn.Codec {
+	if server.protocol == ProtocolAuto {
+		return newDetectingCodec(server.codecFor)
+	}
+	return server.codecFor(server.protocol)
+}
+
+// codecFor creates the conn.Codec for the protocol.
+// The connections of the protocols other than ProtocolProtobuf are refused if the server requires authentication.
+func (server *TCPServer) codecFor(protocol Protocol) conn.Codec {
+	if protocol != ProtocolProtobuf && requiresAuthentication(server.handlers) {
+		return refusingCodec{}
+	}
+	switch protocol {
+	case ProtocolHTTP:
+		return gateway.NewCodec(server.handlers)
+	case ProtocolRESP:
+		return resp.NewCodec(server.handlers)
+	case ProtocolMemcached:
+		return memcached.NewCodec(server.handlers)
+	default:
+		return conn.NewProtobufCodecWithKeepalive(server.handlers, server.keepalive)
+	}
 }
@@ -0,0 +1,30 @@
+package store_test
+
+import (
+	"fmt"
+	"non_blocking_busy_waiting/store"
+	"non_blocking_busy_waiting/store/storetest"
+	"path/filepath"
+	"testing"
+)
+
+func TestInMemoryStoreConformsToStore(t *testing.T) {
+	storetest.TestStore(t, func() store.Store {
+		return store.NewInMemoryStore()
+	})
+}
+
+func TestDurableStoreConformsToStore(t *testing.T) {
+	directory, count := t.TempDir(), 0
+	storetest.TestStore(t, func() store.Store {
+		count++
+		durableStore, err := store.OpenDurableStore(filepath.Join(directory, fmt.Sprintf("%v.wal", count)), store.SyncPolicy{Mode: store.SyncNever}, store.NewInMemoryStore())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = durableStore.Close()
+		})
+		return durableStore
+	})
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPutsAndGetsAKeyInSkipList(t *testing.T) {
+	list := newSkipList()
+	list.put("DiskType", versionedValue{value: []byte("SSD"), version: 1})
+
+	value, ok := list.get("DiskType")
+
+	assert.True(t, ok)
+	assert.Equal(t, []byte("SSD"), value.value)
+}
+
+func TestUpdatesAKeyInSkipList(t *testing.T) {
+	list := newSkipList()
+	list.put("DiskType", versionedValue{value: []byte("SSD"), version: 1})
+	list.put("DiskType", versionedValue{value: []byte("HDD"), version: 2})
+
+	value, ok := list.get("DiskType")
+
+	assert.True(t, ok)
+	assert.Equal(t, []byte("HDD"), value.value)
+	assert.Equal(t, 1, list.length)
+}
+
+func TestDeletesAKeyInSkipList(t *testing.T) {
+	list := newSkipList()
+	list.put("DiskType", versionedValue{value: []byte("SSD"), version: 1})
+
+	assert.True(t, list.delete("DiskType"))
+	assert.False(t, list.delete("DiskType"))
+
+	_, ok := list.get("DiskType")
+	assert.False(t, ok)
+	assert.Equal(t, 0, list.length)
+}
+
+func TestScansKeysInOrderInSkipList(t *testing.T) {
+	list := newSkipList()
+	for count := 99; count >= 0; count-- {
+		list.put(fmt.Sprintf("Key-%02d", count), versionedValue{value: []byte(fmt.Sprintf("Value-%02d", count))})
+	}
+	for count := 0; count < 100; count += 2 {
+		list.delete(fmt.Sprintf("Key-%02d", count))
+	}
+
+	var keys []string
+	list.scan("Key-10", "Key-20", func(key string, value versionedValue) bool {
+		keys = append(keys, key)
+		return true
+	})
+
+	assert.Equal(t, []string{"Key-11", "Key-13", "Key-15", "Key-17", "Key-19"}, keys)
+}
+
+func TestPrefixScansKeysInSkipList(t *testing.T) {
+	list := newSkipList()
+	list.put("disk:nvme", versionedValue{value: []byte("NVMe")})
+	list.put("storage:lsm", versionedValue{value: []byte("LSM")})
+	list.put("disk:ssd", versionedValue{value: []byte("SSD")})
+	list.put("disk", versionedValue{value: []byte("Disk")})
+
+	var keys []string
+	list.prefixScan("disk:", func(key string, value versionedValue) bool {
+		keys = append(keys, key)
+		return true
+	})
+
+	assert.Equal(t, []string{"disk:nvme", "disk:ssd"}, keys)
+}
@@ -0,0 +1,482 @@
+package conn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"single_thread_blocking_io/proto"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// Permission represents an operation on the keys which is allowed by an ACLRule.
+type Permission string
+
+const (
+	PermissionGet    Permission = "get"
+	PermissionPut    Permission = "put"
+	PermissionDelete Permission = "delete"
+	PermissionScan   Permission = "scan"
+)
+
+// ACLConfig represents the rules file of an ACL, which is JSON encoded:
+//
+//	{"rules": [{"user": "alice", "permissions": ["get", "scan"], "keys": ["orders.*"]}]}
+//
+// Everything which is not allowed by a rule is denied.
+type ACLConfig struct {
+	Rules []ACLRule `json:"rules"`
+}
+
+// ACLRule allows the user the permissions on the keys which match any of the patterns.
+// A pattern has the syntax of path.Match, but a key is not a path, so "*" and "?" also match "/": "users/*" matches
+// "users/a/b".
+// A range (Scan or PrefixScan) is allowed only by a prefix pattern, such as "orders.*" or "*", which matches every key
+// of the range.
+type ACLRule struct {
+	User        string       `json:"user"`
+	Permissions []Permission `json:"permissions"`
+	Keys        []string     `json:"keys"`
+}
+
+// ACL decides which operations on which keys are allowed for the authenticated identities.
+// The rules are loaded from a file, and may be reloaded (see Reload and ReloadIfModified) while the ACL is in use,
+// without restarting the server. ACL is safe for concurrent use, and is shared by all the connections of a server.
+type ACL struct {
+	path           string
+	rules          atomic.Pointer[map[string][]aclRule]
+	reloadLock     sync.Mutex
+	lastModifiedAt time.Time
+	failed         bool
+	failedAt       time.Time
+}
+
+// aclRule is the validated ACLRule of a user. Every pattern is compiled into a matcher.
+type aclRule struct {
+	permissions []Permission
+	patterns    []string
+	matchers    []*regexp.Regexp
+}
+
+// NewACL creates a new instance of ACL from the given config. An ACL which is not loaded from a file is never
+// reloaded. It returns an error if a permission is unknown, or if a pattern is malformed.
+func NewACL(config ACLConfig) (*ACL, error) {
+	rules, err := compileRules(config)
+	if err != nil {
+		return nil, err
+	}
+	acl := &ACL{}
+	acl.rules.Store(&rules)
+	return acl, nil
+}
+
+// LoadACL creates a new instance of ACL from the JSON encoded ACLConfig in the file.
+func LoadACL(path string) (*ACL, error) {
+	acl := &ACL{path: path}
+	if err := acl.Reload(); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+// Reload reloads the rules from the file of the ACL. The new rules apply to the requests which are handled after
+// the reload, including the ones of the connections which are already open. The rules are left unchanged if the file
+// can not be loaded.
+func (acl *ACL) Reload() error {
+	if acl.path == "" {
+		return nil
+	}
+	acl.reloadLock.Lock()
+	defer acl.reloadLock.Unlock()
+
+	info, err := os.Stat(acl.path)
+	if err != nil {
+		return err
+	}
+	return acl.reload(info.ModTime())
+}
+
+// ReloadIfModified reloads the rules (see Reload) if the file of the ACL has been modified since it was last loaded,
+// and returns true if the rules were reloaded. A server invokes it periodically, so that an edit of the file takes
+// effect without a restart.
+// A failure is returned once: the same broken file (or the same missing file) is not reported again till it is
+// modified, so that a server which logs the errors does not log one on every invocation.
+func (acl *ACL) ReloadIfModified() (bool, error) {
+	if acl.path == "" {
+		return false, nil
+	}
+	acl.reloadLock.Lock()
+	defer acl.reloadLock.Unlock()
+
+	info, err := os.Stat(acl.path)
+	if err != nil {
+		return false, acl.failOnce(time.Time{}, err)
+	}
+	if info.ModTime().Equal(acl.lastModifiedAt) {
+		acl.failed = false
+		return false, nil
+	}
+	if err := acl.reload(info.ModTime()); err != nil {
+		return false, acl.failOnce(info.ModTime(), err)
+	}
+	acl.failed = false
+	return true, nil
+}
+
+// Allows returns true if the user has the permission on the key.
+func (acl *ACL) Allows(username string, permission Permission, key []byte) bool {
+	for _, rule := range (*acl.rules.Load())[username] {
+		if !rule.has(permission) {
+			continue
+		}
+		for _, matcher := range rule.matchers {
+			if matcher.Match(key) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AllowsScan returns true if the user has PermissionScan on every key in the range [start, end), where an empty end
+// denotes no upper bound.
+func (acl *ACL) AllowsScan(username string, start, end []byte) bool {
+	for _, rule := range (*acl.rules.Load())[username] {
+		if !rule.has(PermissionScan) {
+			continue
+		}
+		for _, pattern := range rule.patterns {
+			if covers(pattern, start, end) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// failOnce returns the error of a reload of the file which was modified at the given time (zero if the file could
+// not be read), unless the previous reload failed for the same modification. The caller holds the reloadLock.
+func (acl *ACL) failOnce(modifiedAt time.Time, err error) error {
+	if acl.failed && acl.failedAt.Equal(modifiedAt) {
+		return nil
+	}
+	acl.failed, acl.failedAt = true, modifiedAt
+	return err
+}
+
+// reload loads the rules from the file, which was modified at the given time. The caller holds the reloadLock.
+func (acl *ACL) reload(modifiedAt time.Time) error {
+	buffer, err := os.ReadFile(acl.path)
+	if err != nil {
+		return err
+	}
+	var config ACLConfig
+	if err := json.Unmarshal(buffer, &config); err != nil {
+		return err
+	}
+	rules, err := compileRules(config)
+	if err != nil {
+		return err
+	}
+	acl.rules.Store(&rules)
+	acl.lastModifiedAt = modifiedAt
+	return nil
+}
+
+// has returns true if the rule has the permission.
+func (rule aclRule) has(permission Permission) bool {
+	for _, rulePermission := range rule.permissions {
+		if rulePermission == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// compileRules validates the rules of the config, and groups them by the user.
+func compileRules(config ACLConfig) (map[string][]aclRule, error) {
+	rules := make(map[string][]aclRule, len(config.Rules))
+	for _, rule := range config.Rules {
+		for _, permission := range rule.Permissions {
+			switch permission {
+			case PermissionGet, PermissionPut, PermissionDelete, PermissionScan:
+			default:
+				return nil, fmt.Errorf("unknown permission %q for the user %q", permission, rule.User)
+			}
+		}
+		matchers := make([]*regexp.Regexp, 0, len(rule.Keys))
+		for _, pattern := range rule.Keys {
+			matcher, err := compilePattern(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("key pattern %q for the user %q: %w", pattern, rule.User, err)
+			}
+			matchers = append(matchers, matcher)
+		}
+		rules[rule.User] = append(rules[rule.User], aclRule{permissions: rule.Permissions, patterns: rule.Keys, matchers: matchers})
+	}
+	return rules, nil
+}
+
+// compilePattern validates the pattern (see path.Match), and compiles it into a regular expression which matches
+// the whole key, where "*" matches any sequence of bytes and "?" any single character, including "/".
+// This way, a prefix pattern matches exactly the keys which it covers (see covers).
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	// literalAt returns the character at the index of the pattern (without its escape, if any), and the length of
+	// the escaped character.
+	literalAt := func(index int) (rune, int) {
+		escaped := pattern[index] == '\\'
+		if escaped {
+			index++
+		}
+		character, size := utf8.DecodeRuneInString(pattern[index:])
+		if escaped {
+			return character, size + 1
+		}
+		return character, size
+	}
+	var expression strings.Builder
+	expression.WriteString(`(?s)^`)
+	for index := 0; index < len(pattern); {
+		switch pattern[index] {
+		case '*':
+			expression.WriteString(`.*`)
+			index++
+		case '?':
+			expression.WriteString(`.`)
+			index++
+		case '[':
+			expression.WriteString(`[`)
+			if index++; pattern[index] == '^' {
+				expression.WriteString(`^`)
+				index++
+			}
+			for pattern[index] != ']' {
+				low, size := literalAt(index)
+				index += size
+				fmt.Fprintf(&expression, `\x{%x}`, low)
+				if pattern[index] == '-' {
+					high, size := literalAt(index + 1)
+					index += 1 + size
+					fmt.Fprintf(&expression, `-\x{%x}`, high)
+				}
+			}
+			expression.WriteString(`]`)
+			index++
+		default:
+			character, size := literalAt(index)
+			expression.WriteString(regexp.QuoteMeta(string(character)))
+			index += size
+		}
+	}
+	expression.WriteString(`$`)
+	return regexp.Compile(expression.String())
+}
+
+// covers returns true if the pattern is a prefix pattern (a literal prefix followed by a single "*"), and every key in
+// the range [start, end) starts with the prefix.
+func covers(pattern string, start, end []byte) bool {
+	prefix, ok := strings.CutSuffix(pattern, "*")
+	if !ok || strings.ContainsAny(prefix, `*?[\`) || !bytes.HasPrefix(start, []byte(prefix)) {
+		return false
+	}
+	upperBound := prefixUpperBound([]byte(prefix))
+	if upperBound == nil {
+		return true
+	}
+	return len(end) > 0 && bytes.Compare(end, upperBound) <= 0
+}
+
+// prefixUpperBound returns the smallest key which is greater than all the keys starting with the prefix, or nil if
+// there is no such key (the prefix is empty, or all of its bytes are 0xff).
+func prefixUpperBound(prefix []byte) []byte {
+	for index := len(prefix) - 1; index >= 0; index-- {
+		if prefix[index] < 0xff {
+			upperBound := append([]byte{}, prefix[:index+1]...)
+			upperBound[index]++
+			return upperBound
+		}
+	}
+	return nil
+}
+
+// AuthorizingHandler is a Handler which handles the messages only on behalf of the identities which are allowed to
+// make them (see NewAuthorizedHandlers).
+type AuthorizingHandler interface {
+	Handler
+	// Allows returns true if the identity is allowed to make the request.
+	Allows(identity *Identity, message *proto.KeyValueMessage) bool
+	// HandleAs handles the incoming message on behalf of the identity, or answers it with
+	// proto.Status_PermissionDenied if the identity is not allowed to make the request.
+	HandleAs(identity *Identity, message *proto.KeyValueMessage) ([]byte, error)
+}
+
+// AuthorizedHandler is an AuthorizingHandler which checks the request against the ACL, before it is handled by
+// the wrapped handler.
+type AuthorizedHandler struct {
+	handler Handler
+	acl     *ACL
+}
+
+// NewAuthorizedHandlers wraps the handlers of the requests which get, put, delete, scan or watch the keys in an
+// AuthorizedHandler, which checks them against the ACL. The other handlers are left as they are.
+// The wrapped handlers replace the given ones in the map, so that everyone who shares the map is authorized.
+// A put stream is authorized once it begins, so the chunks and the end of a stream are not checked again.
+func NewAuthorizedHandlers(handlers map[uint32]Handler, acl *ACL) map[uint32]Handler {
+	for _, kind := range []uint32{
+		proto.KeyValueMessageKindPutOrUpdate,
+		proto.KeyValueMessageKindGet,
+		proto.KeyValueMessageKindDelete,
+		proto.KeyValueMessageKindCompareAndSwap,
+		proto.KeyValueMessageKindMultiGet,
+		proto.KeyValueMessageKindMultiPutOrUpdate,
+		proto.KeyValueMessageKindScan,
+		proto.KeyValueMessageKindPrefixScan,
+		proto.KeyValueMessageKindTimeToLive,
+		proto.KeyValueMessageKindIncrementBy,
+		proto.KeyValueMessageKindStreamBegin,
+		proto.KeyValueMessageKindGetStream,
+		proto.KeyValueMessageKindWatch,
+		proto.KeyValueMessageKindPrefixWatch,
+		proto.KeyValueMessageKindOptimisticWatch,
+	} {
+		handler, ok := handlers[kind]
+		if !ok {
+			continue
+		}
+		authorizedHandler := AuthorizedHandler{handler: handler, acl: acl}
+		if streamingHandler, ok := handler.(StreamingHandler); ok {
+			handlers[kind] = AuthorizedStreamingHandler{
+				AuthorizedHandler: authorizedHandler,
+				streamingHandler:  streamingHandler,
+			}
+			continue
+		}
+		if subscribingHandler, ok := handler.(SubscribingHandler); ok {
+			handlers[kind] = AuthorizedSubscribingHandler{
+				AuthorizedHandler:  authorizedHandler,
+				subscribingHandler: subscribingHandler,
+			}
+			continue
+		}
+		if transactionalHandler, ok := handler.(TransactionalHandler); ok {
+			handlers[kind] = AuthorizedTransactionalHandler{
+				AuthorizedHandler:    authorizedHandler,
+				transactionalHandler: transactionalHandler,
+			}
+			continue
+		}
+		handlers[kind] = authorizedHandler
+	}
+	return handlers
+}
+
+// Handle answers the message with proto.Status_PermissionDenied, because a message without an identity is never
+// allowed.
+func (handler AuthorizedHandler) Handle(message *proto.KeyValueMessage) ([]byte, error) {
+	return handler.HandleAs(nil, message)
+}
+
+// HandleAs handles the incoming message on behalf of the identity, if the ACL allows it.
+func (handler AuthorizedHandler) HandleAs(identity *Identity, message *proto.KeyValueMessage) ([]byte, error) {
+	if !handler.Allows(identity, message) {
+		return proto.NewPermissionDeniedResponseMessage().AnsweringTo(message).Serialize()
+	}
+	return handler.handler.Handle(message)
+}
+
+// Allows returns true if the ACL allows the identity every key of the message:
+// - Get, MultiGet, TimeToLive and GetStream require PermissionGet,
+// - Watch and OptimisticWatch require PermissionGet, because they reveal the changes of the keys,
+// - PutOrUpdate, MultiPutOrUpdate, CompareAndSwap, IncrementBy and StreamBegin require PermissionPut,
+// - Delete requires PermissionDelete, and
+// - Scan and PrefixScan require PermissionScan on the whole range, and so does PrefixWatch on the range of every
+// watched prefix.
+func (handler AuthorizedHandler) Allows(identity *Identity, message *proto.KeyValueMessage) bool {
+	if identity == nil {
+		return false
+	}
+	username := identity.Username
+	switch message.Kind {
+	case proto.KeyValueMessageKindGet, proto.KeyValueMessageKindTimeToLive, proto.KeyValueMessageKindGetStream:
+		return handler.acl.Allows(username, PermissionGet, message.RawKey())
+	case proto.KeyValueMessageKindPutOrUpdate, proto.KeyValueMessageKindCompareAndSwap, proto.KeyValueMessageKindIncrementBy,
+		proto.KeyValueMessageKindStreamBegin:
+		return handler.acl.Allows(username, PermissionPut, message.RawKey())
+	case proto.KeyValueMessageKindDelete:
+		return handler.acl.Allows(username, PermissionDelete, message.RawKey())
+	case proto.KeyValueMessageKindMultiGet, proto.KeyValueMessageKindMultiPutOrUpdate, proto.KeyValueMessageKindWatch,
+		proto.KeyValueMessageKindOptimisticWatch:
+		permission := PermissionGet
+		if message.Kind == proto.KeyValueMessageKindMultiPutOrUpdate {
+			permission = PermissionPut
+		}
+		for _, pair := range message.Pairs {
+			if !handler.acl.Allows(username, permission, pair.RawKey()) {
+				return false
+			}
+		}
+		return true
+	case proto.KeyValueMessageKindScan:
+		return handler.acl.AllowsScan(username, message.RawKey(), message.RawEndKey())
+	case proto.KeyValueMessageKindPrefixScan:
+		return handler.acl.AllowsScan(username, message.RawKey(), prefixUpperBound(message.RawKey()))
+	case proto.KeyValueMessageKindPrefixWatch:
+		for _, pair := range message.Pairs {
+			if !handler.acl.AllowsScan(username, pair.RawKey(), prefixUpperBound(pair.RawKey())) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// AuthorizedStreamingHandler is an AuthorizedHandler for a StreamingHandler. The session authorizes the message
+// (see AuthorizedHandler.Allows) before it is handled in the streams of the connection.
+type AuthorizedStreamingHandler struct {
+	AuthorizedHandler
+	streamingHandler StreamingHandler
+}
+
+// HandleStream handles the incoming message in the streams of the connection, with the wrapped handler.
+func (handler AuthorizedStreamingHandler) HandleStream(streams *Streams, message *proto.KeyValueMessage) ([]byte, error) {
+	return handler.streamingHandler.HandleStream(streams, message)
+}
+
+// AuthorizedSubscribingHandler is an AuthorizedHandler for a SubscribingHandler. The session authorizes the message
+// (see AuthorizedHandler.Allows) before it is handled on behalf of the subscriber.
+type AuthorizedSubscribingHandler struct {
+	AuthorizedHandler
+	subscribingHandler SubscribingHandler
+}
+
+// HandleFor handles the incoming message on behalf of the subscriber, with the wrapped handler.
+func (handler AuthorizedSubscribingHandler) HandleFor(subscriber Subscriber, message *proto.KeyValueMessage) ([]byte, error) {
+	return handler.subscribingHandler.HandleFor(subscriber, message)
+}
+
+// Unsubscribe unsubscribes the subscriber from the wrapped handler.
+func (handler AuthorizedSubscribingHandler) Unsubscribe(subscriber Subscriber) {
+	handler.subscribingHandler.Unsubscribe(subscriber)
+}
+
+// AuthorizedTransactionalHandler is an AuthorizedHandler for a TransactionalHandler. The session authorizes
+// the message (see AuthorizedHandler.Allows) before it is handled in the transaction of the connection.
+type AuthorizedTransactionalHandler struct {
+	AuthorizedHandler
+	transactionalHandler TransactionalHandler
+}
+
+// HandleIn handles the incoming message in the transaction of the connection, with the wrapped handler.
+func (handler AuthorizedTransactionalHandler) HandleIn(transaction *Transaction, message *proto.KeyValueMessage) ([]byte, error) {
+	return handler.transactionalHandler.HandleIn(transaction, message)
+}
@@ -0,0 +1,81 @@
+package conn
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultKeepalive is the Keepalive of the connections, unless the server is configured with another one.
+var DefaultKeepalive = Keepalive{IdleTimeout: 30 * time.Second, PongTimeout: 10 * time.Second}
+
+var (
+	// ErrNotAlive denotes that a connection has not answered the ping of the server within the PongTimeout.
+	ErrNotAlive = errors.New("connection did not answer the ping in time")
+	// ErrIdle denotes that a connection has sent no request within the MaxIdleTime.
+	ErrIdle = errors.New("connection has been idle for too long")
+)
+
+// Keepalive configures the liveness tracking of the protobuf connections: a connection which has sent nothing for
+// the IdleTimeout is pinged by the server (with a proto.KeyValueMessageKindPing), and is closed if it sends nothing
+// (a proto.KeyValueMessageKindPong, or any other request) within the PongTimeout.
+// A zero IdleTimeout disables the pings, and the idle connections are never closed.
+// A non-zero MaxIdleTime closes a connection which has sent no request (a frame other than a Ping or a Pong) for
+// the MaxIdleTime, even if it answers the pings; a server which serves a single connection at a time sets it, so that
+// an idle connection does not keep the other connections waiting forever.
+type Keepalive struct {
+	IdleTimeout time.Duration
+	PongTimeout time.Duration
+	MaxIdleTime time.Duration
+}
+
+// Liveness tracks whether a single connection is alive, as configured by its Keepalive.
+// A Liveness belongs to a single connection, and is not safe for concurrent use.
+type Liveness struct {
+	keepalive     Keepalive
+	lastHeardAt   time.Time
+	lastRequestAt time.Time
+	pingedAt      time.Time
+}
+
+// NewLiveness creates a new instance of Liveness for a connection which is accepted at the given time.
+func NewLiveness(keepalive Keepalive, now time.Time) *Liveness {
+	return &Liveness{keepalive: keepalive, lastHeardAt: now, lastRequestAt: now}
+}
+
+// Heard records that a frame was received from the connection at the given time, which answers the pending ping
+// (if any).
+func (liveness *Liveness) Heard(now time.Time) {
+	liveness.lastHeardAt = now
+	liveness.pingedAt = time.Time{}
+}
+
+// HeardRequest records that a request (a frame other than a Ping or a Pong) was received from the connection at
+// the given time (see Heard), which keeps the connection from being idle for the MaxIdleTime.
+func (liveness *Liveness) HeardRequest(now time.Time) {
+	liveness.Heard(now)
+	liveness.lastRequestAt = now
+}
+
+// Check returns true if the connection is to be pinged at the given time, because it has been idle for
+// the IdleTimeout and is not already pinged; the ping is considered sent once Check returns true.
+// It returns ErrNotAlive if the pending ping has not been answered within the PongTimeout, and ErrIdle if
+// the connection has sent no request within the MaxIdleTime.
+func (liveness *Liveness) Check(now time.Time) (bool, error) {
+	if liveness.keepalive.MaxIdleTime > 0 && now.Sub(liveness.lastRequestAt) >= liveness.keepalive.MaxIdleTime {
+		return false, ErrIdle
+	}
+	if liveness.keepalive.IdleTimeout <= 0 {
+		return false, nil
+	}
+	if !liveness.pingedAt.IsZero() {
+		if now.Sub(liveness.pingedAt) >= liveness.keepalive.PongTimeout {
+			return false, ErrNotAlive
+		}
+		return false, nil
+	}
+	if now.Sub(liveness.lastHeardAt) < liveness.keepalive.IdleTimeout {
+		return false, nil
+	}
+	liveness.pingedAt = now
+	return true, nil
+}
@@ -0,0 +1,239 @@
+package conn
+
+import (
+	"single_thread_blocking_io/proto"
+	"slices"
+	"sync/atomic"
+)
+
+// CompressionThreshold is the size of a response payload (in bytes) above which the response is compressed,
+// for a session which has agreed to proto.FeatureCompression. Smaller payloads are not worth the CPU.
+const CompressionThreshold = 1024
+
+// Session represents the state of a connection which is agreed by the (optional) Hello handshake.
+// A legacy client never sends a Hello, so its session has no features and the responses are framed as before.
+// A Session belongs to a single connection, and is not safe for concurrent use; the exception is Notify, which is
+// invoked by the other connections. The features are atomic, because Notify frames the notifications as agreed while
+// the connection may handle a Hello.
+// A Session is the Subscriber of its connection: the notifications are framed as agreed for the session, and pushed
+// to the connection with the function which is set by PushTo.
+// A Session also holds the Transaction of its connection, its open Streams, and its authenticated Identity.
+type Session struct {
+	features               atomic.Uint32
+	push                   func(frame []byte) error
+	disconnect             func()
+	subscriptions          []SubscribingHandler
+	transaction            Transaction
+	streams                Streams
+	requiresAuthentication bool
+	identity               *Identity
+}
+
+// NewSession creates a new instance of Session without any features, which does not require authentication.
+func NewSession() *Session {
+	return &Session{}
+}
+
+// NewSessionFor creates a new instance of Session for a connection which is served by the given handlers.
+// The session requires authentication if the handler for proto.KeyValueMessageKindAuth does (see NewAuthHandler):
+// till the connection is authenticated, every request other than an Auth is answered with
+// proto.Status_Unauthenticated.
+func NewSessionFor(handlers map[uint32]Handler) *Session {
+	session := NewSession()
+	if authenticatingHandler, ok := handlers[proto.KeyValueMessageKindAuth].(AuthenticatingHandler); ok {
+		session.requiresAuthentication = authenticatingHandler.RequiresAuthentication()
+	}
+	return session
+}
+
+// Handle handles the incoming message using the given handler, and frames the response as agreed for the session.
+// If the message is a Hello, the agreed features apply from the next response onwards, so that the response to
+// the Hello is always readable by the client.
+func (session *Session) Handle(handler Handler, message *proto.KeyValueMessage) ([]byte, error) {
+	buffer, err := session.handle(handler, message)
+	if err != nil {
+		return nil, err
+	}
+	buffer, err = session.frame(buffer)
+	if message.Kind == proto.KeyValueMessageKindHello {
+		_, features, _ := negotiate(message)
+		session.features.Store(features)
+	}
+	return buffer, err
+}
+
+// PushTo makes the connection of the session able to receive notifications, which are pushed with the given function,
+// and disconnected with the disconnect function.
+// Both are invoked from the goroutines of the other connections, so they must be safe for concurrent use with
+// the writes of the responses. A session without PushTo answers the SubscribingHandler requests as unsupported.
+func (session *Session) PushTo(push func(frame []byte) error, disconnect func()) {
+	session.push = push
+	session.disconnect = disconnect
+}
+
+// Notify frames the notification as agreed for the session, and pushes it to the connection.
+func (session *Session) Notify(notification *proto.KeyValueMessage) error {
+	buffer, err := notification.Serialize()
+	if err != nil {
+		return err
+	}
+	if buffer, err = session.frame(buffer); err != nil {
+		return err
+	}
+	return session.push(buffer)
+}
+
+// Disconnect disconnects the connection of the session.
+func (session *Session) Disconnect() {
+	session.disconnect()
+}
+
+// Subscribed returns true if the session has subscriptions, such as watched keys.
+func (session *Session) Subscribed() bool {
+	return len(session.subscriptions) > 0
+}
+
+// Close removes the subscriptions of the session, and aborts its streams. It is invoked once the connection is closed.
+func (session *Session) Close() {
+	for _, handler := range session.subscriptions {
+		handler.Unsubscribe(session)
+	}
+	session.subscriptions = nil
+	session.streams.Close()
+}
+
+// Pull returns the next frame of the get streams of the session (see Streams.Next), framed as agreed for the session,
+// or nil if there is none. A connection pulls the frames once the response to a request is written, and writes each
+// of them before pulling the next one.
+func (session *Session) Pull() ([]byte, error) {
+	message := session.streams.Next()
+	if message == nil {
+		return nil, nil
+	}
+	buffer, err := message.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	return session.frame(buffer)
+}
+
+// FrameErrorResponse returns the response for a request frame which could not be deserialized.
+func (session *Session) FrameErrorResponse() ([]byte, error) {
+	buffer, err := proto.NewFrameErrorResponseMessage().Serialize()
+	if err != nil {
+		return nil, err
+	}
+	return session.frame(buffer)
+}
+
+// UnknownKindResponse returns the response for a request whose kind has no Handler: a
+// proto.KeyValueMessageKindFrameError with proto.Status_NotOk, which carries the request id of the request.
+func (session *Session) UnknownKindResponse(message *proto.KeyValueMessage) ([]byte, error) {
+	buffer, err := proto.NewUnknownKindResponseMessage().AnsweringTo(message).Serialize()
+	if err != nil {
+		return nil, err
+	}
+	return session.frame(buffer)
+}
+
+// PingRequest returns the Ping which the server sends to the idle connection of the session (see Liveness).
+func (session *Session) PingRequest() ([]byte, error) {
+	buffer, err := proto.NewPingMessage().Serialize()
+	if err != nil {
+		return nil, err
+	}
+	return session.frame(buffer)
+}
+
+// Identity returns the authenticated identity of the connection, and false if the connection is not authenticated.
+func (session *Session) Identity() (Identity, bool) {
+	if session.identity == nil {
+		return Identity{}, false
+	}
+	return *session.identity, true
+}
+
+// Has returns true if the given feature is agreed for the session.
+func (session *Session) Has(feature uint32) bool {
+	return session.features.Load()&feature != 0
+}
+
+// handle handles the incoming message using the given handler, on behalf of the session if the handler is
+// a SubscribingHandler and the session can receive notifications.
+// A Ping (or a Pong) is always handled, even before the session is authenticated or while a transaction is open,
+// because it only keeps the connection alive.
+// An AuthenticatingHandler authenticates the session, which is otherwise required to be authenticated first
+// (if it requires authentication).
+// A TransactionalHandler handles the message in the transaction of the session, once it is authorized. While
+// the transaction is open, the other messages are queued (or answered with proto.Status_NotOk if they can not be
+// queued) instead of being handled.
+// An AuthorizingHandler handles the message on behalf of the identity of the session; a request which is queued in
+// the transaction is authorized once it is queued.
+// A StreamingHandler handles the message in the streams of the session, and a SubscribingHandler on behalf of
+// the session, once it is authorized.
+func (session *Session) handle(handler Handler, message *proto.KeyValueMessage) ([]byte, error) {
+	if message.Kind == proto.KeyValueMessageKindPing || message.Kind == proto.KeyValueMessageKindPong {
+		return handler.Handle(message)
+	}
+	if authenticatingHandler, ok := handler.(AuthenticatingHandler); ok {
+		identity, buffer, err := authenticatingHandler.Authenticate(message)
+		if identity != nil {
+			session.identity = identity
+		}
+		return buffer, err
+	}
+	if session.requiresAuthentication && session.identity == nil {
+		return proto.NewUnauthenticatedResponseMessage().AnsweringTo(message).Serialize()
+	}
+	authorizingHandler, authorizing := handler.(AuthorizingHandler)
+	if transactionalHandler, ok := handler.(TransactionalHandler); ok {
+		if authorizing && !authorizingHandler.Allows(session.identity, message) {
+			return proto.NewPermissionDeniedResponseMessage().AnsweringTo(message).Serialize()
+		}
+		return transactionalHandler.HandleIn(&session.transaction, message)
+	}
+	if session.transaction.Open() {
+		if authorizing && !authorizingHandler.Allows(session.identity, message) {
+			return proto.NewPermissionDeniedResponseMessage().AnsweringTo(message).Serialize()
+		}
+		if !session.transaction.Queue(message) {
+			return proto.NewTransactionUnsuccessfulResponseMessage().AnsweringTo(message).Serialize()
+		}
+		return proto.NewTransactionSuccessfulResponseMessage().AnsweringTo(message).Serialize()
+	}
+	if streamingHandler, ok := handler.(StreamingHandler); ok {
+		if authorizing && !authorizingHandler.Allows(session.identity, message) {
+			return proto.NewPermissionDeniedResponseMessage().AnsweringTo(message).Serialize()
+		}
+		return streamingHandler.HandleStream(&session.streams, message)
+	}
+	subscribingHandler, ok := handler.(SubscribingHandler)
+	if !ok || session.push == nil {
+		if authorizing {
+			return authorizingHandler.HandleAs(session.identity, message)
+		}
+		return handler.Handle(message)
+	}
+	if authorizing && !authorizingHandler.Allows(session.identity, message) {
+		return proto.NewPermissionDeniedResponseMessage().AnsweringTo(message).Serialize()
+	}
+	buffer, err := subscribingHandler.HandleFor(session, message)
+	if err == nil && !slices.Contains(session.subscriptions, subscribingHandler) {
+		session.subscriptions = append(session.subscriptions, subscribingHandler)
+	}
+	return buffer, err
+}
+
+// frame re-frames the serialized frames with checksums if proto.FeatureChecksums is agreed for the session,
+// and compresses the payloads above CompressionThreshold if proto.FeatureCompression is agreed for the session.
+func (session *Session) frame(buffer []byte) ([]byte, error) {
+	features := session.features.Load()
+	options := proto.FrameOptions{Checksum: features&proto.FeatureChecksums != 0}
+	if features&proto.FeatureCompression != 0 {
+		options.CompressAbove = CompressionThreshold
+	}
+	if options == (proto.FrameOptions{}) || len(buffer) == 0 {
+		return buffer, nil
+	}
+	return proto.Reframe(buffer, options)
+}
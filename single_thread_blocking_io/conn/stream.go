@@ -0,0 +1,197 @@
+package conn
+
+import (
+	"errors"
+	"single_thread_blocking_io/proto"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// MaxStreamLength is the memory limit of a put stream: the number of bytes of the value which are reassembled
+	// before the value is put into the store. A stream which exceeds it is aborted.
+	MaxStreamLength = 64 << 20
+	// MaxOpenStreams is the number of put streams which a connection may have open at once.
+	MaxOpenStreams = 16
+	// MaxStreamedBytes is the memory limit of the put streams of all the connections of a server (see StreamBudget),
+	// so the connections can not together hold MaxOpenStreams streams of MaxStreamLength bytes each.
+	MaxStreamedBytes = 256 << 20
+	// StreamChunkSize is the (maximum) size of a chunk of a get stream, in bytes.
+	StreamChunkSize = 64 << 10
+	// MinStreamChunkSize is the minimum size of a chunk of a get stream, in bytes. A smaller chunk size which is
+	// requested by a client is raised to it, because every chunk is a frame of its own.
+	MinStreamChunkSize = 4 << 10
+)
+
+var (
+	ErrUnknownStream  = errors.New("stream is not open")
+	ErrStreamOpen     = errors.New("stream is already open")
+	ErrTooManyStreams = errors.New("too many open streams")
+	ErrStreamTooLarge = errors.New("stream is larger than the maximum stream length")
+	ErrStreamBudget   = errors.New("streams of the server are larger than the stream budget")
+)
+
+// StreamBudget is the number of bytes which the put streams of all the connections of a server may hold at once.
+// The bytes of a stream are reserved as its chunks are appended, and released once the stream ends, is aborted, or
+// its connection is closed. A StreamBudget is safe for concurrent use.
+type StreamBudget struct {
+	limit    int64
+	reserved atomic.Int64
+}
+
+// NewStreamBudget creates a new instance of StreamBudget of limit bytes.
+func NewStreamBudget(limit int64) *StreamBudget {
+	return &StreamBudget{limit: limit}
+}
+
+// Reserved returns the number of bytes which are reserved by the open streams.
+func (budget *StreamBudget) Reserved() int64 {
+	return budget.reserved.Load()
+}
+
+// reserve reserves n bytes, and returns false (without reserving them) if they exceed the limit.
+// A nil StreamBudget is unlimited.
+func (budget *StreamBudget) reserve(n int) bool {
+	if budget == nil {
+		return true
+	}
+	if budget.reserved.Add(int64(n)) > budget.limit {
+		budget.reserved.Add(-int64(n))
+		return false
+	}
+	return true
+}
+
+// release releases n reserved bytes.
+func (budget *StreamBudget) release(n int) {
+	if budget != nil {
+		budget.reserved.Add(-int64(n))
+	}
+}
+
+// Streams represents the open put streams of a connection, whose values are reassembled from their chunks till
+// the stream ends. A large value is sent in chunks, so the reader of a connection (such as the buffer of
+// a non-blocking client) holds one chunk at a time, instead of a frame of the whole value.
+// Every stream is limited to MaxStreamLength bytes, and a connection to MaxOpenStreams streams, so the memory which
+// is held for the streams of a connection is bounded; the streams of all the connections are bounded by
+// the StreamBudget of the server.
+// The get streams of a connection are sent lazily: the connection pulls their chunks one at a time (see Next), once
+// the previous one is written, so a large value is never serialized as a whole.
+// Streams belong to the Session of a single connection, and are not safe for concurrent use.
+type Streams struct {
+	open    map[uint64]*stream
+	sending []*sendingStream
+}
+
+// stream is an open put stream, whose bytes are reserved in the budget.
+type stream struct {
+	key    []byte
+	ttl    time.Duration
+	value  []byte
+	budget *StreamBudget
+}
+
+// sendingStream is a get stream, whose chunks are yet to be sent from offset onwards.
+type sendingStream struct {
+	request   *proto.KeyValueMessage
+	value     []byte
+	offset    int
+	chunkSize int
+}
+
+// StreamingHandler is a Handler whose requests read or change the Streams of the connection, such as the chunks of
+// a put stream.
+type StreamingHandler interface {
+	Handler
+	// HandleStream handles the incoming message in the streams of the connection.
+	HandleStream(streams *Streams, message *proto.KeyValueMessage) ([]byte, error)
+}
+
+// Begin opens the put stream of the key, with the given time to live, whose bytes are reserved in the budget
+// (a nil budget is unlimited).
+func (streams *Streams) Begin(streamId uint64, key []byte, ttl time.Duration, budget *StreamBudget) error {
+	if _, ok := streams.open[streamId]; ok {
+		return ErrStreamOpen
+	}
+	if len(streams.open) >= MaxOpenStreams {
+		return ErrTooManyStreams
+	}
+	if streams.open == nil {
+		streams.open = make(map[uint64]*stream)
+	}
+	streams.open[streamId] = &stream{key: key, ttl: ttl, budget: budget}
+	return nil
+}
+
+// Append appends the chunk to the value of the stream. The stream is aborted with ErrStreamTooLarge if its value
+// would exceed MaxStreamLength, and with ErrStreamBudget if the chunk exceeds the budget of the stream.
+func (streams *Streams) Append(streamId uint64, chunk []byte) error {
+	stream, ok := streams.open[streamId]
+	if !ok {
+		return ErrUnknownStream
+	}
+	if len(stream.value)+len(chunk) > MaxStreamLength {
+		streams.abort(streamId)
+		return ErrStreamTooLarge
+	}
+	if !stream.budget.reserve(len(chunk)) {
+		streams.abort(streamId)
+		return ErrStreamBudget
+	}
+	stream.value = append(stream.value, chunk...)
+	return nil
+}
+
+// End closes the stream, and returns its key, its reassembled value and its time to live.
+func (streams *Streams) End(streamId uint64) ([]byte, []byte, time.Duration, error) {
+	stream, ok := streams.open[streamId]
+	if !ok {
+		return nil, nil, 0, ErrUnknownStream
+	}
+	delete(streams.open, streamId)
+	stream.budget.release(len(stream.value))
+	return stream.key, stream.value, stream.ttl, nil
+}
+
+// Close aborts the open streams, and releases their bytes. It is invoked once the connection is closed.
+func (streams *Streams) Close() {
+	for streamId := range streams.open {
+		streams.abort(streamId)
+	}
+	streams.sending = nil
+}
+
+// Send queues the get stream of the value, which answers the request, in chunks of chunkSize bytes.
+// The value is not copied, so it must not be changed till the stream is sent.
+func (streams *Streams) Send(request *proto.KeyValueMessage, value []byte, chunkSize int) {
+	streams.sending = append(streams.sending, &sendingStream{request: request, value: value, chunkSize: chunkSize})
+}
+
+// Next returns the next message of the queued get streams: a StreamChunk, or the StreamEnd of a stream whose
+// chunks are sent. It returns nil if there is no get stream to be sent.
+func (streams *Streams) Next() *proto.KeyValueMessage {
+	if len(streams.sending) == 0 {
+		return nil
+	}
+	sending := streams.sending[0]
+	if sending.offset >= len(sending.value) {
+		streams.sending = streams.sending[1:]
+		return proto.NewStreamEndMessage(sending.request.StreamId).AnsweringTo(sending.request)
+	}
+	chunk := sending.value[sending.offset:min(sending.offset+sending.chunkSize, len(sending.value))]
+	sending.offset += len(chunk)
+	return proto.NewStreamChunkMessage(sending.request.StreamId, chunk).AnsweringTo(sending.request)
+}
+
+// Open returns the number of open streams.
+func (streams *Streams) Open() int {
+	return len(streams.open)
+}
+
+// abort closes the stream, and releases its bytes.
+func (streams *Streams) abort(streamId uint64) {
+	if stream, ok := streams.open[streamId]; ok {
+		delete(streams.open, streamId)
+		stream.budget.release(len(stream.value))
+	}
+}
@@ -0,0 +1,86 @@
+package conn
+
+import (
+	"github.com/stretchr/testify/assert"
+	"single_thread_blocking_io/proto"
+	"testing"
+	"time"
+)
+
+func TestStreamsReassembleTheValueOfAStream(t *testing.T) {
+	streams := &Streams{}
+	assert.Nil(t, streams.Begin(1, []byte("DiskImage"), time.Minute, nil))
+	assert.Nil(t, streams.Append(1, []byte("NVMe ")))
+	assert.Nil(t, streams.Append(1, []byte("SSD")))
+	assert.Equal(t, 1, streams.Open())
+
+	key, value, ttl, err := streams.End(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "DiskImage", string(key))
+	assert.Equal(t, "NVMe SSD", string(value))
+	assert.Equal(t, time.Minute, ttl)
+	assert.Equal(t, 0, streams.Open())
+}
+
+func TestStreamsRejectTheChunksOfAnUnknownStream(t *testing.T) {
+	streams := &Streams{}
+
+	assert.ErrorIs(t, streams.Append(1, []byte("NVMe SSD")), ErrUnknownStream)
+	_, _, _, err := streams.End(1)
+	assert.ErrorIs(t, err, ErrUnknownStream)
+
+	assert.Nil(t, streams.Begin(1, []byte("DiskImage"), 0, nil))
+	assert.ErrorIs(t, streams.Begin(1, []byte("DiskImage"), 0, nil), ErrStreamOpen)
+}
+
+func TestStreamsLimitTheNumberOfOpenStreams(t *testing.T) {
+	streams := &Streams{}
+	for streamId := uint64(0); streamId < MaxOpenStreams; streamId++ {
+		assert.Nil(t, streams.Begin(streamId, []byte("DiskImage"), 0, nil))
+	}
+
+	assert.ErrorIs(t, streams.Begin(MaxOpenStreams, []byte("DiskImage"), 0, nil), ErrTooManyStreams)
+}
+
+func TestStreamsAbortAStreamWhichExceedsTheMaximumStreamLength(t *testing.T) {
+	streams := &Streams{}
+	assert.Nil(t, streams.Begin(1, []byte("DiskImage"), 0, nil))
+	assert.Nil(t, streams.Append(1, make([]byte, MaxStreamLength)))
+
+	assert.ErrorIs(t, streams.Append(1, []byte{0}), ErrStreamTooLarge)
+	assert.Equal(t, 0, streams.Open())
+	assert.ErrorIs(t, streams.Append(1, []byte{0}), ErrUnknownStream)
+}
+
+func TestStreamsReleaseTheBudgetOfTheAbortedStreams(t *testing.T) {
+	budget := NewStreamBudget(8)
+	streams := &Streams{}
+	assert.Nil(t, streams.Begin(1, []byte("DiskImage"), 0, budget))
+	assert.Nil(t, streams.Begin(2, []byte("DiskType"), 0, budget))
+	assert.Nil(t, streams.Append(1, []byte("NVMe ")))
+	assert.Equal(t, int64(5), budget.Reserved())
+
+	assert.ErrorIs(t, streams.Append(2, []byte("NVMe SSD")), ErrStreamBudget)
+	assert.Equal(t, 1, streams.Open())
+	assert.Equal(t, int64(5), budget.Reserved())
+
+	streams.Close()
+	assert.Equal(t, 0, streams.Open())
+	assert.Equal(t, int64(0), budget.Reserved())
+}
+
+func TestStreamsSendAValueInChunks(t *testing.T) {
+	streams := &Streams{}
+	streams.Send(proto.NewGetStreamMessage(1, "DiskImage", 0).WithRequestId(7), []byte("NVMe SSD"), 5)
+
+	chunk := streams.Next()
+	assert.Equal(t, proto.KeyValueMessageKindStreamChunk, chunk.Kind)
+	assert.Equal(t, uint64(7), chunk.RequestId)
+	assert.Equal(t, "NVMe ", string(chunk.RawValue()))
+	assert.Equal(t, "SSD", string(streams.Next().RawValue()))
+
+	end := streams.Next()
+	assert.Equal(t, proto.KeyValueMessageKindStreamEnd, end.Kind)
+	assert.Equal(t, uint64(1), end.StreamId)
+	assert.Nil(t, streams.Next())
+}
@@ -0,0 +1,33 @@
+package single_thread_blocking_io
+
+import (
+	"bytes"
+	"net"
+)
+
+// faultyConnection is a net.Conn which flips a bit of the bytes written to it, to simulate corruption in transit.
+// The lowest bit of the byte at offset flipAt of the written stream (counted across all the writes) is flipped, once.
+type faultyConnection struct {
+	net.Conn
+	flipAt  int
+	written int
+}
+
+// newFaultyConnection creates a new instance of faultyConnection over the given connection.
+func newFaultyConnection(connection net.Conn, flipAt int) *faultyConnection {
+	return &faultyConnection{
+		Conn:   connection,
+		flipAt: flipAt,
+	}
+}
+
+// Write writes the buffer to the underlying connection, flipping a bit if the buffer covers flipAt.
+func (connection *faultyConnection) Write(buffer []byte) (int, error) {
+	if connection.flipAt >= connection.written && connection.flipAt < connection.written+len(buffer) {
+		corrupted := bytes.Clone(buffer)
+		corrupted[connection.flipAt-connection.written] ^= 0x01
+		buffer = corrupted
+	}
+	connection.written += len(buffer)
+	return connection.Conn.Write(buffer)
+}
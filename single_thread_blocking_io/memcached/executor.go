@@ -0,0 +1,341 @@
+package memcached
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"single_thread_blocking_io/conn"
+	"single_thread_blocking_io/proto"
+	"strconv"
+	"time"
+)
+
+// relativeExpiryLimit is the largest expiration time (in seconds) which is relative to now; memcached treats a larger
+// expiration time as an absolute unix timestamp.
+const relativeExpiryLimit = 60 * 60 * 24 * 30
+
+var (
+	replyStored    = []byte("STORED\r\n")
+	replyNotStored = []byte("NOT_STORED\r\n")
+	replyExists    = []byte("EXISTS\r\n")
+	replyNotFound  = []byte("NOT_FOUND\r\n")
+	replyDeleted   = []byte("DELETED\r\n")
+	replyEnd       = []byte("END\r\n")
+	replyError     = []byte("ERROR\r\n")
+)
+
+// errNotDurable is answered with a SERVER_ERROR when the store could not make a mutation durable.
+var errNotDurable = errors.New("the store could not make the mutation durable")
+
+var commands = map[string]func(executor *Executor, command Command) []byte{
+	"get":     (*Executor).get,
+	"gets":    (*Executor).get,
+	"set":     (*Executor).store,
+	"add":     (*Executor).store,
+	"replace": (*Executor).store,
+	"cas":     (*Executor).store,
+	"delete":  (*Executor).delete,
+	"incr":    (*Executor).incrementBy,
+	"decr":    (*Executor).incrementBy,
+}
+
+// Executor executes the commands of the memcached text protocol.
+// A command is mapped onto proto.KeyValueMessage(s) which are handled by the conn.Handler for their kind, and the
+// responses of the handlers are mapped onto a memcached reply.
+// The cas unique of an item is its version. The client flags of an item are not stored, they are always returned as 0.
+type Executor struct {
+	handlers map[uint32]conn.Handler
+	clock    func() time.Time
+}
+
+// NewExecutor creates a new instance of Executor.
+func NewExecutor(handlers map[uint32]conn.Handler) *Executor {
+	return &Executor{
+		handlers: handlers,
+		clock:    time.Now,
+	}
+}
+
+// Execute executes the command and returns the reply, which is empty if the command carries noreply.
+// An unknown command is answered with ERROR, and a command with invalid arguments with a CLIENT_ERROR.
+func (executor *Executor) Execute(command Command) []byte {
+	if len(command.Args) == 0 {
+		return replyError
+	}
+	execute, ok := commands[string(command.Args[0])]
+	if !ok {
+		return replyError
+	}
+	return execute(executor, command)
+}
+
+// get answers get|gets <key>*, with a VALUE line and a data block for every key that exists, followed by END.
+// gets also returns the cas unique of every key.
+func (executor *Executor) get(command Command) []byte {
+	if len(command.Args) < 2 {
+		return replyError
+	}
+	keys := make([]string, 0, len(command.Args)-1)
+	for _, key := range command.Args[1:] {
+		if len(key) > maxKeyLength {
+			return clientErrorReplyOf(ErrBadCommandLine)
+		}
+		keys = append(keys, string(key))
+	}
+
+	response, err := executor.handle(proto.NewMultiGetMessage(keys...))
+	if err != nil {
+		return serverErrorReplyOf(err)
+	}
+	withCasUnique := string(command.Args[0]) == "gets"
+
+	var reply []byte
+	for _, pair := range response.Pairs {
+		if pair.Status != proto.Status_Ok {
+			continue
+		}
+		reply = fmt.Appendf(reply, "VALUE %s 0 %d", pair.RawKey(), len(pair.RawValue()))
+		if withCasUnique {
+			reply = fmt.Appendf(reply, " %d", pair.Version)
+		}
+		reply = append(reply, crlf...)
+		reply = append(reply, pair.RawValue()...)
+		reply = append(reply, crlf...)
+	}
+	return append(reply, replyEnd...)
+}
+
+// store answers the storage commands:
+// - set <key> <flags> <exptime> <bytes> [noreply], which stores the value,
+// - add <key> <flags> <exptime> <bytes> [noreply], which stores the value only if the key does not exist,
+// - replace <key> <flags> <exptime> <bytes> [noreply], which stores the value only if the key exists,
+// - cas <key> <flags> <exptime> <bytes> <cas unique> [noreply], which stores the value only if the key has not been
+// modified since it was read by gets.
+func (executor *Executor) store(command Command) []byte {
+	name := string(command.Args[0])
+	fields := 5
+	if name == "cas" {
+		fields = 6
+	}
+	args, noreply := withoutNoReply(command.Args)
+	if len(args) != fields || len(args[1]) > maxKeyLength {
+		return clientErrorReplyOf(ErrBadCommandLine)
+	}
+	if _, err := strconv.ParseUint(string(args[2]), 10, 32); err != nil {
+		return clientErrorReplyOf(ErrBadCommandLine)
+	}
+	exptime, err := strconv.ParseInt(string(args[3]), 10, 64)
+	if err != nil {
+		return clientErrorReplyOf(ErrBadCommandLine)
+	}
+	key, value, ttl := string(args[1]), string(command.Data), executor.timeToLiveOf(exptime)
+
+	var reply []byte
+	switch name {
+	case "set":
+		reply, err = executor.set(key, value, ttl)
+	case "add":
+		reply, err = executor.compareAndSwap(key, value, 0, ttl, replyNotStored, replyNotStored)
+	case "replace":
+		reply, err = executor.replace(key, value, ttl)
+	case "cas":
+		casUnique, parseErr := strconv.ParseUint(string(args[5]), 10, 64)
+		if parseErr != nil {
+			return clientErrorReplyOf(ErrBadCommandLine)
+		}
+		reply, err = executor.cas(key, value, casUnique, ttl)
+	}
+	if err != nil {
+		return serverErrorReplyOf(err)
+	}
+	if noreply {
+		return nil
+	}
+	return reply
+}
+
+// set stores the value.
+func (executor *Executor) set(key, value string, ttl time.Duration) ([]byte, error) {
+	if _, err := executor.handle(proto.NewPutOrUpdateKeyValueMessageWithTTL(key, value, ttl)); err != nil {
+		return nil, err
+	}
+	return replyStored, nil
+}
+
+// replace stores the value only if the key exists. The value does not depend on the current value, so replace
+// swaps against the current version, and retries if the key is modified concurrently.
+func (executor *Executor) replace(key, value string, ttl time.Duration) ([]byte, error) {
+	response, err := executor.handle(proto.NewGetValueMessage(key))
+	if err != nil {
+		return nil, err
+	}
+	version := response.Version
+	for version != 0 {
+		response, err = executor.handle(proto.NewCompareAndSwapMessageWithTTL(key, value, version, ttl))
+		if err != nil {
+			return nil, err
+		}
+		if response.Status == proto.Status_Ok {
+			return replyStored, nil
+		}
+		version = response.Version
+	}
+	return replyNotStored, nil
+}
+
+// cas stores the value only if the version of the key is casUnique.
+// No key has a version of 0, so a casUnique of 0 never matches.
+func (executor *Executor) cas(key, value string, casUnique uint64, ttl time.Duration) ([]byte, error) {
+	if casUnique != 0 {
+		return executor.compareAndSwap(key, value, casUnique, ttl, replyExists, replyNotFound)
+	}
+	response, err := executor.handle(proto.NewGetValueMessage(key))
+	if err != nil {
+		return nil, err
+	}
+	if response.Status != proto.Status_Ok {
+		return replyNotFound, nil
+	}
+	return replyExists, nil
+}
+
+// compareAndSwap stores the value only if the version of the key is expectedVersion, and returns STORED.
+// Otherwise, it returns replyOnConflict if the key exists, and replyOnMissing if it does not.
+func (executor *Executor) compareAndSwap(key, value string, expectedVersion uint64, ttl time.Duration, replyOnConflict, replyOnMissing []byte) ([]byte, error) {
+	response, err := executor.handle(proto.NewCompareAndSwapMessageWithTTL(key, value, expectedVersion, ttl))
+	if err != nil {
+		return nil, err
+	}
+	if response.Status == proto.Status_Ok {
+		return replyStored, nil
+	}
+	if response.Version == 0 {
+		return replyOnMissing, nil
+	}
+	return replyOnConflict, nil
+}
+
+// delete answers delete <key> [noreply].
+func (executor *Executor) delete(command Command) []byte {
+	args, noreply := withoutNoReply(command.Args)
+	if len(args) != 2 {
+		return replyError
+	}
+	response, err := executor.handle(proto.NewDeleteMessage(string(args[1])))
+	if err != nil {
+		return serverErrorReplyOf(err)
+	}
+	if noreply {
+		return nil
+	}
+	if response.Status != proto.Status_Ok {
+		return replyNotFound
+	}
+	return replyDeleted
+}
+
+// incrementBy answers incr|decr <key> <value> [noreply] with the new value, or NOT_FOUND if the key does not exist.
+// The counters are int64 (the IncrementBy message), unlike memcached where they are uint64 and wrap around on
+// overflow. As in memcached, decr does not decrement below 0; the current value caps the decrement, which is not
+// atomic with respect to a concurrent decr of the same key.
+func (executor *Executor) incrementBy(command Command) []byte {
+	args, noreply := withoutNoReply(command.Args)
+	if len(args) != 3 {
+		return replyError
+	}
+	delta, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil || delta < 0 {
+		return clientErrorReplyOf(errors.New("invalid numeric delta argument"))
+	}
+
+	key := string(args[1])
+	response, err := executor.handle(proto.NewGetValueMessage(key))
+	if err != nil {
+		return serverErrorReplyOf(err)
+	}
+	if response.Status != proto.Status_Ok {
+		return replyNotFound
+	}
+	if string(args[0]) == "decr" {
+		current, err := strconv.ParseInt(string(response.RawValue()), 10, 64)
+		if err == nil && current >= 0 {
+			delta = -min(delta, current)
+		} else {
+			delta = -delta
+		}
+	}
+
+	response, err = executor.handle(proto.NewIncrementByMessage(key, delta))
+	if err != nil {
+		return serverErrorReplyOf(err)
+	}
+	switch response.Status {
+	case proto.Status_Ok:
+	case proto.Status_Overflow:
+		return clientErrorReplyOf(errors.New("increment or decrement would overflow"))
+	default:
+		return clientErrorReplyOf(errors.New("cannot increment or decrement non-numeric value"))
+	}
+	if noreply {
+		return nil
+	}
+	return append(response.RawValue(), crlf...)
+}
+
+// handle handles the message with the conn.Handler for its kind, and returns the deserialized response.
+// A response with proto.Status_NotDurable is returned as errNotDurable.
+func (executor *Executor) handle(message *proto.KeyValueMessage) (*proto.KeyValueMessage, error) {
+	handler, ok := executor.handlers[message.Kind]
+	if !ok {
+		return nil, fmt.Errorf("no handler for the message kind %v", message.Kind)
+	}
+	buffer, err := handler.Handle(message)
+	if err != nil {
+		return nil, err
+	}
+	response, err := proto.DeserializeFrom(bytes.NewReader(buffer))
+	if err == nil && response.Status == proto.Status_NotDurable {
+		return nil, errNotDurable
+	}
+	return response, err
+}
+
+// timeToLiveOf returns the time to live for the expiration time of a storage command, which is either relative
+// (in seconds) or an absolute unix timestamp. An expiration time in the past expires the key at once, which is
+// denoted by the smallest time to live which the messages carry (a millisecond).
+func (executor *Executor) timeToLiveOf(exptime int64) time.Duration {
+	if exptime == 0 {
+		return 0
+	}
+	if exptime < 0 {
+		return time.Millisecond
+	}
+	ttl := time.Duration(exptime) * time.Second
+	if exptime > relativeExpiryLimit {
+		ttl = time.Unix(exptime, 0).Sub(executor.clock())
+	}
+	return max(ttl, time.Millisecond)
+}
+
+// withoutNoReply returns the arguments without a trailing noreply, and true if there was one.
+func withoutNoReply(args [][]byte) ([][]byte, bool) {
+	if len(args) > 1 && string(args[len(args)-1]) == "noreply" {
+		return args[:len(args)-1], true
+	}
+	return args, false
+}
+
+// isClientError returns true if the error is caused by a malformed command.
+func isClientError(err error) bool {
+	return errors.Is(err, ErrLineTooLong) || errors.Is(err, ErrBadCommandLine) || errors.Is(err, ErrBadDataChunk)
+}
+
+// clientErrorReplyOf returns the CLIENT_ERROR reply for an error which is caused by the client.
+func clientErrorReplyOf(err error) []byte {
+	return []byte("CLIENT_ERROR " + err.Error() + "\r\n")
+}
+
+// serverErrorReplyOf returns the SERVER_ERROR reply for an unexpected error.
+func serverErrorReplyOf(err error) []byte {
+	return []byte("SERVER_ERROR " + err.Error() + "\r\n")
+}
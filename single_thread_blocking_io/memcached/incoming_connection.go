@@ -0,0 +1,64 @@
+package memcached
+
+import (
+	"net"
+	"single_thread_blocking_io/conn"
+)
+
+// IncomingConnection represents an incoming TCP connection which speaks the memcached text protocol,
+// so that the server can be swapped in for memcached.
+type IncomingConnection struct {
+	connection   net.Conn
+	reader       *Reader
+	executor     *Executor
+	closeChannel chan struct{}
+}
+
+// NewIncomingConnection creates a new IncomingConnection to handle incoming commands.
+func NewIncomingConnection(connection net.Conn, handlers map[uint32]conn.Handler) IncomingConnection {
+	return IncomingConnection{
+		connection:   connection,
+		reader:       NewReader(connection),
+		executor:     NewExecutor(handlers),
+		closeChannel: make(chan struct{}),
+	}
+}
+
+// Handle handles the incoming connection.
+// It runs a loop which reads a command from the connection (using blocking IO), executes it and writes the reply.
+// An idle connection is kept open, since memcached clients keep their connections in a pool.
+// The method returns (and closes the connection) if there is any error in reading from the connection. A malformed
+// command is answered with a CLIENT_ERROR before the connection is closed, because the position of the next command
+// is unknown.
+func (incomingConnection IncomingConnection) Handle() {
+	defer func() {
+		_ = incomingConnection.connection.Close()
+	}()
+	for {
+		select {
+		case <-incomingConnection.closeChannel:
+			return
+		default:
+			command, err := incomingConnection.reader.ReadCommand()
+			if err != nil {
+				if isClientError(err) {
+					_, _ = incomingConnection.connection.Write(clientErrorReplyOf(err))
+				}
+				return
+			}
+			reply := incomingConnection.executor.Execute(command)
+			if len(reply) == 0 {
+				continue
+			}
+			if _, err := incomingConnection.connection.Write(reply); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Close closes the IncomingConnection.
+func (incomingConnection IncomingConnection) Close() {
+	close(incomingConnection.closeChannel)
+	_ = incomingConnection.connection.Close()
+}
@@ -0,0 +1,70 @@
+package memcached
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestParseARetrievalCommand(t *testing.T) {
+	command, consumed, err := ParseCommand([]byte("get DiskType Engine\r\n"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 21, consumed)
+	assert.Equal(t, [][]byte{[]byte("get"), []byte("DiskType"), []byte("Engine")}, command.Args)
+	assert.Nil(t, command.Data)
+}
+
+func TestParseAStorageCommandWithABinaryDataBlock(t *testing.T) {
+	data := []byte("set DiskType 0 0 4\r\n\r\n\x00\xff\r\n")
+	command, consumed, err := ParseCommand(data)
+
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), consumed)
+	assert.Equal(t, "set", string(command.Args[0]))
+	assert.Equal(t, []byte("\r\n\x00\xff"), command.Data)
+}
+
+func TestParseAnIncompleteStorageCommandIncrementally(t *testing.T) {
+	data := []byte("set DiskType 0 0 8\r\nNVMe SSD\r\n")
+	for length := 0; length < len(data); length++ {
+		_, consumed, err := ParseCommand(data[:length])
+		assert.ErrorIs(t, err, ErrIncompleteCommand)
+		assert.Equal(t, 0, consumed)
+	}
+
+	command, consumed, err := ParseCommand(data)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), consumed)
+	assert.Equal(t, []byte("NVMe SSD"), command.Data)
+}
+
+func TestParsePipelinedCommands(t *testing.T) {
+	data := []byte("set DiskType 0 0 3\r\nSSD\r\nget DiskType\n")
+
+	command, consumed, err := ParseCommand(data)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("SSD"), command.Data)
+
+	command, _, err = ParseCommand(data[consumed:])
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("get"), []byte("DiskType")}, command.Args)
+}
+
+func TestParseAStorageCommandWithAnInvalidLength(t *testing.T) {
+	_, _, err := ParseCommand([]byte("set DiskType 0 0 three\r\nSSD\r\n"))
+
+	assert.ErrorIs(t, err, ErrBadCommandLine)
+}
+
+func TestParseADataBlockWhichIsNotTerminatedByCRLF(t *testing.T) {
+	_, _, err := ParseCommand([]byte("set DiskType 0 0 3\r\nNVMe\r\n"))
+
+	assert.ErrorIs(t, err, ErrBadDataChunk)
+}
+
+func TestParseALineWhichIsTooLong(t *testing.T) {
+	_, _, err := ParseCommand([]byte("get " + strings.Repeat("k", maxLineLength)))
+
+	assert.ErrorIs(t, err, ErrLineTooLong)
+}
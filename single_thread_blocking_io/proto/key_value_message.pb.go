@@ -23,8 +23,16 @@ const (
 type Status int32
 
 const (
-	Status_Ok    Status = 0
-	Status_NotOk Status = 1
+	Status_Ok               Status = 0
+	Status_NotOk            Status = 1
+	Status_Conflict         Status = 2
+	Status_NotANumber       Status = 3
+	Status_Overflow         Status = 4
+	Status_Corrupt          Status = 5
+	Status_Unauthenticated  Status = 6
+	Status_PermissionDenied Status = 7
+	Status_TooLarge         Status = 8
+	Status_NotDurable       Status = 9
 )
 
 // Enum value maps for Status.
@@ -32,10 +40,26 @@ var (
 	Status_name = map[int32]string{
 		0: "Ok",
 		1: "NotOk",
+		2: "Conflict",
+		3: "NotANumber",
+		4: "Overflow",
+		5: "Corrupt",
+		6: "Unauthenticated",
+		7: "PermissionDenied",
+		8: "TooLarge",
+		9: "NotDurable",
 	}
 	Status_value = map[string]int32{
-		"Ok":    0,
-		"NotOk": 1,
+		"Ok":               0,
+		"NotOk":            1,
+		"Conflict":         2,
+		"NotANumber":       3,
+		"Overflow":         4,
+		"Corrupt":          5,
+		"Unauthenticated":  6,
+		"PermissionDenied": 7,
+		"TooLarge":         8,
+		"NotDurable":       9,
 	}
 )
 
@@ -66,15 +90,35 @@ func (Status) EnumDescriptor() ([]byte, []int) {
 	return file_key_value_message_proto_rawDescGZIP(), []int{0}
 }
 
+// Keys and values are arbitrary bytes, which are carried in the bytes fields (key_bytes, value_bytes and end_key_bytes).
+// The string fields (key, value and end_key) are deprecated: proto3 requires a string to be valid UTF-8, so they can
+// not carry arbitrary bytes. They are still read from the requests of the (legacy) clients which do not set the bytes
+// fields, and the responses to such clients mirror the bytes fields into the string fields, if those are valid UTF-8.
 type KeyValueMessage struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Key    string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
-	Value  string `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
-	Kind   uint32 `protobuf:"varint,3,opt,name=kind,proto3" json:"kind,omitempty"`
-	Status Status `protobuf:"varint,4,opt,name=status,proto3,enum=Status" json:"status,omitempty"`
+	// Deprecated: Marked as deprecated in key_value_message.proto.
+	Key string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	// Deprecated: Marked as deprecated in key_value_message.proto.
+	Value     string          `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Kind      uint32          `protobuf:"varint,3,opt,name=kind,proto3" json:"kind,omitempty"`
+	Status    Status          `protobuf:"varint,4,opt,name=status,proto3,enum=Status" json:"status,omitempty"`
+	RequestId uint64          `protobuf:"varint,5,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	Version   uint64          `protobuf:"varint,6,opt,name=version,proto3" json:"version,omitempty"`
+	Pairs     []*KeyValuePair `protobuf:"bytes,7,rep,name=pairs,proto3" json:"pairs,omitempty"`
+	// Deprecated: Marked as deprecated in key_value_message.proto.
+	EndKey          string `protobuf:"bytes,8,opt,name=end_key,json=endKey,proto3" json:"end_key,omitempty"`
+	Limit           uint32 `protobuf:"varint,9,opt,name=limit,proto3" json:"limit,omitempty"`
+	TtlMillis       uint64 `protobuf:"varint,10,opt,name=ttl_millis,json=ttlMillis,proto3" json:"ttl_millis,omitempty"`
+	Delta           int64  `protobuf:"zigzag64,11,opt,name=delta,proto3" json:"delta,omitempty"`
+	KeyBytes        []byte `protobuf:"bytes,12,opt,name=key_bytes,json=keyBytes,proto3" json:"key_bytes,omitempty"`
+	ValueBytes      []byte `protobuf:"bytes,13,opt,name=value_bytes,json=valueBytes,proto3" json:"value_bytes,omitempty"`
+	EndKeyBytes     []byte `protobuf:"bytes,14,opt,name=end_key_bytes,json=endKeyBytes,proto3" json:"end_key_bytes,omitempty"`
+	ProtocolVersion uint32 `protobuf:"varint,15,opt,name=protocol_version,json=protocolVersion,proto3" json:"protocol_version,omitempty"`
+	Features        uint32 `protobuf:"varint,16,opt,name=features,proto3" json:"features,omitempty"`
+	StreamId        uint64 `protobuf:"varint,17,opt,name=stream_id,json=streamId,proto3" json:"stream_id,omitempty"`
 }
 
 func (x *KeyValueMessage) Reset() {
@@ -109,6 +153,7 @@ func (*KeyValueMessage) Descriptor() ([]byte, []int) {
 	return file_key_value_message_proto_rawDescGZIP(), []int{0}
 }
 
+// Deprecated: Marked as deprecated in key_value_message.proto.
 func (x *KeyValueMessage) GetKey() string {
 	if x != nil {
 		return x.Key
@@ -116,6 +161,7 @@ func (x *KeyValueMessage) GetKey() string {
 	return ""
 }
 
+// Deprecated: Marked as deprecated in key_value_message.proto.
 func (x *KeyValueMessage) GetValue() string {
 	if x != nil {
 		return x.Value
@@ -137,21 +183,249 @@ func (x *KeyValueMessage) GetStatus() Status {
 	return Status_Ok
 }
 
+func (x *KeyValueMessage) GetRequestId() uint64 {
+	if x != nil {
+		return x.RequestId
+	}
+	return 0
+}
+
+func (x *KeyValueMessage) GetVersion() uint64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *KeyValueMessage) GetPairs() []*KeyValuePair {
+	if x != nil {
+		return x.Pairs
+	}
+	return nil
+}
+
+// Deprecated: Marked as deprecated in key_value_message.proto.
+func (x *KeyValueMessage) GetEndKey() string {
+	if x != nil {
+		return x.EndKey
+	}
+	return ""
+}
+
+func (x *KeyValueMessage) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *KeyValueMessage) GetTtlMillis() uint64 {
+	if x != nil {
+		return x.TtlMillis
+	}
+	return 0
+}
+
+func (x *KeyValueMessage) GetDelta() int64 {
+	if x != nil {
+		return x.Delta
+	}
+	return 0
+}
+
+func (x *KeyValueMessage) GetKeyBytes() []byte {
+	if x != nil {
+		return x.KeyBytes
+	}
+	return nil
+}
+
+func (x *KeyValueMessage) GetValueBytes() []byte {
+	if x != nil {
+		return x.ValueBytes
+	}
+	return nil
+}
+
+func (x *KeyValueMessage) GetEndKeyBytes() []byte {
+	if x != nil {
+		return x.EndKeyBytes
+	}
+	return nil
+}
+
+func (x *KeyValueMessage) GetProtocolVersion() uint32 {
+	if x != nil {
+		return x.ProtocolVersion
+	}
+	return 0
+}
+
+func (x *KeyValueMessage) GetFeatures() uint32 {
+	if x != nil {
+		return x.Features
+	}
+	return 0
+}
+
+func (x *KeyValueMessage) GetStreamId() uint64 {
+	if x != nil {
+		return x.StreamId
+	}
+	return 0
+}
+
+type KeyValuePair struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Deprecated: Marked as deprecated in key_value_message.proto.
+	Key string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	// Deprecated: Marked as deprecated in key_value_message.proto.
+	Value      string `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Status     Status `protobuf:"varint,3,opt,name=status,proto3,enum=Status" json:"status,omitempty"`
+	Version    uint64 `protobuf:"varint,4,opt,name=version,proto3" json:"version,omitempty"`
+	KeyBytes   []byte `protobuf:"bytes,5,opt,name=key_bytes,json=keyBytes,proto3" json:"key_bytes,omitempty"`
+	ValueBytes []byte `protobuf:"bytes,6,opt,name=value_bytes,json=valueBytes,proto3" json:"value_bytes,omitempty"`
+}
+
+func (x *KeyValuePair) Reset() {
+	*x = KeyValuePair{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_key_value_message_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *KeyValuePair) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KeyValuePair) ProtoMessage() {}
+
+func (x *KeyValuePair) ProtoReflect() protoreflect.Message {
+	mi := &file_key_value_message_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KeyValuePair.ProtoReflect.Descriptor instead.
+func (*KeyValuePair) Descriptor() ([]byte, []int) {
+	return file_key_value_message_proto_rawDescGZIP(), []int{1}
+}
+
+// Deprecated: Marked as deprecated in key_value_message.proto.
+func (x *KeyValuePair) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+// Deprecated: Marked as deprecated in key_value_message.proto.
+func (x *KeyValuePair) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *KeyValuePair) GetStatus() Status {
+	if x != nil {
+		return x.Status
+	}
+	return Status_Ok
+}
+
+func (x *KeyValuePair) GetVersion() uint64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *KeyValuePair) GetKeyBytes() []byte {
+	if x != nil {
+		return x.KeyBytes
+	}
+	return nil
+}
+
+func (x *KeyValuePair) GetValueBytes() []byte {
+	if x != nil {
+		return x.ValueBytes
+	}
+	return nil
+}
+
 var File_key_value_message_proto protoreflect.FileDescriptor
 
 var file_key_value_message_proto_rawDesc = []byte{
 	0x0a, 0x17, 0x6b, 0x65, 0x79, 0x5f, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x5f, 0x6d, 0x65, 0x73, 0x73,
-	0x61, 0x67, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x6e, 0x0a, 0x0f, 0x4b, 0x65, 0x79,
-	0x56, 0x61, 0x6c, 0x75, 0x65, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x10, 0x0a, 0x03,
-	0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14,
-	0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76,
-	0x61, 0x6c, 0x75, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x6b, 0x69, 0x6e, 0x64, 0x18, 0x03, 0x20, 0x01,
-	0x28, 0x0d, 0x52, 0x04, 0x6b, 0x69, 0x6e, 0x64, 0x12, 0x1f, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74,
-	0x75, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x07, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x75,
-	0x73, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x2a, 0x1b, 0x0a, 0x06, 0x53, 0x74, 0x61,
+	0x61, 0x67, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x82, 0x04, 0x0a, 0x0f, 0x4b, 0x65,
+	0x79, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x14, 0x0a,
+	0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x42, 0x02, 0x18, 0x01, 0x52, 0x03,
+	0x6b, 0x65, 0x79, 0x12, 0x18, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x09, 0x42, 0x02, 0x18, 0x01, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x12, 0x0a,
+	0x04, 0x6b, 0x69, 0x6e, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x04, 0x6b, 0x69, 0x6e,
+	0x64, 0x12, 0x1f, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x0e, 0x32, 0x07, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74,
+	0x75, 0x73, 0x12, 0x1d, 0x0a, 0x0a, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x5f, 0x69, 0x64,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x04, 0x52, 0x09, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x49,
+	0x64, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x06, 0x20, 0x01,
+	0x28, 0x04, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x23, 0x0a, 0x05, 0x70,
+	0x61, 0x69, 0x72, 0x73, 0x18, 0x07, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0d, 0x2e, 0x4b, 0x65, 0x79,
+	0x56, 0x61, 0x6c, 0x75, 0x65, 0x50, 0x61, 0x69, 0x72, 0x52, 0x05, 0x70, 0x61, 0x69, 0x72, 0x73,
+	0x12, 0x1b, 0x0a, 0x07, 0x65, 0x6e, 0x64, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x08, 0x20, 0x01, 0x28,
+	0x09, 0x42, 0x02, 0x18, 0x01, 0x52, 0x06, 0x65, 0x6e, 0x64, 0x4b, 0x65, 0x79, 0x12, 0x14, 0x0a,
+	0x05, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x18, 0x09, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x6c, 0x69,
+	0x6d, 0x69, 0x74, 0x12, 0x1d, 0x0a, 0x0a, 0x74, 0x74, 0x6c, 0x5f, 0x6d, 0x69, 0x6c, 0x6c, 0x69,
+	0x73, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x04, 0x52, 0x09, 0x74, 0x74, 0x6c, 0x4d, 0x69, 0x6c, 0x6c,
+	0x69, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x64, 0x65, 0x6c, 0x74, 0x61, 0x18, 0x0b, 0x20, 0x01, 0x28,
+	0x12, 0x52, 0x05, 0x64, 0x65, 0x6c, 0x74, 0x61, 0x12, 0x1b, 0x0a, 0x09, 0x6b, 0x65, 0x79, 0x5f,
+	0x62, 0x79, 0x74, 0x65, 0x73, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x08, 0x6b, 0x65, 0x79,
+	0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x1f, 0x0a, 0x0b, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x5f, 0x62,
+	0x79, 0x74, 0x65, 0x73, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0a, 0x76, 0x61, 0x6c, 0x75,
+	0x65, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x22, 0x0a, 0x0d, 0x65, 0x6e, 0x64, 0x5f, 0x6b, 0x65,
+	0x79, 0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0b, 0x65,
+	0x6e, 0x64, 0x4b, 0x65, 0x79, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x29, 0x0a, 0x10, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x0f,
+	0x20, 0x01, 0x28, 0x0d, 0x52, 0x0f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x56, 0x65,
+	0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x1a, 0x0a, 0x08, 0x66, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65,
+	0x73, 0x18, 0x10, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x66, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65,
+	0x73, 0x12, 0x1b, 0x0a, 0x09, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x5f, 0x69, 0x64, 0x18, 0x11,
+	0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x64, 0x22, 0xb7,
+	0x01, 0x0a, 0x0c, 0x4b, 0x65, 0x79, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x50, 0x61, 0x69, 0x72, 0x12,
+	0x14, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x42, 0x02, 0x18, 0x01,
+	0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x18, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x09, 0x42, 0x02, 0x18, 0x01, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x12,
+	0x1f, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0e, 0x32,
+	0x07, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73,
+	0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x04, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x1b, 0x0a, 0x09, 0x6b, 0x65,
+	0x79, 0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x08, 0x6b,
+	0x65, 0x79, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x1f, 0x0a, 0x0b, 0x76, 0x61, 0x6c, 0x75, 0x65,
+	0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0a, 0x76, 0x61,
+	0x6c, 0x75, 0x65, 0x42, 0x79, 0x74, 0x65, 0x73, 0x2a, 0x9d, 0x01, 0x0a, 0x06, 0x53, 0x74, 0x61,
 	0x74, 0x75, 0x73, 0x12, 0x06, 0x0a, 0x02, 0x4f, 0x6b, 0x10, 0x00, 0x12, 0x09, 0x0a, 0x05, 0x4e,
-	0x6f, 0x74, 0x4f, 0x6b, 0x10, 0x01, 0x42, 0x08, 0x5a, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f,
-	0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x6f, 0x74, 0x4f, 0x6b, 0x10, 0x01, 0x12, 0x0c, 0x0a, 0x08, 0x43, 0x6f, 0x6e, 0x66, 0x6c, 0x69,
+	0x63, 0x74, 0x10, 0x02, 0x12, 0x0e, 0x0a, 0x0a, 0x4e, 0x6f, 0x74, 0x41, 0x4e, 0x75, 0x6d, 0x62,
+	0x65, 0x72, 0x10, 0x03, 0x12, 0x0c, 0x0a, 0x08, 0x4f, 0x76, 0x65, 0x72, 0x66, 0x6c, 0x6f, 0x77,
+	0x10, 0x04, 0x12, 0x0b, 0x0a, 0x07, 0x43, 0x6f, 0x72, 0x72, 0x75, 0x70, 0x74, 0x10, 0x05, 0x12,
+	0x13, 0x0a, 0x0f, 0x55, 0x6e, 0x61, 0x75, 0x74, 0x68, 0x65, 0x6e, 0x74, 0x69, 0x63, 0x61, 0x74,
+	0x65, 0x64, 0x10, 0x06, 0x12, 0x14, 0x0a, 0x10, 0x50, 0x65, 0x72, 0x6d, 0x69, 0x73, 0x73, 0x69,
+	0x6f, 0x6e, 0x44, 0x65, 0x6e, 0x69, 0x65, 0x64, 0x10, 0x07, 0x12, 0x0c, 0x0a, 0x08, 0x54, 0x6f,
+	0x6f, 0x4c, 0x61, 0x72, 0x67, 0x65, 0x10, 0x08, 0x12, 0x0e, 0x0a, 0x0a, 0x4e, 0x6f, 0x74, 0x44,
+	0x75, 0x72, 0x61, 0x62, 0x6c, 0x65, 0x10, 0x09, 0x42, 0x08, 0x5a, 0x06, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x2f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -167,18 +441,21 @@ func file_key_value_message_proto_rawDescGZIP() []byte {
 }
 
 var file_key_value_message_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_key_value_message_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_key_value_message_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
 var file_key_value_message_proto_goTypes = []interface{}{
 	(Status)(0),             // 0: Status
 	(*KeyValueMessage)(nil), // 1: KeyValueMessage
+	(*KeyValuePair)(nil),    // 2: KeyValuePair
 }
 var file_key_value_message_proto_depIdxs = []int32{
 	0, // 0: KeyValueMessage.status:type_name -> Status
-	1, // [1:1] is the sub-list for method output_type
-	1, // [1:1] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	2, // 1: KeyValueMessage.pairs:type_name -> KeyValuePair
+	0, // 2: KeyValuePair.status:type_name -> Status
+	3, // [3:3] is the sub-list for method output_type
+	3, // [3:3] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
 }
 
 func init() { file_key_value_message_proto_init() }
@@ -199,6 +476,18 @@ func file_key_value_message_proto_init() {
 				return nil
 			}
 		}
+		file_key_value_message_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*KeyValuePair); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -206,7 +495,7 @@ func file_key_value_message_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_key_value_message_proto_rawDesc,
 			NumEnums:      1,
-			NumMessages:   1,
+			NumMessages:   2,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -2,8 +2,12 @@ package proto
 
 import (
 	"bytes"
+	"encoding/binary"
 	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSerializesAndDeserializesAPutOrUpdateMessage(t *testing.T) {
@@ -15,8 +19,8 @@ func TestSerializesAndDeserializesAPutOrUpdateMessage(t *testing.T) {
 	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
 
 	assert.Nil(t, err)
-	assert.Equal(t, "DiskType", deserializedMessage.Key)
-	assert.Equal(t, "SSD", deserializedMessage.Value)
+	assert.Equal(t, "DiskType", string(deserializedMessage.RawKey()))
+	assert.Equal(t, "SSD", string(deserializedMessage.RawValue()))
 	assert.Equal(t, KeyValueMessageKindPutOrUpdate, deserializedMessage.Kind)
 }
 
@@ -29,6 +33,417 @@ func TestSerializesAndDeserializesAGetMessage(t *testing.T) {
 	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
 
 	assert.Nil(t, err)
-	assert.Equal(t, "DiskType", deserializedMessage.Key)
+	assert.Equal(t, "DiskType", string(deserializedMessage.RawKey()))
 	assert.Equal(t, KeyValueMessageKindGet, deserializedMessage.Kind)
 }
+
+func TestSerializesAndDeserializesAMessageWithRequestId(t *testing.T) {
+	message := NewGetValueMessage("DiskType").WithRequestId(42)
+	buffer, err := message.Serialize()
+
+	assert.Nil(t, err)
+
+	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(42), deserializedMessage.RequestId)
+}
+
+func TestDeserializesPipelinedMessagesFromABuffer(t *testing.T) {
+	first, _ := NewPutOrUpdateKeyValueMessage("DiskType", "SSD").WithRequestId(1).Serialize()
+	second, _ := NewGetValueMessage("DiskType").WithRequestId(2).Serialize()
+
+	buffer := bytes.NewBuffer(append(first, second[:3]...))
+
+	deserializedMessage, err := DeserializeFromBuffer(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), deserializedMessage.RequestId)
+
+	_, err = DeserializeFromBuffer(buffer)
+	assert.Equal(t, ErrIncompleteFrame, err)
+
+	buffer.Write(second[3:])
+
+	deserializedMessage, err = DeserializeFromBuffer(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), deserializedMessage.RequestId)
+	assert.Equal(t, "DiskType", string(deserializedMessage.RawKey()))
+}
+
+func TestSerializesAndDeserializesADeleteMessage(t *testing.T) {
+	message := NewDeleteMessage("DiskType")
+	buffer, err := message.Serialize()
+
+	assert.Nil(t, err)
+
+	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "DiskType", string(deserializedMessage.RawKey()))
+	assert.Equal(t, KeyValueMessageKindDelete, deserializedMessage.Kind)
+}
+
+func TestSerializesAndDeserializesACompareAndSwapMessage(t *testing.T) {
+	message := NewCompareAndSwapMessage("DiskType", "SSD", 3)
+	buffer, err := message.Serialize()
+
+	assert.Nil(t, err)
+
+	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "DiskType", string(deserializedMessage.RawKey()))
+	assert.Equal(t, "SSD", string(deserializedMessage.RawValue()))
+	assert.Equal(t, uint64(3), deserializedMessage.Version)
+	assert.Equal(t, KeyValueMessageKindCompareAndSwap, deserializedMessage.Kind)
+}
+
+func TestSerializesAndDeserializesAMultiPutOrUpdateMessage(t *testing.T) {
+	message := NewMultiPutOrUpdateMessage(NewKeyValuePair("DiskType", "SSD"), NewKeyValuePair("Storage", "LSM"))
+	buffer, err := message.Serialize()
+
+	assert.Nil(t, err)
+
+	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, KeyValueMessageKindMultiPutOrUpdate, deserializedMessage.Kind)
+	assert.Equal(t, 2, len(deserializedMessage.Pairs))
+	assert.Equal(t, "Storage", string(deserializedMessage.Pairs[1].RawKey()))
+	assert.Equal(t, "LSM", string(deserializedMessage.Pairs[1].RawValue()))
+}
+
+func TestSerializesAndDeserializesAPrefixWatchMessage(t *testing.T) {
+	message := NewPrefixWatchMessage("Disk", "Storage")
+	buffer, err := message.Serialize()
+
+	assert.Nil(t, err)
+
+	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, KeyValueMessageKindPrefixWatch, deserializedMessage.Kind)
+	assert.Equal(t, 2, len(deserializedMessage.Pairs))
+	assert.Equal(t, "Storage", string(deserializedMessage.Pairs[1].RawKey()))
+}
+
+func TestSerializesAndDeserializesAPublishMessage(t *testing.T) {
+	message := NewPublishMessage("news.sports", "kick-off")
+	buffer, err := message.Serialize()
+
+	assert.Nil(t, err)
+
+	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, KeyValueMessageKindPublish, deserializedMessage.Kind)
+	assert.Equal(t, "news.sports", string(deserializedMessage.RawKey()))
+	assert.Equal(t, "kick-off", string(deserializedMessage.RawValue()))
+}
+
+func TestSerializesAndDeserializesAnExecResponseMessage(t *testing.T) {
+	message := NewExecResponseMessage([]*KeyValuePair{
+		{KeyBytes: []byte("DiskType"), Status: Status_Ok, Version: 3},
+		{KeyBytes: []byte("Engine"), Status: Status_NotOk},
+	})
+	buffer, err := message.Serialize()
+
+	assert.Nil(t, err)
+
+	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, KeyValueMessageKindExecResponse, deserializedMessage.Kind)
+	assert.Equal(t, 2, len(deserializedMessage.Pairs))
+	assert.Equal(t, uint64(3), deserializedMessage.Pairs[0].Version)
+	assert.Equal(t, Status_NotOk, deserializedMessage.Pairs[1].Status)
+}
+
+func TestSerializesAndDeserializesAnAuthPasswordMessage(t *testing.T) {
+	message := NewAuthPasswordMessage("alice", "s3cr3t")
+	buffer, err := message.Serialize()
+
+	assert.Nil(t, err)
+
+	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, KeyValueMessageKindAuth, deserializedMessage.Kind)
+	assert.Equal(t, "alice", string(deserializedMessage.RawKey()))
+	assert.Equal(t, "s3cr3t", string(deserializedMessage.RawValue()))
+}
+
+func TestSerializesAndDeserializesAScanMessage(t *testing.T) {
+	message := NewScanMessage("Disk", "System", 10)
+	buffer, err := message.Serialize()
+
+	assert.Nil(t, err)
+
+	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Disk", string(deserializedMessage.RawKey()))
+	assert.Equal(t, "System", string(deserializedMessage.RawEndKey()))
+	assert.Equal(t, uint32(10), deserializedMessage.Limit)
+	assert.Equal(t, KeyValueMessageKindScan, deserializedMessage.Kind)
+}
+
+func TestSerializesAndDeserializesMultipleFrames(t *testing.T) {
+	buffer, err := SerializeAll([]*KeyValueMessage{
+		NewScanResponseMessage([]byte("DiskType"), []byte("SSD"), 1),
+		NewScanEndMessage(),
+	})
+
+	assert.Nil(t, err)
+
+	reader := bytes.NewReader(buffer)
+	deserializedMessage, err := DeserializeFrom(reader)
+
+	assert.Nil(t, err)
+	assert.Equal(t, KeyValueMessageKindScanResponse, deserializedMessage.Kind)
+	assert.Equal(t, "SSD", string(deserializedMessage.RawValue()))
+
+	deserializedMessage, err = DeserializeFrom(reader)
+
+	assert.Nil(t, err)
+	assert.Equal(t, KeyValueMessageKindScanEnd, deserializedMessage.Kind)
+}
+
+func TestSerializesAndDeserializesAPutOrUpdateMessageWithTTL(t *testing.T) {
+	message := NewPutOrUpdateKeyValueMessageWithTTL("DiskType", "SSD", 5*time.Second)
+	buffer, err := message.Serialize()
+
+	assert.Nil(t, err)
+
+	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "DiskType", string(deserializedMessage.RawKey()))
+	assert.Equal(t, 5*time.Second, deserializedMessage.TimeToLive())
+}
+
+func TestTimeToLiveResponseRoundsUpToMilliseconds(t *testing.T) {
+	message := NewTimeToLiveSuccessfulResponseMessage([]byte("DiskType"), 1500*time.Microsecond)
+
+	assert.Equal(t, 2*time.Millisecond, message.TimeToLive())
+}
+
+func TestSerializesAndDeserializesAnIncrementByMessage(t *testing.T) {
+	message := NewIncrementByMessage("Counter", -5)
+	buffer, err := message.Serialize()
+
+	assert.Nil(t, err)
+
+	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Counter", string(deserializedMessage.RawKey()))
+	assert.Equal(t, int64(-5), deserializedMessage.Delta)
+	assert.Equal(t, KeyValueMessageKindIncrementBy, deserializedMessage.Kind)
+}
+
+func TestSerializesAndDeserializesBinaryKeysAndValues(t *testing.T) {
+	key, value := string([]byte{0xff, 0xfe, 0x00}), "SSD"+string(FooterBytes)+string([]byte{0xc3, 0x28})
+	message := NewPutOrUpdateKeyValueMessage(key, value)
+	buffer, err := message.Serialize()
+
+	assert.Nil(t, err)
+
+	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(key), deserializedMessage.RawKey())
+	assert.Equal(t, []byte(value), deserializedMessage.RawValue())
+}
+
+func TestReadsTheLegacyStringFields(t *testing.T) {
+	message := &KeyValueMessage{Key: "DiskType", Value: "SSD", Kind: KeyValueMessageKindPutOrUpdate}
+	buffer, err := message.Serialize()
+
+	assert.Nil(t, err)
+
+	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("DiskType"), deserializedMessage.RawKey())
+	assert.Equal(t, []byte("SSD"), deserializedMessage.RawValue())
+}
+
+func TestMirrorsTheResponseIntoTheLegacyStringFieldsForALegacyRequest(t *testing.T) {
+	request := &KeyValueMessage{Key: "DiskType", Kind: KeyValueMessageKindGet, RequestId: 7}
+	response := NewGetValueSuccessfulResponseMessage([]byte("DiskType"), []byte("SSD"), 1).AnsweringTo(request)
+
+	assert.Equal(t, uint64(7), response.RequestId)
+	assert.Equal(t, "DiskType", response.Key)
+	assert.Equal(t, "SSD", response.Value)
+}
+
+func TestDoesNotMirrorInvalidUTF8IntoTheLegacyStringFields(t *testing.T) {
+	request := &KeyValueMessage{Key: "DiskType", Kind: KeyValueMessageKindGet}
+	response := NewGetValueSuccessfulResponseMessage([]byte("DiskType"), []byte{0xc3, 0x28}, 1).AnsweringTo(request)
+
+	_, err := response.Serialize()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", response.Value)
+	assert.Equal(t, []byte{0xc3, 0x28}, response.RawValue())
+}
+
+func TestDoesNotMirrorTheResponseIntoTheLegacyStringFieldsForARequestWithBytesFields(t *testing.T) {
+	request := NewGetValueMessage("DiskType")
+	response := NewGetValueSuccessfulResponseMessage([]byte("DiskType"), []byte("SSD"), 1).AnsweringTo(request)
+
+	assert.Equal(t, "", response.Key)
+	assert.Equal(t, "", response.Value)
+}
+
+func TestSerializesAndDeserializesAHelloMessage(t *testing.T) {
+	message := NewHelloMessage(ProtocolVersion, FeaturePipelining|FeatureChecksums)
+	buffer, err := message.Serialize()
+
+	assert.Nil(t, err)
+
+	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, KeyValueMessageKindHello, deserializedMessage.Kind)
+	assert.Equal(t, ProtocolVersion, deserializedMessage.ProtocolVersion)
+	assert.Equal(t, FeaturePipelining|FeatureChecksums, deserializedMessage.Features)
+}
+
+func TestSerializesAndDeserializesAMessageWithChecksum(t *testing.T) {
+	message := NewPutOrUpdateKeyValueMessage("DiskType", "SSD"+string(FooterBytes))
+	buffer, err := message.SerializeWithChecksum()
+
+	assert.Nil(t, err)
+
+	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "DiskType", string(deserializedMessage.RawKey()))
+	assert.Equal(t, "SSD"+string(FooterBytes), string(deserializedMessage.RawValue()))
+}
+
+func TestDetectsACorruptPayloadWithChecksum(t *testing.T) {
+	buffer, _ := NewPutOrUpdateKeyValueMessage("DiskType", "SSD").SerializeWithChecksum()
+	next, _ := NewGetValueMessage("DiskType").Serialize()
+	buffer[ReservedHeaderLength+3] ^= 0x01
+
+	reader := bytes.NewReader(append(buffer, next...))
+	_, err := DeserializeFrom(reader)
+
+	assert.ErrorIs(t, err, ErrCorruptFrame)
+	assert.Equal(t, ErrChecksumMismatch, err)
+
+	deserializedMessage, err := DeserializeFrom(reader)
+
+	assert.Nil(t, err)
+	assert.Equal(t, KeyValueMessageKindGet, deserializedMessage.Kind)
+}
+
+func TestDetectsACorruptFooter(t *testing.T) {
+	buffer, _ := NewGetValueMessage("DiskType").Serialize()
+	buffer[len(buffer)-1] ^= 0x01
+
+	_, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Equal(t, ErrMalformedFrame, err)
+}
+
+func TestRejectsAFrameWhichIsLongerThanTheMaximumFrameLength(t *testing.T) {
+	header := make([]byte, ReservedHeaderLength)
+	binary.LittleEndian.PutUint32(header, uint32(MaxFrameLength+1))
+
+	_, err := DeserializeFrom(bytes.NewReader(header))
+
+	assert.ErrorIs(t, err, ErrMalformedFrame)
+	assert.Equal(t, ErrFrameTooLong, err)
+
+	_, err = DeserializeFromBuffer(bytes.NewBuffer(header))
+
+	assert.Equal(t, ErrFrameTooLong, err)
+
+	binary.LittleEndian.PutUint32(header, uint32(MaxPayloadLength)|ChecksumFlag|CompressionFlag)
+	_, err = DeserializeFrom(bytes.NewReader(header))
+
+	assert.Equal(t, ErrFrameTooLong, err)
+}
+
+func TestReframesAllTheFramesWithChecksums(t *testing.T) {
+	frames, _ := SerializeAll([]*KeyValueMessage{
+		NewScanResponseMessage([]byte("DiskType"), []byte("SSD"), 1),
+		NewScanEndMessage(),
+	})
+
+	buffer, err := Reframe(frames, FrameOptions{Checksum: true})
+
+	assert.Nil(t, err)
+	assert.Equal(t, len(frames)+2*ChecksumLength, len(buffer))
+
+	reader := bytes.NewBuffer(buffer)
+	for _, kind := range []uint32{KeyValueMessageKindScanResponse, KeyValueMessageKindScanEnd} {
+		assert.NotZero(t, reader.Bytes()[ReservedHeaderLength-1]&0x80)
+
+		deserializedMessage, err := DeserializeFromBuffer(reader)
+
+		assert.Nil(t, err)
+		assert.Equal(t, kind, deserializedMessage.Kind)
+	}
+}
+
+func TestSerializesAndDeserializesACompressedMessage(t *testing.T) {
+	value := strings.Repeat("NVMe SSD ", 200)
+	message := NewPutOrUpdateKeyValueMessage("DiskType", value)
+	buffer, err := message.SerializeWith(FrameOptions{Checksum: true, CompressAbove: 256})
+
+	assert.Nil(t, err)
+	assert.NotZero(t, binary.LittleEndian.Uint32(buffer)&CompressionFlag)
+	assert.Less(t, len(buffer), len(value))
+
+	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, value, string(deserializedMessage.RawValue()))
+}
+
+func TestDoesNotCompressASmallMessage(t *testing.T) {
+	buffer, err := NewPutOrUpdateKeyValueMessage("DiskType", "SSD").SerializeWith(FrameOptions{CompressAbove: 256})
+
+	assert.Nil(t, err)
+	assert.Zero(t, binary.LittleEndian.Uint32(buffer)&CompressionFlag)
+}
+
+func TestDoesNotCompressAnIncompressibleMessage(t *testing.T) {
+	value := make([]byte, 1024)
+	_, _ = rand.New(rand.NewSource(7)).Read(value)
+
+	buffer, err := NewPutOrUpdateKeyValueMessage("DiskType", string(value)).SerializeWith(FrameOptions{CompressAbove: 256})
+
+	assert.Nil(t, err)
+	assert.Zero(t, binary.LittleEndian.Uint32(buffer)&CompressionFlag)
+}
+
+func TestDetectsACorruptCompressedPayload(t *testing.T) {
+	buffer, _ := NewPutOrUpdateKeyValueMessage("DiskType", strings.Repeat("SSD", 500)).SerializeWith(FrameOptions{CompressAbove: 256})
+	for index := ReservedHeaderLength; index < len(buffer)-FooterLength; index++ {
+		buffer[index] = 0xff
+	}
+
+	_, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.ErrorIs(t, err, ErrCorruptFrame)
+}
+
+func TestSerializesAndDeserializesAStreamChunkMessage(t *testing.T) {
+	message := NewStreamChunkMessage(7, []byte("NVMe SSD"))
+	buffer, err := message.Serialize()
+
+	assert.Nil(t, err)
+
+	deserializedMessage, err := DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, KeyValueMessageKindStreamChunk, deserializedMessage.Kind)
+	assert.Equal(t, uint64(7), deserializedMessage.StreamId)
+	assert.Equal(t, "NVMe SSD", string(deserializedMessage.RawValue()))
+}
@@ -0,0 +1,146 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const (
+	maxBulkLength   = 512 * 1024 * 1024
+	maxArrayLength  = 1024 * 1024
+	maxInlineLength = 64 * 1024
+)
+
+var crlf = []byte{'\r', '\n'}
+
+var (
+	ErrIncompleteCommand = errors.New("incomplete command, more bytes are needed")
+	ErrProtocol          = errors.New("Protocol error")
+)
+
+// ParseCommand parses a single command from the beginning of data, and returns its arguments (the first one is the
+// name of the command) along with the number of bytes consumed.
+// A command is either a RESP array of bulk strings, which is sent by redis-cli and the client libraries, or an inline
+// command: a line of space separated arguments, which is convenient with telnet.
+// If data does not hold a complete command, ErrIncompleteCommand is returned and nothing is consumed, so the parser is
+// incremental: it can be invoked again once more bytes have arrived.
+// A command without arguments (an empty line or an empty array) is returned as empty arguments.
+// The arguments refer to data, they are valid as long as data is not modified.
+func ParseCommand(data []byte) ([][]byte, int, error) {
+	if len(data) == 0 {
+		return nil, 0, ErrIncompleteCommand
+	}
+	if data[0] != '*' {
+		return parseInline(data)
+	}
+
+	count, position, err := parseLength(data, 0, maxArrayLength)
+	if err != nil {
+		return nil, 0, err
+	}
+	if count <= 0 {
+		return nil, position, nil
+	}
+
+	args := make([][]byte, 0, count)
+	for index := 0; index < count; index++ {
+		if position == len(data) {
+			return nil, 0, ErrIncompleteCommand
+		}
+		if data[position] != '$' {
+			return nil, 0, fmt.Errorf("%w: expected '$', got '%c'", ErrProtocol, data[position])
+		}
+		length, next, err := parseLength(data, position, maxBulkLength)
+		if err != nil {
+			return nil, 0, err
+		}
+		if length < 0 {
+			return nil, 0, fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+		}
+		if len(data) < next+length+len(crlf) {
+			return nil, 0, ErrIncompleteCommand
+		}
+		if !bytes.Equal(data[next+length:next+length+len(crlf)], crlf) {
+			return nil, 0, fmt.Errorf("%w: bulk string is not terminated by CRLF", ErrProtocol)
+		}
+		args = append(args, data[next:next+length])
+		position = next + length + len(crlf)
+	}
+	return args, position, nil
+}
+
+// AppendSimpleString appends a RESP simple string (+OK\r\n) to the buffer.
+func AppendSimpleString(buffer []byte, value string) []byte {
+	buffer = append(buffer, '+')
+	buffer = append(buffer, value...)
+	return append(buffer, crlf...)
+}
+
+// AppendError appends a RESP error (-ERR message\r\n) to the buffer.
+// The message is expected to start with an error code, ERR for the generic errors.
+func AppendError(buffer []byte, message string) []byte {
+	buffer = append(buffer, '-')
+	buffer = append(buffer, message...)
+	return append(buffer, crlf...)
+}
+
+// AppendInteger appends a RESP integer (:1\r\n) to the buffer.
+func AppendInteger(buffer []byte, value int64) []byte {
+	buffer = append(buffer, ':')
+	buffer = strconv.AppendInt(buffer, value, 10)
+	return append(buffer, crlf...)
+}
+
+// AppendBulkString appends a RESP bulk string ($3\r\nSSD\r\n) to the buffer. A bulk string is binary-safe.
+func AppendBulkString(buffer []byte, value []byte) []byte {
+	buffer = append(buffer, '$')
+	buffer = strconv.AppendInt(buffer, int64(len(value)), 10)
+	buffer = append(buffer, crlf...)
+	buffer = append(buffer, value...)
+	return append(buffer, crlf...)
+}
+
+// AppendNullBulkString appends a RESP null bulk string ($-1\r\n) to the buffer, which denotes a non-existing key.
+func AppendNullBulkString(buffer []byte) []byte {
+	return append(buffer, '$', '-', '1', '\r', '\n')
+}
+
+// AppendArray appends the header of a RESP array (*2\r\n) of the given length to the buffer.
+// The header is expected to be followed by length elements.
+func AppendArray(buffer []byte, length int) []byte {
+	buffer = append(buffer, '*')
+	buffer = strconv.AppendInt(buffer, int64(length), 10)
+	return append(buffer, crlf...)
+}
+
+// parseInline parses an inline command, a line of space separated arguments.
+func parseInline(data []byte) ([][]byte, int, error) {
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		if len(data) > maxInlineLength {
+			return nil, 0, fmt.Errorf("%w: too big inline request", ErrProtocol)
+		}
+		return nil, 0, ErrIncompleteCommand
+	}
+	line := bytes.TrimSuffix(data[:end], []byte{'\r'})
+	return bytes.Fields(line), end + 1, nil
+}
+
+// parseLength parses the line which starts at position, made of a type byte (* or $) followed by a length and CRLF.
+// It returns the length, and the position after the line.
+func parseLength(data []byte, position int, maxLength int) (int, int, error) {
+	end := bytes.Index(data[position:], crlf)
+	if end < 0 {
+		if len(data)-position > maxInlineLength {
+			return 0, 0, fmt.Errorf("%w: too big length line", ErrProtocol)
+		}
+		return 0, 0, ErrIncompleteCommand
+	}
+	length, err := strconv.Atoi(string(data[position+1 : position+end]))
+	if err != nil || length > maxLength {
+		return 0, 0, fmt.Errorf("%w: invalid length", ErrProtocol)
+	}
+	return length, position + end + len(crlf), nil
+}
@@ -0,0 +1,91 @@
+package resp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseACommand(t *testing.T) {
+	args, consumed, err := ParseCommand([]byte("*3\r\n$3\r\nSET\r\n$8\r\nDiskType\r\n$8\r\nNVMe SSD\r\n"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 41, consumed)
+	assert.Equal(t, [][]byte{[]byte("SET"), []byte("DiskType"), []byte("NVMe SSD")}, args)
+}
+
+func TestParseACommandWithABinaryArgument(t *testing.T) {
+	args, _, err := ParseCommand([]byte("*2\r\n$3\r\nGET\r\n$4\r\n\r\n\x00\xff\r\n"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("\r\n\x00\xff"), args[1])
+}
+
+func TestParseAnIncompleteCommandIncrementally(t *testing.T) {
+	command := []byte("*2\r\n$3\r\nGET\r\n$8\r\nDiskType\r\n")
+	for length := 0; length < len(command); length++ {
+		_, consumed, err := ParseCommand(command[:length])
+		assert.ErrorIs(t, err, ErrIncompleteCommand)
+		assert.Equal(t, 0, consumed)
+	}
+
+	args, consumed, err := ParseCommand(command)
+	assert.Nil(t, err)
+	assert.Equal(t, len(command), consumed)
+	assert.Equal(t, []byte("DiskType"), args[1])
+}
+
+func TestParsePipelinedCommands(t *testing.T) {
+	data := []byte("*1\r\n$4\r\nPING\r\n*2\r\n$3\r\nGET\r\n$8\r\nDiskType\r\n")
+
+	args, consumed, err := ParseCommand(data)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("PING")}, args)
+
+	args, _, err = ParseCommand(data[consumed:])
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("GET"), []byte("DiskType")}, args)
+}
+
+func TestParseAnInlineCommand(t *testing.T) {
+	args, consumed, err := ParseCommand([]byte("SET  DiskType SSD\r\nGET"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 19, consumed)
+	assert.Equal(t, [][]byte{[]byte("SET"), []byte("DiskType"), []byte("SSD")}, args)
+}
+
+func TestParseAnEmptyInlineCommand(t *testing.T) {
+	args, consumed, err := ParseCommand([]byte("\r\n"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, consumed)
+	assert.Empty(t, args)
+}
+
+func TestParseACommandWithAnInvalidBulkLength(t *testing.T) {
+	_, _, err := ParseCommand([]byte("*1\r\n$abc\r\n"))
+
+	assert.ErrorIs(t, err, ErrProtocol)
+}
+
+func TestParseACommandWithoutABulkString(t *testing.T) {
+	_, _, err := ParseCommand([]byte("*1\r\n:1\r\n"))
+
+	assert.ErrorIs(t, err, ErrProtocol)
+}
+
+func TestParseABulkStringWhichIsNotTerminatedByCRLF(t *testing.T) {
+	_, _, err := ParseCommand([]byte("*1\r\n$4\r\nPINGxx"))
+
+	assert.ErrorIs(t, err, ErrProtocol)
+}
+
+func TestAppendReplies(t *testing.T) {
+	assert.Equal(t, "+OK\r\n", string(AppendSimpleString(nil, "OK")))
+	assert.Equal(t, "-ERR syntax error\r\n", string(AppendError(nil, "ERR syntax error")))
+	assert.Equal(t, ":-10\r\n", string(AppendInteger(nil, -10)))
+	assert.Equal(t, "$3\r\nSSD\r\n", string(AppendBulkString(nil, []byte("SSD"))))
+	assert.Equal(t, "$0\r\n\r\n", string(AppendBulkString(nil, []byte{})))
+	assert.Equal(t, "$-1\r\n", string(AppendNullBulkString(nil)))
+	assert.Equal(t, "*2\r\n:1\r\n:2\r\n", string(AppendInteger(AppendInteger(AppendArray(nil, 2), 1), 2)))
+}
@@ -0,0 +1,44 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Reader reads commands from a (blocking) io.Reader, usually an incoming connection.
+// The bytes which are read beyond a command are kept for the next command, which allows clients to pipeline commands.
+type Reader struct {
+	reader io.Reader
+	buffer *bytes.Buffer
+	chunk  []byte
+}
+
+// NewReader creates a new instance of Reader.
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		reader: reader,
+		buffer: bytes.NewBuffer([]byte{}),
+		chunk:  make([]byte, 4096),
+	}
+}
+
+// ReadCommand reads a single command, blocking until a complete command has arrived.
+// The arguments are valid until the next invocation of ReadCommand.
+func (reader *Reader) ReadCommand() ([][]byte, error) {
+	for {
+		args, consumed, err := ParseCommand(reader.buffer.Bytes())
+		if err == nil {
+			reader.buffer.Next(consumed)
+			return args, nil
+		}
+		if !errors.Is(err, ErrIncompleteCommand) {
+			return nil, err
+		}
+		n, err := reader.reader.Read(reader.chunk)
+		reader.buffer.Write(reader.chunk[:n])
+		if err != nil && n == 0 {
+			return nil, err
+		}
+	}
+}
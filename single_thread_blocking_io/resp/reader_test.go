@@ -0,0 +1,24 @@
+package resp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReadPipelinedCommandsWhichArriveByteByByte(t *testing.T) {
+	reader := NewReader(iotest.OneByteReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$8\r\nDiskType\r\nPING\r\n")))
+
+	args, err := reader.ReadCommand()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("GET"), []byte("DiskType")}, args)
+
+	args, err = reader.ReadCommand()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("PING")}, args)
+
+	_, err = reader.ReadCommand()
+	assert.ErrorIs(t, err, io.EOF)
+}
@@ -1,11 +1,22 @@
 package single_thread_blocking_io
 
 import (
+	"bufio"
+	"encoding/json"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"net"
+	"net/http"
+	"os"
+	"path/filepath"
 	"single_thread_blocking_io/conn"
+	"single_thread_blocking_io/gateway"
 	"single_thread_blocking_io/proto"
+	"single_thread_blocking_io/store"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSendsAPutOrUpdateAndGetOverAConnection(t *testing.T) {
@@ -35,7 +46,7 @@ func TestSendsAPutOrUpdateAndGetOverAConnection(t *testing.T) {
 	message, err := connectionReader.AttemptReadOrErrorOut()
 
 	assert.Nil(t, err)
-	assert.Equal(t, "NVMe SSD", message.Value)
+	assert.Equal(t, "NVMe SSD", string(message.RawValue()))
 }
 
 func TestSendsMultiplePutOrUpdateAndAGetOverAConnection(t *testing.T) {
@@ -79,5 +90,884 @@ func TestSendsMultiplePutOrUpdateAndAGetOverAConnection(t *testing.T) {
 	message, err := attemptLastRead()
 
 	assert.Nil(t, err)
-	assert.Equal(t, "Distributed", message.Value)
+	assert.Equal(t, "Distributed", string(message.RawValue()))
+}
+
+func TestPipelinesRequestsAndCorrelatesResponsesByRequestId(t *testing.T) {
+	server, err := NewTCPServer("localhost", 7070)
+	assert.Nil(t, err)
+
+	go func() {
+		server.Start()
+	}()
+
+	defer func() {
+		server.Stop()
+	}()
+
+	connection, err := net.Dial("tcp", "localhost:7070")
+	assert.Nil(t, err)
+
+	const totalRequests = 10_000
+
+	go func() {
+		writer := bufio.NewWriter(connection)
+		for requestId := uint64(1); requestId <= totalRequests; requestId++ {
+			var message *proto.KeyValueMessage
+			if requestId%2 == 1 {
+				message = proto.NewPutOrUpdateKeyValueMessage(fmt.Sprintf("Key-%v", requestId), fmt.Sprintf("Value-%v", requestId))
+			} else {
+				message = proto.NewGetValueMessage(fmt.Sprintf("Key-%v", requestId-1))
+			}
+			buffer, _ := message.WithRequestId(requestId).Serialize()
+			_, _ = writer.Write(buffer)
+		}
+		_ = writer.Flush()
+	}()
+
+	_ = connection.SetReadDeadline(time.Now().Add(10 * time.Second))
+	reader := bufio.NewReader(connection)
+
+	responsesByRequestId := make(map[uint64]*proto.KeyValueMessage)
+	for count := 0; count < totalRequests; count++ {
+		message, err := proto.DeserializeFrom(reader)
+		if !assert.Nil(t, err) {
+			return
+		}
+		responsesByRequestId[message.RequestId] = message
+	}
+
+	assert.Equal(t, totalRequests, len(responsesByRequestId))
+	for requestId := uint64(1); requestId <= totalRequests; requestId++ {
+		response := responsesByRequestId[requestId]
+		if requestId%2 == 1 {
+			assert.Equal(t, proto.KeyValueMessageKindPutOrUpdate, response.Kind)
+			continue
+		}
+		assert.Equal(t, proto.KeyValueMessageKindGetResponse, response.Kind)
+		assert.Equal(t, fmt.Sprintf("Value-%v", requestId-1), string(response.RawValue()))
+	}
+}
+
+func TestAnswersACorruptFrameWithAFrameErrorOverAConnection(t *testing.T) {
+	server, err := NewTCPServer("localhost", 7077)
+	assert.Nil(t, err)
+
+	go func() {
+		server.Start()
+	}()
+
+	defer func() {
+		server.Stop()
+	}()
+
+	connection, err := net.Dial("tcp", "localhost:7077")
+	assert.Nil(t, err)
+
+	hello, _ := proto.NewHelloMessage(proto.ProtocolVersion, proto.FeatureChecksums).Serialize()
+	putOrUpdate, _ := proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe SSD").WithRequestId(1).SerializeWithChecksum()
+	get, _ := proto.NewGetValueMessage("DiskType").WithRequestId(2).SerializeWithChecksum()
+
+	faulty := newFaultyConnection(connection, len(hello)+proto.ReservedHeaderLength+2)
+	for _, buffer := range [][]byte{hello, putOrUpdate, get} {
+		_, _ = faulty.Write(buffer)
+	}
+
+	connectionReader := conn.NewConnectionReader(connection)
+	message, err := connectionReader.AttemptReadOrErrorOut()
+
+	assert.Nil(t, err)
+	assert.Equal(t, proto.KeyValueMessageKindHelloResponse, message.Kind)
+	assert.Equal(t, proto.FeatureChecksums, message.Features)
+
+	message, err = connectionReader.AttemptReadOrErrorOut()
+
+	assert.Nil(t, err)
+	assert.Equal(t, proto.KeyValueMessageKindFrameError, message.Kind)
+	assert.Equal(t, proto.Status_Corrupt, message.Status)
+
+	message, err = connectionReader.AttemptReadOrErrorOut()
+
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), message.RequestId)
+	assert.Equal(t, proto.Status_NotOk, message.Status)
+}
+
+func TestSendsRESPCommandsOverAConnection(t *testing.T) {
+	server, err := NewTCPServerWithProtocol("localhost", 7082, ProtocolRESP)
+	assert.Nil(t, err)
+
+	go func() {
+		server.Start()
+	}()
+
+	defer func() {
+		server.Stop()
+	}()
+
+	connection, err := net.Dial("tcp", "localhost:7082")
+	assert.Nil(t, err)
+
+	client := newRESPClient(connection)
+	for _, exchange := range []struct {
+		command []string
+		reply   string
+	}{
+		{[]string{"PING"}, "PONG"},
+		{[]string{"SET", "DiskType", "NVMe SSD"}, "OK"},
+		{[]string{"GET", "DiskType"}, `"NVMe SSD"`},
+		{[]string{"INCR", "counter"}, "(integer) 1"},
+		{[]string{"INCRBY", "counter", "10"}, "(integer) 11"},
+		{[]string{"INCR", "DiskType"}, "(error) ERR value is not an integer or out of range"},
+		{[]string{"DEL", "DiskType", "counter", "unknown"}, "(integer) 2"},
+		{[]string{"GET", "DiskType"}, "(nil)"},
+		{[]string{"FLUSHALL"}, "(error) ERR unknown command 'FLUSHALL'"},
+	} {
+		reply, err := client.do(exchange.command...)
+		assert.Nil(t, err)
+		assert.Equal(t, exchange.reply, reply)
+	}
+}
+
+func TestPipelinesRESPCommandsOverAConnection(t *testing.T) {
+	server, err := NewTCPServerWithProtocol("localhost", 7083, ProtocolRESP)
+	assert.Nil(t, err)
+
+	go func() {
+		server.Start()
+	}()
+
+	defer func() {
+		server.Stop()
+	}()
+
+	connection, err := net.Dial("tcp", "localhost:7083")
+	assert.Nil(t, err)
+
+	client := newRESPClient(connection)
+	for count := 1; count <= 100; count++ {
+		assert.Nil(t, client.send("INCR", "counter"))
+	}
+	for count := 1; count <= 100; count++ {
+		reply, err := client.receive()
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("(integer) %d", count), reply)
+	}
+
+	_, _ = connection.Write([]byte("*1\r\n$abc\r\n"))
+	reply, err := client.receive()
+	assert.Nil(t, err)
+	assert.Equal(t, "(error) ERR Protocol error: invalid length", reply)
+
+	_, err = client.receive()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestSendsMemcachedCommandsOverAConnection(t *testing.T) {
+	server, err := NewTCPServerWithProtocol("localhost", 7085, ProtocolMemcached)
+	assert.Nil(t, err)
+
+	go func() {
+		server.Start()
+	}()
+
+	defer func() {
+		server.Stop()
+	}()
+
+	connection, err := net.Dial("tcp", "localhost:7085")
+	assert.Nil(t, err)
+
+	_, _ = connection.Write([]byte(
+		"set DiskType 0 0 8\r\nNVMe SSD\r\n" +
+			"set Engine 0 0 8 noreply\r\nskiplist\r\n" +
+			"get DiskType Unknown Engine\r\n" +
+			"incr DiskType 1\r\n" +
+			"delete DiskType\r\n",
+	))
+
+	reader := bufio.NewReader(connection)
+	for _, reply := range []string{
+		"STORED",
+		"VALUE DiskType 0 8", "NVMe SSD", "VALUE Engine 0 8", "skiplist", "END",
+		"CLIENT_ERROR cannot increment or decrement non-numeric value",
+		"DELETED",
+	} {
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, reply+"\r\n", line)
+	}
+}
+
+func TestSharesTheStoreBetweenTheTCPServerAndTheHTTPGateway(t *testing.T) {
+	server, err := NewTCPServer("localhost", 7088)
+	assert.Nil(t, err)
+	assert.Nil(t, server.StartHTTPGateway("localhost", 7089))
+
+	go func() {
+		server.Start()
+	}()
+
+	defer func() {
+		server.Stop()
+	}()
+
+	connection, err := net.Dial("tcp", "localhost:7088")
+	assert.Nil(t, err)
+
+	buffer, _ := proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe SSD").Serialize()
+	_, _ = connection.Write(buffer)
+
+	connectionReader := conn.NewConnectionReader(connection)
+	_, err = connectionReader.AttemptReadOrErrorOut()
+	assert.Nil(t, err)
+
+	response, err := http.Get("http://localhost:7089/keys/DiskType")
+	assert.Nil(t, err)
+	defer response.Body.Close()
+
+	var keyValue gateway.KeyValue
+	assert.Nil(t, json.NewDecoder(response.Body).Decode(&keyValue))
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "NVMe SSD", keyValue.Value)
+
+	request, _ := http.NewRequest(http.MethodPut, "http://localhost:7089/keys/Engine", strings.NewReader("skiplist"))
+	response, err = http.DefaultClient.Do(request)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, response.StatusCode)
+
+	buffer, _ = proto.NewGetValueMessage("Engine").Serialize()
+	_, _ = connection.Write(buffer)
+
+	message, err := connectionReader.AttemptReadOrErrorOut()
+	assert.Nil(t, err)
+	assert.Equal(t, "skiplist", string(message.RawValue()))
+}
+
+func TestDetectsTheProtocolOfEveryConnectionOnASinglePort(t *testing.T) {
+	server, err := NewTCPServerWithProtocol("localhost", 7091, ProtocolAuto)
+	assert.Nil(t, err)
+
+	go func() {
+		server.Start()
+	}()
+
+	defer func() {
+		server.Stop()
+	}()
+
+	connection, err := net.Dial("tcp", "localhost:7091")
+	assert.Nil(t, err)
+
+	buffer, _ := proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe SSD").Serialize()
+	_, _ = connection.Write(buffer)
+
+	_, err = conn.NewConnectionReader(connection).AttemptReadOrErrorOut()
+	assert.Nil(t, err)
+	_ = connection.Close()
+
+	connection, err = net.Dial("tcp", "localhost:7091")
+	assert.Nil(t, err)
+
+	client := newRESPClient(connection)
+	reply, err := client.do("GET", "DiskType")
+	assert.Nil(t, err)
+	assert.Equal(t, `"NVMe SSD"`, reply)
+
+	reply, err = client.do("SET", "Engine", "skiplist")
+	assert.Nil(t, err)
+	assert.Equal(t, "OK", reply)
+	_ = connection.Close()
+
+	connection, err = net.Dial("tcp", "localhost:7091")
+	assert.Nil(t, err)
+
+	_, _ = connection.Write([]byte("get Engine\r\n"))
+	reader := bufio.NewReader(connection)
+	for _, reply := range []string{"VALUE Engine 0 8", "skiplist", "END"} {
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, reply+"\r\n", line)
+	}
+	_ = connection.Close()
+
+	response, err := http.Get("http://localhost:7091/keys/Engine")
+	assert.Nil(t, err)
+	defer response.Body.Close()
+
+	var keyValue gateway.KeyValue
+	assert.Nil(t, json.NewDecoder(response.Body).Decode(&keyValue))
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "skiplist", keyValue.Value)
+}
+
+func TestPushesTheNotificationsOfAWatchedKeyToAConnection(t *testing.T) {
+	server, err := NewTCPServer("localhost", 7093)
+	assert.Nil(t, err)
+	assert.Nil(t, server.StartHTTPGateway("localhost", 7094))
+
+	go func() {
+		server.Start()
+	}()
+
+	defer func() {
+		server.Stop()
+	}()
+
+	watchingConnection, err := net.Dial("tcp", "localhost:7093")
+	assert.Nil(t, err)
+
+	buffer, _ := proto.NewPrefixWatchMessage("Disk").WithRequestId(1).Serialize()
+	_, _ = watchingConnection.Write(buffer)
+
+	watchingConnectionReader := conn.NewConnectionReader(watchingConnection)
+	message, err := watchingConnectionReader.AttemptReadOrErrorOut()
+	assert.Nil(t, err)
+	assert.Equal(t, proto.KeyValueMessageKindWatchResponse, message.Kind)
+	assert.Equal(t, proto.Status_Ok, message.Status)
+
+	// a watching connection is not closed when it is idle, so the changes are made through the HTTP gateway,
+	// because the server serves the next connection only after the watching connection is closed.
+	time.Sleep(300 * time.Millisecond)
+
+	request, _ := http.NewRequest(http.MethodPut, "http://localhost:7094/keys/DiskType", strings.NewReader("NVMe SSD"))
+	response, err := http.DefaultClient.Do(request)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, response.StatusCode)
+
+	request, _ = http.NewRequest(http.MethodPut, "http://localhost:7094/keys/Engine", strings.NewReader("skiplist"))
+	_, err = http.DefaultClient.Do(request)
+	assert.Nil(t, err)
+
+	request, _ = http.NewRequest(http.MethodDelete, "http://localhost:7094/keys/DiskType", nil)
+	_, err = http.DefaultClient.Do(request)
+	assert.Nil(t, err)
+
+	message, err = watchingConnectionReader.AttemptReadOrErrorOut()
+	assert.Nil(t, err)
+	assert.Equal(t, proto.KeyValueMessageKindWatchNotification, message.Kind)
+	assert.Equal(t, uint64(1), message.RequestId)
+	assert.Equal(t, proto.Status_Ok, message.Status)
+	assert.Equal(t, "NVMe SSD", string(message.RawValue()))
+
+	message, err = watchingConnectionReader.AttemptReadOrErrorOut()
+	assert.Nil(t, err)
+	assert.Equal(t, proto.KeyValueMessageKindWatchNotification, message.Kind)
+	assert.Equal(t, "DiskType", string(message.RawKey()))
+	assert.Equal(t, proto.Status_NotOk, message.Status)
+}
+
+func TestPushesThePublishedMessagesToASubscribedConnection(t *testing.T) {
+	server, err := NewTCPServer("localhost", 7096)
+	assert.Nil(t, err)
+
+	go func() {
+		server.Start()
+	}()
+
+	defer func() {
+		server.Stop()
+	}()
+
+	connection, err := net.Dial("tcp", "localhost:7096")
+	assert.Nil(t, err)
+
+	connectionReader := conn.NewConnectionReader(connection)
+	buffer, _ := proto.NewPatternSubscribeMessage("news.*").WithRequestId(1).Serialize()
+	_, _ = connection.Write(buffer)
+
+	message, err := connectionReader.AttemptReadOrErrorOut()
+	assert.Nil(t, err)
+	assert.Equal(t, proto.KeyValueMessageKindSubscribeResponse, message.Kind)
+	assert.Equal(t, proto.Status_Ok, message.Status)
+
+	// the server serves the next connection only after the subscribed connection is closed, so the connection
+	// publishes to itself. The message is pushed by the goroutine of the subscriber, so it may arrive before
+	// the response to the Publish.
+	buffer, _ = proto.NewPublishMessage("news.sports", "kick-off").WithRequestId(2).Serialize()
+	_, _ = connection.Write(buffer)
+
+	messages := make(map[uint32]*proto.KeyValueMessage)
+	for count := 0; count < 2; count++ {
+		message, err = connectionReader.AttemptReadOrErrorOut()
+		assert.Nil(t, err)
+		messages[message.Kind] = message
+	}
+	assert.Equal(t, uint64(2), messages[proto.KeyValueMessageKindPublishResponse].RequestId)
+
+	message = messages[proto.KeyValueMessageKindChannelMessage]
+	assert.Equal(t, uint64(1), message.RequestId)
+	assert.Equal(t, "news.sports", string(message.RawKey()))
+	assert.Equal(t, "kick-off", string(message.RawValue()))
+}
+
+func TestExecutesATransactionWithAnOptimisticWatchOverAConnection(t *testing.T) {
+	server, err := NewTCPServer("localhost", 7098)
+	assert.Nil(t, err)
+	assert.Nil(t, server.StartHTTPGateway("localhost", 7099))
+
+	go func() {
+		server.Start()
+	}()
+
+	defer func() {
+		server.Stop()
+	}()
+
+	connection, err := net.Dial("tcp", "localhost:7098")
+	assert.Nil(t, err)
+
+	connectionReader := conn.NewConnectionReader(connection)
+	send := func(connection net.Conn, connectionReader conn.ConnectionReader, message *proto.KeyValueMessage) *proto.KeyValueMessage {
+		buffer, _ := message.Serialize()
+		_, _ = connection.Write(buffer)
+
+		response, err := connectionReader.AttemptReadOrErrorOut()
+		assert.Nil(t, err)
+		return response
+	}
+
+	for _, message := range []*proto.KeyValueMessage{
+		proto.NewOptimisticWatchMessage("Counter"),
+		proto.NewMultiMessage(),
+		proto.NewPutOrUpdateKeyValueMessage("Counter", "1"),
+	} {
+		assert.Equal(t, proto.Status_Ok, send(connection, connectionReader, message).Status)
+	}
+
+	// the server serves the next connection only after this connection is closed, so the watched key is changed
+	// through the HTTP gateway, which aborts the transaction.
+	request, _ := http.NewRequest(http.MethodPut, "http://localhost:7099/keys/Counter", strings.NewReader("10"))
+	httpResponse, err := http.DefaultClient.Do(request)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, httpResponse.StatusCode)
+
+	response := send(connection, connectionReader, proto.NewExecMessage())
+	assert.Equal(t, proto.KeyValueMessageKindExecResponse, response.Kind)
+	assert.Equal(t, proto.Status_Conflict, response.Status)
+
+	for _, message := range []*proto.KeyValueMessage{
+		proto.NewMultiMessage(),
+		proto.NewPutOrUpdateKeyValueMessage("Counter", "11"),
+		proto.NewGetValueMessage("Counter"),
+	} {
+		assert.Equal(t, proto.Status_Ok, send(connection, connectionReader, message).Status)
+	}
+
+	response = send(connection, connectionReader, proto.NewExecMessage())
+	assert.Equal(t, proto.Status_Ok, response.Status)
+	assert.Equal(t, 2, len(response.Pairs))
+	assert.Equal(t, "11", string(response.Pairs[1].RawValue()))
+}
+
+func TestAuthenticatesAConnectionBeforeServingIt(t *testing.T) {
+	server, err := NewTCPServer("localhost", 7104)
+	assert.Nil(t, err)
+
+	credential, _ := conn.NewUserCredential("alice", "wonderland")
+	authenticator, err := conn.NewAuthenticator(conn.AuthenticationConfig{Users: []conn.UserCredential{credential}})
+	assert.Nil(t, err)
+	assert.Nil(t, server.RequireAuthentication(authenticator))
+	assert.ErrorIs(t, server.StartHTTPGateway("localhost", 7105), ErrAuthenticationNotSupported)
+
+	go func() {
+		server.Start()
+	}()
+
+	defer func() {
+		server.Stop()
+	}()
+
+	connection, err := net.Dial("tcp", "localhost:7104")
+	assert.Nil(t, err)
+
+	connectionReader := conn.NewConnectionReader(connection)
+	send := func(message *proto.KeyValueMessage) *proto.KeyValueMessage {
+		buffer, _ := message.Serialize()
+		_, _ = connection.Write(buffer)
+
+		response, err := connectionReader.AttemptReadOrErrorOut()
+		assert.Nil(t, err)
+		return response
+	}
+
+	response := send(proto.NewGetValueMessage("DiskType"))
+	assert.Equal(t, proto.KeyValueMessageKindAuthResponse, response.Kind)
+	assert.Equal(t, proto.Status_Unauthenticated, response.Status)
+
+	assert.Equal(t, proto.Status_NotOk, send(proto.NewAuthPasswordMessage("alice", "looking-glass")).Status)
+	assert.Equal(t, proto.Status_Unauthenticated, send(proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe SSD")).Status)
+
+	response = send(proto.NewAuthPasswordMessage("alice", "wonderland"))
+	assert.Equal(t, proto.Status_Ok, response.Status)
+	assert.Equal(t, "alice", string(response.RawKey()))
+
+	assert.Equal(t, proto.Status_Ok, send(proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe SSD")).Status)
+	response = send(proto.NewGetValueMessage("DiskType"))
+	assert.Equal(t, proto.KeyValueMessageKindGetResponse, response.Kind)
+	assert.Equal(t, "NVMe SSD", string(response.RawValue()))
+}
+
+func TestRefusesTheConnectionsWhichCanNotAuthenticate(t *testing.T) {
+	server, err := NewTCPServerWithProtocol("localhost", 7106, ProtocolAuto)
+	assert.Nil(t, err)
+
+	authenticator, _ := conn.NewAuthenticator(conn.AuthenticationConfig{Token: "s3cr3t"})
+	assert.Nil(t, server.RequireAuthentication(authenticator))
+
+	go func() {
+		server.Start()
+	}()
+
+	defer func() {
+		server.Stop()
+	}()
+
+	connection, err := net.Dial("tcp", "localhost:7106")
+	assert.Nil(t, err)
+
+	_, _ = connection.Write([]byte("*2\r\n$3\r\nGET\r\n$8\r\nDiskType\r\n"))
+	// the connection is closed (or reset) without a reply.
+	n, err := connection.Read(make([]byte, 64))
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, n)
+
+	respServer, err := NewTCPServerWithProtocol("localhost", 7107, ProtocolRESP)
+	assert.Nil(t, err)
+	defer respServer.Stop()
+	assert.ErrorIs(t, respServer.RequireAuthentication(authenticator), ErrAuthenticationNotSupported)
+}
+
+func TestAuthorizesTheRequestsOfAConnectionWithTheReloadedACL(t *testing.T) {
+	server, err := NewTCPServer("localhost", 7109)
+	assert.Nil(t, err)
+
+	path := filepath.Join(t.TempDir(), "acl.json")
+	writeACL := func(keys []string, modifiedAt time.Time) {
+		buffer, _ := json.Marshal(conn.ACLConfig{Rules: []conn.ACLRule{
+			{User: conn.TokenUsername, Permissions: []conn.Permission{conn.PermissionGet, conn.PermissionPut}, Keys: keys},
+		}})
+		assert.Nil(t, os.WriteFile(path, buffer, 0o600))
+		assert.Nil(t, os.Chtimes(path, modifiedAt, modifiedAt))
+	}
+	now := time.Now()
+	writeACL([]string{"orders.*"}, now)
+	acl, err := conn.LoadACL(path)
+	assert.Nil(t, err)
+
+	assert.ErrorIs(t, server.RequireAuthorization(acl), ErrAuthenticationRequired)
+	authenticator, _ := conn.NewAuthenticator(conn.AuthenticationConfig{Token: "s3cr3t"})
+	assert.Nil(t, server.RequireAuthentication(authenticator))
+	assert.Nil(t, server.RequireAuthorization(acl))
+
+	go func() {
+		server.Start()
+	}()
+
+	defer func() {
+		server.Stop()
+	}()
+
+	connection, err := net.Dial("tcp", "localhost:7109")
+	assert.Nil(t, err)
+
+	connectionReader := conn.NewConnectionReader(connection)
+	send := func(message *proto.KeyValueMessage) *proto.KeyValueMessage {
+		buffer, _ := message.Serialize()
+		_, _ = connection.Write(buffer)
+
+		response, err := connectionReader.AttemptReadOrErrorOut()
+		assert.Nil(t, err)
+		return response
+	}
+
+	assert.Equal(t, proto.Status_Ok, send(proto.NewAuthTokenMessage("s3cr3t")).Status)
+	assert.Equal(t, proto.Status_Ok, send(proto.NewPutOrUpdateKeyValueMessage("orders.1", "NVMe SSD")).Status)
+
+	response := send(proto.NewPutOrUpdateKeyValueMessage("invoices.1", "NVMe SSD"))
+	assert.Equal(t, proto.KeyValueMessageKindAuthResponse, response.Kind)
+	assert.Equal(t, proto.Status_PermissionDenied, response.Status)
+
+	writeACL([]string{"orders.*", "invoices.*"}, now.Add(time.Second))
+	assert.Eventually(t, func() bool {
+		return send(proto.NewPutOrUpdateKeyValueMessage("invoices.1", "NVMe SSD")).Status == proto.Status_Ok
+	}, 5*ACLReloadInterval, 100*time.Millisecond)
+}
+
+func TestPingsAnIdleConnectionAndClosesItIfItDoesNotAnswer(t *testing.T) {
+	server, err := NewTCPServer("localhost", 7111)
+	assert.Nil(t, err)
+	server.SetKeepalive(conn.Keepalive{IdleTimeout: 300 * time.Millisecond, PongTimeout: time.Second})
+
+	go func() {
+		server.Start()
+	}()
+
+	defer func() {
+		server.Stop()
+	}()
+
+	connection, err := net.Dial("tcp", "localhost:7111")
+	assert.Nil(t, err)
+
+	read := func() (*proto.KeyValueMessage, error) {
+		_ = connection.SetReadDeadline(time.Now().Add(2 * time.Second))
+		return proto.DeserializeFrom(connection)
+	}
+	buffer, _ := proto.NewPingMessage().WithRequestId(1).Serialize()
+	_, _ = connection.Write(buffer)
+
+	response, err := read()
+	assert.Nil(t, err)
+	assert.Equal(t, proto.KeyValueMessageKindPong, response.Kind)
+	assert.Equal(t, uint64(1), response.RequestId)
+
+	ping, err := read()
+	assert.Nil(t, err)
+	assert.Equal(t, proto.KeyValueMessageKindPing, ping.Kind)
+
+	buffer, _ = proto.NewPongMessage().Serialize()
+	_, _ = connection.Write(buffer)
+
+	ping, err = read()
+	assert.Nil(t, err)
+	assert.Equal(t, proto.KeyValueMessageKindPing, ping.Kind)
+
+	// the second ping is not answered, so the connection is closed.
+	_, err = read()
+	assert.NotNil(t, err)
+	assert.NotErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestClosesAnIdleConnectionWhichAnswersThePingsSoTheNextOneIsServed(t *testing.T) {
+	server, err := NewTCPServer("localhost", 7119)
+	assert.Nil(t, err)
+	server.SetKeepalive(conn.Keepalive{IdleTimeout: 100 * time.Millisecond, PongTimeout: time.Second, MaxIdleTime: 500 * time.Millisecond})
+
+	go func() {
+		server.Start()
+	}()
+
+	defer func() {
+		server.Stop()
+	}()
+
+	idle, err := net.Dial("tcp", "localhost:7119")
+	assert.Nil(t, err)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			_ = idle.SetReadDeadline(time.Now().Add(2 * time.Second))
+			message, err := proto.DeserializeFrom(idle)
+			if err != nil {
+				return
+			}
+			if message.Kind == proto.KeyValueMessageKindPing {
+				buffer, _ := proto.NewPongMessage().Serialize()
+				_, _ = idle.Write(buffer)
+			}
+		}
+	}()
+
+	connection, err := net.Dial("tcp", "localhost:7119")
+	assert.Nil(t, err)
+	buffer, _ := proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe SSD").Serialize()
+	_, _ = connection.Write(buffer)
+
+	// the idle connection answers every ping, and is closed after the MaxIdleTime.
+	_ = connection.SetReadDeadline(time.Now().Add(3 * time.Second))
+	response, err := proto.DeserializeFrom(connection)
+	assert.Nil(t, err)
+	assert.Equal(t, proto.Status_Ok, response.Status)
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		assert.Fail(t, "idle connection is not closed")
+	}
+}
+
+func TestStreamsALargeValueInChunksOverAConnection(t *testing.T) {
+	server, err := NewTCPServer("localhost", 7113)
+	assert.Nil(t, err)
+
+	go func() {
+		server.Start()
+	}()
+
+	defer func() {
+		server.Stop()
+	}()
+
+	connection, err := net.Dial("tcp", "localhost:7113")
+	assert.Nil(t, err)
+
+	value := make([]byte, 3<<20)
+	for index := range value {
+		value[index] = byte(index % 251)
+	}
+	write := func(message *proto.KeyValueMessage) {
+		buffer, _ := message.Serialize()
+		_, _ = connection.Write(buffer)
+	}
+	write(proto.NewPutStreamBeginMessage(1, "DiskImage", 0))
+	for offset := 0; offset < len(value); offset += conn.StreamChunkSize {
+		write(proto.NewStreamChunkMessage(1, value[offset:min(offset+conn.StreamChunkSize, len(value))]))
+	}
+	write(proto.NewStreamEndMessage(1))
+
+	connectionReader := conn.NewConnectionReader(connection)
+	response, err := connectionReader.AttemptReadOrErrorOut()
+	assert.Nil(t, err)
+	assert.Equal(t, proto.KeyValueMessageKindStreamResponse, response.Kind)
+	assert.Equal(t, proto.Status_Ok, response.Status)
+
+	write(proto.NewGetStreamMessage(2, "DiskImage", 0))
+	begin, err := connectionReader.AttemptReadOrErrorOut()
+	assert.Nil(t, err)
+	assert.Equal(t, proto.KeyValueMessageKindStreamBegin, begin.Kind)
+	assert.Equal(t, response.Version, begin.Version)
+
+	var streamed []byte
+	for {
+		message, err := connectionReader.AttemptReadOrErrorOut()
+		assert.Nil(t, err)
+		if err != nil || message.Kind == proto.KeyValueMessageKindStreamEnd {
+			break
+		}
+		assert.Equal(t, proto.KeyValueMessageKindStreamChunk, message.Kind)
+		streamed = append(streamed, message.RawValue()...)
+	}
+	assert.Equal(t, value, streamed)
+}
+
+func TestServesTheKeyValuePairsOfTheGivenStore(t *testing.T) {
+	kvStore := store.NewInMemoryStore()
+	kvStore.PutOrUpdate([]byte("DiskType"), []byte("NVMe SSD"))
+
+	server, err := NewTCPServerWithStore("localhost", 7115, ProtocolProtobuf, kvStore)
+	assert.Nil(t, err)
+
+	go func() {
+		server.Start()
+	}()
+
+	defer func() {
+		server.Stop()
+	}()
+
+	connection, err := net.Dial("tcp", "localhost:7115")
+	assert.Nil(t, err)
+
+	buffer, _ := proto.NewGetValueMessage("DiskType").Serialize()
+	_, _ = connection.Write(buffer)
+	buffer, _ = proto.NewPutOrUpdateKeyValueMessage("Storage", "LSM").Serialize()
+	_, _ = connection.Write(buffer)
+
+	connectionReader := conn.NewConnectionReader(connection)
+	message, err := connectionReader.AttemptReadOrErrorOut()
+	assert.Nil(t, err)
+	assert.Equal(t, "NVMe SSD", string(message.RawValue()))
+
+	_, err = connectionReader.AttemptReadOrErrorOut()
+	assert.Nil(t, err)
+
+	value, ok := kvStore.GetValue([]byte("Storage"))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("LSM"), value)
+}
+
+func TestPersistsTheKeyValuePairsAcrossARestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.wal")
+	kvStore, err := store.OpenDurableStore(path, store.SyncPolicy{Mode: store.SyncAlways}, store.NewInMemoryStore())
+	assert.Nil(t, err)
+
+	server, err := NewTCPServerWithStore("localhost", 7117, ProtocolProtobuf, kvStore)
+	assert.Nil(t, err)
+
+	go func() {
+		server.Start()
+	}()
+
+	connection, err := net.Dial("tcp", "localhost:7117")
+	assert.Nil(t, err)
+
+	buffer, _ := proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe SSD").Serialize()
+	_, _ = connection.Write(buffer)
+
+	// the put is acknowledged once it is synced to the write-ahead log.
+	connectionReader := conn.NewConnectionReader(connection)
+	message, err := connectionReader.AttemptReadOrErrorOut()
+	assert.Nil(t, err)
+	assert.Equal(t, proto.Status_Ok, message.Status)
+	assert.Equal(t, kvStore.Appended(), kvStore.Synced())
+
+	server.Stop()
+	assert.Nil(t, kvStore.Close())
+
+	kvStore, err = store.OpenDurableStore(path, store.SyncPolicy{Mode: store.SyncAlways}, store.NewInMemoryStore())
+	assert.Nil(t, err)
+	defer func() {
+		_ = kvStore.Close()
+	}()
+	value, ok := kvStore.GetValue([]byte("DiskType"))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("NVMe SSD"), value)
+}
+
+func TestRestartsADurableServerWithItsKeyValuePairs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.wal")
+	policy := store.SyncPolicy{Mode: store.SyncAlways}
+	server, err := NewDurableTCPServer("localhost", 7121, ProtocolProtobuf, path, policy)
+	assert.Nil(t, err)
+
+	go func() {
+		server.Start()
+	}()
+
+	connection, err := net.Dial("tcp", "localhost:7121")
+	assert.Nil(t, err)
+	connectionReader := conn.NewConnectionReader(connection)
+	for _, message := range []*proto.KeyValueMessage{
+		proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe SSD"),
+		proto.NewPutOrUpdateKeyValueMessage("Engine", "btree"),
+		proto.NewDeleteMessage("Engine"),
+	} {
+		buffer, _ := message.Serialize()
+		_, _ = connection.Write(buffer)
+		response, err := connectionReader.AttemptReadOrErrorOut()
+		assert.Nil(t, err)
+		assert.Equal(t, proto.Status_Ok, response.Status)
+	}
+	_ = connection.Close()
+	server.Stop()
+
+	// the restarted server replays the write-ahead log which is closed by Stop.
+	server, err = NewDurableTCPServer("localhost", 7123, ProtocolProtobuf, path, policy)
+	assert.Nil(t, err)
+
+	go func() {
+		server.Start()
+	}()
+	defer func() {
+		server.Stop()
+	}()
+
+	connection, err = net.Dial("tcp", "localhost:7123")
+	assert.Nil(t, err)
+	connectionReader = conn.NewConnectionReader(connection)
+	for key, status := range map[string]proto.Status{"DiskType": proto.Status_Ok, "Engine": proto.Status_NotOk} {
+		buffer, _ := proto.NewGetValueMessage(key).Serialize()
+		_, _ = connection.Write(buffer)
+		response, err := connectionReader.AttemptReadOrErrorOut()
+		assert.Nil(t, err)
+		assert.Equal(t, status, response.Status)
+	}
+}
+
+func TestDoesNotCreateADurableServerWithAnInvalidSyncPolicy(t *testing.T) {
+	_, err := NewDurableTCPServer("localhost", 0, ProtocolProtobuf, filepath.Join(t.TempDir(), "store.wal"), store.SyncPolicy{Mode: store.SyncPeriodically})
+
+	assert.ErrorIs(t, err, store.ErrInvalidSyncPolicy)
 }
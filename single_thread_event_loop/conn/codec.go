@@ -0,0 +1,135 @@
+package conn
+
+import (
+	"bytes"
+	"errors"
+	"single_thread_eventloop/proto"
+	"time"
+)
+
+var ErrIncompleteRequest = errors.New("incomplete request, more bytes are needed")
+
+// Codec decodes the requests from the bytes which are read from a connection, and answers them.
+// A non-blocking client reads whatever is available, so a Codec must be able to decode a request incrementally.
+type Codec interface {
+	// Answer answers the first request in the buffer, and consumes it from the buffer.
+	// It returns ErrIncompleteRequest (without consuming anything) if the buffer does not hold a complete request.
+	// Any other error closes the connection, after the returned response (if any) is written.
+	Answer(buffer *bytes.Buffer) ([]byte, error)
+}
+
+// PushingCodec is a Codec whose connections can receive pushed (unsolicited) frames, such as the notifications of
+// the watched keys.
+type PushingCodec interface {
+	Codec
+	// PushTo makes the codec push the frames with the given function, and disconnect the connection with
+	// the disconnect function (such as when it does not keep up with the pushed frames). Both must be safe for
+	// concurrent use with the writes of the responses.
+	PushTo(push func(frame []byte) error, disconnect func())
+	// Close removes the subscriptions of the connection, once the connection is closed.
+	Close()
+}
+
+// PullingCodec is a Codec whose responses may be followed by the frames which are pulled by the connection, such as
+// the chunks of a get stream (see Session.Pull). A connection pulls the frames once the response is written, and
+// writes each of them before pulling the next one, so the frames are never held as a whole.
+type PullingCodec interface {
+	Codec
+	// Pull returns the next frame to be written, or nil if there is none.
+	Pull() ([]byte, error)
+}
+
+// KeepaliveCodec is a Codec whose connections are pinged by the server when they are idle, and closed if they do not
+// answer the ping in time (see Liveness).
+type KeepaliveCodec interface {
+	Codec
+	// Ping returns the ping to be written to the connection at the given time, or nil if no ping is due.
+	// It returns ErrNotAlive if the connection has not answered the previous ping in time.
+	Ping(now time.Time) ([]byte, error)
+}
+
+// ProtobufCodec is the Codec for the length prefixed protobuf frames of the proto package.
+type ProtobufCodec struct {
+	handlers map[uint32]Handler
+	session  *Session
+	liveness *Liveness
+}
+
+// NewProtobufCodec creates a new instance of ProtobufCodec. A ProtobufCodec holds the Session of a connection,
+// so it must not be shared between connections. The connection must authenticate first if the handlers require
+// authentication (see NewSessionFor). The connection is kept alive with the DefaultKeepalive.
+func NewProtobufCodec(handlers map[uint32]Handler) *ProtobufCodec {
+	return NewProtobufCodecWithKeepalive(handlers, DefaultKeepalive)
+}
+
+// NewProtobufCodecWithKeepalive creates a new instance of ProtobufCodec (see NewProtobufCodec), whose connection is
+// pinged by the server when it is idle, and closed if it does not answer, as configured by the keepalive.
+func NewProtobufCodecWithKeepalive(handlers map[uint32]Handler, keepalive Keepalive) *ProtobufCodec {
+	return &ProtobufCodec{
+		handlers: handlers,
+		session:  NewSessionFor(handlers),
+		liveness: NewLiveness(keepalive, time.Now()),
+	}
+}
+
+// Answer answers the first frame in the buffer, with the Handler for the kind of its message.
+// A Pong (which answers the Ping of the server) is answered with an empty response.
+// A corrupt request frame is answered with a proto.KeyValueMessageKindFrameError response. The connection continues
+// after a corrupt frame (such as a checksum mismatch), because the frame has been consumed; it is closed after
+// a malformed frame, because the position of the next frame is unknown.
+// A request of a kind which has no Handler is answered with proto.Status_NotOk (see Session.UnknownKindResponse).
+func (codec *ProtobufCodec) Answer(buffer *bytes.Buffer) ([]byte, error) {
+	keyValueMessage, err := proto.DeserializeFromBuffer(buffer)
+	if err != nil {
+		if errors.Is(err, proto.ErrIncompleteFrame) {
+			return nil, ErrIncompleteRequest
+		}
+		if errors.Is(err, proto.ErrCorruptFrame) {
+			return codec.session.FrameErrorResponse()
+		}
+		if errors.Is(err, proto.ErrMalformedFrame) {
+			response, _ := codec.session.FrameErrorResponse()
+			return response, err
+		}
+		return nil, err
+	}
+	if keyValueMessage.Kind == proto.KeyValueMessageKindPing || keyValueMessage.Kind == proto.KeyValueMessageKindPong {
+		codec.liveness.Heard(time.Now())
+	} else {
+		codec.liveness.HeardRequest(time.Now())
+	}
+	handler, ok := codec.handlers[keyValueMessage.Kind]
+	if !ok {
+		return codec.session.UnknownKindResponse(keyValueMessage)
+	}
+	return codec.session.Handle(handler, keyValueMessage)
+}
+
+// Ping returns the Ping of the server if the connection has been idle for too long (see Liveness).
+func (codec *ProtobufCodec) Ping(now time.Time) ([]byte, error) {
+	ping, err := codec.liveness.Check(now)
+	if err != nil || !ping {
+		return nil, err
+	}
+	return codec.session.PingRequest()
+}
+
+// PushTo makes the Session of the codec push the notifications with the given functions.
+func (codec *ProtobufCodec) PushTo(push func(frame []byte) error, disconnect func()) {
+	codec.session.PushTo(push, disconnect)
+}
+
+// Pull returns the next frame of the get streams of the Session of the codec.
+func (codec *ProtobufCodec) Pull() ([]byte, error) {
+	return codec.session.Pull()
+}
+
+// Identity returns the authenticated identity of the connection, and false if the connection is not authenticated.
+func (codec *ProtobufCodec) Identity() (Identity, bool) {
+	return codec.session.Identity()
+}
+
+// Close closes the Session of the codec.
+func (codec *ProtobufCodec) Close() {
+	codec.session.Close()
+}
@@ -0,0 +1,131 @@
+package conn
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"single_thread_eventloop/proto"
+	store2 "single_thread_eventloop/store"
+	"testing"
+	"time"
+)
+
+func TestProtobufCodecWithAnIncompleteFrame(t *testing.T) {
+	codec := NewProtobufCodec(NewHandlers(store2.NewInMemoryStore()))
+	frame, _ := proto.NewGetValueMessage("DiskType").Serialize()
+
+	buffer := bytes.NewBuffer(frame[:len(frame)-1])
+	_, err := codec.Answer(buffer)
+
+	assert.ErrorIs(t, err, ErrIncompleteRequest)
+	assert.Equal(t, len(frame)-1, buffer.Len())
+}
+
+func TestProtobufCodecAnswersPipelinedFrames(t *testing.T) {
+	codec := NewProtobufCodec(NewHandlers(store2.NewInMemoryStore()))
+	putOrUpdate, _ := proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe SSD").Serialize()
+	get, _ := proto.NewGetValueMessage("DiskType").Serialize()
+
+	buffer := bytes.NewBuffer(append(putOrUpdate, get...))
+	_, err := codec.Answer(buffer)
+	assert.Nil(t, err)
+
+	response, err := codec.Answer(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, buffer.Len())
+
+	message, _ := proto.DeserializeFrom(bytes.NewReader(response))
+	assert.Equal(t, "NVMe SSD", string(message.RawValue()))
+}
+
+func TestProtobufCodecAnswersACorruptFrameWithAFrameError(t *testing.T) {
+	codec := NewProtobufCodec(NewHandlers(store2.NewInMemoryStore()))
+	frame, _ := proto.NewGetValueMessage("DiskType").SerializeWithChecksum()
+	frame[proto.ReservedHeaderLength] ^= 1
+
+	response, err := codec.Answer(bytes.NewBuffer(frame))
+	assert.Nil(t, err)
+
+	message, _ := proto.DeserializeFrom(bytes.NewReader(response))
+	assert.Equal(t, proto.KeyValueMessageKindFrameError, message.Kind)
+}
+
+func TestProtobufCodecAnswersAnUnknownKindWithNotOk(t *testing.T) {
+	codec := NewProtobufCodec(NewHandlers(store2.NewInMemoryStore()))
+	request := &proto.KeyValueMessage{Kind: 1000, RequestId: 7}
+	frame, _ := request.Serialize()
+
+	response, err := codec.Answer(bytes.NewBuffer(frame))
+	assert.Nil(t, err)
+
+	message, _ := proto.DeserializeFrom(bytes.NewReader(response))
+	assert.Equal(t, proto.KeyValueMessageKindFrameError, message.Kind)
+	assert.Equal(t, proto.Status_NotOk, message.Status)
+	assert.Equal(t, uint64(7), message.RequestId)
+}
+
+func TestProtobufCodecPushesTheNotificationsOfTheWatchedKeys(t *testing.T) {
+	handlers := NewHandlers(store2.NewInMemoryStore())
+	codec := NewProtobufCodec(handlers)
+
+	pushed := make(chan []byte, 16)
+	codec.PushTo(func(frame []byte) error {
+		pushed <- frame
+		return nil
+	}, func() {})
+
+	watch, _ := proto.NewWatchMessage("DiskType").Serialize()
+	response, err := codec.Answer(bytes.NewBuffer(watch))
+	assert.Nil(t, err)
+
+	message, _ := proto.DeserializeFrom(bytes.NewReader(response))
+	assert.Equal(t, proto.KeyValueMessageKindWatchResponse, message.Kind)
+	assert.Equal(t, proto.Status_Ok, message.Status)
+
+	_, err = handlers[proto.KeyValueMessageKindPutOrUpdate].Handle(proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe SSD"))
+	assert.Nil(t, err)
+
+	var frame []byte
+	select {
+	case frame = <-pushed:
+	case <-time.After(time.Second):
+		assert.Fail(t, "no notification is pushed")
+	}
+	message, _ = proto.DeserializeFrom(bytes.NewReader(frame))
+	assert.Equal(t, proto.KeyValueMessageKindWatchNotification, message.Kind)
+	assert.Equal(t, "NVMe SSD", string(message.RawValue()))
+
+	codec.Close()
+	_, err = handlers[proto.KeyValueMessageKindPutOrUpdate].Handle(proto.NewPutOrUpdateKeyValueMessage("DiskType", "HDD"))
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, len(pushed))
+}
+
+func TestProtobufCodecPingsAnIdleConnectionTillItAnswers(t *testing.T) {
+	codec := NewProtobufCodecWithKeepalive(
+		NewHandlers(store2.NewInMemoryStore()),
+		Keepalive{IdleTimeout: time.Millisecond, PongTimeout: time.Hour},
+	)
+	time.Sleep(2 * time.Millisecond)
+
+	ping, err := codec.Ping(time.Now())
+	assert.Nil(t, err)
+	message, _ := proto.DeserializeFrom(bytes.NewReader(ping))
+	assert.Equal(t, proto.KeyValueMessageKindPing, message.Kind)
+
+	ping, err = codec.Ping(time.Now())
+	assert.Nil(t, err)
+	assert.Nil(t, ping)
+
+	pong, _ := proto.NewPongMessage().Serialize()
+	response, err := codec.Answer(bytes.NewBuffer(pong))
+	assert.Nil(t, err)
+	assert.Empty(t, response)
+
+	ping, err = codec.Ping(time.Now().Add(time.Second))
+	assert.Nil(t, err)
+	assert.NotNil(t, ping)
+
+	_, err = codec.Ping(time.Now().Add(2 * time.Hour))
+	assert.ErrorIs(t, err, ErrNotAlive)
+}
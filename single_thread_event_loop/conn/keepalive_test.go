@@ -0,0 +1,78 @@
+package conn
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLivenessPingsAnIdleConnectionOnce(t *testing.T) {
+	now := time.Now()
+	liveness := NewLiveness(Keepalive{IdleTimeout: time.Second, PongTimeout: time.Second}, now)
+
+	ping, err := liveness.Check(now.Add(500 * time.Millisecond))
+	assert.Nil(t, err)
+	assert.False(t, ping)
+
+	ping, err = liveness.Check(now.Add(time.Second))
+	assert.Nil(t, err)
+	assert.True(t, ping)
+
+	ping, err = liveness.Check(now.Add(1500 * time.Millisecond))
+	assert.Nil(t, err)
+	assert.False(t, ping)
+}
+
+func TestLivenessKeepsAConnectionWhichAnswersThePingAlive(t *testing.T) {
+	now := time.Now()
+	liveness := NewLiveness(Keepalive{IdleTimeout: time.Second, PongTimeout: time.Second}, now)
+
+	ping, _ := liveness.Check(now.Add(time.Second))
+	assert.True(t, ping)
+	liveness.Heard(now.Add(1500 * time.Millisecond))
+
+	ping, err := liveness.Check(now.Add(2 * time.Second))
+	assert.Nil(t, err)
+	assert.False(t, ping)
+
+	ping, err = liveness.Check(now.Add(2500 * time.Millisecond))
+	assert.Nil(t, err)
+	assert.True(t, ping)
+}
+
+func TestLivenessGivesUpOnAConnectionWhichDoesNotAnswerThePing(t *testing.T) {
+	now := time.Now()
+	liveness := NewLiveness(Keepalive{IdleTimeout: time.Second, PongTimeout: time.Second}, now)
+
+	ping, _ := liveness.Check(now.Add(time.Second))
+	assert.True(t, ping)
+
+	_, err := liveness.Check(now.Add(2 * time.Second))
+	assert.ErrorIs(t, err, ErrNotAlive)
+}
+
+func TestLivenessWithoutAnIdleTimeoutNeverPings(t *testing.T) {
+	now := time.Now()
+	liveness := NewLiveness(Keepalive{}, now)
+
+	ping, err := liveness.Check(now.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.False(t, ping)
+}
+
+func TestLivenessGivesUpOnAConnectionWhichOnlyAnswersThePingsForTheMaxIdleTime(t *testing.T) {
+	now := time.Now()
+	liveness := NewLiveness(Keepalive{IdleTimeout: time.Second, PongTimeout: time.Second, MaxIdleTime: 3 * time.Second}, now)
+
+	liveness.HeardRequest(now.Add(time.Second))
+	ping, err := liveness.Check(now.Add(2 * time.Second))
+	assert.Nil(t, err)
+	assert.True(t, ping)
+
+	liveness.Heard(now.Add(2500 * time.Millisecond))
+	_, err = liveness.Check(now.Add(3500 * time.Millisecond))
+	assert.Nil(t, err)
+
+	_, err = liveness.Check(now.Add(4 * time.Second))
+	assert.ErrorIs(t, err, ErrIdle)
+}
@@ -0,0 +1,375 @@
+package conn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"single_thread_eventloop/proto"
+	store2 "single_thread_eventloop/store"
+	"testing"
+	"time"
+)
+
+func TestSessionWithoutHelloDoesNotAddChecksums(t *testing.T) {
+	session := NewSession()
+
+	buffer, err := session.Handle(NewGetHandler(store2.NewInMemoryStore()), proto.NewGetValueMessage("DiskType"))
+
+	assert.Nil(t, err)
+	assert.Zero(t, binary.LittleEndian.Uint32(buffer)&proto.ChecksumFlag)
+}
+
+func TestSessionAddsChecksumsAfterTheyAreAgreed(t *testing.T) {
+	session := NewSession()
+
+	buffer, err := session.Handle(NewHelloHandler(), proto.NewHelloMessage(proto.ProtocolVersion, proto.FeatureChecksums))
+
+	assert.Nil(t, err)
+	assert.Zero(t, binary.LittleEndian.Uint32(buffer)&proto.ChecksumFlag)
+	assert.True(t, session.Has(proto.FeatureChecksums))
+
+	buffer, err = session.Handle(NewGetHandler(store2.NewInMemoryStore()), proto.NewGetValueMessage("DiskType"))
+
+	assert.Nil(t, err)
+	assert.NotZero(t, binary.LittleEndian.Uint32(buffer)&proto.ChecksumFlag)
+
+	response, err := proto.DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, proto.KeyValueMessageKindGetResponse, response.Kind)
+}
+
+func TestSessionFrameErrorResponse(t *testing.T) {
+	buffer, err := NewSession().FrameErrorResponse()
+
+	assert.Nil(t, err)
+
+	response, _ := proto.DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Equal(t, proto.KeyValueMessageKindFrameError, response.Kind)
+	assert.Equal(t, proto.Status_Corrupt, response.Status)
+}
+
+func TestSessionCompressesLargeResponsesAfterCompressionIsAgreed(t *testing.T) {
+	store := store2.NewInMemoryStore()
+	store.PutOrUpdate([]byte("DiskType"), bytes.Repeat([]byte("NVMe SSD "), CompressionThreshold))
+	store.PutOrUpdate([]byte("Storage"), []byte("LSM"))
+
+	session := NewSession()
+	_, err := session.Handle(NewHelloHandler(), proto.NewHelloMessage(proto.ProtocolVersion, proto.FeatureCompression))
+
+	assert.Nil(t, err)
+
+	buffer, err := session.Handle(NewGetHandler(store), proto.NewGetValueMessage("DiskType"))
+
+	assert.Nil(t, err)
+	assert.NotZero(t, binary.LittleEndian.Uint32(buffer)&proto.CompressionFlag)
+
+	response, err := proto.DeserializeFrom(bytes.NewReader(buffer))
+
+	assert.Nil(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("NVMe SSD "), CompressionThreshold), response.RawValue())
+
+	buffer, err = session.Handle(NewGetHandler(store), proto.NewGetValueMessage("Storage"))
+
+	assert.Nil(t, err)
+	assert.Zero(t, binary.LittleEndian.Uint32(buffer)&proto.CompressionFlag)
+}
+
+func TestSessionPushesTheNotificationsOfTheWatchedKeys(t *testing.T) {
+	store := store2.NewInMemoryStore()
+	handlers := NewHandlers(store)
+
+	pushed := make(chan []byte, 16)
+	session := NewSession()
+	session.PushTo(func(frame []byte) error {
+		pushed <- frame
+		return nil
+	}, func() {})
+
+	buffer, err := session.Handle(handlers[proto.KeyValueMessageKindWatch], proto.NewWatchMessage("DiskType").WithRequestId(3))
+	assert.Nil(t, err)
+
+	response, err := proto.DeserializeFrom(bytes.NewReader(buffer))
+	assert.Nil(t, err)
+	assert.Equal(t, proto.Status_Ok, response.Status)
+	assert.True(t, session.Subscribed())
+
+	_, err = handlers[proto.KeyValueMessageKindPutOrUpdate].Handle(proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe SSD"))
+	assert.Nil(t, err)
+
+	var frame []byte
+	select {
+	case frame = <-pushed:
+	case <-time.After(time.Second):
+		assert.Fail(t, "no notification is pushed")
+	}
+	notification, err := proto.DeserializeFrom(bytes.NewReader(frame))
+	assert.Nil(t, err)
+	assert.Equal(t, proto.KeyValueMessageKindWatchNotification, notification.Kind)
+	assert.Equal(t, uint64(3), notification.RequestId)
+	assert.Equal(t, "NVMe SSD", string(notification.RawValue()))
+
+	session.Close()
+	_, err = handlers[proto.KeyValueMessageKindDelete].Handle(proto.NewDeleteMessage("DiskType"))
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, len(pushed))
+}
+
+func TestSessionFramesTheNotificationsWhileAHelloIsHandled(t *testing.T) {
+	session := NewSession()
+	session.PushTo(func(frame []byte) error { return nil }, func() {})
+
+	// the notifications are framed in another goroutine, while the connection agrees to the features (go test -race).
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for count := 0; count < 100; count++ {
+			assert.Nil(t, session.Notify(proto.NewWatchNotificationMessage([]byte("DiskType"), []byte("NVMe SSD"))))
+		}
+	}()
+	_, err := session.Handle(NewHelloHandler(), proto.NewHelloMessage(proto.ProtocolVersion, proto.FeatureChecksums))
+	<-done
+
+	assert.Nil(t, err)
+	assert.True(t, session.Has(proto.FeatureChecksums))
+}
+
+func TestSessionWhichCanNotReceiveNotificationsDoesNotWatch(t *testing.T) {
+	session := NewSession()
+
+	buffer, err := session.Handle(NewWatchHandler(NewWatchers()), proto.NewWatchMessage("DiskType"))
+	assert.Nil(t, err)
+
+	response, err := proto.DeserializeFrom(bytes.NewReader(buffer))
+	assert.Nil(t, err)
+	assert.Equal(t, proto.KeyValueMessageKindWatchResponse, response.Kind)
+	assert.Equal(t, proto.Status_NotOk, response.Status)
+	assert.False(t, session.Subscribed())
+}
+
+func TestSessionQueuesTheRequestsOfATransactionTillExec(t *testing.T) {
+	store := store2.NewInMemoryStore()
+	handlers := NewHandlers(store)
+	session := NewSession()
+
+	handle := func(message *proto.KeyValueMessage) *proto.KeyValueMessage {
+		buffer, err := session.Handle(handlers[message.Kind], message)
+		assert.Nil(t, err)
+		response, err := proto.DeserializeFrom(bytes.NewReader(buffer))
+		assert.Nil(t, err)
+		return response
+	}
+
+	assert.Equal(t, proto.Status_Ok, handle(proto.NewMultiMessage()).Status)
+	assert.Equal(t, proto.Status_NotOk, handle(proto.NewMultiMessage()).Status)
+	for _, message := range []*proto.KeyValueMessage{
+		proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe SSD"),
+		proto.NewGetValueMessage("DiskType"),
+		proto.NewDeleteMessage("Engine"),
+	} {
+		response := handle(message)
+		assert.Equal(t, proto.KeyValueMessageKindTransactionResponse, response.Kind)
+		assert.Equal(t, proto.Status_Ok, response.Status)
+	}
+	assert.Equal(t, proto.Status_NotOk, handle(proto.NewIncrementByMessage("Counter", 1)).Status)
+
+	_, ok := store.GetValue([]byte("DiskType"))
+	assert.False(t, ok)
+
+	response := handle(proto.NewExecMessage().WithRequestId(7))
+	assert.Equal(t, proto.KeyValueMessageKindExecResponse, response.Kind)
+	assert.Equal(t, uint64(7), response.RequestId)
+	assert.Equal(t, proto.Status_Ok, response.Status)
+	assert.Equal(t, 3, len(response.Pairs))
+	assert.Equal(t, proto.Status_Ok, response.Pairs[0].Status)
+	assert.Equal(t, "NVMe SSD", string(response.Pairs[1].RawValue()))
+	assert.Equal(t, proto.Status_NotOk, response.Pairs[2].Status)
+
+	value, _ := store.GetValue([]byte("DiskType"))
+	assert.Equal(t, "NVMe SSD", string(value))
+
+	assert.Equal(t, proto.KeyValueMessageKindGetResponse, handle(proto.NewGetValueMessage("DiskType")).Kind)
+	assert.Equal(t, proto.Status_NotOk, handle(proto.NewExecMessage()).Status)
+}
+
+func TestSessionAbortsATransactionIfAnOptimisticallyWatchedKeyChanges(t *testing.T) {
+	store := store2.NewInMemoryStore()
+	handlers := NewHandlers(store)
+	session := NewSession()
+
+	for _, message := range []*proto.KeyValueMessage{
+		proto.NewOptimisticWatchMessage("DiskType"),
+		proto.NewMultiMessage(),
+		proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe SSD"),
+	} {
+		_, err := session.Handle(handlers[message.Kind], message)
+		assert.Nil(t, err)
+	}
+	store.PutOrUpdate([]byte("DiskType"), []byte("HDD"))
+
+	buffer, err := session.Handle(handlers[proto.KeyValueMessageKindExec], proto.NewExecMessage())
+	assert.Nil(t, err)
+
+	response, err := proto.DeserializeFrom(bytes.NewReader(buffer))
+	assert.Nil(t, err)
+	assert.Equal(t, proto.Status_Conflict, response.Status)
+
+	value, _ := store.GetValue([]byte("DiskType"))
+	assert.Equal(t, "HDD", string(value))
+}
+
+func TestSessionOnlyHandlesAnAuthTillItIsAuthenticated(t *testing.T) {
+	handlers := NewHandlers(store2.NewInMemoryStore())
+	authenticator, _ := NewAuthenticator(AuthenticationConfig{Token: "s3cr3t"})
+	handlers[proto.KeyValueMessageKindAuth] = NewAuthHandler(authenticator)
+	session := NewSessionFor(handlers)
+
+	handle := func(message *proto.KeyValueMessage) *proto.KeyValueMessage {
+		buffer, err := session.Handle(handlers[message.Kind], message)
+		assert.Nil(t, err)
+		response, err := proto.DeserializeFrom(bytes.NewReader(buffer))
+		assert.Nil(t, err)
+		return response
+	}
+
+	response := handle(proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe SSD").WithRequestId(1))
+	assert.Equal(t, proto.KeyValueMessageKindAuthResponse, response.Kind)
+	assert.Equal(t, uint64(1), response.RequestId)
+	assert.Equal(t, proto.Status_Unauthenticated, response.Status)
+
+	assert.Equal(t, proto.Status_NotOk, handle(proto.NewAuthTokenMessage("guess")).Status)
+	_, ok := session.Identity()
+	assert.False(t, ok)
+
+	response = handle(proto.NewAuthTokenMessage("s3cr3t"))
+	assert.Equal(t, proto.Status_Ok, response.Status)
+	assert.Equal(t, TokenUsername, string(response.RawKey()))
+
+	identity, ok := session.Identity()
+	assert.True(t, ok)
+	assert.Equal(t, TokenUsername, identity.Username)
+	assert.Equal(t, proto.Status_Ok, handle(proto.NewPutOrUpdateKeyValueMessage("DiskType", "NVMe SSD")).Status)
+}
+
+func TestSessionDoesNotRequireAuthenticationByDefault(t *testing.T) {
+	handlers := NewHandlers(store2.NewInMemoryStore())
+	session := NewSessionFor(handlers)
+
+	buffer, err := session.Handle(handlers[proto.KeyValueMessageKindGet], proto.NewGetValueMessage("DiskType"))
+	assert.Nil(t, err)
+
+	response, err := proto.DeserializeFrom(bytes.NewReader(buffer))
+	assert.Nil(t, err)
+	assert.Equal(t, proto.KeyValueMessageKindGetResponse, response.Kind)
+}
+
+func TestSessionHandlesOnlyTheRequestsWhichTheACLAllows(t *testing.T) {
+	handlers := NewHandlers(store2.NewInMemoryStore())
+	authenticator, _ := NewAuthenticator(AuthenticationConfig{Token: "s3cr3t"})
+	handlers[proto.KeyValueMessageKindAuth] = NewAuthHandler(authenticator)
+	acl, _ := NewACL(ACLConfig{Rules: []ACLRule{
+		{User: TokenUsername, Permissions: []Permission{PermissionGet, PermissionPut}, Keys: []string{"disks.*"}},
+	}})
+	NewAuthorizedHandlers(handlers, acl)
+	session := NewSessionFor(handlers)
+
+	handle := func(message *proto.KeyValueMessage) *proto.KeyValueMessage {
+		buffer, err := session.Handle(handlers[message.Kind], message)
+		assert.Nil(t, err)
+		response, err := proto.DeserializeFrom(bytes.NewReader(buffer))
+		assert.Nil(t, err)
+		return response
+	}
+
+	assert.Equal(t, proto.Status_Ok, handle(proto.NewAuthTokenMessage("s3cr3t")).Status)
+	assert.Equal(t, proto.Status_Ok, handle(proto.NewPutOrUpdateKeyValueMessage("disks.primary", "NVMe SSD")).Status)
+	assert.Equal(t, "NVMe SSD", string(handle(proto.NewGetValueMessage("disks.primary")).RawValue()))
+
+	response := handle(proto.NewDeleteMessage("disks.primary").WithRequestId(1))
+	assert.Equal(t, proto.KeyValueMessageKindAuthResponse, response.Kind)
+	assert.Equal(t, uint64(1), response.RequestId)
+	assert.Equal(t, proto.Status_PermissionDenied, response.Status)
+	assert.Equal(t, proto.Status_PermissionDenied, handle(proto.NewPutOrUpdateKeyValueMessage("cpus.primary", "8")).Status)
+
+	assert.Equal(t, proto.Status_Ok, handle(proto.NewMultiMessage()).Status)
+	assert.Equal(t, proto.Status_PermissionDenied, handle(proto.NewGetValueMessage("cpus.primary")).Status)
+	assert.Equal(t, proto.Status_Ok, handle(proto.NewGetValueMessage("disks.primary")).Status)
+
+	response = handle(proto.NewExecMessage())
+	assert.Equal(t, proto.Status_Ok, response.Status)
+	assert.Equal(t, 1, len(response.Pairs))
+}
+
+func TestSessionWatchesOnlyTheKeysWhichTheACLAllows(t *testing.T) {
+	handlers := NewHandlers(store2.NewInMemoryStore())
+	authenticator, _ := NewAuthenticator(AuthenticationConfig{Token: "s3cr3t"})
+	handlers[proto.KeyValueMessageKindAuth] = NewAuthHandler(authenticator)
+	acl, _ := NewACL(ACLConfig{Rules: []ACLRule{
+		{User: TokenUsername, Permissions: []Permission{PermissionGet, PermissionScan}, Keys: []string{"disks.*"}},
+		{User: TokenUsername, Permissions: []Permission{PermissionPut}, Keys: []string{"*"}},
+	}})
+	NewAuthorizedHandlers(handlers, acl)
+	session := NewSessionFor(handlers)
+	pushed := make(chan []byte, 16)
+	session.PushTo(func(frame []byte) error {
+		pushed <- frame
+		return nil
+	}, func() {})
+	defer session.Close()
+
+	handle := func(message *proto.KeyValueMessage) *proto.KeyValueMessage {
+		buffer, err := session.Handle(handlers[message.Kind], message)
+		assert.Nil(t, err)
+		response, err := proto.DeserializeFrom(bytes.NewReader(buffer))
+		assert.Nil(t, err)
+		return response
+	}
+
+	assert.Equal(t, proto.Status_Ok, handle(proto.NewAuthTokenMessage("s3cr3t")).Status)
+	assert.Equal(t, proto.Status_PermissionDenied, handle(proto.NewWatchMessage("disks.primary", "cpus.primary")).Status)
+	assert.Equal(t, proto.Status_PermissionDenied, handle(proto.NewPrefixWatchMessage("cpus.")).Status)
+	assert.Equal(t, proto.Status_PermissionDenied, handle(proto.NewPrefixWatchMessage("")).Status)
+	assert.Equal(t, proto.Status_PermissionDenied, handle(proto.NewOptimisticWatchMessage("cpus.primary")).Status)
+	assert.False(t, session.Subscribed())
+
+	assert.Equal(t, proto.Status_Ok, handle(proto.NewPutOrUpdateKeyValueMessage("cpus.primary", "8")).Status)
+	assert.Equal(t, 0, len(pushed))
+
+	assert.Equal(t, proto.Status_Ok, handle(proto.NewPrefixWatchMessage("disks.")).Status)
+	assert.Equal(t, proto.Status_Ok, handle(proto.NewOptimisticWatchMessage("disks.primary")).Status)
+	assert.True(t, session.Subscribed())
+}
+
+func TestAuthorizedHandlerDeniesTheRequestsWithoutAnIdentity(t *testing.T) {
+	acl, _ := NewACL(ACLConfig{Rules: []ACLRule{
+		{User: TokenUsername, Permissions: []Permission{PermissionGet}, Keys: []string{"*"}},
+	}})
+	handlers := NewAuthorizedHandlers(NewHandlers(store2.NewInMemoryStore()), acl)
+
+	buffer, err := handlers[proto.KeyValueMessageKindGet].Handle(proto.NewGetValueMessage("DiskType"))
+	assert.Nil(t, err)
+
+	response, err := proto.DeserializeFrom(bytes.NewReader(buffer))
+	assert.Nil(t, err)
+	assert.Equal(t, proto.Status_PermissionDenied, response.Status)
+}
+
+func TestSessionAnswersAPingBeforeItIsAuthenticated(t *testing.T) {
+	handlers := NewHandlers(store2.NewInMemoryStore())
+	authenticator, _ := NewAuthenticator(AuthenticationConfig{Token: "s3cr3t"})
+	handlers[proto.KeyValueMessageKindAuth] = NewAuthHandler(authenticator)
+	session := NewSessionFor(handlers)
+
+	buffer, err := session.Handle(handlers[proto.KeyValueMessageKindPing], proto.NewPingMessage())
+	assert.Nil(t, err)
+
+	response, err := proto.DeserializeFrom(bytes.NewReader(buffer))
+	assert.Nil(t, err)
+	assert.Equal(t, proto.KeyValueMessageKindPong, response.Kind)
+
+	buffer, err = session.Handle(handlers[proto.KeyValueMessageKindPong], proto.NewPongMessage())
+	assert.Nil(t, err)
+	assert.Empty(t, buffer)
+}
@@ -0,0 +1,89 @@
+package single_thread_event_loop
+
+import (
+	"bytes"
+	"single_thread_eventloop/conn"
+	"single_thread_eventloop/proto"
+	"time"
+)
+
+// detectingCodec is a conn.Codec which detects the protocol of a connection from its first bytes (see DetectProtocol),
+// and delegates to the codec of the detected protocol. The bytes arrive incrementally, so the detection waits for
+// as many bytes as DetectProtocol needs.
+// A detectingCodec is a conn.PushingCodec, which passes the push functions on to the detected codec if it is one,
+// a conn.KeepaliveCodec, which pings the connection if the detected codec does, and a conn.PullingCodec, which pulls
+// the frames of the detected codec.
+type detectingCodec struct {
+	newCodec   func(protocol Protocol) conn.Codec
+	codec      conn.Codec
+	push       func(frame []byte) error
+	disconnect func()
+}
+
+// newDetectingCodec creates a new instance of detectingCodec, which creates the codec of the detected protocol
+// with newCodec.
+func newDetectingCodec(newCodec func(protocol Protocol) conn.Codec) *detectingCodec {
+	return &detectingCodec{
+		newCodec: newCodec,
+	}
+}
+
+// Answer answers the first request in the buffer with the codec of the detected protocol.
+func (codec *detectingCodec) Answer(buffer *bytes.Buffer) ([]byte, error) {
+	if codec.codec == nil {
+		protocol, ok := DetectProtocol(buffer.Bytes())
+		if !ok {
+			return nil, conn.ErrIncompleteRequest
+		}
+		codec.codec = codec.newCodec(protocol)
+		if pushingCodec, ok := codec.codec.(conn.PushingCodec); ok && codec.push != nil {
+			pushingCodec.PushTo(codec.push, codec.disconnect)
+		}
+	}
+	return codec.codec.Answer(buffer)
+}
+
+// PushTo keeps the push functions for the codec which is yet to be detected.
+func (codec *detectingCodec) PushTo(push func(frame []byte) error, disconnect func()) {
+	codec.push = push
+	codec.disconnect = disconnect
+}
+
+// Ping returns the ping of the detected codec, if it is a conn.KeepaliveCodec.
+func (codec *detectingCodec) Ping(now time.Time) ([]byte, error) {
+	if keepaliveCodec, ok := codec.codec.(conn.KeepaliveCodec); ok {
+		return keepaliveCodec.Ping(now)
+	}
+	return nil, nil
+}
+
+// Pull returns the next frame of the detected codec, if it is a conn.PullingCodec.
+func (codec *detectingCodec) Pull() ([]byte, error) {
+	if pullingCodec, ok := codec.codec.(conn.PullingCodec); ok {
+		return pullingCodec.Pull()
+	}
+	return nil, nil
+}
+
+// Close closes the detected codec, if it is a conn.PushingCodec.
+func (codec *detectingCodec) Close() {
+	if pushingCodec, ok := codec.codec.(conn.PushingCodec); ok {
+		pushingCodec.Close()
+	}
+}
+
+// refusingCodec is a conn.Codec which closes the connection without answering it, for the protocols whose connections
+// can not authenticate when the server requires authentication.
+type refusingCodec struct{}
+
+// Answer returns ErrAuthenticationNotSupported, which closes the connection.
+func (codec refusingCodec) Answer(buffer *bytes.Buffer) ([]byte, error) {
+	return nil, ErrAuthenticationNotSupported
+}
+
+// requiresAuthentication returns true if the handlers require the connections to authenticate
+// (see conn.NewSessionFor).
+func requiresAuthentication(handlers map[uint32]conn.Handler) bool {
+	authenticatingHandler, ok := handlers[proto.KeyValueMessageKindAuth].(conn.AuthenticatingHandler)
+	return ok && authenticatingHandler.RequiresAuthentication()
+}
@@ -2,104 +2,364 @@ package event_loop
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"single_thread_eventloop/conn"
-	"single_thread_eventloop/proto"
+	"sync"
 	"syscall"
+	"time"
 )
 
+// MaxPendingPushes is the number of pending bytes beyond which the pushed frames are no longer kept for a client,
+// so a client which does not read its pushed frames can not grow its pendingWrites without bound.
+const MaxPendingPushes = 1 << 20
+
+// MaxPendingResponses is the number of pending (and held back) bytes beyond which a client is disconnected, so
+// a client which pipelines requests without reading the responses can not grow its pendingWrites without bound.
+const MaxPendingResponses = 8 << 20
+
+var (
+	// ErrTooManyPendingPushes is returned when a frame is pushed to a client with more than MaxPendingPushes
+	// pending bytes.
+	ErrTooManyPendingPushes = errors.New("too many pending pushed frames")
+	// ErrTooManyPendingResponses is returned when a response is written for a client with more than
+	// MaxPendingResponses pending (or held back) bytes.
+	ErrTooManyPendingResponses = errors.New("too many pending responses")
+	// ErrNotDurable is returned when the write-ahead log of the durability fails while a client holds back
+	// responses, whose records can then never be synced.
+	ErrNotDurable = errors.New("write-ahead log failed before the held back responses were synced")
+)
+
+// Durability represents the write-ahead log of a durable store, whose mutations return without waiting for
+// the syncs (see store.DurableStore.Deferred), so the responses are held back till the log is synced.
+type Durability interface {
+	// Appended returns the sequence of the last record which is appended to the log.
+	Appended() uint64
+	// Synced returns the sequence of the last record which is synced to the log.
+	Synced() uint64
+	// Err returns the error which failed the log, if any.
+	Err() error
+	// OnSync registers onSync which is invoked every time records are synced, and once the log fails.
+	OnSync(onSync func())
+}
+
 // Client handles an incoming connection.
 type Client struct {
 	fd            int
-	handlers      map[uint32]conn.Handler
+	codec         conn.Codec
 	stopChannel   chan struct{}
 	readBuffer    []byte
 	currentBuffer *bytes.Buffer
+	pendingWrites *bytes.Buffer
+	writeLock     sync.Mutex
+	onPending     func()
+	durability    Durability
+	awaitingSync  []awaitingResponse
+	awaitingBytes int
+	pulled        []byte
+	readPaused    bool
+}
+
+// awaitingResponse represents a response which is held back till the records which are appended to
+// the write-ahead log before it (up to sequence) are synced.
+type awaitingResponse struct {
+	response []byte
+	sequence uint64
 }
 
 // NewClient creates a new instance of the client.
 // It reads the chunk from the file descriptor and maintains the current buffer.
 // currentBuffer denotes the chunk that is read currently.
+// The codec decodes the requests from the currentBuffer and answers them, in the protocol of the server.
+// pendingWrites holds the responses that could not be written because the socket send buffer was full.
+// If the codec is a conn.PushingCodec, the client receives the pushed frames (such as the notifications of the
+// watched keys and the published messages), which are written between the responses. A frame is pushed from the handler of another client
+// (in the event loop goroutine), or from the goroutines of the HTTP gateway; onPending is invoked if the pushed frame
+// could not be written completely, so that the event loop flushes it when the file descriptor is ready to be written.
+// If the codec is a conn.PullingCodec, the responses are followed by the frames which are pulled from the codec
+// (see pull).
 // The provided file descriptor is set to non-blocking by the caller.
-func NewClient(fd int, handlers map[uint32]conn.Handler) *Client {
-	return &Client{
+func NewClient(fd int, codec conn.Codec, onPending func()) *Client {
+	client := &Client{
 		fd:            fd,
-		handlers:      handlers,
+		codec:         codec,
 		stopChannel:   make(chan struct{}),
 		readBuffer:    make([]byte, 1024),
 		currentBuffer: bytes.NewBuffer([]byte{}),
+		pendingWrites: bytes.NewBuffer([]byte{}),
+		onPending:     onPending,
 	}
+	if pushingCodec, ok := codec.(conn.PushingCodec); ok {
+		pushingCodec.PushTo(client.push, client.disconnect)
+	}
+	return client
 }
 
 // Run runs the client.
 // It is invoked when the client's file descriptor is ready to be read.
+// It answers all the complete requests that can be read without blocking, and returns when the file descriptor
+// has no more data to offer (EAGAIN/EWOULDBLOCK) or there is an error.
+// A client whose pending responses exceed MaxPendingResponses, or whose held back responses can not be synced, is
+// disconnected (see acknowledge).
+// The client stops answering requests while it has a pulled frame which is not written yet (see Pulling), so that
+// the responses are not written ahead of the frames of a get stream; the event loop runs it again once its pending
+// responses are written.
 func (client *Client) Run() {
 	for {
 		select {
 		case <-client.stopChannel:
 			return
 		default:
-			keyValueMessage, err := client.read()
-			if err != nil {
+			if err := client.pull(); err != nil || client.Pulling() {
 				return
 			}
-			if err := client.handle(keyValueMessage); err != nil {
+			response, err := client.read()
+			if response != nil {
+				if err := client.acknowledge(response); err != nil {
+					if errors.Is(err, ErrTooManyPendingResponses) || errors.Is(err, ErrNotDurable) {
+						client.disconnect()
+					}
+					return
+				}
+			}
+			if err != nil || response == nil {
 				return
 			}
 		}
 	}
 }
 
-// Stop stops the client.
+// Pulling returns true if the client has a frame which is pulled from its codec, and waits for the pending (or held
+// back) responses to be written.
+func (client *Client) Pulling() bool {
+	return client.pulled != nil
+}
+
+// Stop stops the client, and closes the codec if it is a conn.PushingCodec.
 func (client *Client) Stop() {
+	if pushingCodec, ok := client.codec.(conn.PushingCodec); ok {
+		pushingCodec.Close()
+	}
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
 	close(client.stopChannel)
 	_ = syscall.Close(client.fd)
 }
 
-// read reads a single proto.KeyValueMessage from the file descriptor.
+// Flush writes the pending responses to the file descriptor.
+// It is invoked when the client's file descriptor is ready to be written.
+func (client *Client) Flush() error {
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
+	for client.pendingWrites.Len() > 0 {
+		n, err := syscall.Write(client.fd, client.pendingWrites.Bytes())
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EWOULDBLOCK) {
+				return nil
+			}
+			return err
+		}
+		client.pendingWrites.Next(n)
+	}
+	return nil
+}
+
+// Ping writes the ping which is due for the connection at the given time, if the codec is a conn.KeepaliveCodec.
+// It returns conn.ErrNotAlive if the connection has not answered the previous ping in time.
+func (client *Client) Ping(now time.Time) error {
+	keepaliveCodec, ok := client.codec.(conn.KeepaliveCodec)
+	if !ok {
+		return nil
+	}
+	ping, err := keepaliveCodec.Ping(now)
+	if err != nil || ping == nil {
+		return err
+	}
+	_, err = client.writeResponse(ping)
+	return err
+}
+
+// ReleaseSynced writes the responses which are held back, whose records are synced to the write-ahead log.
+// It is invoked when the write-ahead log is synced, or fails. It returns ErrNotDurable if the log has failed and
+// responses are still held back, because their records can never be synced.
+func (client *Client) ReleaseSynced() error {
+	if client.durability == nil {
+		return nil
+	}
+	synced := client.durability.Synced()
+	for len(client.awaitingSync) > 0 && client.awaitingSync[0].sequence <= synced {
+		if _, err := client.writeResponse(client.awaitingSync[0].response); err != nil {
+			return err
+		}
+		client.awaitingBytes -= len(client.awaitingSync[0].response)
+		client.awaitingSync = client.awaitingSync[1:]
+	}
+	if len(client.awaitingSync) > 0 && client.durability.Err() != nil {
+		return ErrNotDurable
+	}
+	return nil
+}
+
+// HasPendingWrites returns true if there are responses waiting for the file descriptor to be ready to be written.
+func (client *Client) HasPendingWrites() bool {
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
+	return client.hasPendingWrites()
+}
+
+// read reads a single request from the file descriptor, and returns the response of the codec.
 // read will be triggered when the non-blocking file descriptor is ready.
 // This means syscall.Read(..) will not block.
-// read will continue reading till it finds the proto.FooterBytes.
+// read first attempts to answer a complete request from client.currentBuffer. A client may pipeline requests,
+// which means a single syscall.Read(..) may return many requests, and all of them remain in the currentBuffer.
+// If the currentBuffer does not contain a complete request, read will continue reading till a complete request is received.
 // However, it is possible that syscall.Read(..) does not return the amount of data that is requested.
-// In that case, the received data will be stored in client.currentBuffer and the read method will return.
+// In that case, the received data will be stored in client.currentBuffer and the read method will return (nil, nil).
 // When the read method is invoked again, at a later point in time when the file descriptor is ready,
-// it will read further data until proto.FooterBytes are found.
-// The combined data represented by the currentBuffer will be deserialized into proto.KeyValueMessage.
-func (client *Client) read() (*proto.KeyValueMessage, error) {
+// it will read further data until a complete request is received.
+func (client *Client) read() ([]byte, error) {
 	for {
-		n, err := syscall.Read(client.fd, client.readBuffer)
-		if n <= 0 {
-			break
+		response, err := client.codec.Answer(client.currentBuffer)
+		if !errors.Is(err, conn.ErrIncompleteRequest) {
+			return response, err
 		}
-		client.currentBuffer.Write(client.readBuffer[:n])
+		n, err := syscall.Read(client.fd, client.readBuffer)
 		if err != nil {
-			if err == io.EOF {
-				break
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EWOULDBLOCK) {
+				return nil, nil
 			}
 			return nil, err
 		}
-		if bytes.Contains(client.readBuffer, proto.FooterBytes) {
-			break
+		if n == 0 {
+			return nil, io.EOF
 		}
+		client.currentBuffer.Write(client.readBuffer[:n])
 	}
-	keyValueMessage, err := proto.DeserializeFrom(client.currentBuffer)
-	if err != nil {
-		return nil, err
+}
+
+// writeResponse writes the response to the file descriptor.
+// syscall.Write(..) on a non-blocking file descriptor may write fewer bytes than requested,
+// or fail with EAGAIN/EWOULDBLOCK if the socket send buffer is full (for example, when a client pipelines requests
+// without reading the responses). The bytes that could not be written are kept in client.pendingWrites,
+// and the event loop flushes them when the file descriptor is ready to be written.
+// Responses are never written ahead of pendingWrites, which preserves their order.
+// The writes are serialized by the writeLock, because the frames are also pushed from the goroutines of the HTTP
+// gateway.
+func (client *Client) writeResponse(buffer []byte) (int, error) {
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
+	return client.write(buffer)
+}
+
+// acknowledge writes the response, unless the client holds its responses for the durability (a write-ahead log)
+// and the log has records which are not synced yet, or earlier responses are held back. The response is then
+// held back till the records which are appended so far are synced (see ReleaseSynced), which preserves the order of
+// the responses. A response is held back conservatively (even if its request did not append a record), because
+// the client does not know which requests are mutations.
+// It returns ErrTooManyPendingResponses (without writing the response) if the client has more than
+// MaxPendingResponses pending and held back bytes, and ErrNotDurable if the response would be held back but
+// the log has failed.
+func (client *Client) acknowledge(response []byte) error {
+	client.writeLock.Lock()
+	pending := client.pendingWrites.Len()
+	client.writeLock.Unlock()
+	if pending+client.awaitingBytes > MaxPendingResponses {
+		return ErrTooManyPendingResponses
+	}
+	if client.durability != nil {
+		appended := client.durability.Appended()
+		if len(client.awaitingSync) > 0 || client.durability.Synced() < appended {
+			if client.durability.Err() != nil {
+				return ErrNotDurable
+			}
+			client.awaitingSync = append(client.awaitingSync, awaitingResponse{response: response, sequence: appended})
+			client.awaitingBytes += len(response)
+			return nil
+		}
 	}
-	return keyValueMessage, nil
+	_, err := client.writeResponse(response)
+	return err
 }
 
-// handle handles the incoming message.
-func (client *Client) handle(keyValueMessage *proto.KeyValueMessage) error {
-	buffer, err := client.handlers[keyValueMessage.Kind].Handle(keyValueMessage)
-	if err != nil {
+// pull writes the frames which are pulled from the codec (such as the chunks of a get stream), if the codec is
+// a conn.PullingCodec. A frame is written only if there are no pending (or held back) responses, otherwise it is
+// kept in pulled till they are written, so a get stream holds a single frame at a time instead of the whole value.
+func (client *Client) pull() error {
+	pullingCodec, ok := client.codec.(conn.PullingCodec)
+	if !ok {
+		return nil
+	}
+	for {
+		if client.pulled == nil {
+			frame, err := pullingCodec.Pull()
+			if err != nil || frame == nil {
+				return err
+			}
+			client.pulled = frame
+		}
+		if len(client.awaitingSync) > 0 || client.HasPendingWrites() {
+			return nil
+		}
+		if _, err := client.writeResponse(client.pulled); err != nil {
+			return err
+		}
+		client.pulled = nil
+	}
+}
+
+// push writes the pushed frame to the file descriptor, unless the client is stopped, and invokes onPending if
+// the frame could not be written completely.
+// The frame is dropped with ErrTooManyPendingPushes if the client has more than MaxPendingPushes pending bytes.
+func (client *Client) push(frame []byte) error {
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
+	select {
+	case <-client.stopChannel:
+		return nil
+	default:
+		if client.pendingWrites.Len() > MaxPendingPushes {
+			return ErrTooManyPendingPushes
+		}
+		_, err := client.write(frame)
+		if err == nil && client.hasPendingWrites() {
+			client.onPending()
+		}
 		return err
 	}
-	_, err = client.writeResponse(buffer)
-	return err
 }
 
-// writeResponse writes the response to the file descriptor.
-func (client *Client) writeResponse(buffer []byte) (int, error) {
-	return syscall.Write(client.fd, buffer)
+// disconnect shuts the file descriptor down, unless the client is stopped. The event loop receives EOF for
+// the file descriptor, and stops the client.
+func (client *Client) disconnect() {
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
+	select {
+	case <-client.stopChannel:
+		return
+	default:
+		_ = syscall.Shutdown(client.fd, syscall.SHUT_RDWR)
+	}
+}
+
+// write writes the buffer (or keeps it in pendingWrites), and is invoked holding the writeLock.
+func (client *Client) write(buffer []byte) (int, error) {
+	if client.hasPendingWrites() {
+		return client.pendingWrites.Write(buffer)
+	}
+	n, err := syscall.Write(client.fd, buffer)
+	if err != nil {
+		if !errors.Is(err, syscall.EAGAIN) && !errors.Is(err, syscall.EWOULDBLOCK) {
+			return 0, err
+		}
+		n = 0
+	}
+	if n < len(buffer) {
+		client.pendingWrites.Write(buffer[n:])
+	}
+	return len(buffer), nil
+}
+
+// hasPendingWrites returns true if there are pending responses, and is invoked holding the writeLock.
+func (client *Client) hasPendingWrites() bool {
+	return client.pendingWrites.Len() > 0
 }
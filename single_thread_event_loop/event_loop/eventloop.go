@@ -3,6 +3,15 @@ package event_loop
 import (
 	"single_thread_eventloop/conn"
 	"syscall"
+	"time"
+)
+
+// syncedEventId is the identifier of the EVFILT_USER event, which is triggered when the write-ahead log of
+// the durability is synced. stopEventId is the identifier of the EVFILT_USER event, which wakes the event loop up
+// once it is stopped.
+const (
+	syncedEventId = 1
+	stopEventId   = 2
 )
 
 // EventLoop represents a single goroutine event loop.
@@ -10,13 +19,18 @@ type EventLoop struct {
 	serverFd       int
 	kQueue         *KQueue
 	clients        map[int]*Client
-	clientHandlers map[uint32]conn.Handler
+	newCodec       func() conn.Codec
+	timers         map[uint64]func()
+	durability     Durability
+	running        bool
 	stopChannel    chan struct{}
+	stoppedChannel chan struct{}
 }
 
 // NewEventLoop creates a new instance of EventLoop.
 // It also subscribes using the EVFILT_READ filter on the server file descriptor.
-func NewEventLoop(serverFd int, maxClients int, clientHandlers map[uint32]conn.Handler) (*EventLoop, error) {
+// newCodec creates the conn.Codec of every accepted client, which decides the protocol spoken by the clients.
+func NewEventLoop(serverFd int, maxClients int, newCodec func() conn.Codec) (*EventLoop, error) {
 	// NewKQueue creates a new kernel KQueue data structure to hold various events on the subscribed file descriptor.
 	kQueue, err := NewKQueue(maxClients)
 	if err != nil {
@@ -26,8 +40,17 @@ func NewEventLoop(serverFd int, maxClients int, clientHandlers map[uint32]conn.H
 		serverFd:       serverFd,
 		kQueue:         kQueue,
 		clients:        make(map[int]*Client),
-		clientHandlers: clientHandlers,
+		newCodec:       newCodec,
+		timers:         make(map[uint64]func()),
 		stopChannel:    make(chan struct{}),
+		stoppedChannel: make(chan struct{}),
+	}
+	if err := kQueue.Subscribe(syscall.Kevent_t{
+		Ident:  stopEventId,
+		Filter: syscall.EVFILT_USER,
+		Flags:  syscall.EV_ADD | syscall.EV_CLEAR,
+	}); err != nil {
+		return nil, err
 	}
 	// subscribes to the given server file descriptor using EVFILT_READ and EV_ADD flag.
 	// This means an event will be added to the kernel KQueue when the server file descriptor is ready to be read
@@ -41,12 +64,19 @@ func NewEventLoop(serverFd int, maxClients int, clientHandlers map[uint32]conn.H
 
 // Run runs an event loop. It:
 // - runs an event loop in its own goroutine.
-// - polls the KQueue for events on the subscribed file descriptors.
+// - polls the KQueue for events on the subscribed file descriptors and timers.
+// - if the polled event is a timer event: the task of the timer is run,
+// - if the polled event is the user event of the durability: the responses whose records are synced are written
+// (the user event which stops the event loop only wakes it up),
 // - if the polled event's file descriptor is same as the server's file descriptor: a new client is accepted,
+// - else if the polled event is a write event: the pending responses of an existing client are flushed (and
+// the client is run again if it is pulling),
 // - else: an existing client for the file descriptor is run.
 func (eventLoop *EventLoop) Run() {
 	// TODO: Handle client error
+	eventLoop.running = true
 	go func() {
+		defer close(eventLoop.stoppedChannel)
 		for {
 			select {
 			case <-eventLoop.stopChannel:
@@ -57,6 +87,16 @@ func (eventLoop *EventLoop) Run() {
 					continue
 				}
 				for _, event := range events {
+					if event.Filter == syscall.EVFILT_TIMER {
+						eventLoop.runTimer(event.Ident)
+						continue
+					}
+					if event.Filter == syscall.EVFILT_USER {
+						if event.Ident == syncedEventId {
+							eventLoop.releaseSyncedResponses()
+						}
+						continue
+					}
 					if event.Flags&syscall.EV_EOF == syscall.EV_EOF {
 						eventLoop.stopClient(int(event.Ident))
 						delete(eventLoop.clients, int(event.Ident))
@@ -66,6 +106,8 @@ func (eventLoop *EventLoop) Run() {
 						if err := eventLoop.acceptClient(); err != nil {
 							continue
 						}
+					} else if event.Filter == syscall.EVFILT_WRITE {
+						eventLoop.flushClient(int(event.Ident))
 					} else {
 						eventLoop.runClient(int(event.Ident))
 					}
@@ -75,15 +117,83 @@ func (eventLoop *EventLoop) Run() {
 	}()
 }
 
-// Stop stops the event loop.
+// Stop stops the event loop, and waits for its goroutine to return. Then, the clients write the responses which are
+// held back and whose records are synced, and are stopped. The write-ahead log of the durability (if any) is
+// expected to be closed (or synced) before, so that the responses of the mutations which are durable are not lost.
 func (eventLoop *EventLoop) Stop() {
 	close(eventLoop.stopChannel)
-	_ = syscall.Close(eventLoop.kQueue.fd)
+	if eventLoop.running {
+		_ = eventLoop.kQueue.Subscribe(syscall.Kevent_t{
+			Ident:  stopEventId,
+			Filter: syscall.EVFILT_USER,
+			Fflags: syscall.NOTE_TRIGGER,
+		})
+		<-eventLoop.stoppedChannel
+	}
+	_ = eventLoop.kQueue.Close()
 	for _, client := range eventLoop.clients {
+		if err := client.ReleaseSynced(); err == nil {
+			_ = client.Flush()
+		}
 		client.Stop()
 	}
 }
 
+// AddTimer adds a timer which runs the given task every interval, in the event loop goroutine.
+// The task runs between the client events, so it must not block.
+// It uses the EVFILT_TIMER filter of KQueue, and is expected to be invoked before the event loop is run.
+func (eventLoop *EventLoop) AddTimer(interval time.Duration, task func()) error {
+	timerId := uint64(len(eventLoop.timers) + 1)
+	eventLoop.timers[timerId] = task
+
+	return eventLoop.kQueue.Subscribe(syscall.Kevent_t{
+		Ident:  timerId,
+		Filter: syscall.EVFILT_TIMER,
+		Flags:  syscall.EV_ADD,
+		Data:   interval.Milliseconds(),
+	})
+}
+
+// HoldResponsesFor makes the clients hold their responses back till the write-ahead log of the durability is synced
+// (see Client.ReleaseSynced), so that a mutation is acknowledged once it is durable, without blocking the event loop
+// on fsync: the log is synced in its own goroutine, which triggers an EVFILT_USER event of KQueue once it is synced
+// (or fails). The handlers are expected to mutate a store which does not wait for the syncs.
+// It is expected to be invoked before the event loop is run.
+func (eventLoop *EventLoop) HoldResponsesFor(durability Durability) error {
+	if err := eventLoop.kQueue.Subscribe(syscall.Kevent_t{
+		Ident:  syncedEventId,
+		Filter: syscall.EVFILT_USER,
+		Flags:  syscall.EV_ADD | syscall.EV_CLEAR,
+	}); err != nil {
+		return err
+	}
+	eventLoop.durability = durability
+	durability.OnSync(func() {
+		_ = eventLoop.kQueue.Subscribe(syscall.Kevent_t{
+			Ident:  syncedEventId,
+			Filter: syscall.EVFILT_USER,
+			Fflags: syscall.NOTE_TRIGGER,
+		})
+	})
+	return nil
+}
+
+// PingClients pings the idle clients (see Client.Ping), and stops the clients which have not answered the previous
+// ping in time. It is run on a timer of the event loop (see AddTimer).
+func (eventLoop *EventLoop) PingClients() {
+	now := time.Now()
+	for fd, client := range eventLoop.clients {
+		if err := client.Ping(now); err != nil {
+			eventLoop.stopClient(fd)
+			delete(eventLoop.clients, fd)
+			continue
+		}
+		if client.HasPendingWrites() {
+			_ = eventLoop.subscribeWrite(fd)
+		}
+	}
+}
+
 // subscribeRead subscribes to the given file descriptor using EVFILT_READ filter and an EV_ADD flag which will add the
 // file descriptor to the Kernel KQueue when the file descriptor is ready to be read.
 func (eventLoop *EventLoop) subscribeRead(fd int) error {
@@ -94,6 +204,30 @@ func (eventLoop *EventLoop) subscribeRead(fd int) error {
 	})
 }
 
+// pauseRead disables (or enables) the EVFILT_READ filter of the given file descriptor.
+func (eventLoop *EventLoop) pauseRead(fd int, pause bool) error {
+	flags := uint16(syscall.EV_ENABLE)
+	if pause {
+		flags = syscall.EV_DISABLE
+	}
+	return eventLoop.kQueue.Subscribe(syscall.Kevent_t{
+		Ident:  uint64(fd),
+		Filter: syscall.EVFILT_READ,
+		Flags:  flags,
+	})
+}
+
+// subscribeWrite subscribes to the given file descriptor using EVFILT_WRITE filter and EV_ADD|EV_ONESHOT flags.
+// An event will be added to the kernel KQueue (only once) when the file descriptor is ready to be written.
+// It is used when a client has responses which could not be written because the socket send buffer was full.
+func (eventLoop *EventLoop) subscribeWrite(fd int) error {
+	return eventLoop.kQueue.Subscribe(syscall.Kevent_t{
+		Ident:  uint64(fd),
+		Filter: syscall.EVFILT_WRITE,
+		Flags:  syscall.EV_ADD | syscall.EV_ONESHOT,
+	})
+}
+
 // acceptClient accepts a new client (/socket).
 // syscall.Accept(..) will not block because the method is called when the non-blocking file descriptor is ready.
 func (eventLoop *EventLoop) acceptClient() error {
@@ -102,7 +236,11 @@ func (eventLoop *EventLoop) acceptClient() error {
 		return err
 	}
 
-	eventLoop.clients[fd] = NewClient(fd, eventLoop.clientHandlers)
+	client := NewClient(fd, eventLoop.newCodec(), func() {
+		_ = eventLoop.subscribeWrite(fd)
+	})
+	client.durability = eventLoop.durability
+	eventLoop.clients[fd] = client
 	_ = syscall.SetNonblock(fd, true)
 
 	if err := eventLoop.subscribeRead(fd); err != nil {
@@ -112,12 +250,70 @@ func (eventLoop *EventLoop) acceptClient() error {
 }
 
 // runClient runs the client for the file descriptor.
+// If the client could not write all of its responses, the event loop subscribes for the write readiness of the
+// file descriptor. The read readiness of a client which is pulling (see Client.Pulling) is disabled till it is run
+// again, because the client does not read its requests meanwhile.
 func (eventLoop *EventLoop) runClient(fd int) {
 	client := eventLoop.clients[fd]
 	if client == nil {
 		return
 	}
 	client.Run()
+	if client.Pulling() != client.readPaused {
+		if err := eventLoop.pauseRead(fd, client.Pulling()); err == nil {
+			client.readPaused = client.Pulling()
+		}
+	}
+	if client.HasPendingWrites() {
+		_ = eventLoop.subscribeWrite(fd)
+	}
+}
+
+// resumeClient runs the client for the file descriptor again, if it is pulling and its pending responses are written.
+func (eventLoop *EventLoop) resumeClient(fd int, client *Client) {
+	if client.Pulling() && !client.HasPendingWrites() {
+		eventLoop.runClient(fd)
+	}
+}
+
+// releaseSyncedResponses writes the held back responses of the clients whose records are synced, and stops
+// the clients which fail to write them, or whose held back responses can not be synced because the log has failed.
+func (eventLoop *EventLoop) releaseSyncedResponses() {
+	for fd, client := range eventLoop.clients {
+		if err := client.ReleaseSynced(); err != nil {
+			eventLoop.stopClient(fd)
+			delete(eventLoop.clients, fd)
+			continue
+		}
+		eventLoop.resumeClient(fd, client)
+		if client.HasPendingWrites() {
+			_ = eventLoop.subscribeWrite(fd)
+		}
+	}
+}
+
+// runTimer runs the task of the timer.
+func (eventLoop *EventLoop) runTimer(timerId uint64) {
+	task := eventLoop.timers[timerId]
+	if task == nil {
+		return
+	}
+	task()
+}
+
+// flushClient flushes the pending responses of the client for the file descriptor.
+func (eventLoop *EventLoop) flushClient(fd int) {
+	client := eventLoop.clients[fd]
+	if client == nil {
+		return
+	}
+	if err := client.Flush(); err != nil {
+		return
+	}
+	eventLoop.resumeClient(fd, client)
+	if client.HasPendingWrites() {
+		_ = eventLoop.subscribeWrite(fd)
+	}
 }
 
 // stopClient stops the client corresponding to the file descriptor and closes the descriptor.
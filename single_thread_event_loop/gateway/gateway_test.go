@@ -0,0 +1,145 @@
+package gateway
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"single_thread_eventloop/conn"
+	"single_thread_eventloop/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serve(gateway *Gateway, method, target, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	gateway.ServeHTTP(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return recorder
+}
+
+func decode[T any](t *testing.T, recorder *httptest.ResponseRecorder) T {
+	var body T
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&body))
+	return body
+}
+
+func TestPutAndGetAKey(t *testing.T) {
+	gateway := NewGateway(conn.NewHandlers(store.NewInMemoryStore()))
+
+	recorder := serve(gateway, http.MethodPut, "/keys/DiskType", "NVMe SSD")
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+
+	recorder = serve(gateway, http.MethodGet, "/keys/DiskType", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	keyValue := decode[KeyValue](t, recorder)
+	assert.Equal(t, "DiskType", keyValue.Key)
+	assert.Equal(t, "NVMe SSD", keyValue.Value)
+	assert.True(t, keyValue.Version > 0)
+}
+
+func TestPutAKeyWithSlashesAndATimeToLive(t *testing.T) {
+	keyValueStore := store.NewInMemoryStore()
+	gateway := NewGateway(conn.NewHandlers(keyValueStore))
+
+	recorder := serve(gateway, http.MethodPut, "/keys/disk/nvme?ttl=1m", "SSD")
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+
+	ttl, ok := keyValueStore.TimeToLive([]byte("disk/nvme"))
+	assert.True(t, ok)
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+}
+
+func TestGetANonExistingKey(t *testing.T) {
+	gateway := NewGateway(conn.NewHandlers(store.NewInMemoryStore()))
+
+	recorder := serve(gateway, http.MethodGet, "/keys/DiskType", "")
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	errorResponse := decode[ErrorResponse](t, recorder)
+	assert.Equal(t, "NotOk", errorResponse.Status)
+	assert.Equal(t, "DiskType", errorResponse.Key)
+}
+
+func TestCompareAndSwapAKey(t *testing.T) {
+	gateway := NewGateway(conn.NewHandlers(store.NewInMemoryStore()))
+
+	recorder := serve(gateway, http.MethodPut, "/keys/DiskType?version=0", "SSD")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	version := decode[KeyValue](t, recorder).Version
+
+	recorder = serve(gateway, http.MethodPut, "/keys/DiskType?version=0", "HDD")
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+
+	errorResponse := decode[ErrorResponse](t, recorder)
+	assert.Equal(t, "Conflict", errorResponse.Status)
+	assert.Equal(t, version, errorResponse.Version)
+}
+
+func TestPutWithAnInvalidTimeToLive(t *testing.T) {
+	gateway := NewGateway(conn.NewHandlers(store.NewInMemoryStore()))
+
+	recorder := serve(gateway, http.MethodPut, "/keys/DiskType?ttl=soon", "SSD")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, "Corrupt", decode[ErrorResponse](t, recorder).Status)
+}
+
+func TestDeleteAKey(t *testing.T) {
+	gateway := NewGateway(conn.NewHandlers(store.NewInMemoryStore()))
+	serve(gateway, http.MethodPut, "/keys/DiskType", "SSD")
+
+	assert.Equal(t, http.StatusNoContent, serve(gateway, http.MethodDelete, "/keys/DiskType", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(gateway, http.MethodDelete, "/keys/DiskType", "").Code)
+}
+
+func TestBatchPutAndBatchGetKeys(t *testing.T) {
+	gateway := NewGateway(conn.NewHandlers(store.NewInMemoryStore()))
+
+	recorder := serve(gateway, http.MethodPost, "/batch/put", `{"pairs": [{"key": "DiskType", "value": "SSD"}, {"key": "Engine", "value": "skiplist"}]}`)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Len(t, decode[BatchResponse](t, recorder).Pairs, 2)
+
+	recorder = serve(gateway, http.MethodPost, "/batch/get", `{"keys": ["Engine", "Unknown", "DiskType"]}`)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	pairs := decode[BatchResponse](t, recorder).Pairs
+	assert.Equal(t, []string{"skiplist", "", "SSD"}, []string{pairs[0].Value, pairs[1].Value, pairs[2].Value})
+	assert.Equal(t, []string{"Ok", "NotOk", "Ok"}, []string{pairs[0].Status, pairs[1].Status, pairs[2].Status})
+}
+
+func TestBatchGetWithAMalformedBody(t *testing.T) {
+	gateway := NewGateway(conn.NewHandlers(store.NewInMemoryStore()))
+
+	recorder := serve(gateway, http.MethodPost, "/batch/get", `{"keys": `)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, "Corrupt", decode[ErrorResponse](t, recorder).Status)
+}
+
+func TestPutAKeyWhichTheStoreCanNotMakeDurable(t *testing.T) {
+	durableStore, _ := store.OpenDurableStore(filepath.Join(t.TempDir(), "store.wal"), store.SyncPolicy{Mode: store.SyncAlways}, store.NewInMemoryStore())
+	_ = durableStore.Close()
+	gateway := NewGateway(conn.NewHandlers(durableStore))
+
+	recorder := serve(gateway, http.MethodPut, "/keys/DiskType", "NVMe SSD")
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+
+	errorResponse := decode[ErrorResponse](t, recorder)
+	assert.Equal(t, "NotDurable", errorResponse.Status)
+	assert.Equal(t, "DiskType", errorResponse.Key)
+}
+
+func TestPutAKeyOnceItIsDurable(t *testing.T) {
+	durableStore, _ := store.OpenDurableStore(filepath.Join(t.TempDir(), "store.wal"), store.SyncPolicy{Mode: store.SyncAlways}, store.NewInMemoryStore())
+	defer func() {
+		_ = durableStore.Close()
+	}()
+	deferred := durableStore.Deferred()
+	gateway := NewGatewayWithDurability(conn.NewHandlers(deferred), deferred)
+
+	recorder := serve(gateway, http.MethodPut, "/keys/DiskType", "NVMe SSD")
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Equal(t, durableStore.Appended(), durableStore.Synced())
+}
@@ -0,0 +1,41 @@
+package memcached
+
+import (
+	"bytes"
+	"errors"
+	"single_thread_eventloop/conn"
+)
+
+// Codec is the conn.Codec for the memcached text protocol, so that the server can be swapped in for memcached.
+type Codec struct {
+	executor *Executor
+}
+
+// NewCodec creates a new instance of Codec.
+func NewCodec(handlers map[uint32]conn.Handler) *Codec {
+	return &Codec{
+		executor: NewExecutor(handlers),
+	}
+}
+
+// Answer executes the first command in the buffer, and returns its reply.
+// ParseCommand is incremental (a storage command is complete only once its data block has arrived), so a command
+// which has partially arrived stays in the buffer until the rest arrives.
+// Commands which carry noreply are executed without being answered. A malformed command is answered with a
+// CLIENT_ERROR before the connection is closed, because the position of the next command is unknown.
+func (codec *Codec) Answer(buffer *bytes.Buffer) ([]byte, error) {
+	for {
+		command, consumed, err := ParseCommand(buffer.Bytes())
+		if err != nil {
+			if errors.Is(err, ErrIncompleteCommand) {
+				return nil, conn.ErrIncompleteRequest
+			}
+			return clientErrorReplyOf(err), err
+		}
+		reply := codec.executor.Execute(command)
+		buffer.Next(consumed)
+		if len(reply) > 0 {
+			return reply, nil
+		}
+	}
+}
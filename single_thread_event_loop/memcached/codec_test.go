@@ -0,0 +1,43 @@
+package memcached
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"single_thread_eventloop/conn"
+	"single_thread_eventloop/store"
+	"testing"
+)
+
+func TestCodecAnswersAStorageCommandWhichArrivesInPieces(t *testing.T) {
+	codec := NewCodec(conn.NewHandlers(store.NewInMemoryStore()))
+	buffer := bytes.NewBuffer([]byte{})
+
+	for _, piece := range []string{"set DiskType 0 0", " 8\r\nNVMe", " SSD\r"} {
+		buffer.WriteString(piece)
+		_, err := codec.Answer(buffer)
+		assert.ErrorIs(t, err, conn.ErrIncompleteRequest)
+	}
+	buffer.WriteString("\n")
+
+	reply, err := codec.Answer(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "STORED\r\n", string(reply))
+	assert.Equal(t, 0, buffer.Len())
+}
+
+func TestCodecExecutesCommandsWithNoReplyWithoutAnsweringThem(t *testing.T) {
+	codec := NewCodec(conn.NewHandlers(store.NewInMemoryStore()))
+	buffer := bytes.NewBufferString("set DiskType 0 0 3 noreply\r\nSSD\r\nget DiskType\r\n")
+
+	reply, err := codec.Answer(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "VALUE DiskType 0 3\r\nSSD\r\nEND\r\n", string(reply))
+}
+
+func TestCodecAnswersABadDataChunkWithAClientError(t *testing.T) {
+	codec := NewCodec(conn.NewHandlers(store.NewInMemoryStore()))
+
+	reply, err := codec.Answer(bytes.NewBufferString("set DiskType 0 0 3\r\nNVMe\r\n"))
+	assert.ErrorIs(t, err, ErrBadDataChunk)
+	assert.Equal(t, "CLIENT_ERROR bad data chunk\r\n", string(reply))
+}
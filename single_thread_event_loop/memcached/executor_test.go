@@ -0,0 +1,148 @@
+package memcached
+
+import (
+	"github.com/stretchr/testify/assert"
+	"single_thread_eventloop/conn"
+	"single_thread_eventloop/store"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func execute(executor *Executor, line string) string {
+	command, _, err := ParseCommand([]byte(line))
+	if err != nil {
+		return err.Error()
+	}
+	return string(executor.Execute(command))
+}
+
+func TestExecuteSetAndGet(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+
+	assert.Equal(t, "STORED\r\n", execute(executor, "set DiskType 0 0 8\r\nNVMe SSD\r\n"))
+	assert.Equal(t, "VALUE DiskType 0 8\r\nNVMe SSD\r\nEND\r\n", execute(executor, "get DiskType\r\n"))
+}
+
+func TestExecuteGetMultipleKeys(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+	execute(executor, "set DiskType 0 0 3\r\nSSD\r\n")
+	execute(executor, "set Engine 0 0 8\r\nskiplist\r\n")
+
+	assert.Equal(t,
+		"VALUE Engine 0 8\r\nskiplist\r\nVALUE DiskType 0 3\r\nSSD\r\nEND\r\n",
+		execute(executor, "get Engine Unknown DiskType\r\n"),
+	)
+}
+
+func TestExecuteGetANonExistingKey(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+
+	assert.Equal(t, "END\r\n", execute(executor, "get DiskType\r\n"))
+}
+
+func TestExecuteSetWithAnExpirationTime(t *testing.T) {
+	keyValueStore := store.NewInMemoryStore()
+	executor := NewExecutor(conn.NewHandlers(keyValueStore))
+
+	assert.Equal(t, "STORED\r\n", execute(executor, "set DiskType 0 100 3\r\nSSD\r\n"))
+
+	ttl, ok := keyValueStore.TimeToLive([]byte("DiskType"))
+	assert.True(t, ok)
+	assert.True(t, ttl > 90*time.Second && ttl <= 100*time.Second)
+}
+
+func TestExecuteSetWithAnAbsoluteExpirationTime(t *testing.T) {
+	keyValueStore := store.NewInMemoryStore()
+	executor := NewExecutor(conn.NewHandlers(keyValueStore))
+	now := time.Now()
+	executor.clock = func() time.Time { return now }
+
+	execute(executor, "set DiskType 0 "+strconv.FormatInt(now.Add(time.Hour).Unix(), 10)+" 3\r\nSSD\r\n")
+
+	ttl, ok := keyValueStore.TimeToLive([]byte("DiskType"))
+	assert.True(t, ok)
+	assert.True(t, ttl > 59*time.Minute && ttl <= time.Hour)
+}
+
+func TestExecuteSetWithNoReply(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+
+	assert.Equal(t, "", execute(executor, "set DiskType 0 0 3 noreply\r\nSSD\r\n"))
+	assert.Equal(t, "VALUE DiskType 0 3\r\nSSD\r\nEND\r\n", execute(executor, "get DiskType\r\n"))
+}
+
+func TestExecuteAdd(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+
+	assert.Equal(t, "STORED\r\n", execute(executor, "add DiskType 0 0 3\r\nSSD\r\n"))
+	assert.Equal(t, "NOT_STORED\r\n", execute(executor, "add DiskType 0 0 3\r\nHDD\r\n"))
+	assert.Equal(t, "VALUE DiskType 0 3\r\nSSD\r\nEND\r\n", execute(executor, "get DiskType\r\n"))
+}
+
+func TestExecuteReplace(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+
+	assert.Equal(t, "NOT_STORED\r\n", execute(executor, "replace DiskType 0 0 3\r\nSSD\r\n"))
+	execute(executor, "set DiskType 0 0 3\r\nSSD\r\n")
+	assert.Equal(t, "STORED\r\n", execute(executor, "replace DiskType 0 0 3\r\nHDD\r\n"))
+	assert.Equal(t, "VALUE DiskType 0 3\r\nHDD\r\nEND\r\n", execute(executor, "get DiskType\r\n"))
+}
+
+func TestExecuteGetsAndCas(t *testing.T) {
+	keyValueStore := store.NewInMemoryStore()
+	executor := NewExecutor(conn.NewHandlers(keyValueStore))
+	execute(executor, "set DiskType 0 0 3\r\nSSD\r\n")
+	_, version, _ := keyValueStore.GetVersionedValue([]byte("DiskType"))
+	casUnique := strconv.FormatUint(version, 10)
+
+	assert.Equal(t, "VALUE DiskType 0 3 "+casUnique+"\r\nSSD\r\nEND\r\n", execute(executor, "gets DiskType\r\n"))
+	assert.Equal(t, "STORED\r\n", execute(executor, "cas DiskType 0 0 3 "+casUnique+"\r\nHDD\r\n"))
+	assert.Equal(t, "EXISTS\r\n", execute(executor, "cas DiskType 0 0 3 "+casUnique+"\r\nSSD\r\n"))
+	assert.Equal(t, "NOT_FOUND\r\n", execute(executor, "cas Engine 0 0 3 "+casUnique+"\r\nLSM\r\n"))
+	assert.Equal(t, "NOT_FOUND\r\n", execute(executor, "cas Engine 0 0 3 0\r\nLSM\r\n"))
+}
+
+func TestExecuteDelete(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+	execute(executor, "set DiskType 0 0 3\r\nSSD\r\n")
+
+	assert.Equal(t, "DELETED\r\n", execute(executor, "delete DiskType\r\n"))
+	assert.Equal(t, "NOT_FOUND\r\n", execute(executor, "delete DiskType\r\n"))
+}
+
+func TestExecuteIncrAndDecr(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+
+	assert.Equal(t, "NOT_FOUND\r\n", execute(executor, "incr counter 1\r\n"))
+
+	execute(executor, "set counter 0 0 2\r\n10\r\n")
+	assert.Equal(t, "15\r\n", execute(executor, "incr counter 5\r\n"))
+	assert.Equal(t, "12\r\n", execute(executor, "decr counter 3\r\n"))
+	assert.Equal(t, "0\r\n", execute(executor, "decr counter 100\r\n"))
+}
+
+func TestExecuteIncrOnANonNumericValue(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+	execute(executor, "set DiskType 0 0 3\r\nSSD\r\n")
+
+	assert.Equal(t, "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n", execute(executor, "incr DiskType 1\r\n"))
+	assert.Equal(t, "CLIENT_ERROR invalid numeric delta argument\r\n", execute(executor, "incr DiskType one\r\n"))
+}
+
+func TestExecuteAnUnknownCommand(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+
+	assert.Equal(t, "ERROR\r\n", execute(executor, "flush_all\r\n"))
+	assert.Equal(t, "ERROR\r\n", execute(executor, "\r\n"))
+}
+
+func TestExecuteAStorageCommandWithAnInvalidKey(t *testing.T) {
+	executor := NewExecutor(conn.NewHandlers(store.NewInMemoryStore()))
+
+	assert.Equal(t,
+		"CLIENT_ERROR bad command line format\r\n",
+		execute(executor, "set "+strings.Repeat("k", maxKeyLength+1)+" 0 0 3\r\nSSD\r\n"),
+	)
+}
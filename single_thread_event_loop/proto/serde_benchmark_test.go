@@ -0,0 +1,75 @@
+package proto
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// benchmarkValue returns a value of the given size which compresses roughly like a JSON document:
+// a small vocabulary of words along with random numbers.
+func benchmarkValue(size int) string {
+	words := []string{`{"disk":`, `"ssd"`, `"nvme"`, `,"storage":`, `"lsm"`, `"btree"`, `,"replicas":`, `},`}
+	random := rand.New(rand.NewSource(int64(size)))
+
+	var buffer bytes.Buffer
+	for buffer.Len() < size {
+		buffer.WriteString(words[random.Intn(len(words))])
+		buffer.WriteString(fmt.Sprint(random.Intn(100000)))
+	}
+	return buffer.String()[:size]
+}
+
+// BenchmarkSerialize shows the CPU versus bytes trade-off of compression: compare ns/op and wire-bytes/op of
+// the Uncompressed and the Compressed variants for every value size.
+func BenchmarkSerialize(b *testing.B) {
+	for _, size := range []int{256, 4 * 1024, 64 * 1024} {
+		message := NewGetValueSuccessfulResponseMessage([]byte("DiskType"), []byte(benchmarkValue(size)), 1)
+		for _, variant := range []struct {
+			name    string
+			options FrameOptions
+		}{
+			{name: "Uncompressed", options: FrameOptions{}},
+			{name: "Compressed", options: FrameOptions{CompressAbove: 1}},
+		} {
+			b.Run(fmt.Sprintf("%v/%vB", variant.name, size), func(b *testing.B) {
+				b.SetBytes(int64(size))
+				wireBytes := 0
+				for count := 0; count < b.N; count++ {
+					buffer, err := message.SerializeWith(variant.options)
+					if err != nil {
+						b.Fatal(err)
+					}
+					wireBytes = len(buffer)
+				}
+				b.ReportMetric(float64(wireBytes), "wire-bytes/op")
+			})
+		}
+	}
+}
+
+// BenchmarkDeserialize shows the CPU cost of decompression on the receiving side.
+func BenchmarkDeserialize(b *testing.B) {
+	for _, size := range []int{256, 4 * 1024, 64 * 1024} {
+		message := NewGetValueSuccessfulResponseMessage([]byte("DiskType"), []byte(benchmarkValue(size)), 1)
+		for _, variant := range []struct {
+			name    string
+			options FrameOptions
+		}{
+			{name: "Uncompressed", options: FrameOptions{}},
+			{name: "Compressed", options: FrameOptions{CompressAbove: 1}},
+		} {
+			buffer, _ := message.SerializeWith(variant.options)
+			b.Run(fmt.Sprintf("%v/%vB", variant.name, size), func(b *testing.B) {
+				b.SetBytes(int64(size))
+				for count := 0; count < b.N; count++ {
+					if _, err := DeserializeFrom(bytes.NewReader(buffer)); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(buffer)), "wire-bytes/op")
+			})
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package single_thread_event_loop
+
+import (
+	"github.com/stretchr/testify/assert"
+	"single_thread_eventloop/proto"
+	"testing"
+)
+
+func TestDetectsTheProtocolFromTheFirstBytes(t *testing.T) {
+	frame, _ := proto.NewGetValueMessage("DiskType").Serialize()
+	for _, detection := range []struct {
+		data     []byte
+		protocol Protocol
+	}{
+		{frame, ProtocolProtobuf},
+		{[]byte("*2\r\n$3\r\nGET\r\n$8\r\nDiskType\r\n"), ProtocolRESP},
+		{[]byte("$4\r\nPING\r\n"), ProtocolRESP},
+		{[]byte("set DiskType 0 0 8\r\nNVMe SSD\r\n"), ProtocolMemcached},
+		{[]byte("gets DiskType\r\n"), ProtocolMemcached},
+		{[]byte("GET /keys/DiskType HTTP/1.1\r\n"), ProtocolHTTP},
+		{[]byte("DELETE /keys/DiskType HTTP/1.1\r\n"), ProtocolHTTP},
+		{[]byte("*a\r\n"), ProtocolProtobuf},
+		{[]byte("*\r\n"), ProtocolProtobuf},
+		{[]byte("fetch DiskType\r\n"), ProtocolProtobuf},
+		{[]byte("GETS /keys HTTP/1.1\r\n"), ProtocolProtobuf},
+		{[]byte("*1234567890123456789"), ProtocolProtobuf},
+	} {
+		protocol, ok := DetectProtocol(detection.data)
+		assert.True(t, ok, string(detection.data))
+		assert.Equal(t, detection.protocol, protocol, string(detection.data))
+	}
+}
+
+func TestDetectsTheProtocolFromBytesWhichArriveIncrementally(t *testing.T) {
+	for _, detection := range []struct {
+		data      string
+		decidedAt int
+		protocol  Protocol
+	}{
+		{"*2\r\n$3\r\nGET\r\n", 4, ProtocolRESP},
+		{"replace DiskType 0 0 8\r\n", 8, ProtocolMemcached},
+		{"OPTIONS / HTTP/1.1\r\n", 8, ProtocolHTTP},
+		{"*\x00\x00\x00", 2, ProtocolProtobuf},
+		{"ge\x00\x00", 3, ProtocolProtobuf},
+	} {
+		for length := 0; length < detection.decidedAt; length++ {
+			_, ok := DetectProtocol([]byte(detection.data[:length]))
+			assert.False(t, ok, detection.data[:length])
+		}
+		protocol, ok := DetectProtocol([]byte(detection.data[:detection.decidedAt]))
+		assert.True(t, ok, detection.data)
+		assert.Equal(t, detection.protocol, protocol, detection.data)
+	}
+}
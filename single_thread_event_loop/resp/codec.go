@@ -0,0 +1,43 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"single_thread_eventloop/conn"
+)
+
+// Codec is the conn.Codec for RESP (the Redis serialization protocol), so that redis-cli and the Redis client
+// libraries can talk to the server.
+type Codec struct {
+	executor *Executor
+}
+
+// NewCodec creates a new instance of Codec.
+func NewCodec(handlers map[uint32]conn.Handler) *Codec {
+	return &Codec{
+		executor: NewExecutor(handlers),
+	}
+}
+
+// Answer executes the first command in the buffer, and returns its reply.
+// ParseCommand is incremental, so a command which has partially arrived stays in the buffer until the rest arrives.
+// Commands without arguments (such as empty lines) are skipped. A protocol error is answered with an error reply
+// before the connection is closed, because the position of the next command is unknown.
+func (codec *Codec) Answer(buffer *bytes.Buffer) ([]byte, error) {
+	for {
+		args, consumed, err := ParseCommand(buffer.Bytes())
+		if err != nil {
+			if errors.Is(err, ErrIncompleteCommand) {
+				return nil, conn.ErrIncompleteRequest
+			}
+			return AppendError(nil, "ERR "+err.Error()), err
+		}
+		if len(args) == 0 {
+			buffer.Next(consumed)
+			continue
+		}
+		reply := codec.executor.Execute(args)
+		buffer.Next(consumed)
+		return reply, nil
+	}
+}
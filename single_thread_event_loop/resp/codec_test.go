@@ -0,0 +1,42 @@
+package resp
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"single_thread_eventloop/conn"
+	"single_thread_eventloop/store"
+	"testing"
+)
+
+func TestCodecAnswersACommandWhichArrivesInPieces(t *testing.T) {
+	codec := NewCodec(conn.NewHandlers(store.NewInMemoryStore()))
+	buffer := bytes.NewBuffer([]byte{})
+
+	for _, piece := range []string{"*3\r\n$3\r\nSET\r", "\n$8\r\nDiskType\r\n$3\r", "\nSSD\r\n"} {
+		_, err := codec.Answer(buffer)
+		assert.ErrorIs(t, err, conn.ErrIncompleteRequest)
+		buffer.WriteString(piece)
+	}
+
+	reply, err := codec.Answer(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "+OK\r\n", string(reply))
+	assert.Equal(t, 0, buffer.Len())
+}
+
+func TestCodecSkipsEmptyInlineCommands(t *testing.T) {
+	codec := NewCodec(conn.NewHandlers(store.NewInMemoryStore()))
+	buffer := bytes.NewBufferString("\r\n\r\nPING\r\n")
+
+	reply, err := codec.Answer(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "+PONG\r\n", string(reply))
+}
+
+func TestCodecAnswersAProtocolErrorWithAnErrorReply(t *testing.T) {
+	codec := NewCodec(conn.NewHandlers(store.NewInMemoryStore()))
+
+	reply, err := codec.Answer(bytes.NewBufferString("*1\r\n$abc\r\n"))
+	assert.ErrorIs(t, err, ErrProtocol)
+	assert.Equal(t, "-ERR Protocol error: invalid length\r\n", string(reply))
+}
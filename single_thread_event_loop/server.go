@@ -1,25 +1,84 @@
 package single_thread_event_loop
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"single_thread_eventloop/conn"
 	"single_thread_eventloop/event_loop"
+	"single_thread_eventloop/gateway"
+	"single_thread_eventloop/memcached"
 	"single_thread_eventloop/proto"
+	"single_thread_eventloop/resp"
 	"single_thread_eventloop/store"
 	"syscall"
+	"time"
 )
 
-const MaxClients = 10_000
+const (
+	MaxClients               = 10_000
+	ExpiryInterval           = 100 * time.Millisecond
+	MaxKeysExaminedPerExpiry = 1000
+	KeepaliveInterval        = 100 * time.Millisecond
+	ACLReloadInterval        = time.Second
+)
+
+// ErrAuthenticationNotSupported denotes that authentication is required for a server which serves the protocols
+// other than ProtocolProtobuf, whose connections can not authenticate.
+var ErrAuthenticationNotSupported = errors.New("authentication is only supported by the protobuf protocol")
+
+// ErrAuthenticationRequired denotes that authorization is required for a server which does not require authentication,
+// whose connections have no identity to authorize.
+var ErrAuthenticationRequired = errors.New("authorization requires authentication")
 
 // TCPServer represents an async TCP TCPServer
 type TCPServer struct {
-	serverFd  int
-	eventLoop *event_loop.EventLoop
+	serverFd    int
+	eventLoop   *event_loop.EventLoop
+	handlers    map[uint32]conn.Handler
+	durable     *store.DurableStore
+	protocol    Protocol
+	httpServer  *http.Server
+	keepalive   *conn.Keepalive
+	openedStore *store.DurableStore
 }
 
-// NewTCPServer creates a new instance of TCPServer.
+// NewTCPServer creates a new instance of TCPServer, which speaks ProtocolProtobuf.
 func NewTCPServer(host string, port uint16) (*TCPServer, error) {
+	return NewTCPServerWithProtocol(host, port, ProtocolProtobuf)
+}
+
+// NewTCPServerWithProtocol creates a new instance of TCPServer, which speaks the given protocol.
+// A server with ProtocolAuto detects the protocol of every connection (see DetectProtocol), and serves all the
+// protocols on a single port. The HTTP connections are served by the gateway.Codec.
+func NewTCPServerWithProtocol(host string, port uint16, protocol Protocol) (*TCPServer, error) {
+	return NewTCPServerWithStore(host, port, protocol, store.NewInMemoryStore())
+}
+
+// NewDurableTCPServer creates a new instance of TCPServer, which speaks the given protocol and holds the Key/Value
+// pairs in a store.DurableStore: the write-ahead log at the given path is replayed into the store, and is synced as
+// per the policy, so that the pairs survive a restart of the server. The log is closed when the server is stopped.
+// It returns store.ErrInvalidSyncPolicy for an invalid policy, and store.ErrCorruptRecord for a log which can not be
+// replayed.
+func NewDurableTCPServer(host string, port uint16, protocol Protocol, path string, policy store.SyncPolicy) (*TCPServer, error) {
+	durableStore, err := store.OpenDurableStore(path, policy, store.NewInMemoryStore())
+	if err != nil {
+		return nil, err
+	}
+	server, err := NewTCPServerWithStore(host, port, protocol, durableStore)
+	if err != nil {
+		_ = durableStore.Close()
+		return nil, err
+	}
+	server.openedStore = durableStore
+	return server, nil
+}
+
+// NewTCPServerWithStore creates a new instance of TCPServer, which speaks the given protocol and holds the Key/Value
+// pairs in the given store. The store may be any implementation of store.Store (see storetest.TestStore).
+func NewTCPServerWithStore(host string, port uint16, protocol Protocol, kvStore store.Store) (*TCPServer, error) {
 	//starts the listener on the given port and returns the server file descriptor, if there is no error.
 	startListener := func() (int, error) {
 		// syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0) creates an IPv4 (AF_INET), bidirectional (SOCK_STREAM), TCP (0) socket.
@@ -49,14 +108,60 @@ func NewTCPServer(host string, port uint16) (*TCPServer, error) {
 		return serverFd, nil
 	}
 	//createEventLoop creates an instance of Event loop.
-	createEventLoop := func(serverFd int, store *store.InMemoryStore) (*event_loop.EventLoop, error) {
-		eventLoop, err := event_loop.NewEventLoop(serverFd, MaxClients, map[uint32]conn.Handler{
-			proto.KeyValueMessageKindPutOrUpdate: conn.NewPutOrUpdateHandler(store),
-			proto.KeyValueMessageKindGet:         conn.NewGetHandler(store),
-		})
+	// the protobuf connections are kept alive with the keepalive, which may be changed (see SetKeepalive) till
+	// the server is started.
+	createEventLoop := func(
+		serverFd int,
+		store store.Store,
+		durable *store.DurableStore,
+		handlers map[uint32]conn.Handler,
+		keepalive *conn.Keepalive,
+	) (*event_loop.EventLoop, error) {
+		// the connections of the protocols other than ProtocolProtobuf are refused if the server requires
+		// authentication.
+		codecFor := func(protocol Protocol) conn.Codec {
+			if protocol != ProtocolProtobuf && requiresAuthentication(handlers) {
+				return refusingCodec{}
+			}
+			switch protocol {
+			case ProtocolHTTP:
+				return gateway.NewCodec(handlers)
+			case ProtocolRESP:
+				return resp.NewCodec(handlers)
+			case ProtocolMemcached:
+				return memcached.NewCodec(handlers)
+			default:
+				return conn.NewProtobufCodecWithKeepalive(handlers, *keepalive)
+			}
+		}
+		newCodec := func() conn.Codec {
+			if protocol == ProtocolAuto {
+				return newDetectingCodec(codecFor)
+			}
+			return codecFor(protocol)
+		}
+		eventLoop, err := event_loop.NewEventLoop(serverFd, MaxClients, newCodec)
 		if err != nil {
 			return nil, err
 		}
+		// expired keys are actively deleted from the store on a timer of the event loop, every ExpiryInterval.
+		if err := eventLoop.AddTimer(ExpiryInterval, func() {
+			store.EvictExpired(MaxKeysExaminedPerExpiry)
+		}); err != nil {
+			return nil, err
+		}
+		// the idle clients are pinged (and the ones which do not answer are stopped) on a timer of the event loop,
+		// every KeepaliveInterval.
+		if err := eventLoop.AddTimer(KeepaliveInterval, eventLoop.PingClients); err != nil {
+			return nil, err
+		}
+		// the mutations of a durable store are acknowledged once they are synced to its write-ahead log, without
+		// blocking the event loop on fsync.
+		if durable != nil {
+			if err := eventLoop.HoldResponsesFor(durable); err != nil {
+				return nil, err
+			}
+		}
 		return eventLoop, nil
 	}
 	//init creates an instance of TCPServer.
@@ -65,13 +170,27 @@ func NewTCPServer(host string, port uint16) (*TCPServer, error) {
 		if err != nil {
 			return nil, err
 		}
-		eventLoop, err := createEventLoop(serverFd, store.NewInMemoryStore())
+		// the handlers of a durable store which syncs every record (see store.DurableStore) mutate its Deferred
+		// view, which does not wait for the syncs, and the responses are held back till the log is synced instead.
+		var durable *store.DurableStore
+		handlerStore := kvStore
+		if durableStore, ok := kvStore.(*store.DurableStore); ok && durableStore.Policy().Mode == store.SyncAlways {
+			durable = durableStore.Deferred()
+			handlerStore = durable
+		}
+		handlers := conn.NewHandlers(handlerStore)
+		keepalive := conn.DefaultKeepalive
+		eventLoop, err := createEventLoop(serverFd, kvStore, durable, handlers, &keepalive)
 		if err != nil {
 			return nil, err
 		}
 		return &TCPServer{
 			serverFd:  serverFd,
 			eventLoop: eventLoop,
+			handlers:  handlers,
+			durable:   durable,
+			protocol:  protocol,
+			keepalive: &keepalive,
 		}, nil
 	}
 	return init()
@@ -84,10 +203,82 @@ func (server *TCPServer) Start() {
 	server.eventLoop.Run()
 }
 
-// Stop stops the server.
+// StartHTTPGateway starts the HTTP/JSON gateway (see gateway.Gateway) on the given host and port, alongside the
+// server. The gateway shares the store of the server, and is stopped along with the server.
+// The gateway serves the requests in goroutines of its own, outside the event loop; the store is safe for concurrent
+// use, so the handlers are shared as they are. The handlers of a durable store do not wait for the syncs of its
+// write-ahead log, so the gateway waits for them (see gateway.NewGatewayWithDurability).
+// It returns once the gateway is listening, and is expected to be invoked before the server is stopped.
+// It returns ErrAuthenticationNotSupported if the server requires authentication.
+func (server *TCPServer) StartHTTPGateway(host string, port uint16) error {
+	if requiresAuthentication(server.handlers) {
+		return ErrAuthenticationNotSupported
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%v", host, port))
+	if err != nil {
+		return err
+	}
+	httpGateway := gateway.NewGateway(server.handlers)
+	if server.durable != nil {
+		httpGateway = gateway.NewGatewayWithDurability(server.handlers, server.durable)
+	}
+	server.httpServer = &http.Server{Handler: httpGateway}
+	go func() {
+		_ = server.httpServer.Serve(listener)
+	}()
+	return nil
+}
+
+// RequireAuthentication makes every connection authenticate with the given authenticator (see conn.NewAuthHandler)
+// before any other request, and records the authenticated identity of the connection.
+// Only the protobuf connections can authenticate, so a server with ProtocolAuto closes the connections of the other
+// protocols. It returns ErrAuthenticationNotSupported for a server of any other protocol, or with the HTTP gateway.
+// It is expected to be invoked before the server is started.
+func (server *TCPServer) RequireAuthentication(authenticator *conn.Authenticator) error {
+	if (server.protocol != ProtocolProtobuf && server.protocol != ProtocolAuto) || server.httpServer != nil {
+		return ErrAuthenticationNotSupported
+	}
+	server.handlers[proto.KeyValueMessageKindAuth] = conn.NewAuthHandler(authenticator)
+	return nil
+}
+
+// RequireAuthorization makes every get, put, delete and scan of the keys be authorized by the ACL for the identity of
+// the connection (see conn.NewAuthorizedHandlers), and answers the denied requests with proto.Status_PermissionDenied.
+// The rules of the ACL are reloaded if its file is modified, on a timer of the event loop, every ACLReloadInterval.
+// The timer only stats the file while it is unmodified, so it does not hold up the clients for long. A file which
+// can not be loaded is logged once for every modification (see conn.ACL.ReloadIfModified).
+// It returns ErrAuthenticationRequired if the server does not require authentication (see RequireAuthentication).
+// It is expected to be invoked before the server is started.
+func (server *TCPServer) RequireAuthorization(acl *conn.ACL) error {
+	if !requiresAuthentication(server.handlers) {
+		return ErrAuthenticationRequired
+	}
+	conn.NewAuthorizedHandlers(server.handlers, acl)
+	return server.eventLoop.AddTimer(ACLReloadInterval, func() {
+		if _, err := acl.ReloadIfModified(); err != nil {
+			log.Println("Failed to reload the ACL:", err)
+		}
+	})
+}
+
+// SetKeepalive sets the conn.Keepalive of the protobuf connections, which are pinged when they are idle and closed
+// if they do not answer in time. It is expected to be invoked before the server is started.
+func (server *TCPServer) SetKeepalive(keepalive conn.Keepalive) {
+	*server.keepalive = keepalive
+}
+
+// Stop stops the server, and the HTTP gateway if it is started. The write-ahead log of a server which is created by
+// NewDurableTCPServer is closed after syncing the appended records, before the event loop is stopped, so that
+// the responses which are held back for the final sync are written (see event_loop.EventLoop.Stop).
 func (server *TCPServer) Stop() {
 	log.Println("Stopping TCPServer")
 
+	if server.openedStore != nil {
+		_ = server.openedStore.Close()
+	}
 	server.eventLoop.Stop()
 	_ = syscall.Close(server.serverFd)
+	if server.httpServer != nil {
+		_ = server.httpServer.Close()
+	}
 }
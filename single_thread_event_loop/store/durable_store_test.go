@@ -0,0 +1,160 @@
+package store
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplaysTheMutationsOfADurableStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.wal")
+	store, err := OpenDurableStore(path, SyncPolicy{Mode: SyncAlways}, NewInMemoryStore())
+	assert.Nil(t, err)
+
+	store.PutOrUpdate([]byte("DiskType"), []byte("HDD"))
+	store.MultiPutOrUpdate([]KeyValuePair{
+		{Key: []byte("Storage"), Value: []byte("LSM")},
+		{Key: []byte("Engine"), Value: []byte("btree")},
+	})
+	_, version, _ := store.GetVersionedValue([]byte("DiskType"))
+	store.CompareAndSwap([]byte("DiskType"), version, []byte("SSD"))
+	store.CompareAndSwap([]byte("DiskType"), version, []byte("NVMe"))
+	_, _ = store.IncrementBy([]byte("Counter"), 5)
+	store.Transact(nil, []Operation{
+		{Kind: OperationDelete, Key: []byte("Engine")},
+		{Kind: OperationGet, Key: []byte("Storage")},
+		{Kind: OperationPutOrUpdate, Key: []byte("System"), Value: []byte("Distributed"), TTL: time.Minute},
+	})
+	store.Delete([]byte("Storage"))
+	_, latestVersion, _ := store.GetVersionedValue([]byte("System"))
+	assert.Nil(t, store.Close())
+
+	store, err = OpenDurableStore(path, SyncPolicy{Mode: SyncAlways}, NewInMemoryStore())
+	assert.Nil(t, err)
+	defer func() {
+		_ = store.Close()
+	}()
+
+	value, _ := store.GetValue([]byte("DiskType"))
+	assert.Equal(t, []byte("SSD"), value)
+	value, _ = store.GetValue([]byte("Counter"))
+	assert.Equal(t, []byte("5"), value)
+	_, ok := store.GetValue([]byte("Engine"))
+	assert.False(t, ok)
+	_, ok = store.GetValue([]byte("Storage"))
+	assert.False(t, ok)
+
+	_, version, _ = store.GetVersionedValue([]byte("System"))
+	assert.Equal(t, latestVersion, version)
+	ttl, _ := store.TimeToLive([]byte("System"))
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+}
+
+func TestDeletesTheExpiredKeysOnReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.wal")
+	store, _ := OpenDurableStore(path, SyncPolicy{Mode: SyncNever}, NewInMemoryStore())
+	// the keys are put two seconds ago, so the key with a time to live of a second has expired.
+	store.clock = func() time.Time { return time.Now().Add(-2 * time.Second) }
+
+	store.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+	store.PutOrUpdateWithTTL([]byte("DiskType"), []byte("HDD"), time.Second)
+	store.PutOrUpdate([]byte("Storage"), []byte("LSM"))
+	_, latestVersion, _ := store.GetVersionedValue([]byte("Storage"))
+	assert.Nil(t, store.Close())
+
+	store, err := OpenDurableStore(path, SyncPolicy{Mode: SyncNever}, NewInMemoryStore())
+	assert.Nil(t, err)
+	defer func() {
+		_ = store.Close()
+	}()
+
+	_, ok := store.GetValue([]byte("DiskType"))
+	assert.False(t, ok)
+	_, version, _ := store.GetVersionedValue([]byte("Storage"))
+	assert.Equal(t, latestVersion, version)
+}
+
+func TestDefersTheSyncsOfADurableStore(t *testing.T) {
+	store, _ := OpenDurableStore(filepath.Join(t.TempDir(), "store.wal"), SyncPolicy{Mode: SyncAlways}, NewInMemoryStore())
+	defer func() {
+		_ = store.Close()
+	}()
+	synced := make(chan struct{}, 1)
+	store.OnSync(func() {
+		select {
+		case synced <- struct{}{}:
+		default:
+		}
+	})
+	deferred := store.Deferred()
+
+	deferred.PutOrUpdate([]byte("DiskType"), []byte("SSD"))
+	appended := deferred.Appended()
+
+	select {
+	case <-synced:
+	case <-time.After(time.Second):
+		t.Fatal("log is not synced")
+	}
+	assert.Equal(t, appended, deferred.Synced())
+
+	store.PutOrUpdate([]byte("DiskType"), []byte("NVMe"))
+	assert.Equal(t, store.Appended(), store.Synced())
+	value, _ := deferred.GetValue([]byte("DiskType"))
+	assert.Equal(t, []byte("NVMe"), value)
+}
+
+func TestReportsTheSyncedSequenceOfAPeriodicallySyncedStore(t *testing.T) {
+	store, _ := OpenDurableStore(filepath.Join(t.TempDir(), "store.wal"), SyncPolicy{Mode: SyncPeriodically, Interval: time.Hour}, NewInMemoryStore())
+
+	assert.Nil(t, store.PutOrUpdate([]byte("DiskType"), []byte("SSD")))
+	assert.True(t, store.Synced() < store.Appended())
+
+	assert.Nil(t, store.Close())
+	assert.Equal(t, store.Appended(), store.Synced())
+}
+
+func TestDoesNotApplyAMutationWhichCanNotBeAppended(t *testing.T) {
+	store, _ := OpenDurableStore(filepath.Join(t.TempDir(), "store.wal"), SyncPolicy{Mode: SyncAlways}, NewInMemoryStore())
+	assert.Nil(t, store.PutOrUpdate([]byte("DiskType"), []byte("SSD")))
+	assert.Nil(t, store.Close())
+
+	assert.ErrorIs(t, store.PutOrUpdate([]byte("DiskType"), []byte("HDD")), ErrWALClosed)
+	_, err := store.IncrementBy([]byte("Counter"), 1)
+	assert.ErrorIs(t, err, ErrWALClosed)
+	ok, err := store.Delete([]byte("DiskType"))
+	assert.ErrorIs(t, err, ErrWALClosed)
+	assert.False(t, ok)
+
+	value, _ := store.GetValue([]byte("DiskType"))
+	assert.Equal(t, []byte("SSD"), value)
+	_, ok = store.GetValue([]byte("Counter"))
+	assert.False(t, ok)
+}
+
+func TestMutatesADurableStoreConcurrently(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.wal")
+	store, _ := OpenDurableStore(path, SyncPolicy{Mode: SyncAlways}, NewInMemoryStore())
+
+	var group sync.WaitGroup
+	for goroutine := 0; goroutine < 8; goroutine++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for count := 0; count < 50; count++ {
+				_, _ = store.IncrementBy([]byte("Counter"), 1)
+			}
+		}()
+	}
+	group.Wait()
+	assert.Nil(t, store.Close())
+
+	store, _ = OpenDurableStore(path, SyncPolicy{Mode: SyncAlways}, NewInMemoryStore())
+	defer func() {
+		_ = store.Close()
+	}()
+	value, _ := store.GetValue([]byte("Counter"))
+	assert.Equal(t, []byte("400"), value)
+}
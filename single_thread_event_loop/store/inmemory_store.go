@@ -1,33 +1,377 @@
 package store
 
-import "sync"
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotANumber      = errors.New("value is not a 64-bit signed integer")
+	ErrCounterOverflow = errors.New("increment or decrement overflows a 64-bit signed integer")
+)
 
 // InMemoryStore represents a store to hold Key/Value pairs in RAM.
-// It is a wrapper over golang's map.
+// Keys and values are arbitrary bytes, they need not be valid UTF-8.
+// It is a wrapper over a skipList, which keeps the keys in ascending order and allows range and prefix scans.
+// Every key carries a version which changes on every PutOrUpdate or CompareAndSwap of the key.
+// Versions are drawn from a store-wide counter, so a key which is deleted and put again never reuses an old version.
+// A key may also carry a time to live (TTL). An expired key is never returned; it is removed lazily by GetValue,
+// and actively by EvictExpired which is invoked periodically by the server.
 type InMemoryStore struct {
-	lock       sync.RWMutex
-	valueByKey map[string]string
+	lock          sync.RWMutex
+	entries       *skipList
+	expiringKeys  map[string]time.Time
+	latestVersion uint64
+	clock         func() time.Time
+}
+
+// versionedValue represents a value along with its version and its expiry time.
+// A zero expiresAt denotes that the value never expires.
+type versionedValue struct {
+	value     []byte
+	version   uint64
+	expiresAt time.Time
+}
+
+// KeyValuePair represents a key/value pair which is put by MultiPutOrUpdate.
+type KeyValuePair struct {
+	Key   []byte
+	Value []byte
+}
+
+// VersionedKeyValue represents a key along with its value and version, as returned by MultiGet and scans.
+// Exists is false if the key does not exist.
+type VersionedKeyValue struct {
+	Key     []byte
+	Value   []byte
+	Version uint64
+	Exists  bool
+}
+
+// OperationKind denotes the kind of an Operation.
+type OperationKind int
+
+const (
+	OperationPutOrUpdate OperationKind = iota
+	OperationGet
+	OperationDelete
+)
+
+// Operation represents a put (or update), a get or a delete of a key, which is applied by Transact.
+// Value and TTL are only used by OperationPutOrUpdate; a TTL of 0 denotes that the key never expires.
+type Operation struct {
+	Kind  OperationKind
+	Key   []byte
+	Value []byte
+	TTL   time.Duration
+}
+
+// KeyVersion represents the version of a key which is watched by Transact.
+// A version of 0 denotes that the key did not exist.
+type KeyVersion struct {
+	Key     []byte
+	Version uint64
 }
 
 // NewInMemoryStore creates a new instance if InMemoryStore.
 func NewInMemoryStore() *InMemoryStore {
 	return &InMemoryStore{
-		valueByKey: make(map[string]string),
+		entries:      newSkipList(),
+		expiringKeys: make(map[string]time.Time),
+		clock:        time.Now,
 	}
 }
 
 // PutOrUpdate puts or updates the value of the given key.
-func (store *InMemoryStore) PutOrUpdate(key, value string) {
+// The key does not expire, even if it had a time to live earlier.
+// The store keeps a copy of the value, so the caller is free to reuse the value slice.
+func (store *InMemoryStore) PutOrUpdate(key, value []byte) error {
+	return store.PutOrUpdateWithTTL(key, value, 0)
+}
+
+// PutOrUpdateWithTTL puts or updates the value of the given key, which expires after the given time to live.
+// A time to live of 0 denotes that the key never expires.
+func (store *InMemoryStore) PutOrUpdateWithTTL(key, value []byte, ttl time.Duration) error {
 	store.lock.Lock()
-	store.valueByKey[key] = value
+	store.put(string(key), value, store.expiryOf(ttl))
 	store.lock.Unlock()
+	return nil
 }
 
 // GetValue gets the value of the given key.
-func (store *InMemoryStore) GetValue(key string) (string, bool) {
+// The returned value is shared with the store, and must not be modified.
+func (store *InMemoryStore) GetValue(key []byte) ([]byte, bool) {
+	value, _, ok := store.GetVersionedValue(key)
+	return value, ok
+}
+
+// GetVersionedValue gets the value and the version of the given key.
+// If the key has expired, it is deleted (lazy expiry) and is reported as non-existing.
+func (store *InMemoryStore) GetVersionedValue(key []byte) ([]byte, uint64, bool) {
+	store.lock.RLock()
+	versioned, ok := store.entries.get(string(key))
+	store.lock.RUnlock()
+
+	if ok && versioned.isExpiredAt(store.clock()) {
+		store.lock.Lock()
+		store.deleteIfExpired(string(key), store.clock())
+		store.lock.Unlock()
+		return nil, 0, false
+	}
+	return versioned.value, versioned.version, ok
+}
+
+// TimeToLive returns the remaining time to live of the given key, and true if the key exists.
+// A remaining time to live of 0 denotes that the key never expires.
+func (store *InMemoryStore) TimeToLive(key []byte) (time.Duration, bool) {
 	store.lock.RLock()
 	defer store.lock.RUnlock()
 
-	value, ok := store.valueByKey[key]
-	return value, ok
+	now := store.clock()
+	versioned, ok := store.get(string(key), now)
+	if !ok || versioned.expiresAt.IsZero() {
+		return 0, ok
+	}
+	return versioned.expiresAt.Sub(now), true
+}
+
+// MultiGet gets the values and the versions of the given keys.
+// All the keys are read under a single read lock, so the result is a consistent snapshot.
+func (store *InMemoryStore) MultiGet(keys [][]byte) []VersionedKeyValue {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	now := store.clock()
+	values := make([]VersionedKeyValue, 0, len(keys))
+	for _, key := range keys {
+		versioned, ok := store.get(string(key), now)
+		values = append(values, VersionedKeyValue{Key: key, Value: versioned.value, Version: versioned.version, Exists: ok})
+	}
+	return values
+}
+
+// MultiPutOrUpdate puts or updates the values of all the given pairs atomically, under a single lock.
+// It returns the new version of each pair, in the order of the pairs.
+func (store *InMemoryStore) MultiPutOrUpdate(pairs []KeyValuePair) ([]uint64, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	versions := make([]uint64, 0, len(pairs))
+	for _, pair := range pairs {
+		versions = append(versions, store.put(string(pair.Key), pair.Value, time.Time{}))
+	}
+	return versions, nil
+}
+
+// Transact applies the operations in order, atomically under a single lock, provided that every watched key still
+// has its watched version. It returns false (and applies nothing) if any watched key has changed. A key which did not
+// exist is considered unchanged if it does not exist, even if it was put and deleted in the meantime.
+// It returns the result of each operation, in the order of the operations: the new version for
+// OperationPutOrUpdate, the value and the version for OperationGet, and whether the key existed (Exists) for
+// OperationGet and OperationDelete.
+func (store *InMemoryStore) Transact(watched []KeyVersion, operations []Operation) ([]VersionedKeyValue, bool, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	now := store.clock()
+	for _, keyVersion := range watched {
+		if current, _ := store.get(string(keyVersion.Key), now); current.version != keyVersion.Version {
+			return nil, false, nil
+		}
+	}
+	results := make([]VersionedKeyValue, 0, len(operations))
+	for _, operation := range operations {
+		key := string(operation.Key)
+		switch operation.Kind {
+		case OperationPutOrUpdate:
+			version := store.put(key, operation.Value, store.expiryOf(operation.TTL))
+			results = append(results, VersionedKeyValue{Key: operation.Key, Version: version, Exists: true})
+		case OperationGet:
+			versioned, ok := store.get(key, now)
+			results = append(results, VersionedKeyValue{Key: operation.Key, Value: versioned.value, Version: versioned.version, Exists: ok})
+		case OperationDelete:
+			_, ok := store.get(key, now)
+			store.delete(key)
+			results = append(results, VersionedKeyValue{Key: operation.Key, Exists: ok})
+		}
+	}
+	return results, true, nil
+}
+
+// Scan returns the key/value pairs with keys in the range [start, end), in ascending order of keys.
+// An empty end denotes no upper bound, and a limit of 0 denotes no limit on the number of pairs.
+func (store *InMemoryStore) Scan(start, end []byte, limit int) []VersionedKeyValue {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	var values []VersionedKeyValue
+	store.entries.scan(string(start), string(end), collectInto(&values, limit, store.clock()))
+	return values
+}
+
+// PrefixScan returns the key/value pairs with keys starting with the given prefix, in ascending order of keys.
+// A limit of 0 denotes no limit on the number of pairs.
+func (store *InMemoryStore) PrefixScan(prefix []byte, limit int) []VersionedKeyValue {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	var values []VersionedKeyValue
+	store.entries.prefixScan(string(prefix), collectInto(&values, limit, store.clock()))
+	return values
+}
+
+// CompareAndSwap puts or updates the value of the given key only if the current version of the key is expectedVersion.
+// An expectedVersion of 0 denotes that the key must not exist.
+// It returns the new version and true if the value is swapped, else the current version and false.
+func (store *InMemoryStore) CompareAndSwap(key []byte, expectedVersion uint64, value []byte) (uint64, bool, error) {
+	return store.CompareAndSwapWithTTL(key, expectedVersion, value, 0)
+}
+
+// CompareAndSwapWithTTL is the same as CompareAndSwap, and the swapped value expires after the given time to live.
+// A time to live of 0 denotes that the key never expires.
+func (store *InMemoryStore) CompareAndSwapWithTTL(key []byte, expectedVersion uint64, value []byte, ttl time.Duration) (uint64, bool, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	current, _ := store.get(string(key), store.clock())
+	if current.version != expectedVersion {
+		return current.version, false, nil
+	}
+	return store.put(string(key), value, store.expiryOf(ttl)), true, nil
+}
+
+// IncrementBy atomically adds delta (which may be negative) to the value of the given key, which is parsed as int64.
+// A non-existing key is considered to be 0. The time to live of an existing key is retained.
+// It returns the new value, or ErrNotANumber if the value is not an int64, or ErrCounterOverflow if the new value
+// overflows int64.
+func (store *InMemoryStore) IncrementBy(key []byte, delta int64) (int64, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	current, ok := store.get(string(key), store.clock())
+	counter, err := incremented(current.value, ok, delta)
+	if err != nil {
+		return 0, err
+	}
+	store.put(string(key), strconv.AppendInt(nil, counter, 10), current.expiresAt)
+	return counter, nil
+}
+
+// incremented returns the counter of the value (0 if the key does not exist) incremented by delta, or ErrNotANumber
+// if the value is not an int64, or ErrCounterOverflow if the new counter overflows int64.
+func incremented(value []byte, exists bool, delta int64) (int64, error) {
+	counter := int64(0)
+	if exists {
+		var err error
+		if counter, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			return 0, ErrNotANumber
+		}
+	}
+	if (delta > 0 && counter > math.MaxInt64-delta) || (delta < 0 && counter < math.MinInt64-delta) {
+		return 0, ErrCounterOverflow
+	}
+	return counter + delta, nil
+}
+
+// Delete deletes the given key.
+// It returns true if the key existed.
+func (store *InMemoryStore) Delete(key []byte) (bool, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	_, ok := store.get(string(key), store.clock())
+	store.delete(string(key))
+	return ok, nil
+}
+
+// EvictExpired deletes the expired keys (active expiry).
+// It examines at most maxKeys keys which carry a time to live, so that a single invocation holds the lock for a
+// bounded time, and returns the number of deleted keys.
+func (store *InMemoryStore) EvictExpired(maxKeys int) int {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	now, examined, evicted := store.clock(), 0, 0
+	for key := range store.expiringKeys {
+		if examined == maxKeys {
+			break
+		}
+		examined++
+		if store.deleteIfExpired(key, now) {
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// get gets the value of the given key, treating an expired key as non-existing.
+// The caller is expected to hold the lock.
+func (store *InMemoryStore) get(key string, now time.Time) (versionedValue, bool) {
+	versioned, ok := store.entries.get(key)
+	if !ok || versioned.isExpiredAt(now) {
+		return versionedValue{}, false
+	}
+	return versioned, true
+}
+
+// put puts or updates (a copy of) the value of the given key with the next version, and returns the version.
+// A zero expiresAt denotes that the key never expires.
+// The caller is expected to hold the lock.
+func (store *InMemoryStore) put(key string, value []byte, expiresAt time.Time) uint64 {
+	store.latestVersion++
+
+	versioned := versionedValue{value: bytes.Clone(value), version: store.latestVersion, expiresAt: expiresAt}
+	if !expiresAt.IsZero() {
+		store.expiringKeys[key] = expiresAt
+	} else {
+		delete(store.expiringKeys, key)
+	}
+	store.entries.put(key, versioned)
+	return store.latestVersion
+}
+
+// expiryOf returns the expiry time for the given time to live, a zero time if the time to live is 0.
+func (store *InMemoryStore) expiryOf(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return store.clock().Add(ttl)
+}
+
+// delete deletes the given key.
+// The caller is expected to hold the lock.
+func (store *InMemoryStore) delete(key string) {
+	store.entries.delete(key)
+	delete(store.expiringKeys, key)
+}
+
+// deleteIfExpired deletes the given key if it has expired, and returns true if the key is deleted.
+// The caller is expected to hold the lock.
+func (store *InMemoryStore) deleteIfExpired(key string, now time.Time) bool {
+	versioned, ok := store.entries.get(key)
+	if !ok || !versioned.isExpiredAt(now) {
+		return false
+	}
+	store.delete(key)
+	return true
+}
+
+// isExpiredAt returns true if the value has a time to live and has expired at the given time.
+func (value versionedValue) isExpiredAt(now time.Time) bool {
+	return !value.expiresAt.IsZero() && !now.Before(value.expiresAt)
+}
+
+// collectInto returns a skipList visitor which collects at most limit non-expired pairs into values.
+func collectInto(values *[]VersionedKeyValue, limit int, now time.Time) func(key string, value versionedValue) bool {
+	return func(key string, value versionedValue) bool {
+		if value.isExpiredAt(now) {
+			return true
+		}
+		*values = append(*values, VersionedKeyValue{Key: []byte(key), Value: value.value, Version: value.version, Exists: true})
+		return limit == 0 || len(*values) < limit
+	}
 }
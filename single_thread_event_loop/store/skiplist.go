@@ -0,0 +1,142 @@
+package store
+
+import (
+	"math/rand"
+	"strings"
+)
+
+const (
+	maxSkipListLevel   = 24
+	skipListLevelRatio = 4
+)
+
+// skipList represents an ordered map of keys to versioned values.
+// It is a probabilistic data structure: every node is present in level 0, and a node present in level i
+// is also present in level i+1 with a probability of 1/skipListLevelRatio.
+// Searching a key starts at the highest level and moves down a level when the next key in the current level
+// is greater than or equal to the searched key, which gives an expected O(log n) get/put/delete.
+// skipList is not safe for concurrent use.
+type skipList struct {
+	head   *skipListNode
+	level  int
+	length int
+	random *rand.Rand
+}
+
+// skipListNode represents a node of the skipList.
+// next[i] is the next node in level i.
+type skipListNode struct {
+	key   string
+	value versionedValue
+	next  []*skipListNode
+}
+
+// newSkipList creates a new instance of skipList.
+func newSkipList() *skipList {
+	return &skipList{
+		head:   &skipListNode{next: make([]*skipListNode, maxSkipListLevel)},
+		level:  1,
+		random: rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+// get gets the value of the given key.
+func (list *skipList) get(key string) (versionedValue, bool) {
+	node := list.seek(key)
+	if node != nil && node.key == key {
+		return node.value, true
+	}
+	return versionedValue{}, false
+}
+
+// put puts or updates the value of the given key.
+func (list *skipList) put(key string, value versionedValue) {
+	predecessors := list.predecessorsOf(key)
+	if next := predecessors[0].next[0]; next != nil && next.key == key {
+		next.value = value
+		return
+	}
+
+	level := list.randomLevel()
+	if level > list.level {
+		for index := list.level; index < level; index++ {
+			predecessors[index] = list.head
+		}
+		list.level = level
+	}
+
+	node := &skipListNode{key: key, value: value, next: make([]*skipListNode, level)}
+	for index := 0; index < level; index++ {
+		node.next[index] = predecessors[index].next[index]
+		predecessors[index].next[index] = node
+	}
+	list.length++
+}
+
+// delete deletes the given key.
+// It returns true if the key existed.
+func (list *skipList) delete(key string) bool {
+	predecessors := list.predecessorsOf(key)
+	node := predecessors[0].next[0]
+	if node == nil || node.key != key {
+		return false
+	}
+	for index := 0; index < len(node.next); index++ {
+		predecessors[index].next[index] = node.next[index]
+	}
+	for list.level > 1 && list.head.next[list.level-1] == nil {
+		list.level--
+	}
+	list.length--
+	return true
+}
+
+// seek returns the first node with a key greater than or equal to the given key, nil if there is no such node.
+func (list *skipList) seek(key string) *skipListNode {
+	return list.predecessorsOf(key)[0].next[0]
+}
+
+// scan invokes the visitor for the keys in the range [start, end), in ascending order of keys.
+// An empty end denotes no upper bound. The scan stops when the visitor returns false.
+func (list *skipList) scan(start, end string, visitor func(key string, value versionedValue) bool) {
+	for node := list.seek(start); node != nil; node = node.next[0] {
+		if end != "" && node.key >= end {
+			return
+		}
+		if !visitor(node.key, node.value) {
+			return
+		}
+	}
+}
+
+// prefixScan invokes the visitor for the keys which start with the given prefix, in ascending order of keys.
+// The scan stops when the visitor returns false.
+func (list *skipList) prefixScan(prefix string, visitor func(key string, value versionedValue) bool) {
+	for node := list.seek(prefix); node != nil && strings.HasPrefix(node.key, prefix); node = node.next[0] {
+		if !visitor(node.key, node.value) {
+			return
+		}
+	}
+}
+
+// predecessorsOf returns, for every level, the last node with a key smaller than the given key.
+func (list *skipList) predecessorsOf(key string) []*skipListNode {
+	predecessors := make([]*skipListNode, maxSkipListLevel)
+	node := list.head
+	for level := list.level - 1; level >= 0; level-- {
+		for node.next[level] != nil && node.next[level].key < key {
+			node = node.next[level]
+		}
+		predecessors[level] = node
+	}
+	return predecessors
+}
+
+// randomLevel returns a random level for a new node.
+func (list *skipList) randomLevel() int {
+	level := 1
+	for level < maxSkipListLevel && list.random.Intn(skipListLevelRatio) == 0 {
+		level++
+	}
+	return level
+}
@@ -0,0 +1,201 @@
+package store
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplaysTheAppendedRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.wal")
+	wal, err := OpenWAL(path, SyncPolicy{Mode: SyncAlways})
+	assert.Nil(t, err)
+	_, err = wal.Replay(func([]byte) error { return nil })
+	assert.Nil(t, err)
+
+	_, err = wal.Append([]byte("first"))
+	assert.Nil(t, err)
+	sequence, err := wal.Append([]byte("second"))
+	assert.Nil(t, err)
+	assert.Nil(t, wal.WaitSynced(sequence))
+	assert.Equal(t, sequence, wal.Synced())
+	assert.Nil(t, wal.Close())
+
+	wal, err = OpenWAL(path, SyncPolicy{Mode: SyncAlways})
+	assert.Nil(t, err)
+	defer func() {
+		_ = wal.Close()
+	}()
+	var payloads []string
+	records, err := wal.Replay(func(payload []byte) error {
+		payloads = append(payloads, string(payload))
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, records)
+	assert.Equal(t, []string{"first", "second"}, payloads)
+	assert.Equal(t, sequence, wal.Appended())
+}
+
+func TestRecoversFromATruncatedTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.wal")
+	wal, err := OpenWAL(path, SyncPolicy{Mode: SyncNever})
+	assert.Nil(t, err)
+	_, _ = wal.Replay(func([]byte) error { return nil })
+	first, _ := wal.Append([]byte("first"))
+	_, _ = wal.Append([]byte("second"))
+	assert.Nil(t, wal.Close())
+
+	// a crash in the middle of the second append leaves a partial record behind.
+	assert.Nil(t, os.Truncate(path, int64(first)+recordHeaderSize+3))
+
+	wal, err = OpenWAL(path, SyncPolicy{Mode: SyncNever})
+	assert.Nil(t, err)
+	var payloads []string
+	replay := func(payload []byte) error {
+		payloads = append(payloads, string(payload))
+		return nil
+	}
+	records, err := wal.Replay(replay)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, records)
+	assert.Equal(t, first, wal.Appended())
+
+	_, err = wal.Append([]byte("third"))
+	assert.Nil(t, err)
+	assert.Nil(t, wal.Close())
+
+	wal, _ = OpenWAL(path, SyncPolicy{Mode: SyncNever})
+	defer func() {
+		_ = wal.Close()
+	}()
+	payloads = nil
+	records, err = wal.Replay(replay)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, records)
+	assert.Equal(t, []string{"first", "third"}, payloads)
+}
+
+func TestTruncatesACorruptRecordAtTheTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.wal")
+	wal, _ := OpenWAL(path, SyncPolicy{Mode: SyncNever})
+	_, _ = wal.Replay(func([]byte) error { return nil })
+	first, _ := wal.Append([]byte("first"))
+	_, _ = wal.Append([]byte("second"))
+	assert.Nil(t, wal.Close())
+
+	contents, _ := os.ReadFile(path)
+	contents[len(contents)-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(path, contents, 0644))
+
+	wal, _ = OpenWAL(path, SyncPolicy{Mode: SyncNever})
+	defer func() {
+		_ = wal.Close()
+	}()
+	records, err := wal.Replay(func([]byte) error { return nil })
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, records)
+	assert.Equal(t, first, wal.Appended())
+}
+
+func TestRefusesToReplayALogWhichIsCorruptBeforeItsTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.wal")
+	wal, _ := OpenWAL(path, SyncPolicy{Mode: SyncNever})
+	_, _ = wal.Replay(func([]byte) error { return nil })
+	_, _ = wal.Append([]byte("first"))
+	_, _ = wal.Append([]byte("second"))
+	assert.Nil(t, wal.Close())
+
+	contents, _ := os.ReadFile(path)
+	contents[recordHeaderSize] ^= 0xff
+	assert.Nil(t, os.WriteFile(path, contents, 0644))
+
+	wal, _ = OpenWAL(path, SyncPolicy{Mode: SyncNever})
+	records, err := wal.Replay(func([]byte) error { return nil })
+	assert.Nil(t, wal.Close())
+
+	assert.ErrorIs(t, err, ErrCorruptRecord)
+	assert.Equal(t, 0, records)
+	replayed, _ := os.ReadFile(path)
+	assert.Equal(t, contents, replayed)
+}
+
+func TestDoesNotOpenALogWithAnInvalidSyncPolicy(t *testing.T) {
+	for _, policy := range []SyncPolicy{
+		{Mode: SyncPeriodically},
+		{Mode: SyncPeriodically, Interval: -time.Second},
+		{Mode: SyncMode(7)},
+	} {
+		_, err := OpenWAL(filepath.Join(t.TempDir(), "store.wal"), policy)
+
+		assert.ErrorIs(t, err, ErrInvalidSyncPolicy)
+	}
+}
+
+func TestSyncsTheLogPeriodically(t *testing.T) {
+	wal, _ := OpenWAL(filepath.Join(t.TempDir(), "store.wal"), SyncPolicy{Mode: SyncPeriodically, Interval: 10 * time.Millisecond})
+	defer func() {
+		_ = wal.Close()
+	}()
+	_, _ = wal.Replay(func([]byte) error { return nil })
+	synced := make(chan struct{}, 1)
+	wal.OnSync(func() {
+		select {
+		case synced <- struct{}{}:
+		default:
+		}
+	})
+
+	sequence, _ := wal.Append([]byte("first"))
+
+	select {
+	case <-synced:
+	case <-time.After(time.Second):
+		t.Fatal("log is not synced")
+	}
+	assert.Equal(t, sequence, wal.Synced())
+}
+
+func TestDoesNotAppendToAClosedLog(t *testing.T) {
+	wal, _ := OpenWAL(filepath.Join(t.TempDir(), "store.wal"), SyncPolicy{Mode: SyncAlways})
+	_, _ = wal.Replay(func([]byte) error { return nil })
+	assert.Nil(t, wal.Close())
+
+	_, err := wal.Append([]byte("first"))
+
+	assert.ErrorIs(t, err, ErrWALClosed)
+}
+
+func TestInvokesOnSyncOnceTheLogFails(t *testing.T) {
+	wal, _ := OpenWAL(filepath.Join(t.TempDir(), "store.wal"), SyncPolicy{Mode: SyncAlways})
+	defer func() {
+		_ = wal.Close()
+	}()
+	_, _ = wal.Replay(func([]byte) error { return nil })
+	failed := make(chan struct{}, 1)
+	wal.OnSync(func() {
+		if wal.Err() == nil {
+			return
+		}
+		select {
+		case failed <- struct{}{}:
+		default:
+		}
+	})
+	_ = wal.file.Close()
+
+	_, err := wal.Append([]byte("first"))
+
+	assert.NotNil(t, err)
+	select {
+	case <-failed:
+	case <-time.After(time.Second):
+		t.Fatal("OnSync is not invoked for the failed log")
+	}
+	assert.Equal(t, err, wal.Err())
+}